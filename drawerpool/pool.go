@@ -1,6 +1,7 @@
 package drawerpool
 
 import (
+	"context"
 	"image"
 	"io/ioutil"
 	"log"
@@ -11,6 +12,10 @@ import (
 
 var fontf *truetype.Font
 
+// Face is shared by all drawers, so they share one glyph atlas in kkformat. It is not safe for concurrent use,
+// drawers should only be used through kkformat which serialises the access.
+var Face font.Face
+
 const (
 	FontSize   = 16
 	DPI        = 72
@@ -24,6 +29,11 @@ func init() {
 		log.Fatalln(err)
 	} else {
 		fontf = f
+		Face = truetype.NewFace(fontf, &truetype.Options{
+			Size:    FontSize,
+			DPI:     DPI,
+			Hinting: font.HintingNone,
+		})
 	}
 }
 
@@ -44,12 +54,8 @@ func NewPool(size int, imgW, imgH int, initstub func(d *font.Drawer)) *Pool {
 	for i := 0; i < size; i++ {
 		pp := &pair{
 			Drawer: &font.Drawer{
-				Dst: image.NewPaletted(image.Rect(0, 0, imgW, imgH), nil),
-				Face: truetype.NewFace(fontf, &truetype.Options{
-					Size:    FontSize,
-					DPI:     DPI,
-					Hinting: font.HintingNone,
-				}),
+				Dst:  image.NewPaletted(image.Rect(0, 0, imgW, imgH), nil),
+				Face: Face,
 			},
 			pool: p,
 		}
@@ -66,6 +72,16 @@ func (p *Pool) Get() *pair {
 	return <-p.c
 }
 
+// GetContext is like Get but gives up when ctx is done
+func (p *Pool) GetContext(ctx context.Context) (*pair, error) {
+	select {
+	case pp := <-p.c:
+		return pp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func (p *pair) Free() {
 	p.pool.c <- p
 }
@@ -0,0 +1,392 @@
+package kkformat
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/bidi"
+)
+
+// A simplified Unicode Bidirectional Algorithm (UAX #9) working on a single line:
+// explicit embeddings, overrides and isolates are ignored, bracket pairs (N0) are not resolved.
+
+type bidiGlyph struct {
+	x int  // x of the rune
+	r rune // the rune to draw, may be mirrored
+}
+
+var bidiMirrors = map[rune]rune{
+	'(': ')', ')': '(', '[': ']', ']': '[', '{': '}', '}': '{', '<': '>', '>': '<',
+	'«': '»', '»': '«', '‹': '›', '›': '‹', '≤': '≥', '≥': '≤',
+	'（': '）', '）': '（', '［': '］', '］': '［', '｛': '｝', '｝': '｛',
+	'〈': '〉', '〉': '〈', '《': '》', '》': '《', '「': '」', '」': '「', '『': '』', '』': '『',
+	'【': '】', '】': '【', '〔': '〕', '〕': '〔',
+}
+
+// isNarrowRTL reports whether r is a Hebrew or Arabic rune, these runes are half wide
+func isNarrowRTL(r rune) bool {
+	return (r >= 0x0590 && r <= 0x06ff) || (r >= 0x0750 && r <= 0x077f) ||
+		(r >= 0xfb1d && r <= 0xfdff) || (r >= 0xfe70 && r <= 0xfefe)
+}
+
+func bidiClass(r rune) bidi.Class {
+	p, _ := bidi.LookupRune(r)
+	switch c := p.Class(); c {
+	case bidi.LRO, bidi.RLO, bidi.LRE, bidi.RLE, bidi.PDF, bidi.Control:
+		return bidi.BN
+	case bidi.LRI, bidi.RLI, bidi.FSI, bidi.PDI:
+		return bidi.ON
+	default:
+		return c
+	}
+}
+
+// paragraphIsRTL finds the first strong rune of the paragraph starting at buf (rule P2, P3)
+func paragraphIsRTL(buf []byte) bool {
+	for i := 0; i < len(buf); {
+		r, w := utf8.DecodeRune(buf[i:])
+		if r == '\n' {
+			break
+		}
+
+		switch bidiClass(r) {
+		case bidi.L:
+			return false
+		case bidi.R, bidi.AL:
+			return true
+		}
+		i += w
+	}
+	return false
+}
+
+// hasRTL reports whether runes contain any right to left rune
+func hasRTL(runes []rune) bool {
+	for _, r := range runes {
+		if r >= 0x0590 {
+			if c := bidiClass(r); c == bidi.R || c == bidi.AL || c == bidi.AN {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bidiLevels resolves the embedding levels of runes
+func bidiLevels(runes []rune, rtl bool) []uint8 {
+	n := len(runes)
+	base, sos := uint8(0), bidi.L
+	if rtl {
+		base, sos = 1, bidi.R
+	}
+
+	orig := make([]bidi.Class, n)
+	types := make([]bidi.Class, n)
+	for i, r := range runes {
+		orig[i] = bidiClass(r)
+		types[i] = orig[i]
+	}
+
+	// W1: NSM (and BN) get the type of the previous rune
+	for i, t := range types {
+		if t == bidi.NSM || t == bidi.BN {
+			if i == 0 {
+				types[i] = sos
+			} else {
+				types[i] = types[i-1]
+			}
+		}
+	}
+
+	// W2, W3: EN after AL becomes AN, AL becomes R
+	last := sos
+	for i, t := range types {
+		switch t {
+		case bidi.L, bidi.R, bidi.AL:
+			last = t
+		case bidi.EN:
+			if last == bidi.AL {
+				types[i] = bidi.AN
+			}
+		}
+	}
+	for i, t := range types {
+		if t == bidi.AL {
+			types[i] = bidi.R
+		}
+	}
+
+	// W4: a single separator between two numbers of the same type
+	for i := 1; i < n-1; i++ {
+		prev, next := types[i-1], types[i+1]
+		switch types[i] {
+		case bidi.ES:
+			if prev == bidi.EN && next == bidi.EN {
+				types[i] = bidi.EN
+			}
+		case bidi.CS:
+			if prev == next && (prev == bidi.EN || prev == bidi.AN) {
+				types[i] = prev
+			}
+		}
+	}
+
+	// W5: terminators adjacent to EN
+	for i := 0; i < n; i++ {
+		if types[i] != bidi.ET {
+			continue
+		}
+
+		j := i
+		for j < n && types[j] == bidi.ET {
+			j++
+		}
+		if (i > 0 && types[i-1] == bidi.EN) || (j < n && types[j] == bidi.EN) {
+			for k := i; k < j; k++ {
+				types[k] = bidi.EN
+			}
+		}
+		i = j
+	}
+
+	// W6, W7
+	last = sos
+	for i, t := range types {
+		switch t {
+		case bidi.ES, bidi.ET, bidi.CS:
+			types[i] = bidi.ON
+		case bidi.L, bidi.R:
+			last = t
+		case bidi.EN:
+			if last == bidi.L {
+				types[i] = bidi.L
+			}
+		}
+	}
+
+	// N1, N2: neutrals take the direction of the surrounding strong runes if they agree,
+	// otherwise the direction of the paragraph
+	strong := func(t bidi.Class) bidi.Class {
+		switch t {
+		case bidi.L:
+			return bidi.L
+		case bidi.R, bidi.EN, bidi.AN:
+			return bidi.R
+		}
+		return bidi.ON
+	}
+	for i := 0; i < n; i++ {
+		if strong(types[i]) != bidi.ON {
+			continue
+		}
+
+		j := i
+		for j < n && strong(types[j]) == bidi.ON {
+			j++
+		}
+
+		before, after := sos, sos
+		if i > 0 {
+			before = strong(types[i-1])
+		}
+		if j < n {
+			after = strong(types[j])
+		}
+
+		dir := sos
+		if before == after {
+			dir = before
+		}
+		for k := i; k < j; k++ {
+			types[k] = dir
+		}
+		i = j
+	}
+
+	// I1, I2
+	levels := make([]uint8, n)
+	for i, t := range types {
+		levels[i] = base
+		switch {
+		case base == 0 && t == bidi.R:
+			levels[i] = 1
+		case base == 0 && (t == bidi.AN || t == bidi.EN):
+			levels[i] = 2
+		case base == 1 && (t == bidi.L || t == bidi.AN || t == bidi.EN):
+			levels[i] = 2
+		}
+	}
+
+	// L1: separators and trailing whitespaces are reset to the paragraph level
+	trailing := true
+	for i := n - 1; i >= 0; i-- {
+		switch orig[i] {
+		case bidi.S, bidi.B:
+			levels[i] = base
+			trailing = true
+		case bidi.WS, bidi.BN:
+			if trailing {
+				levels[i] = base
+			}
+		default:
+			trailing = false
+		}
+	}
+
+	return levels
+}
+
+// bidiReorder returns the indexes of runes in visual order (rule L2)
+func bidiReorder(levels []uint8) []int {
+	order := make([]int, len(levels))
+	max, minOdd := uint8(0), uint8(255)
+	for i, l := range levels {
+		order[i] = i
+		if l > max {
+			max = l
+		}
+		if l%2 == 1 && l < minOdd {
+			minOdd = l
+		}
+	}
+
+	for l := max; l >= minOdd && l > 0; l-- {
+		for i := 0; i < len(order); i++ {
+			if levels[order[i]] < l {
+				continue
+			}
+
+			j := i
+			for j < len(order) && levels[order[j]] >= l {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				order[a], order[b] = order[b], order[a]
+			}
+			i = j
+		}
+	}
+
+	return order
+}
+
+// bidiVisual returns runes in visual order with mirrored brackets
+func bidiVisual(runes []rune, rtl bool) []rune {
+	levels := bidiLevels(runes, rtl)
+	ret := make([]rune, 0, len(runes))
+	for _, i := range bidiReorder(levels) {
+		r := runes[i]
+		if m, ok := bidiMirrors[r]; ok && levels[i]%2 == 1 {
+			r = m
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// layoutBidi calculates the positions of all runes in words, the line starts at x,
+// if rtl is true, the line will be aligned to the right
+func (o *Formatter) layoutBidi(words words_t, x int, rtl bool) {
+	unit := o.Pos.Dx + 1
+	runes := make([]rune, 0, o.Columns)
+	for _, w := range words {
+		// marks are skipped by join, they take no glyph
+		if w.getType() != runeMark {
+			runes = append(runes, w.value...)
+		}
+	}
+
+	if width := int(StringWidth(runes)); rtl && width < int(o.Columns) {
+		x += (int(o.Columns) - width) * unit
+	}
+
+	levels := bidiLevels(runes, rtl)
+	o.bidi = make([]bidiGlyph, len(runes))
+	for _, i := range bidiReorder(levels) {
+		r := runes[i]
+		if m, ok := bidiMirrors[r]; ok && levels[i]%2 == 1 {
+			r = m
+		}
+		o.bidi[i] = bidiGlyph{x: x, r: r}
+		x += int(RuneWidth(runes[i])) * unit
+	}
+	o.bidiIdx, o.bidiEnd = 0, x
+}
+
+// arabicForms holds the isolated, final, initial and medial forms of Arabic letters,
+// right joining letters have no initial and medial forms
+var arabicForms = map[rune][4]rune{
+	0x0621: {0xfe80}, 0x0622: {0xfe81, 0xfe82}, 0x0623: {0xfe83, 0xfe84}, 0x0624: {0xfe85, 0xfe86},
+	0x0625: {0xfe87, 0xfe88}, 0x0626: {0xfe89, 0xfe8a, 0xfe8b, 0xfe8c}, 0x0627: {0xfe8d, 0xfe8e},
+	0x0628: {0xfe8f, 0xfe90, 0xfe91, 0xfe92}, 0x0629: {0xfe93, 0xfe94}, 0x062a: {0xfe95, 0xfe96, 0xfe97, 0xfe98},
+	0x062b: {0xfe99, 0xfe9a, 0xfe9b, 0xfe9c}, 0x062c: {0xfe9d, 0xfe9e, 0xfe9f, 0xfea0},
+	0x062d: {0xfea1, 0xfea2, 0xfea3, 0xfea4}, 0x062e: {0xfea5, 0xfea6, 0xfea7, 0xfea8},
+	0x062f: {0xfea9, 0xfeaa}, 0x0630: {0xfeab, 0xfeac}, 0x0631: {0xfead, 0xfeae}, 0x0632: {0xfeaf, 0xfeb0},
+	0x0633: {0xfeb1, 0xfeb2, 0xfeb3, 0xfeb4}, 0x0634: {0xfeb5, 0xfeb6, 0xfeb7, 0xfeb8},
+	0x0635: {0xfeb9, 0xfeba, 0xfebb, 0xfebc}, 0x0636: {0xfebd, 0xfebe, 0xfebf, 0xfec0},
+	0x0637: {0xfec1, 0xfec2, 0xfec3, 0xfec4}, 0x0638: {0xfec5, 0xfec6, 0xfec7, 0xfec8},
+	0x0639: {0xfec9, 0xfeca, 0xfecb, 0xfecc}, 0x063a: {0xfecd, 0xfece, 0xfecf, 0xfed0},
+	0x0641: {0xfed1, 0xfed2, 0xfed3, 0xfed4}, 0x0642: {0xfed5, 0xfed6, 0xfed7, 0xfed8},
+	0x0643: {0xfed9, 0xfeda, 0xfedb, 0xfedc}, 0x0644: {0xfedd, 0xfede, 0xfedf, 0xfee0},
+	0x0645: {0xfee1, 0xfee2, 0xfee3, 0xfee4}, 0x0646: {0xfee5, 0xfee6, 0xfee7, 0xfee8},
+	0x0647: {0xfee9, 0xfeea, 0xfeeb, 0xfeec}, 0x0648: {0xfeed, 0xfeee}, 0x0649: {0xfeef, 0xfef0},
+	0x064a: {0xfef1, 0xfef2, 0xfef3, 0xfef4}, 0x067e: {0xfb56, 0xfb57, 0xfb58, 0xfb59},
+	0x0686: {0xfb7a, 0xfb7b, 0xfb7c, 0xfb7d}, 0x0698: {0xfb8a, 0xfb8b}, 0x06a9: {0xfb8e, 0xfb8f, 0xfb90, 0xfb91},
+	0x06af: {0xfb92, 0xfb93, 0xfb94, 0xfb95}, 0x06cc: {0xfbfc, 0xfbfd, 0xfbfe, 0xfbff},
+}
+
+// lamAlef holds the isolated and final forms of the ligatures of lam and alef
+var lamAlef = map[rune][2]rune{
+	0x0622: {0xfef5, 0xfef6}, 0x0623: {0xfef7, 0xfef8}, 0x0625: {0xfef9, 0xfefa}, 0x0627: {0xfefb, 0xfefc},
+}
+
+const arabicTatweel = 0x0640
+
+func arabicJoinsNext(r rune) bool {
+	f, ok := arabicForms[r]
+	return r == arabicTatweel || (ok && f[2] != 0)
+}
+
+func arabicJoinsPrev(r rune) bool {
+	_, ok := arabicForms[r]
+	return r == arabicTatweel || (ok && r != 0x0621)
+}
+
+// shapeArabic replaces Arabic letters with their contextual presentation forms
+func shapeArabic(in []rune) []rune {
+	out := make([]rune, 0, len(in))
+	for i := 0; i < len(in); i++ {
+		r := in[i]
+		f, ok := arabicForms[r]
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+
+		prev := i > 0 && arabicJoinsNext(in[i-1])
+		if r == 0x0644 && i+1 < len(in) {
+			if l, ok := lamAlef[in[i+1]]; ok {
+				if prev {
+					out = append(out, l[1])
+				} else {
+					out = append(out, l[0])
+				}
+				i++
+				continue
+			}
+		}
+
+		next := f[2] != 0 && i+1 < len(in) && arabicJoinsPrev(in[i+1])
+		switch {
+		case prev && next:
+			r = f[3]
+		case prev && f[1] != 0:
+			r = f[1]
+		case next:
+			r = f[2]
+		default:
+			r = f[0]
+		}
+		out = append(out, r)
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package kkformat
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestBidiVisual(t *testing.T) {
+	for _, c := range []struct {
+		in, out string
+		rtl     bool
+	}{
+		{"abc אבג def", "abc גבא def", false},
+		{"אבג def דהו", "והד def גבא", true},
+		{"אבג 123 דהו", "והד 123 גבא", true},
+		{"אבג (דהו)", "(והד) גבא", true},
+		{"hello", "hello", false},
+	} {
+		if res := string(bidiVisual([]rune(c.in), c.rtl)); res != c.out {
+			t.Errorf("%q: %q, expect %q", c.in, res, c.out)
+		}
+	}
+}
+
+func TestShapeArabic(t *testing.T) {
+	for in, out := range map[string]string{
+		"بيت":  "ﺑﻴﺖ",
+		"لا":   "ﻻ",
+		"سلام": "ﺳﻼﻡ",
+		"و":    "ﻭ",
+	} {
+		if res := string(shapeArabic([]rune(in))); res != out {
+			t.Errorf("%q: %q, expect %q", in, res, out)
+		}
+	}
+}
+
+func TestBidiMarks(t *testing.T) {
+	layout := func(src string) []bidiGlyph {
+		o := &Formatter{Columns: 40}
+		o.Pos.Dx = 7
+		s, words := stream_t{buf: []byte(src), marks: true}, words_t{}
+		for w := s.nextWord(); w != nil; w = s.nextWord() {
+			words = append(words, w)
+		}
+		o.layoutBidi(words, 0, true)
+		return o.bidi
+	}
+
+	// marks only change the background, glyphs stay where they are without them
+	plain := layout("אבג דהו זחט")
+	marked := layout("אבג " + string(markOn) + "דהו" + string(markOff) + " זחט")
+	if len(plain) != len(marked) {
+		t.Fatal(len(plain), len(marked))
+	}
+	for i := range plain {
+		if plain[i] != marked[i] {
+			t.Error(i, plain[i], marked[i])
+		}
+	}
+
+	// the changed part of the right pane is marked
+	r := &Renderer{Columns: 40, LineHeight: 16, Face: basicfont.Face7x13, Theme: WhiteTheme}
+	dst := image.NewRGBA(image.Rect(0, 0, 800, 16*4))
+	if res, err := r.RenderSideBySide(context.Background(), dst, []byte("אבג דהו זחט\n"), []byte("אבג דהוי זחט\n")); err != nil || res.Rows != 1 {
+		t.Error(res, err)
+	}
+}
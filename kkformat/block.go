@@ -0,0 +1,154 @@
+package kkformat
+
+import (
+	"image"
+	"image/draw"
+	"strings"
+)
+
+// Outside code blocks a line may start a block construct:
+//
+//	## heading      "#" to "######", the markers are removed and the line is drawn in bold
+//	> quote         the markers are removed and every row of the line gets a bar on its left
+//	- item          "-", "*", "+", "1." or "1)", continuation rows are indented under the text
+//
+// Quotes can be nested ("> > text") and contain headings or list items. Tables are blocks too, see table.go.
+// The prefix of a row is a single code word (so adjustableJoin won't touch it) of specialIndent.
+
+const blockMaxListNumber = 9 // digits
+
+type block_t struct {
+	heading int    // level of the heading, 0 means none
+	quote   int    // depth of the quote
+	marker  string // list marker of the first row with its leading spaces, e.g. "  - ", "12. "
+	indent  uint32 // leading indentation of the line, only when Formatter.Indent is set
+	table   *tableRow_t
+	gutter  uint32 // columns taken by the gutter of a numbered row of prose
+	rule    uint32 // column of the rule after the gutter, 0 means none
+}
+
+// parseBlock parses the start of line, skip is the number of bytes of markers which should be discarded.
+// Like leadingWidth, prefixes must be narrower than half of columns: deeper quotes stay in the text and
+// markers after too many spaces are not markers, otherwise continuation rows would have no room left.
+func parseBlock(line []byte, columns uint32) (b block_t, skip int) {
+	i := 0
+	for i < len(line) && line[i] == '>' && uint32(2*(b.quote+1))*2 < columns {
+		b.quote++
+		if i++; i < len(line) && line[i] == ' ' {
+			i++
+		}
+	}
+	skip = i
+
+	h := 0
+	for i+h < len(line) && line[i+h] == '#' {
+		h++
+	}
+	if h >= 1 && h <= 6 && i+h < len(line) && line[i+h] == ' ' {
+		b.heading = h
+		return b, i + h + 1
+	}
+
+	j := i
+	for j < len(line) && line[j] == ' ' {
+		j++
+	}
+	if j >= len(line) {
+		return b, skip
+	}
+
+	k := j
+	switch c := line[j]; {
+	case c == '-' || c == '*' || c == '+':
+		k++
+	case c >= '0' && c <= '9':
+		for k < len(line) && k-j < blockMaxListNumber && line[k] >= '0' && line[k] <= '9' {
+			k++
+		}
+		if k >= len(line) || (line[k] != '.' && line[k] != ')') {
+			return b, skip
+		}
+		k++
+	default:
+		return b, skip
+	}
+
+	if k >= len(line) || line[k] != ' ' {
+		return b, skip
+	}
+	if uint32(2*b.quote+k+1-i)*2 >= columns {
+		return b, skip
+	}
+
+	b.marker = string(line[i : k+1])
+	return b, k + 1
+}
+
+// prefix returns the prefix of the first row
+func (b *block_t) prefix() string {
+	return strings.Repeat("  ", b.quote) + b.marker
+}
+
+// hang returns the width of the prefix of continuation rows
+func (b *block_t) hang() uint32 {
+	return uint32(2*b.quote) + StringWidth(b.marker) + b.indent
+}
+
+// leadingWidth returns the width of the leading spaces of line, 0 if it is too wide to be an indentation
+func leadingWidth(line []byte, columns uint32, tabs int) uint32 {
+	w := uint32(0)
+	for _, r := range string(line) {
+		switch r {
+		case ' ':
+			w++
+		case '\t':
+			w += tabStop(w, tabs)
+		case fullSpace:
+			w += 2
+		default:
+			if w*2 > columns {
+				return 0
+			}
+			return w
+		}
+	}
+	// blank lines
+	return 0
+}
+
+func indentWord(s string) *word_t {
+	return (&word_t{}).setType(runeSpace).setValue([]rune(s)).setLen(StringWidth(s)).setSpecialType(specialIndent).setIsCode()
+}
+
+// drawBlock draws the gutter rule, the quote bars, the heading rules or the table borders of the current row
+func (o *Formatter) drawBlock() {
+	if o.Vertical {
+		return
+	}
+	if o.block.rule > 0 {
+		o.drawGutterRule(o.block.rule)
+	}
+	if o.block.table != nil {
+		o.drawTableRow(o.block.table)
+		return
+	}
+	if o.block.quote == 0 && o.block.heading == 0 {
+		return
+	}
+
+	dx, top := o.Pos.Dx, o.rowTop()
+	left := dx*2 + 2 + int(o.block.gutter)*(dx+1)
+	for k := 0; k < o.block.quote; k++ {
+		x := left + k*2*(dx+1) + dx/2
+		draw.Draw(o.Img.Dst, image.Rect(x, top, x+2, top+o.LineHeight), o.Theme[TNLineWrap], image.Point{}, draw.Src)
+	}
+
+	if h := o.block.heading; h == 1 || h == 2 {
+		tn, y := TNNormal, top+o.LineHeight-2
+		if h == 2 {
+			tn = TNLineWrap
+		}
+		x := left + 2*o.block.quote*(dx+1)
+		draw.Draw(o.Img.Dst, image.Rect(x, y, left+int(o.Columns)*(dx+1), y+1), o.Theme[tn], image.Point{}, draw.Src)
+	}
+}
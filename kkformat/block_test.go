@@ -0,0 +1,117 @@
+package kkformat
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBlock(t *testing.T) {
+	for _, c := range []struct {
+		line   string
+		b      block_t
+		skip   int
+		prefix string
+	}{
+		{"plain", block_t{}, 0, ""},
+		{"## title", block_t{heading: 2}, 3, ""},
+		{"####### seven", block_t{}, 0, ""},
+		{"#hashtag", block_t{}, 0, ""},
+		{"> quote", block_t{quote: 1}, 2, "  "},
+		{">> > deep", block_t{quote: 3}, 5, "      "},
+		{"> # quoted title", block_t{quote: 1, heading: 1}, 4, "  "},
+		{"- item", block_t{marker: "- "}, 2, "- "},
+		{"  * nested", block_t{marker: "  * "}, 4, "  * "},
+		{"> 12. numbered", block_t{quote: 1, marker: "12. "}, 6, "  12. "},
+		{"3) item", block_t{marker: "3) "}, 3, "3) "},
+		{"-1 is not", block_t{}, 0, ""},
+		{"**bold**", block_t{}, 0, ""},
+		{"1234567890. too long", block_t{}, 0, ""},
+		// prefixes are narrower than half of the columns
+		{strings.Repeat(">", 25) + " x", block_t{quote: 19}, 19, strings.Repeat(" ", 38)},
+		{strings.Repeat(" ", 38) + "- x", block_t{}, 0, ""},
+		{strings.Repeat(" ", 37) + "- x", block_t{marker: strings.Repeat(" ", 37) + "- "}, 39, strings.Repeat(" ", 37) + "- "},
+	} {
+		b, skip := parseBlock([]byte(c.line), 80)
+		if b != c.b || skip != c.skip || b.prefix() != c.prefix {
+			t.Errorf("%q: %+v %d %q", c.line, b, skip, b.prefix())
+		}
+	}
+}
+
+func TestRenderHangingIndent(t *testing.T) {
+	fo := testFormatter("- a b c d e f g h i j k l m n o p q r s t u v w x y z a b c d e f g h i j k l m n o p q r", 16*10)
+	fo.Columns = 20
+	res, err := fo.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 38 letters, 9 per row after the "- " prefix
+	if res.Rows != 5 {
+		t.Error(res.Rows)
+	}
+}
+
+func TestRenderDeepBlocks(t *testing.T) {
+	for _, src := range []string{
+		strings.Repeat(" ", 78) + "- " + strings.Repeat("word ", 40),
+		strings.Repeat(">", 39) + " - " + strings.Repeat("text ", 40),
+		strings.Repeat(">", 50) + " " + strings.Repeat("text ", 40),
+	} {
+		done := make(chan error, 1)
+		go func() {
+			_, err := testFormatter(src, 16*100).Render()
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(src[:10], err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal(src[:10], "the render doesn't stop")
+		}
+	}
+
+	// quotes deeper than the spaces of def.go
+	fo := testFormatter(strings.Repeat(">", 60)+" text", 16*10)
+	fo.Columns = 300
+	if _, err := fo.Render(); err != nil {
+		t.Error(err)
+	}
+
+	if words := (&word_t{value: []rune("word"), len: 4}).split(0, 0); words != nil {
+		t.Error(words)
+	}
+}
+
+func TestRenderIndent(t *testing.T) {
+	src := "    a b c d e f g h i j k l m n o p q r s t u v w x y z"
+	for _, c := range []struct {
+		indent bool
+		rows   int
+	}{
+		// 4 + 8 letters in the first row, 10 letters in the others
+		{false, 3},
+		// 4 + 8 letters in every row
+		{true, 4},
+	} {
+		fo := testFormatter(src, 16*10)
+		fo.Columns, fo.Indent = 20, c.indent
+		res, err := fo.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Rows != c.rows {
+			t.Error(c.indent, res.Rows)
+		}
+	}
+
+	if w := leadingWidth([]byte(" \t  x"), 80, 0); w != DefaultTabWidth+2 {
+		t.Error(w)
+	}
+	if w := leadingWidth([]byte("   "), 80, 0); w != 0 {
+		t.Error(w)
+	}
+}
@@ -0,0 +1,93 @@
+package kkformat
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"strconv"
+
+	"golang.org/x/image/math/fixed"
+)
+
+// Callouts are numbers like "<1>" at the end of a line of code, they may follow a comment marker:
+//
+//	fmt.Println(a) // <1>
+//	os.Exit(1)     # <2> <3>
+//
+// They are drawn as numbered badges, the comment markers before them are removed.
+
+const calloutMaxDigits = 2
+
+// calloutAt parses the callout at buf[idx:], skip is the number of bytes it takes (with the comment marker
+// and the spaces before it), 0 if there is none
+func calloutAt(buf []byte, idx int) (n int, skip int) {
+	if idx >= len(buf) || (buf[idx] != '<' && buf[idx] != '/' && buf[idx] != '#') {
+		return 0, 0
+	}
+	if idx > 0 && bytes.IndexByte([]byte(" \t>"), buf[idx-1]) == -1 {
+		return 0, 0
+	}
+
+	line := bytes.TrimRight(lineAt(buf, idx), " \t\r")
+	i := 0
+	if bytes.HasPrefix(line, []byte("//")) {
+		i = 2
+	} else if line[0] == '#' {
+		i = 1
+	}
+	if i > 0 && (i == len(line) || line[i] != ' ') {
+		return 0, 0
+	}
+	for i < len(line) && line[i] == ' ' {
+		i++
+	}
+
+	// the rest of the line must be callouts
+	for j := i; j < len(line); {
+		k := j + 1
+		for k < len(line) && k-j <= calloutMaxDigits && line[k] >= '0' && line[k] <= '9' {
+			k++
+		}
+		if line[j] != '<' || k == j+1 || k >= len(line) || line[k] != '>' {
+			return 0, 0
+		}
+
+		if j == i {
+			n, _ = strconv.Atoi(string(line[j+1 : k]))
+			skip = k + 1
+		}
+		j = k + 1
+		for j < len(line) && line[j] == ' ' {
+			j++
+		}
+	}
+
+	return n, skip
+}
+
+// calloutWord returns the badge of callout n, it is a column wider than the number
+func calloutWord(n int) *word_t {
+	s := strconv.Itoa(n)
+	return (&word_t{}).setType(runeLatin).setValue([]rune(s)).setLen(uint32(len(s)) + 1).setSpecialType(specialCallout).setIsCode()
+}
+
+// drawCallout draws the badge of word at the current position
+func (o *Formatter) drawCallout(word *word_t) {
+	dx, top := o.Pos.Dx, o.rowTop()
+	w := int(word.len) * (dx + 1)
+	x0, y0, x1, y1 := o.Pos.X+1, top+2, o.Pos.X+w-1, top+o.LineHeight-2
+
+	// the corners are left out so the badge looks rounded
+	src := o.Theme[TNComment]
+	draw.Draw(o.Img.Dst, image.Rect(x0+1, y0, x1-1, y1), src, image.Point{}, draw.Src)
+	draw.Draw(o.Img.Dst, image.Rect(x0, y0+1, x1, y1-1), src, image.Point{}, draw.Src)
+
+	o.Img.Src = o.Theme[TNBackground]
+	x := o.Pos.X + (w-len(word.value)*(dx+1))/2
+	for _, r := range word.value {
+		o.Img.Dot = fixed.P(x, o.Pos.Y)
+		o.drawGlyph(o.Img.Dot, r)
+		x += dx + 1
+	}
+	o.Pos.X += w
+}
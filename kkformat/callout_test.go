@@ -0,0 +1,37 @@
+package kkformat
+
+import "testing"
+
+func TestCalloutAt(t *testing.T) {
+	for _, c := range []struct {
+		src     string
+		idx     int
+		n, skip int
+	}{
+		{"f() // <1>", 4, 1, 6},
+		{"f() // <1> <12>", 11, 12, 4},
+		{"f() # <3>  \r\n", 4, 3, 5},
+		{"<2><3>", 0, 2, 3},
+		{"f() // <1> note", 4, 0, 0},
+		{"a <b> c", 2, 0, 0},
+		{"x // <123>", 2, 0, 0},
+		{"a<1>", 1, 0, 0},
+		{"//<1>", 0, 0, 0},
+	} {
+		if n, skip := calloutAt([]byte(c.src), c.idx); n != c.n || skip != c.skip {
+			t.Errorf("%q: %d %d", c.src, n, skip)
+		}
+	}
+
+	s, out := stream_t{buf: []byte("x // <1> <2>"), code: true}, ""
+	for w := s.nextWord(); w != nil; w = s.nextWord() {
+		if w.getSpecialType() == specialCallout {
+			out += "(" + string(w.value) + ")"
+		} else {
+			out += string(w.value)
+		}
+	}
+	if out != "x (1) (2)" {
+		t.Error(out)
+	}
+}
@@ -0,0 +1,48 @@
+package kkformat
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+const cardMaxScale = 3
+
+// Card fits the top of res into a w x h image for link previews. Rows are scaled up to fill the width
+// and cut at the last row that fits, the card keeps the palette of res.Image if it has one.
+func (r *Renderer) Card(res *Result, w, h int) image.Image {
+	src := res.Image
+	b := src.Bounds()
+	margin := h / 12
+
+	// short rows are scaled up further, vertical text starts at the right so it is never cut
+	crop := b
+	if dx := cachedGlyph(r.Face, 'a').advance.Round(); !r.Vertical && res.TextBounds.Max.X+dx*2 < crop.Max.X {
+		crop.Max.X = res.TextBounds.Max.X + dx*2
+	}
+	scale := math.Min(float64(w-margin*2)/float64(crop.Dx()), cardMaxScale)
+	if maxH := int(float64(h-margin*2) / scale); crop.Dy() > maxH {
+		crop.Max.Y = crop.Min.Y + maxH
+		for i := len(res.Lines) - 1; i >= 0; i-- {
+			if l := res.Lines[i]; l.Max.Y <= crop.Max.Y && l.Max.Y > crop.Min.Y {
+				crop.Max.Y = l.Max.Y
+				break
+			}
+		}
+	}
+
+	card := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(card, card.Rect, image.NewUniform(src.At(b.Min.X, b.Min.Y)), image.Point{}, draw.Src)
+	dr := image.Rect(0, 0, int(float64(crop.Dx())*scale), int(float64(crop.Dy())*scale))
+	dr = dr.Add(image.Pt((w-dr.Dx())/2, (h-dr.Dy())/2))
+	xdraw.CatmullRom.Scale(card, dr, src, crop, draw.Src, nil)
+
+	if p, ok := src.(*image.Paletted); ok {
+		dst := image.NewPaletted(card.Rect, p.Palette)
+		draw.Draw(dst, dst.Rect, card, image.Point{}, draw.Src)
+		return dst
+	}
+	return card
+}
@@ -0,0 +1,41 @@
+package kkformat
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestCard(t *testing.T) {
+	rd := &Renderer{Columns: 40, LineHeight: 16, Face: basicfont.Face7x13, Theme: WhiteTheme}
+	for _, src := range []string{"short", strings.Repeat("a long line of text\n", 60)} {
+		dst := image.NewPaletted(image.Rect(0, 0, 400, 16*80), GetPalette())
+		draw.Draw(dst, dst.Rect, rd.Theme[TNBackground], image.Point{}, draw.Src)
+		res, err := rd.Render(context.Background(), dst, []byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		card, ok := rd.Card(res, 1200, 630).(*image.Paletted)
+		if !ok || card.Rect != image.Rect(0, 0, 1200, 630) {
+			t.Fatal(card)
+		}
+
+		// text is inked somewhere, the corners are left blank
+		inked := image.Rectangle{}
+		for y := 0; y < 630; y++ {
+			for x := 0; x < 1200; x++ {
+				if card.ColorIndexAt(x, y) != card.ColorIndexAt(0, 0) {
+					inked = inked.Union(image.Rect(x, y, x+1, y+1))
+				}
+			}
+		}
+		if inked.Empty() || inked.Min.Y < 630/12 || inked.Max.Y > 630-630/12 {
+			t.Error(len(src), inked)
+		}
+	}
+}
@@ -26,10 +26,12 @@ var (
 )
 
 const (
-	tabWidth  = 4
-	fullSpace = '\u3000' // CJK space
+	DefaultTabWidth = 4  // default width of tab stops
+	MaxTabWidth     = 16 // wider tab stops are ignored
 )
 
+const fullSpace = '\u3000' // CJK space
+
 const (
 	runeUnknown = iota
 
@@ -45,6 +47,7 @@ const (
 	runeContToNext
 	runeContFromPrev
 	runeEndOfBuffer
+	runeMark // zero width marks toggling the background of the following runes, see sidebyside.go
 )
 
 const (
@@ -55,9 +58,25 @@ const (
 	specialCommentHash  // "#"
 	specialDoubleQuote  // "\""
 	specialSingleQuote  // "'"
+	specialIndent       // prefix of a row in a block construct, see block.go
+	specialCallout      // numbered badge at the end of a line of code, see callout.go
+
+	// specials below are words in the gutter
 	specialLineNumber
+	specialLineHighlight // line number of a highlighted line
+	specialDiffAdd       // line numbers of an added line
+	specialDiffDel       // line numbers of a removed line
+	specialDiffHunk      // gutter of a hunk header or a file header
 )
 
+// lineBackgrounds maps the special types of gutter words to the backgrounds of their rows
+var lineBackgrounds = map[uint16]int{
+	specialLineHighlight: TNHighlight,
+	specialDiffAdd:       TNDiffAdd,
+	specialDiffDel:       TNDiffDel,
+	specialDiffHunk:      TNDiffHunk,
+}
+
 const (
 	TNBackground = iota
 	TNNormal
@@ -67,6 +86,12 @@ const (
 	TNString
 	TNNumber
 	TNComment
+	TNHighlight
+	TNDiffAdd
+	TNDiffDel
+	TNDiffHunk
+	TNDiffAddWord // changed parts of an added line
+	TNDiffDelWord // changed parts of a removed line
 )
 
 var (
@@ -83,6 +108,12 @@ var WhiteTheme = []image.Image{
 	image.NewUniform(color.RGBA{0x51, 0x2d, 0xa8, 255}),
 	image.NewUniform(color.RGBA{0xff, 0x57, 0x22, 255}),
 	image.NewUniform(color.RGBA{0x00, 0x79, 0x6b, 255}),
+	image.NewUniform(color.RGBA{0xff, 0xf5, 0xc4, 255}),
+	image.NewUniform(color.RGBA{0xe6, 0xff, 0xed, 255}),
+	image.NewUniform(color.RGBA{0xff, 0xee, 0xf0, 255}),
+	image.NewUniform(color.RGBA{0xf1, 0xf8, 0xff, 255}),
+	image.NewUniform(color.RGBA{0xac, 0xf2, 0xbd, 255}),
+	image.NewUniform(color.RGBA{0xfd, 0xb8, 0xc0, 255}),
 }
 
 var PureWhiteTheme = []image.Image{
@@ -94,6 +125,12 @@ var PureWhiteTheme = []image.Image{
 	image.Black,
 	image.Black,
 	image.Black,
+	image.NewUniform(color.RGBA{0xee, 0xee, 0xee, 255}),
+	image.NewUniform(color.RGBA{0xf4, 0xf4, 0xf4, 255}),
+	image.NewUniform(color.RGBA{0xe4, 0xe4, 0xe4, 255}),
+	image.NewUniform(color.RGBA{0xea, 0xea, 0xea, 255}),
+	image.NewUniform(color.RGBA{0xd0, 0xd0, 0xd0, 255}),
+	image.NewUniform(color.RGBA{0xc0, 0xc0, 0xc0, 255}),
 }
 
 var PureBlackTheme = []image.Image{
@@ -105,6 +142,12 @@ var PureBlackTheme = []image.Image{
 	image.White,
 	image.White,
 	image.White,
+	image.NewUniform(color.RGBA{0x33, 0x33, 0x33, 255}),
+	image.NewUniform(color.RGBA{0x1c, 0x1c, 0x1c, 255}),
+	image.NewUniform(color.RGBA{0x2c, 0x2c, 0x2c, 255}),
+	image.NewUniform(color.RGBA{0x24, 0x24, 0x24, 255}),
+	image.NewUniform(color.RGBA{0x3c, 0x3c, 0x3c, 255}),
+	image.NewUniform(color.RGBA{0x4c, 0x4c, 0x4c, 255}),
 }
 
 var BlackTheme = []image.Image{
@@ -116,13 +159,22 @@ var BlackTheme = []image.Image{
 	image.NewUniform(color.RGBA{0x00, 0xbc, 0xd4, 255}),
 	image.NewUniform(color.RGBA{0xff, 0x98, 0x00, 255}),
 	image.NewUniform(color.RGBA{0x00, 0x96, 0x88, 255}),
+	image.NewUniform(color.RGBA{0x3a, 0x36, 0x20, 255}),
+	image.NewUniform(color.RGBA{0x14, 0x3a, 0x22, 255}),
+	image.NewUniform(color.RGBA{0x42, 0x1c, 0x20, 255}),
+	image.NewUniform(color.RGBA{0x16, 0x2a, 0x40, 255}),
+	image.NewUniform(color.RGBA{0x23, 0x6e, 0x3a, 255}),
+	image.NewUniform(color.RGBA{0x7a, 0x2a, 0x31, 255}),
 }
 
 func GetPalette() color.Palette {
 	p := make(color.Palette, 0)
-	for i := 0; i <= TNComment; i++ {
+	for i := range WhiteTheme {
 		p = append(p, WhiteTheme[i].At(0, 0), BlackTheme[i].At(0, 0))
 	}
+	for i := TNHighlight; i < len(PureWhiteTheme); i++ {
+		p = append(p, PureWhiteTheme[i].At(0, 0), PureBlackTheme[i].At(0, 0))
+	}
 	return p
 }
 
@@ -148,7 +200,11 @@ func runeType(r rune) uint16 {
 
 func RuneWidth(r rune) uint32 {
 	if r == '\t' {
-		return tabWidth
+		return DefaultTabWidth
+	}
+
+	if isNarrowRTL(r) {
+		return 1
 	}
 
 	s := string(r)
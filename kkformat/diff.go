@@ -0,0 +1,241 @@
+package kkformat
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// In a ```diff block (or an input detected as a unified diff), the gutter shows the old and new line numbers
+// of every line in hunks, rows of added, removed lines and hunk headers get their own backgrounds.
+
+type diffState struct {
+	old, new int
+	inHunk   bool
+}
+
+// next consumes a line of the diff, returns its kind (a special type) and its old and new line numbers, 0 means none
+func (d *diffState) next(line []byte) (kind uint16, old, new int) {
+	if bytes.HasPrefix(line, []byte("@@ -")) {
+		d.inHunk = false
+		// @@ -old[,n] +new[,n] @@
+		f := bytes.Fields(line)
+		if len(f) >= 3 && len(f[2]) > 1 && f[2][0] == '+' {
+			o, ok1 := diffRangeStart(f[1][1:])
+			n, ok2 := diffRangeStart(f[2][1:])
+			if ok1 && ok2 {
+				d.old, d.new, d.inHunk = o, n, true
+			}
+		}
+		return specialDiffHunk, 0, 0
+	}
+
+	if !d.inHunk {
+		return specialDiffHunk, 0, 0
+	}
+
+	if len(line) == 0 {
+		// some tools strip the trailing space of empty context lines
+		line = []byte{' '}
+	}
+
+	switch line[0] {
+	case ' ':
+		d.old++
+		d.new++
+		return specialLineNumber, d.old - 1, d.new - 1
+	case '-':
+		d.old++
+		return specialDiffDel, d.old - 1, 0
+	case '+':
+		d.new++
+		return specialDiffAdd, 0, d.new - 1
+	case '\\': // \ No newline at end of file
+		return specialLineNumber, 0, 0
+	}
+
+	d.inHunk = false
+	return specialDiffHunk, 0, 0
+}
+
+func diffRangeStart(r []byte) (int, bool) {
+	if idx := bytes.IndexByte(r, ','); idx > -1 {
+		r = r[:idx]
+	}
+	n, err := strconv.Atoi(string(r))
+	return n, err == nil && n >= 0 && n <= fenceMaxStart
+}
+
+// diffGutter returns the width of one column of line numbers in the diff block starting at buf[idx:], it is at least 3
+func diffGutter(buf []byte, idx int) uint32 {
+	d, max := diffState{}, 0
+	for idx < len(buf) && !bytes.HasPrefix(buf[idx:], []byte("```")) {
+		line := lineAt(buf, idx)
+		if _, o, n := d.next(line); o > max || n > max {
+			if max = o; n > o {
+				max = n
+			}
+		}
+		idx += len(line) + 1
+	}
+
+	w := uint32(len(strconv.Itoa(max)))
+	if w < 3 {
+		w = 3
+	}
+	return w
+}
+
+// diffNumbers formats the old and new line numbers of a row, each in w columns
+func diffNumbers(old, new int, w uint32) string {
+	return padNumber(old, w) + " " + padNumber(new, w)
+}
+
+// padNumber right aligns n in w columns, 0 will be blank
+func padNumber(n int, w uint32) string {
+	s := ""
+	if n > 0 {
+		s = strconv.Itoa(n)
+	}
+	if uint32(len(s)) < w {
+		s = spaces[:w-uint32(len(s))] + s
+	}
+	return s
+}
+
+// lineAt returns the line starting at buf[idx:] without "\n"
+func lineAt(buf []byte, idx int) []byte {
+	line := buf[idx:]
+	if end := bytes.IndexByte(line, '\n'); end > -1 {
+		line = line[:end]
+	}
+	return line
+}
+
+// isUnifiedDiff tells whether the whole source looks like the output of "diff -u" or "git diff"
+func isUnifiedDiff(buf []byte) bool {
+	if bytes.Contains(buf, []byte("```")) || !bytes.Contains(buf, []byte("\n@@ -")) {
+		return false
+	}
+
+	first := lineAt(buf, 0)
+	if bytes.HasPrefix(first, []byte("diff ")) || bytes.HasPrefix(first, []byte("Index: ")) {
+		return true
+	}
+
+	if bytes.HasPrefix(first, []byte("--- ")) && len(first) < len(buf) {
+		return bytes.HasPrefix(lineAt(buf, len(first)+1), []byte("+++ "))
+	}
+	return false
+}
+
+// diffMaxD limits the edit distance myersDiff will search, larger differences are reported as "replace all"
+const diffMaxD = 1500
+
+type editOp struct {
+	kind byte // '=', '-' or '+'
+	a, b int  // indexes in the old and new sequences, a is for '=' and '-', b is for '=' and '+'
+}
+
+// myersDiff returns the shortest edit script from a to b, see "An O(ND) Difference Algorithm and Its Variations"
+func myersDiff(a, b []int) []editOp {
+	// common prefix and suffix are trimmed to keep the search small
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := make([]editOp, 0, len(a)+len(b))
+	for i := 0; i < pre; i++ {
+		ops = append(ops, editOp{'=', i, i})
+	}
+	ops = append(ops, myersMiddle(a[pre:len(a)-suf], b[pre:len(b)-suf], pre)...)
+	for i := 0; i < suf; i++ {
+		ops = append(ops, editOp{'=', len(a) - suf + i, len(b) - suf + i})
+	}
+	return ops
+}
+
+func myersMiddle(a, b []int, off int) []editOp {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	// trace[d] holds v[-d..d] before round d
+	v := make([]int, 2*max+2)
+	trace := [][]int{}
+	at := func(w []int, d, k int) int { return w[k+d] }
+
+	for d := 0; d <= max && d <= diffMaxD; d++ {
+		trace = append(trace, append([]int(nil), v[max-d:max+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			x := 0
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+
+			if x < n || y < m {
+				continue
+			}
+
+			// backtrack
+			var ops []editOp
+			for d := len(trace) - 1; d >= 0; d-- {
+				w, k := trace[d], x-y
+				prevK := k - 1
+				if k == -d || (k != d && at(w, d, k-1) < at(w, d, k+1)) {
+					prevK = k + 1
+				}
+
+				prevX := 0
+				if d > 0 {
+					prevX = at(w, d, prevK)
+				}
+				prevY := prevX - prevK
+
+				for x > prevX && y > prevY {
+					x, y = x-1, y-1
+					ops = append(ops, editOp{'=', off + x, off + y})
+				}
+				if d == 0 {
+					break
+				}
+				if x == prevX {
+					y--
+					ops = append(ops, editOp{'+', 0, off + y})
+				} else {
+					x--
+					ops = append(ops, editOp{'-', off + x, 0})
+				}
+			}
+
+			for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+				ops[i], ops[j] = ops[j], ops[i]
+			}
+			return ops
+		}
+	}
+
+	// too different, replace all
+	ops := make([]editOp, 0, n+m)
+	for i := range a {
+		ops = append(ops, editOp{'-', off + i, 0})
+	}
+	for i := range b {
+		ops = append(ops, editOp{'+', 0, off + i})
+	}
+	return ops
+}
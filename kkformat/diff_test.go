@@ -0,0 +1,48 @@
+package kkformat
+
+import "testing"
+
+func TestDiffState(t *testing.T) {
+	d := diffState{}
+	for i, c := range []struct {
+		line     string
+		kind     uint16
+		old, new int
+	}{
+		{"--- a/x.go", specialDiffHunk, 0, 0},
+		{"+++ b/x.go", specialDiffHunk, 0, 0},
+		{"@@ -10,3 +20,4 @@ func main() {", specialDiffHunk, 0, 0},
+		{" a", specialLineNumber, 10, 20},
+		{"-b", specialDiffDel, 11, 0},
+		{"+c", specialDiffAdd, 0, 21},
+		{"+d", specialDiffAdd, 0, 22},
+		{"", specialLineNumber, 12, 23},
+		{`\ No newline at end of file`, specialLineNumber, 0, 0},
+		{"diff --git a/y.go b/y.go", specialDiffHunk, 0, 0},
+		{"-e", specialDiffHunk, 0, 0},
+		{"@@ -1 +1 @@", specialDiffHunk, 0, 0},
+		{"-e", specialDiffDel, 1, 0},
+	} {
+		if kind, old, new := d.next([]byte(c.line)); kind != c.kind || old != c.old || new != c.new {
+			t.Error(i, c.line, kind, old, new)
+		}
+	}
+
+	if s := diffNumbers(0, 1234, 4); s != "     1234" {
+		t.Errorf("%q", s)
+	}
+}
+
+func TestIsUnifiedDiff(t *testing.T) {
+	for in, out := range map[string]bool{
+		"diff --git a/x b/x\nindex 1..2\n--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b\n": true,
+		"--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b":                                   true,
+		"--- a/x\n+++ b/x\n-a\n+b":                                                false,
+		"--- \nhello\n@@ -1 +1 @@":                                                false,
+		"```diff\n--- a/x\n+++ b/x\n@@ -1 +1 @@\n```":                             false,
+	} {
+		if isUnifiedDiff([]byte(in)) != out {
+			t.Errorf("%q", in)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package kkformat
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/image/math/fixed"
+)
+
+// Outside code blocks a small inline markup is recognized:
+//
+//	**bold** _italic_ `code` ~~strike~~
+//
+// Markers are consumed by stream_t and never become words, every word carries the styles it is in,
+// so the column count and the justification are not affected. A marker is only recognized when
+// its closing one can be found in the same line, otherwise it is an ordinary character.
+
+const (
+	styleBold uint8 = 1 << iota
+	styleItalic
+	styleCode
+	styleStrike
+)
+
+var emphasisMarkers = []struct {
+	marker string
+	style  uint8
+}{
+	{"**", styleBold},
+	{"~~", styleStrike},
+	{"_", styleItalic},
+	{"`", styleCode},
+}
+
+func isBlank(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == fullSpace || r == utf8.RuneError
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// emphasisAt returns the style toggled by the marker at buf[idx:] and the length of the marker, 0 if there is none,
+// open holds the styles currently in effect
+func emphasisAt(buf []byte, idx int, open uint8) (style uint8, n int) {
+	var m string
+	for _, e := range emphasisMarkers {
+		if bytes.HasPrefix(buf[idx:], []byte(e.marker)) {
+			m, style = e.marker, e.style
+			break
+		}
+	}
+
+	if m == "" || (open&styleCode != 0 && style != styleCode) {
+		// nothing is recognized in `code`
+		return 0, 0
+	}
+
+	if open&style != 0 {
+		if emphasisCanClose(buf, idx, m) {
+			return style, len(m)
+		}
+		return 0, 0
+	}
+
+	prev, _ := utf8.DecodeLastRune(buf[:idx])
+	next, _ := utf8.DecodeRune(buf[idx+len(m):])
+	switch style {
+	case styleCode:
+		if prev == '`' || next == '`' {
+			// ``` and ``, leave them alone
+			return 0, 0
+		}
+	case styleItalic:
+		if isBlank(next) || isWordRune(prev) {
+			// snake_case
+			return 0, 0
+		}
+	default:
+		if isBlank(next) {
+			return 0, 0
+		}
+	}
+
+	for j := idx + len(m) + 1; j < len(buf) && buf[j] != '\n'; j++ {
+		if bytes.HasPrefix(buf[j:], []byte(m)) && emphasisCanClose(buf, j, m) {
+			return style, len(m)
+		}
+	}
+	return 0, 0
+}
+
+// emphasisCanClose tells whether the marker m at buf[idx:] can close its style
+func emphasisCanClose(buf []byte, idx int, m string) bool {
+	prev, _ := utf8.DecodeLastRune(buf[:idx])
+	next, _ := utf8.DecodeRune(buf[idx+len(m):])
+	switch m {
+	case "`":
+		return prev != '`' && next != '`'
+	case "_":
+		return !isBlank(prev) && !isWordRune(next)
+	default:
+		return !isBlank(prev)
+	}
+}
+
+// nextEmphasis consumes the marker at the current position and toggles its style
+func (s *stream_t) nextEmphasis() bool {
+	style, n := emphasisAt(s.buf, s.idx, s.style)
+	if n == 0 {
+		return false
+	}
+	s.style ^= style
+	s.idx += n
+	return true
+}
+
+// styleTN returns the color of a word with styles outside code blocks
+func (w *word_t) styleTN() int {
+	switch {
+	case w.style&styleCode != 0:
+		return TNString
+	case w.style&styleStrike != 0:
+		return TNSymbol
+	}
+	return TNNormal
+}
+
+// drawStrike draws a line through the current line from x0 to x1
+func (o *Formatter) drawStrike(x0, x1 int) {
+	y := o.Pos.Y - o.metrics().Ascent.Ceil()/3
+	draw.Draw(o.Img.Dst, image.Rect(x0, y, x1, y+1), o.Img.Src, image.Point{}, draw.Over)
+}
+
+// drawStyledGlyph draws g at dr with synthetic bold (drawn twice) and oblique (rows sheared to the right) styles
+func (o *Formatter) drawStyledGlyph(g *glyph_t, dr image.Rectangle, dot fixed.Point26_6) {
+	drawRow := func(dr image.Rectangle, mp image.Point) {
+		draw.DrawMask(o.Img.Dst, dr, o.Img.Src, image.Point{}, g.mask, mp, draw.Over)
+		if o.style&styleBold != 0 {
+			draw.DrawMask(o.Img.Dst, dr.Add(image.Pt(1, 0)), o.Img.Src, image.Point{}, g.mask, mp, draw.Over)
+		}
+	}
+
+	if o.style&styleItalic == 0 {
+		drawRow(dr, g.maskp)
+		return
+	}
+
+	base := dot.Y.Round()
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		shift := (base - y) / 4
+		row := image.Rect(dr.Min.X+shift, y, dr.Max.X+shift, y+1)
+		drawRow(row, g.maskp.Add(image.Pt(0, y-dr.Min.Y)))
+	}
+}
@@ -0,0 +1,38 @@
+package kkformat
+
+import "testing"
+
+func TestEmphasis(t *testing.T) {
+	for _, c := range []struct {
+		src  string
+		want string // <n> marks where the style changes to n
+	}{
+		{"a **b c** d", "a <1>b c<0> d"},
+		{"_x_ `y_z` ~~w~~", "<2>x<0> <4>y_z<0> <8>w<0>"},
+		{"**`k`**", "<5>k<0>"},
+		{"snake_case_name", "snake_case_name"},
+		{"2 * 3 ** 4 and _ alone", "2 * 3 ** 4 and _ alone"},
+		{"**unclosed\nbold**", "**unclosed\nbold**"},
+		{"```go", "```go"},
+		{"`a **b** c`", "<4>a **b** c<0>"},
+	} {
+		s, out, style := stream_t{buf: []byte(c.src)}, "", uint8(0)
+		for w := s.nextWord(); w != nil; w = s.nextWord() {
+			if w.getType() == runeNewline {
+				out += "\n"
+				continue
+			}
+			if w.style != style && w.getType() != runeEndOfBuffer {
+				style = w.style
+				out += "<" + string(rune('0'+style)) + ">"
+			}
+			out += string(w.value)
+		}
+		if style != 0 {
+			out += "<0>"
+		}
+		if out != c.want {
+			t.Errorf("%q: %q", c.src, out)
+		}
+	}
+}
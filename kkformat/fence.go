@@ -0,0 +1,156 @@
+package kkformat
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+const fenceMaxStart = 999999999
+
+// fence_t holds the options of a code block, they are written after the opening "```":
+//
+//	```go:120 {3,7-9}
+//
+// "go" is the language, line numbers start from 120, lines 3 and 7 to 9 (as shown in the gutter) will be highlighted.
+// "tabs=8" sets the width of tab stops in the block, "nums=", "every=" and "rule=" customise the gutter, see Gutter.
+type fence_t struct {
+	lang       string
+	start      int
+	highlights [][2]int
+	tabs       int
+	nums, rule int8 // 1 on, -1 off, 0 follows Formatter.Gutter
+	every      int
+}
+
+func parseFence(info string) fence_t {
+	f := fence_t{start: 1}
+
+	for i, tok := range strings.Fields(info) {
+		if strings.HasPrefix(tok, "{") && strings.HasSuffix(tok, "}") {
+			f.highlights = append(f.highlights, parseLineRanges(tok[1:len(tok)-1])...)
+			continue
+		}
+
+		if strings.HasPrefix(tok, "tabs=") {
+			if n, err := strconv.Atoi(tok[5:]); err == nil && n > 0 && n <= MaxTabWidth {
+				f.tabs = n
+			}
+			continue
+		}
+
+		if idx := strings.IndexByte(tok, '='); idx > 0 {
+			switch k, v := tok[:idx], tok[idx+1:]; k {
+			case "nums":
+				f.nums = parseSwitch(v)
+			case "rule":
+				f.rule = parseSwitch(v)
+			case "every":
+				if n, err := strconv.Atoi(v); err == nil && n > 0 {
+					f.every = n
+				}
+			}
+			continue
+		}
+
+		if i > 0 {
+			continue
+		}
+
+		f.lang = tok
+		if idx := strings.LastIndexByte(tok, ':'); idx > -1 {
+			if n, err := strconv.Atoi(tok[idx+1:]); err == nil && n >= 0 && n <= fenceMaxStart {
+				f.lang, f.start = tok[:idx], n
+			}
+		}
+	}
+
+	return f
+}
+
+// parseLineRanges parses "3,7-9" into [3, 3], [7, 9], invalid parts are ignored
+func parseLineRanges(s string) [][2]int {
+	var ranges [][2]int
+	for _, part := range strings.Split(s, ",") {
+		a, b := part, part
+		if idx := strings.IndexByte(part, '-'); idx > 0 {
+			a, b = part[:idx], part[idx+1:]
+		}
+
+		from, err1 := strconv.Atoi(strings.TrimSpace(a))
+		to, err2 := strconv.Atoi(strings.TrimSpace(b))
+		if err1 != nil || err2 != nil || from > to {
+			continue
+		}
+		ranges = append(ranges, [2]int{from, to})
+	}
+	return ranges
+}
+
+func (f *fence_t) isHighlighted(lineNo int) bool {
+	for _, r := range f.highlights {
+		if lineNo >= r[0] && lineNo <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// gutterWidth returns the width of the line numbers of a block which has n lines, it is at least 3
+func (f *fence_t) gutterWidth(n int) uint32 {
+	if n < 1 {
+		n = 1
+	}
+
+	w := uint32(len(strconv.Itoa(f.start + n - 1)))
+	if w < 3 {
+		w = 3
+	}
+	return w
+}
+
+// countFenceLines counts the lines from buf[idx:] to the closing "```" or the end of buf
+func countFenceLines(buf []byte, idx int) int {
+	n := 0
+	for idx < len(buf) {
+		if bytes.HasPrefix(buf[idx:], []byte("```")) {
+			break
+		}
+
+		n++
+		next := bytes.IndexByte(buf[idx:], '\n')
+		if next == -1 {
+			break
+		}
+		idx += next + 1
+	}
+	return n
+}
+
+// drawLineBackground fills the background of the current line (or column in vertical mode) with o.Theme[tn]
+func (o *Formatter) drawLineBackground(tn int) {
+	var rect image.Rectangle
+	if o.Vertical {
+		_, colW, bandH, _ := o.verticalGeometry()
+		x, y := o.columnOrigin(o.Rows - 1)
+		rect = image.Rect(x, y, x+colW, y+bandH)
+	} else {
+		top := o.rowTop()
+		rect = image.Rect(0, top, o.Img.Dst.Bounds().Dx(), top+o.LineHeight)
+	}
+	draw.Draw(o.Img.Dst, rect, o.Theme[tn], image.Point{}, draw.Src)
+}
+
+// drawRuneBackground fills the background of the cell starting at x, w pixels wide, in the current line
+func (o *Formatter) drawRuneBackground(x, w int, tn int) {
+	top := o.rowTop()
+	draw.Draw(o.Img.Dst, image.Rect(x, top, x+w, top+o.LineHeight), o.Theme[tn], image.Point{}, draw.Src)
+}
+
+// rowTop returns the top of the current line in horizontal mode
+func (o *Formatter) rowTop() int {
+	m := o.metrics()
+	return o.Pos.Y - m.Ascent.Ceil() - (o.LineHeight-m.Ascent.Ceil()-m.Descent.Ceil())/2
+}
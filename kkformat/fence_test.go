@@ -0,0 +1,69 @@
+package kkformat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFence(t *testing.T) {
+	f := parseFence("go:120 {3,7-9,x,5-4}")
+	if f.lang != "go" || f.start != 120 || len(f.highlights) != 2 {
+		t.Fatal(f)
+	}
+	for n, hl := range map[int]bool{3: true, 4: false, 7: true, 9: true, 10: false} {
+		if f.isHighlighted(n) != hl {
+			t.Error(n)
+		}
+	}
+
+	if f := parseFence("c:99999999999"); f.lang != "c:99999999999" || f.start != 1 {
+		t.Error(f)
+	}
+	if f := parseFence(""); f.lang != "" || f.start != 1 {
+		t.Error(f)
+	}
+	if w := (&fence_t{start: 995}).gutterWidth(10); w != 4 {
+		t.Error(w)
+	}
+	if f := parseFence("make tabs=8"); f.lang != "make" || f.tabs != 8 {
+		t.Error(f)
+	}
+	if f := parseFence("make tabs=99"); f.tabs != 0 {
+		t.Error(f)
+	}
+}
+
+func TestTabStops(t *testing.T) {
+	for _, c := range []struct {
+		src  string
+		tabs int
+		want string
+	}{
+		{"a\tb", 0, "a   b"},
+		{"abcd\tb", 0, "abcd    b"},
+		{"ab \t\tc", 8, "ab              c"},
+		{"x\n\ty", 2, "x  y"},
+		{"**x**\ty\tz", 0, "x   y   z"},
+		{"x\x01\ty\tz", 0, "x   y   z"},
+	} {
+		s, out := stream_t{buf: []byte(c.src), tabs: c.tabs}, ""
+		for w := s.nextWord(); w != nil; w = s.nextWord() {
+			out += string(w.value)
+		}
+		if out != c.want {
+			t.Errorf("%q: %q", c.src, out)
+		}
+	}
+}
+
+func TestRenderLongCode(t *testing.T) {
+	src := "```\n" + strings.Repeat("x := 1\n", 1200) + "```\n```:99999999 {99999999}\ny\n```\n"
+	fo := testFormatter(src, 30000)
+	res, err := fo.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rows <= 1200 || res.Truncated {
+		t.Error(res.Rows, res.Truncated)
+	}
+}
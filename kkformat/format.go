@@ -1,10 +1,12 @@
 package kkformat
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"image"
-	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"golang.org/x/image/font"
@@ -14,6 +16,12 @@ type stream_t struct {
 	buf       []byte
 	idx       int
 	beforeEnd bool
+	code      bool   // inside a code block, no ruby will be recognized
+	rtl       bool   // the current paragraph is right to left
+	marks     bool   // markOn and markOff will be recognized
+	style     uint8  // inline styles in effect
+	tabs      int    // width of tab stops, 0 means DefaultTabWidth
+	col       uint32 // column of the next word in the current line
 }
 
 func (s *stream_t) nextRune() (rune, int) {
@@ -48,6 +56,30 @@ func (s *stream_t) nextRuneIsEndOfLine() (bool, int) {
 }
 
 func (s *stream_t) nextWord() *word_t {
+	if s.idx == 0 || (s.idx < len(s.buf) && s.buf[s.idx-1] == '\n') {
+		s.rtl = paragraphIsRTL(s.buf[s.idx:])
+	}
+
+	w := s.nextWordImpl()
+	if w != nil {
+		if w.getType() == runeNewline {
+			s.col = 0
+		} else {
+			s.col += w.len
+		}
+	}
+	if w != nil && s.rtl {
+		w.setIsRTL()
+	}
+	if w != nil && !s.code {
+		w.style = s.style
+	}
+	return w
+}
+
+func (s *stream_t) nextWordImpl() *word_t {
+	// markers and unknown runes are skipped here, not by calling nextWord again, which would count s.col twice
+AGAIN:
 	if s.beforeEnd {
 		return nil
 	}
@@ -57,6 +89,31 @@ func (s *stream_t) nextWord() *word_t {
 		return (&word_t{}).setType(runeEndOfBuffer)
 	}
 
+	if !s.code {
+		if s.nextEmphasis() {
+			goto AGAIN
+		}
+		if s.style&styleCode == 0 {
+			if ruby := s.nextRuby(); ruby != nil {
+				return ruby
+			}
+		}
+	}
+
+	if s.code && !s.marks {
+		if n, skip := calloutAt(s.buf, s.idx); skip > 0 {
+			s.idx += skip
+			return calloutWord(n)
+		}
+	}
+
+	if s.marks {
+		if r, w := s.nextRune(); r == markOn || r == markOff {
+			s.idx += w
+			return (&word_t{}).setType(runeMark).setValue([]rune{r})
+		}
+	}
+
 	pp, p := s.prevprevRune()
 	r, w := s.nextRune()
 	s.idx += w
@@ -66,7 +123,7 @@ func (s *stream_t) nextWord() *word_t {
 		if r != '\r' {
 			//fmt.Println("unknown:", string(r), "=", r)
 		}
-		return s.nextWord()
+		goto AGAIN
 	}
 
 	ret := (&word_t{}).setType(t)
@@ -108,13 +165,11 @@ func (s *stream_t) nextWord() *word_t {
 		return false
 	}
 
-	icSpace := func(r rune) string {
+	// icSpace expands r at column col
+	icSpace := func(r rune, col uint32) string {
 		switch r {
 		case '\t':
-			if tabWidth < 16 {
-				return "                "[:tabWidth]
-			}
-			return strings.Repeat(" ", tabWidth)
+			return spaces[:s.tabStop(col)]
 		case fullSpace:
 			return "  "
 		default:
@@ -128,12 +183,17 @@ func (s *stream_t) nextWord() *word_t {
 			if r == '/' || r == '*' || r == '#' || r == '"' || r == '\'' || r == '\\' {
 				break
 			}
+			if !s.code {
+				if _, n := emphasisAt(s.buf, s.idx, s.style); n > 0 {
+					break
+				}
+			}
 
 			s.idx += w
 
 			if runeType(r) == t {
 				if t == runeSpace {
-					sp := icSpace(r)
+					sp := icSpace(r, s.col+ret.len)
 					ret.value = append(ret.value, []rune(sp)...)
 					ret.len += StringWidth(sp)
 				} else {
@@ -154,9 +214,10 @@ func (s *stream_t) nextWord() *word_t {
 
 	switch t {
 	case runeNewline:
+		s.style = 0
 		return ret // len = 0
 	case runeSpace:
-		sp := icSpace(r)
+		sp := icSpace(r, s.col)
 		ret.value = []rune(sp)
 		ret.len = StringWidth(sp)
 		keepReading()
@@ -164,6 +225,11 @@ func (s *stream_t) nextWord() *word_t {
 		ret.value = []rune{r}
 		ret.len = RuneWidth(r)
 		keepReading()
+
+		if r >= 0x0600 && r <= 0x06ff {
+			ret.value = shapeArabic(ret.value)
+			ret.len = StringWidth(ret.value)
+		}
 	default:
 		ret.value = []rune{r}
 		ret.len = RuneWidth(r)
@@ -172,12 +238,24 @@ func (s *stream_t) nextWord() *word_t {
 	return ret
 }
 
-func splitRune(in []rune, at uint32) ([]rune, []rune, bool) {
+// tabStop returns the width of a tab at column col
+func (s *stream_t) tabStop(col uint32) uint32 {
+	return tabStop(col, s.tabs)
+}
+
+func tabStop(col uint32, tabs int) uint32 {
+	if tabs <= 0 || tabs > MaxTabWidth {
+		tabs = DefaultTabWidth
+	}
+	return uint32(tabs) - col%uint32(tabs)
+}
+
+func splitRune(in []rune, at uint32) ([]rune, []rune, bool, error) {
 	a := at
 	for i := 0; i < len(in); i++ {
 		w := RuneWidth(in[i])
 		if w == at {
-			return in[:i+1], in[i+1:], true
+			return in[:i+1], in[i+1:], true, nil
 		}
 
 		if w < at {
@@ -185,13 +263,13 @@ func splitRune(in []rune, at uint32) ([]rune, []rune, bool) {
 			continue
 		}
 
-		return in[:i], in[i:], false
+		return in[:i], in[i:], false, nil
 	}
 
-	panic(string(in) + " " + strconv.Itoa(int(a)))
+	return nil, nil, false, fmt.Errorf("kkformat: can't split %q at %d", string(in), a)
 }
 
-// Formatter struct
+// Formatter holds the state of a single rendering, it can't be used concurrently, see Renderer
 type Formatter struct {
 	Source     []byte // source buffer of the input
 	Columns    uint32 // columns of the output
@@ -202,7 +280,23 @@ type Formatter struct {
 	Pos        struct {
 		X, Y, Dx int
 	}
-	Theme []image.Image
+	Theme    []image.Image
+	Hyphens  *Hyphenator // optional, breaks Latin words at syllables instead of leaving wide gaps
+	Vertical bool        // vertical writing mode, lines become columns laid from right to left
+	Indent   bool        // wrapped rows inherit the leading indentation of their lines
+	TabWidth int         // width of tab stops, 0 means 4, it can be changed by code blocks like "```make tabs=8"
+	Gutter   Gutter      // line numbers, see gutter.go
+	ruby     bool        // source contains ruby annotations, columns need extra space in vertical mode
+	bidi     []bidiGlyph // positions of runes of the current line, nil if no reordering is needed
+	bidiIdx  int
+	bidiEnd  int
+	Budget   Budget            // limits of a single rendering
+	maxX     int               // the right edge of the widest line
+	rows     []image.Rectangle // see Result.Lines
+	pane     *pane_t
+	marking  bool    // runes are between markOn and markOff
+	style    uint8   // inline styles of the word being drawn
+	block    block_t // block construct of the row being drawn
 
 	wp words_t // for a single line, wp holds the content whose spaces have been processed
 	wd words_t // for a single line, wd holds the delimeters in it
@@ -215,62 +309,259 @@ func (o *Formatter) resetPDL() {
 	o.wl = o.wl[:0]
 }
 
-// Render renders the content into an image
-func (o *Formatter) Render() image.Image {
+// Budget limits the resources a single rendering can take, zero values mean no limit
+type Budget struct {
+	MaxRunes    int           // runes after this will be discarded
+	MaxRows     int           // rows after this will be discarded
+	MaxDuration time.Duration // the rendering will be aborted after this
+}
+
+// Render renders the content into an image, if the content is too long to fit into the image,
+// it will be truncated and the result will be marked
+func (o *Formatter) Render() (*Result, error) {
+	return o.RenderContext(context.Background())
+}
+
+// RenderContext is like Render but aborts with ctx.Err() once ctx is done or Budget.MaxDuration is exceeded
+func (o *Formatter) RenderContext(ctx context.Context) (res *Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, fmt.Errorf("kkformat: render failed at row %d: %v", o.Rows, r)
+		}
+	}()
+
+	if o.Budget.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Budget.MaxDuration)
+		defer cancel()
+	}
+
+	res = &Result{}
+	res.Image, err = o.render(ctx, res)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Rows, res.Lines = o.Rows, o.rows
+	res.TextBounds = res.Image.Bounds()
+	if !o.Vertical {
+		res.TextBounds.Min.X, res.TextBounds.Max.X = o.Pos.Dx*2, o.maxX
+	}
+	return res, nil
+}
+
+func (o *Formatter) render(ctx context.Context, res *Result) (image.Image, error) {
+	src := o.Source
+	if max := o.Budget.MaxRunes; max > 0 && utf8.RuneCount(src) > max {
+		for i := range string(src) {
+			if max--; max < 0 {
+				src = src[:i]
+				break
+			}
+		}
+		res.Truncated = true
+		res.Warnings = append(res.Warnings, fmt.Sprintf("source truncated at %d runes", o.Budget.MaxRunes))
+	}
+
+	if o.pane == nil && isUnifiedDiff(src) {
+		src = append(append([]byte("```diff\n"), src...), "\n```"...)
+	}
+
 	// Init Formatter
-	o.wp, o.wd, o.wl = make(words_t, 0, 32), make(words_t, 0, 32), make(words_t, 0, 32)
-	o.Pos.Dx = (int(o.Img.MeasureString("a")) >> 6)
-	ws := stream_t{buf: o.Source}
+	if o.wp == nil {
+		o.wp, o.wd, o.wl = make(words_t, 0, 32), make(words_t, 0, 32), make(words_t, 0, 32)
+	}
+	o.Pos.Dx = int(o.glyph('a').advance >> 6)
+	o.rows = nil
+	o.ruby = bytes.ContainsRune(src, rubyOpen)
+	ws := stream_t{buf: src, marks: o.pane != nil, tabs: o.TabWidth}
 
 	line, length, lineNo := make(words_t, 0, 10), uint32(0), 0
 	nobrk := false
 	cont := true
 	nextWordIsNaturalStart := true
+	var renderErr error
+	fence, gutter, rowSpecial := fence_t{}, uint32(0), uint16(specialLineNumber)
+	codeIndent := uint32(0) // leading indentation of the current line of code
+	diff := diffState{}
+	block := block_t{}
+	numbers := o.Gutter // gutter options of the current code block
+
+	// lines of prose are numbered in a gutter of proseGutter columns and a space
+	proseGutter, lead := uint32(0), uint32(0)
+	if o.Gutter.Prose && o.pane == nil {
+		proseGutter = (&fence_t{start: 1}).gutterWidth(bytes.Count(src, []byte{'\n'}) + 1)
+		if lead = proseGutter + 1; lead >= o.Columns/2 {
+			proseGutter, lead = 0, 0
+			res.Warnings = append(res.Warnings, "too many lines to number the prose")
+		}
+	}
+	srcLine, srcLineIdx := 1, 0 // src[srcLineIdx] is at line srcLine
+	lineOf := func(idx int) int {
+		srcLine += bytes.Count(src[srcLineIdx:idx], []byte{'\n'})
+		srcLineIdx = idx
+		return srcLine
+	}
 
 	insertlineNo := func() {
+		if o.Indent {
+			codeIndent = leadingWidth(lineAt(ws.buf, ws.idx), o.Columns-gutter-1, ws.tabs)
+		}
+
+		var s string
+		if o.pane != nil {
+			s, rowSpecial = o.pane.gutter(lineNo)
+		} else if fence.lang == "diff" {
+			var old, new int
+			rowSpecial, old, new = diff.next(lineAt(ws.buf, ws.idx))
+			s = diffNumbers(old, new, (gutter-1)/2)
+		} else {
+			s = numbers.number(fence.start + lineNo)
+			rowSpecial = specialLineNumber
+			if fence.isHighlighted(fence.start + lineNo) {
+				rowSpecial = specialLineHighlight
+			}
+		}
 		lineNo++
-		s := strconv.Itoa(lineNo)
-		num := (&word_t{}).setType(runeLatin).setValue([]rune(s)).setLen(uint32(len(s))).setSpecialType(specialLineNumber)
-		space := spaceWord.dup().setIsCode()
-
-		switch len(s) {
-		case 1:
-			line = append(line, space, space, num, space)
-		case 2:
-			line = append(line, space, num, space)
-		case 3:
-			line = append(line, num, space)
-		default:
-			panic("?")
+
+		if gutter == 0 {
+			// line numbers are hidden, the empty word only carries the background of the row
+			line = append(line, (&word_t{}).setType(runeSpace).setSpecialType(rowSpecial).setIsCode())
+			length = 0
+			return
 		}
 
-		length = 4
+		num := (&word_t{}).setType(runeLatin).setValue([]rune(s)).setLen(uint32(len(s))).setSpecialType(rowSpecial).setIsCode()
+		if n := uint32(len(s)); n < gutter {
+			pad := gutter - n
+			line = append(line, (&word_t{}).setType(runeSpace).setValue([]rune(spaces[:pad])).setLen(pad).setIsCode())
+		}
+		line = append(line, num, spaceWord.dup().setIsCode())
+		length = gutter + 1
 	}
 
 	appendReset := func() {
 		// lines = append(lines, line)
 		last := line.last()
-		cont = line.adjustableJoin(o)
+		wrapped := last != nil && last.getType() != runeNewline && last.getType() != runeEndOfBuffer
+		if err := ctx.Err(); err != nil {
+			renderErr, cont = err, false
+		} else if o.Budget.MaxRows > 0 && o.Rows >= o.Budget.MaxRows {
+			cont = false
+		} else if cont {
+			o.block = block
+			if wrapped {
+				// the rule of a heading is drawn under its last row
+				o.block.heading = 0
+			}
+			if nobrk && numbers.Rule && gutter > 0 {
+				o.block.rule = gutter
+			} else if !nobrk && lead > 0 && len(line) > 0 && (line.leading() > 0 || line[0].getType() == runeContFromPrev) {
+				// the empty row at the end of the source has no gutter
+				if o.block.gutter = lead; o.Gutter.Rule {
+					o.block.rule = proseGutter
+				}
+			}
+			cont = line.adjustableJoin(o)
+			o.block = block_t{}
+		}
 
 		line = line[:0]
 		if last != nil && last.getType() == runeContToNext {
 			line = append(line, lineContFrom)
 			if nobrk {
-				line = append(line, (&word_t{}).setType(runeSpace).setValue([]rune("    ")).setLen(4).setIsCode())
-				length = 4
+				n := codeIndent
+				if gutter > 0 {
+					n += gutter + 1
+				}
+				indent := (&word_t{}).setType(runeSpace).setValue([]rune(strings.Repeat(" ", int(n)))).setLen(n).setIsCode()
+				if rowSpecial != specialLineNumber {
+					indent.setSpecialType(rowSpecial)
+				}
+				line = append(line, indent)
+				length = n
 			}
 		} else if nobrk {
 			insertlineNo()
 		}
+
+		if !nobrk && wrapped && lead > 0 {
+			line = append(line, gutterWord("", proseGutter))
+			length = lead
+		}
+		if !nobrk && wrapped && block.hang() > 0 {
+			line = append(line, indentWord(strings.Repeat(" ", int(block.hang()))))
+			length = lead + block.hang()
+		}
+	}
+
+	// startBlock is called at the start of every line of prose
+	startBlock := func() {
+		block = block_t{}
+		if nobrk || o.pane != nil {
+			return
+		}
+
+		for cont {
+			t, n := parseTable(ws.buf, ws.idx)
+			if t == nil || !t.layout(o.Columns-lead) {
+				break
+			}
+
+			lines, rows := t.lines()
+			for i := 0; i < len(lines) && cont; i++ {
+				if line = line[:0]; lead > 0 {
+					line = append(line, gutterWord("", proseGutter))
+				}
+				block, line = block_t{table: rows[i]}, append(append(line, lines[i]...), newLine.dup())
+				appendReset()
+			}
+			block, length = block_t{}, 0
+			ws.idx += n
+		}
+
+		if lead > 0 && ws.idx < len(ws.buf) {
+			line = append(line, gutterWord(o.Gutter.number(lineOf(ws.idx)), proseGutter))
+			length = lead
+		}
+
+		var skip int
+		block, skip = parseBlock(lineAt(ws.buf, ws.idx), o.Columns-lead)
+		if o.Indent && block.marker == "" && block.heading == 0 {
+			block.indent = leadingWidth(lineAt(ws.buf, ws.idx+skip), o.Columns-lead-block.hang(), ws.tabs)
+		}
+		if skip == 0 {
+			return
+		}
+
+		ws.idx += skip
+		ws.rtl = paragraphIsRTL(ws.buf[ws.idx:])
+		if block.heading > 0 {
+			ws.style = styleBold
+		}
+		if p := block.prefix(); p != "" {
+			line = append(line, indentWord(p))
+			ws.col = StringWidth(p)
+			length = lead + ws.col
+		}
+	}
+
+	if o.pane != nil {
+		// a pane is a single code block without fences
+		nobrk, ws.code, gutter = true, true, o.pane.gutterW
+		insertlineNo()
 	}
+	startBlock()
 
 	_ = fmt.Println
 	var lastWord *word_t
 	for t := ws.nextWord(); t != nil && cont; t = ws.nextWord() {
 		// fmt.Println(string(t.value), t.getType(), t.getSpecialType(), ws.idx)
 
-		if t.startsWith("```") && (lastWord == nil || lastWord.getType() == runeNewline) {
+		if o.pane == nil && t.startsWith("```") && (lastWord == nil || lastWord.getType() == runeNewline) {
 			nobrk = !nobrk
+			ws.code = nobrk
+			infoStart := bytes.LastIndexByte(src[:ws.idx], '\n') + 1 + 3
 
 			for t := ws.nextWord(); t != nil; t = ws.nextWord() {
 				if t.getType() == runeNewline || t.getType() == runeEndOfBuffer {
@@ -280,9 +571,29 @@ func (o *Formatter) Render() image.Image {
 
 			line = line[:0]
 
+			ws.tabs = o.TabWidth
 			if nobrk {
+				fence = parseFence(string(src[infoStart:ws.idx]))
+				numbers = fence.gutterOf(o.Gutter)
+				if fence.tabs > 0 {
+					ws.tabs = fence.tabs
+				}
+				gutter = fence.gutterWidth(countFenceLines(src, ws.idx))
+				if fence.lang == "diff" {
+					diff, gutter = diffState{}, diffGutter(src, ws.idx)*2+1
+				}
+				if gutter+1 >= o.Columns/2 {
+					// the gutter is too wide to leave any room for the code
+					fence.start, gutter = 1, fence.gutterWidth(1)
+					res.Warnings = append(res.Warnings, "line numbers are too long, numbering from 1")
+				}
+				if numbers.Hide {
+					gutter = 0
+				}
 				lineNo = 0
 				insertlineNo()
+			} else {
+				startBlock()
 			}
 			continue
 		}
@@ -303,7 +614,10 @@ func (o *Formatter) Render() image.Image {
 					if len1 > 0 {
 						t2 := t.dup()
 						var perfect bool
-						t2.value, t.value, perfect = splitRune(t.value, len1)
+						if t2.value, t.value, perfect, renderErr = splitRune(t.value, len1); renderErr != nil {
+							cont = false
+							return
+						}
 						t2.len, t.len = StringWidth(t2.value), StringWidth(t.value)
 						line = append(line, t2)
 						if !perfect {
@@ -322,6 +636,8 @@ func (o *Formatter) Render() image.Image {
 
 					if y, _ := ws.nextRuneIsEndOfLine(); y {
 						lastWord = ws.nextWord()
+						line, length = line[:0], 0
+						startBlock()
 					}
 					return
 				}
@@ -330,7 +646,7 @@ func (o *Formatter) Render() image.Image {
 					last := line[len(line)-1]
 					line = line[:len(line)-1]
 					appendReset()
-					length = last.getLen()
+					length += last.getLen()
 					line = append(line, last)
 					adjusted = true
 					goto AGAIN
@@ -349,11 +665,31 @@ func (o *Formatter) Render() image.Image {
 				length = 0
 				appendReset()
 				nextWordIsNaturalStart = true
+				startBlock()
 			}
 		}
 
 		lastWord = t
-		if words := t.split(o.Columns-length, o.Columns); words == nil {
+		for o.Hyphens != nil && !nobrk && t.getType() == runeLatin && length+t.getLen() > o.Columns {
+			head := o.Hyphens.hyphenate(t, o.Columns-length)
+			if head == nil {
+				break
+			}
+
+			if nextWordIsNaturalStart {
+				head.setIsNaturalStart()
+				nextWordIsNaturalStart = false
+			}
+			read(head, false)
+			length = 0
+			appendReset()
+		}
+
+		hang := block.hang()
+		if !nobrk {
+			hang += lead
+		}
+		if words := t.split(o.Columns-length, o.Columns-hang); words == nil {
 			read(t, false)
 			if nextWordIsNaturalStart {
 				t.setIsNaturalStart()
@@ -371,6 +707,22 @@ func (o *Formatter) Render() image.Image {
 		appendReset()
 	}
 
+	if renderErr != nil {
+		return nil, renderErr
+	}
+
+	if nobrk && cont && !res.Truncated && o.pane == nil {
+		res.Warnings = append(res.Warnings, "code block is not closed")
+	}
+	if !cont {
+		res.Truncated = true
+		res.Warnings = append(res.Warnings, fmt.Sprintf("content truncated at row %d", o.Rows))
+	}
+
+	if o.Vertical {
+		return o.verticalImage(), nil
+	}
+
 	o.Pos.Y += o.LineHeight / 2
 
 	height := o.Pos.Y
@@ -379,7 +731,17 @@ func (o *Formatter) Render() image.Image {
 		height = maxHeight
 	}
 
-	return o.Img.Dst.(IImage).SubImage(image.Rect(0, 0, o.Img.Dst.Bounds().Dx(), height))
+	return o.Img.Dst.(IImage).SubImage(image.Rect(0, 0, o.Img.Dst.Bounds().Dx(), height)), nil
+}
+
+// Result is the output of Formatter.Render
+type Result struct {
+	Image      image.Image
+	TextBounds image.Rectangle   // the area covered by text, it is the whole image in vertical mode
+	Rows       int               // rows (or columns in vertical mode) rendered
+	Truncated  bool              // the content didn't fit into the image, rows after Rows were discarded
+	Lines      []image.Rectangle // the area of every row from the left edge to the end of its text, only in horizontal mode
+	Warnings   []string          // problems found in the source which didn't stop the rendering
 }
 
 type IImage interface {
@@ -0,0 +1,69 @@
+package kkformat
+
+import (
+	"context"
+	"image"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+)
+
+func testFormatter(src string, height int) *Formatter {
+	return &Formatter{
+		Source:     []byte(src),
+		Columns:    80,
+		LineHeight: 16,
+		Theme:      WhiteTheme,
+		Img:        &font.Drawer{Dst: image.NewRGBA(image.Rect(0, 0, 600, height)), Face: basicfont.Face7x13},
+	}
+}
+
+func TestRenderTruncated(t *testing.T) {
+	res, err := testFormatter(strings.Repeat("line\n", 100), 16*10).Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Truncated || res.Rows != 9 || len(res.Warnings) != 1 {
+		t.Error(res.Rows, res.Truncated, res.Warnings)
+	}
+	if h := res.Image.Bounds().Dy(); h > 16*10 {
+		t.Error(h)
+	}
+
+	res, err = testFormatter("```\nnot closed", 16*10).Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Truncated || len(res.Warnings) != 1 {
+		t.Error(res.Truncated, res.Warnings)
+	}
+}
+
+func TestRenderBudget(t *testing.T) {
+	fo := testFormatter(strings.Repeat("line\n", 100), 16*200)
+	fo.Budget.MaxRows = 5
+	res, err := fo.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Truncated || res.Rows != 5 {
+		t.Error(res.Rows, res.Truncated)
+	}
+
+	fo = testFormatter(strings.Repeat("行", 100), 16*200)
+	fo.Budget.MaxRunes = 10
+	if res, err = fo.Render(); err != nil {
+		t.Fatal(err)
+	}
+	if !res.Truncated || res.Rows != 1 || len(res.Warnings) != 1 {
+		t.Error(res.Rows, res.Truncated, res.Warnings)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = testFormatter("a\nb\nc", 16*200).RenderContext(ctx); err != context.Canceled {
+		t.Error(err)
+	}
+}
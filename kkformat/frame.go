@@ -0,0 +1,215 @@
+package kkformat
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/math/fixed"
+)
+
+// Frame holds the decorations composited around a rendered image, zero values mean none:
+//
+//	╭──────────────────────╮
+//	│ ● ● ●    title       │  title bar
+//	├──────────────────────┤
+//	│   padding            │
+//	│     rendered image   │
+//	│ footer               │
+//	╰──────────────────────╯░ drop shadow
+//
+// The card takes the background of the rendered image, everything outside it is transparent.
+type Frame struct {
+	Padding  int    // space around the image in pixels
+	TitleBar bool   // a title bar with three buttons like a window
+	Title    string // text in the title bar, it implies TitleBar
+	Footer   string // a line of text under the image, e.g. the site name and the date
+	Border   bool   // a line around the card
+	Radius   int    // radius of the corners of the card
+	Shadow   int    // size of the drop shadow in pixels
+}
+
+const (
+	frameMaxPadding = 64
+	frameMaxRadius  = 32
+	frameMaxShadow  = 32
+	frameShadowA    = 0.3 // opacity of the shadow under the card
+)
+
+var frameButtons = []color.RGBA{{0xff, 0x5f, 0x56, 255}, {0xff, 0xbd, 0x2e, 255}, {0x27, 0xc9, 0x3f, 255}}
+
+func clampInt(v, max int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// roundedDistance returns the signed distance from (x, y) to the edge of rect with corners of radius r,
+// it is negative inside
+func roundedDistance(rect image.Rectangle, r float64, x, y float64) float64 {
+	x0, y0 := float64(rect.Min.X)+r, float64(rect.Min.Y)+r
+	x1, y1 := float64(rect.Max.X)-r, float64(rect.Max.Y)-r
+	dx := math.Max(math.Max(x0-x, x-x1), 0)
+	dy := math.Max(math.Max(y0-y, y-y1), 0)
+	if dx == 0 && dy == 0 {
+		// inside the straight part
+		return -math.Min(math.Min(x-x0, x1-x), math.Min(y-y0, y1-y)) - r
+	}
+	return math.Hypot(dx, dy) - r
+}
+
+// coverage converts a signed distance into the alpha of an antialiased edge
+func coverage(d float64) float64 {
+	return math.Min(math.Max(0.5-d, 0), 1)
+}
+
+// mix returns a+(b-a)*t
+func mix(a, b color.Color, t float64) color.Color {
+	r1, g1, b1, _ := a.RGBA()
+	r2, g2, b2, _ := b.RGBA()
+	f := func(x, y uint32) uint8 {
+		return uint8((float64(x) + (float64(y)-float64(x))*t) / 257)
+	}
+	return color.RGBA{f(r1, r2), f(g1, g2), f(b1, b2), 255}
+}
+
+// frameLayout clamps the sizes of f and returns the card and the bounds of the decorated image of size,
+// and the heights of the title bar and the footer
+func (r *Renderer) frameLayout(f *Frame, size image.Point) (card, all image.Rectangle, barH, footH int) {
+	f.Padding = clampInt(f.Padding, frameMaxPadding)
+	f.Radius = clampInt(f.Radius, frameMaxRadius)
+	f.Shadow = clampInt(f.Shadow, frameMaxShadow)
+
+	lh := r.LineHeight
+	if f.TitleBar || f.Title != "" {
+		barH = lh * 3 / 2
+	}
+	if f.Footer != "" {
+		footH = lh * 3 / 2
+	}
+
+	// the shadow is cast downwards, there is more room under the card
+	m := f.Shadow
+	card = image.Rect(0, 0, size.X+f.Padding*2, barH+size.Y+f.Padding*2+footH).Add(image.Pt(m, m/2))
+	return card, image.Rect(0, 0, card.Max.X+m, card.Max.Y+m), barH, footH
+}
+
+// DecoratedSize returns the size of the image Decorate makes of an image of size
+func (r *Renderer) DecoratedSize(size image.Point, f Frame) image.Point {
+	_, all, _, _ := r.frameLayout(&f, size)
+	return all.Size()
+}
+
+// Decorate composites the decorations of f around img, which is usually Result.Image
+func (r *Renderer) Decorate(img image.Image, f Frame) *image.RGBA {
+	b, lh := img.Bounds(), r.LineHeight
+	card, all, barH, footH := r.frameLayout(&f, b.Size())
+	m := f.Shadow
+	dst := image.NewRGBA(all)
+
+	rad := float64(f.Radius)
+	cardMask := image.NewAlpha(dst.Bounds())
+	borderMask := image.NewAlpha(dst.Bounds())
+	shadowMask := image.NewAlpha(dst.Bounds())
+	shadow := card.Add(image.Pt(0, m/3))
+	for y := 0; y < dst.Bounds().Dy(); y++ {
+		for x := 0; x < dst.Bounds().Dx(); x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			d := roundedDistance(card, rad, px, py)
+			cardMask.Pix[y*cardMask.Stride+x] = uint8(coverage(d) * 255)
+			if f.Border {
+				borderMask.Pix[y*borderMask.Stride+x] = uint8((coverage(d) - coverage(d+1)) * 255)
+			}
+			if m > 0 {
+				a := frameShadowA * (1 - math.Max(roundedDistance(shadow, rad, px, py), 0)/float64(m*2/3+1))
+				shadowMask.Pix[y*shadowMask.Stride+x] = uint8(math.Max(a, 0) * 255)
+			}
+		}
+	}
+
+	bg := img.At(b.Min.X, b.Min.Y)
+	fill := func(rect image.Rectangle, src image.Image, mask *image.Alpha) {
+		draw.DrawMask(dst, rect, src, image.Point{}, mask, rect.Min, draw.Over)
+	}
+
+	if m > 0 {
+		fill(dst.Bounds(), image.Black, shadowMask)
+	}
+	fill(card, image.NewUniform(bg), cardMask)
+
+	if barH > 0 {
+		bar := image.Rect(card.Min.X, card.Min.Y, card.Max.X, card.Min.Y+barH)
+		fill(bar, image.NewUniform(mix(bg, r.Theme[TNNormal].At(0, 0), 0.06)), cardMask)
+		draw.Draw(dst, image.Rect(bar.Min.X, bar.Max.Y-1, bar.Max.X, bar.Max.Y), r.Theme[TNLineWrap], image.Point{}, draw.Over)
+
+		// buttons
+		br := float64(lh) / 5
+		cx, cy := bar.Min.X+lh/2+f.Radius/2, bar.Min.Y+barH/2
+		for i, c := range frameButtons {
+			x0 := float64(cx + i*lh*3/4)
+			for y := cy - lh/2; y < cy+lh/2; y++ {
+				for x := int(x0) - lh/2; x < int(x0)+lh/2; x++ {
+					d := math.Hypot(float64(x)+0.5-x0, float64(y)+0.5-float64(cy)) - br
+					if a := coverage(d); a > 0 {
+						dst.Set(x, y, mix(dst.At(x, y), c, a))
+					}
+				}
+			}
+		}
+
+		// the title is centered, it is cut if it is too long
+		left := cx + len(frameButtons)*lh*3/4
+		title := []rune(f.Title)
+		for len(title) > 1 && r.textWidth(title) > card.Max.X-left-lh/2 {
+			title = append(title[:len(title)-2], '…')
+		}
+		x := (card.Min.X + card.Max.X - r.textWidth(title)) / 2
+		if x < left {
+			x = left
+		}
+		r.drawText(dst, r.Theme[TNNormal], x, bar.Min.Y, barH, title)
+	}
+
+	content := b.Sub(b.Min).Add(image.Pt(card.Min.X+f.Padding, card.Min.Y+barH+f.Padding))
+	draw.DrawMask(dst, content, img, b.Min, cardMask, content.Min, draw.Over)
+
+	if footH > 0 {
+		// aligned with the text of the image, see Formatter.render
+		x := content.Min.X + cachedGlyph(r.Face, 'a').advance.Round()*2 + 2
+		r.drawText(dst, r.Theme[TNLineNumber], x, card.Max.Y-f.Padding/2-footH, footH, []rune(f.Footer))
+	}
+
+	if f.Border {
+		fill(dst.Bounds(), r.Theme[TNLineWrap], borderMask)
+	}
+	return dst
+}
+
+// textWidth returns the width of s in pixels
+func (r *Renderer) textWidth(s []rune) int {
+	w := fixed.Int26_6(0)
+	for _, c := range s {
+		w += cachedGlyph(r.Face, c).advance
+	}
+	return w.Ceil()
+}
+
+// drawText draws s starting at x, vertically centered in the band of height h at top
+func (r *Renderer) drawText(dst draw.Image, src image.Image, x, top, h int, s []rune) {
+	m := cachedMetrics(r.Face)
+	y := top + (h+m.Ascent.Ceil()-m.Descent.Ceil())/2
+	dot := fixed.P(x, y)
+	for _, c := range s {
+		g := cachedGlyph(r.Face, c)
+		if g.ok {
+			dr := g.dr.Add(image.Pt(dot.X.Round(), dot.Y.Round()))
+			draw.DrawMask(dst, dr, src, image.Point{}, g.mask, g.maskp, draw.Over)
+		}
+		dot.X += g.advance
+	}
+}
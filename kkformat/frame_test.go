@@ -0,0 +1,44 @@
+package kkformat
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestDecorate(t *testing.T) {
+	rd := &Renderer{LineHeight: 16, Face: basicfont.Face7x13, Theme: WhiteTheme}
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	img.Set(0, 0, color.Black)
+
+	if out := rd.Decorate(img, Frame{}); out.Bounds().Size() != img.Bounds().Size() || out.At(0, 0) != (color.RGBA{0, 0, 0, 255}) {
+		t.Error(out.Bounds())
+	}
+
+	f := Frame{Padding: 4, Title: "a very long title which will be cut", Border: true, Radius: 8, Shadow: 8}
+	out := rd.Decorate(img, f)
+	// 8 for the shadow on both sides, 4 above the card and 8 under it, 24 for the title bar
+	if out.Bounds() != image.Rect(0, 0, 40+8+16, 20+8+24+12) {
+		t.Fatal(out.Bounds())
+	}
+	if size := rd.DecoratedSize(img.Bounds().Size(), f); size != out.Bounds().Size() {
+		t.Error(size)
+	}
+	if _, _, _, a := out.At(0, 0).RGBA(); a != 0 {
+		t.Error("margin", a)
+	}
+	if _, _, _, a := out.At(8, 4).RGBA(); a > 0x8000 {
+		// only the shadow is there
+		t.Error("corner", a)
+	}
+	if c := out.At(8+4, 4+24+4); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Error("content", c)
+	}
+	if _, _, _, a := out.At(40, 63).RGBA(); a == 0 {
+		t.Error("shadow")
+	}
+}
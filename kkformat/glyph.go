@@ -0,0 +1,175 @@
+package kkformat
+
+import (
+	"image"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Faces are not safe for concurrent use and rasterising the same glyph again and again is wasteful,
+// so every face gets a lazily filled atlas shared by the whole process: glyphs are rasterised once
+// and their masks are packed into pages of alpha images. A face is created for one size, so the
+// atlas is effectively per (face, size). Faces are used as map keys, they must be comparable (pointers usually).
+
+const (
+	atlasPageSize  = 1024
+	atlasMaxPages  = 64
+	atlasMaxGlyphs = 1 << 17
+)
+
+type glyph_t struct {
+	dr      image.Rectangle // bounds of the mask relative to the dot
+	mask    *image.Alpha    // the atlas page holding the mask
+	maskp   image.Point     // position of the mask in the page
+	advance fixed.Int26_6
+	ok      bool
+}
+
+// alphaAt returns the alpha of the mask at (x, y) relative to its top left corner
+func (g *glyph_t) alphaAt(x, y int) uint8 {
+	return g.mask.AlphaAt(g.maskp.X+x, g.maskp.Y+y).A
+}
+
+type atlas struct {
+	face    font.Face
+	metrics font.Metrics
+	glyphs  map[rune]*glyph_t
+	pages   []*image.Alpha
+	x, y    int // pen of the last page
+	rowH    int // height of the current shelf of the last page
+}
+
+var atlases = struct {
+	sync.RWMutex
+	m map[font.Face]*atlas
+}{m: map[font.Face]*atlas{}}
+
+// GlyphAtlasSize returns the number of glyphs and pages in the atlas of face
+func GlyphAtlasSize(face font.Face) (glyphs, pages int) {
+	atlases.RLock()
+	defer atlases.RUnlock()
+	if a := atlases.m[face]; a != nil {
+		return len(a.glyphs), len(a.pages)
+	}
+	return 0, 0
+}
+
+// getAtlas returns the atlas of face, the write lock must be held
+func getAtlas(face font.Face) *atlas {
+	a := atlases.m[face]
+	if a == nil {
+		a = &atlas{face: face, metrics: face.Metrics(), glyphs: map[rune]*glyph_t{}}
+		atlases.m[face] = a
+	}
+	return a
+}
+
+// alloc reserves a w x h area in the atlas, nil will be returned if the atlas is full
+func (a *atlas) alloc(w, h int) (*image.Alpha, image.Point) {
+	if w > atlasPageSize || h > atlasPageSize {
+		return nil, image.Point{}
+	}
+
+	if len(a.pages) > 0 && a.x+w > atlasPageSize {
+		// start a new shelf
+		a.x, a.y, a.rowH = 0, a.y+a.rowH, 0
+	}
+
+	if len(a.pages) == 0 || a.y+h > atlasPageSize {
+		if len(a.pages) >= atlasMaxPages {
+			return nil, image.Point{}
+		}
+		a.pages = append(a.pages, image.NewAlpha(image.Rect(0, 0, atlasPageSize, atlasPageSize)))
+		a.x, a.y, a.rowH = 0, 0, 0
+	}
+
+	p := image.Pt(a.x, a.y)
+	a.x += w
+	if h > a.rowH {
+		a.rowH = h
+	}
+	return a.pages[len(a.pages)-1], p
+}
+
+// rasterise renders r into the atlas, the write lock must be held
+func (a *atlas) rasterise(r rune) *glyph_t {
+	g := &glyph_t{}
+	dr, mask, maskp, advance, ok := a.face.Glyph(fixed.Point26_6{}, r)
+	if !ok {
+		return g
+	}
+
+	g.dr, g.advance, g.ok = dr, advance, true
+	if page, p := a.alloc(dr.Dx(), dr.Dy()); page != nil {
+		g.mask, g.maskp = page, p
+	} else {
+		// the atlas is full, faces may reuse their mask buffers, so make a copy anyway
+		g.mask = image.NewAlpha(image.Rect(0, 0, dr.Dx(), dr.Dy()))
+	}
+	draw.Draw(g.mask, image.Rectangle{g.maskp, g.maskp.Add(dr.Size())}, mask, maskp, draw.Src)
+	return g
+}
+
+func cachedGlyph(face font.Face, r rune) *glyph_t {
+	atlases.RLock()
+	var g *glyph_t
+	if a := atlases.m[face]; a != nil {
+		g = a.glyphs[r]
+	}
+	atlases.RUnlock()
+	if g != nil {
+		return g
+	}
+
+	atlases.Lock()
+	defer atlases.Unlock()
+	a := getAtlas(face)
+	if g = a.glyphs[r]; g != nil {
+		return g
+	}
+
+	g = a.rasterise(r)
+	if len(a.glyphs) < atlasMaxGlyphs {
+		a.glyphs[r] = g
+	}
+	return g
+}
+
+func cachedMetrics(face font.Face) font.Metrics {
+	atlases.RLock()
+	a := atlases.m[face]
+	atlases.RUnlock()
+	if a != nil {
+		return a.metrics
+	}
+
+	atlases.Lock()
+	defer atlases.Unlock()
+	return getAtlas(face).metrics
+}
+
+func (o *Formatter) glyph(r rune) *glyph_t {
+	return cachedGlyph(o.Img.Face, r)
+}
+
+func (o *Formatter) metrics() font.Metrics {
+	return cachedMetrics(o.Img.Face)
+}
+
+// drawGlyph draws r at dot using the current source color, dot is rounded to pixels
+func (o *Formatter) drawGlyph(dot fixed.Point26_6, r rune) {
+	g := o.glyph(r)
+	if !g.ok {
+		return
+	}
+
+	dr := g.dr.Add(image.Pt(dot.X.Round(), dot.Y.Round()))
+	if o.style != 0 {
+		o.drawStyledGlyph(g, dr, dot)
+		return
+	}
+	draw.DrawMask(o.Img.Dst, dr, o.Img.Src, image.Point{}, g.mask, g.maskp, draw.Over)
+}
@@ -0,0 +1,111 @@
+package kkformat
+
+import (
+	"context"
+	"image"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/math/fixed"
+)
+
+func testTrueTypeFace(t testing.TB) font.Face {
+	return newTestFace(testTrueTypeFont(t))
+}
+
+func testTrueTypeFont(t testing.TB) *truetype.Font {
+	ttf, err := ioutil.ReadFile("../test/unifont-10.0.07.ttf")
+	if err != nil {
+		ttf = gomono.TTF
+	}
+
+	f, err := truetype.Parse(ttf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func newTestFace(f *truetype.Font) font.Face {
+	return truetype.NewFace(f, &truetype.Options{Size: 16, DPI: 72, Hinting: font.HintingNone})
+}
+
+// dropAtlas removes the atlas of face, so the next render will rasterise all glyphs again
+func dropAtlas(face font.Face) {
+	atlases.Lock()
+	delete(atlases.m, face)
+	atlases.Unlock()
+}
+
+func TestGlyphAtlas(t *testing.T) {
+	face := testTrueTypeFace(t)
+	defer dropAtlas(face)
+
+	for _, r := range "a字g,Ω" {
+		g := cachedGlyph(face, r)
+		dr, mask, maskp, advance, ok := face.Glyph(fixed.Point26_6{}, r)
+		if !ok || !g.ok || g.dr != dr || g.advance != advance {
+			t.Fatal(string(r), g.dr, dr)
+		}
+
+		for y := 0; y < dr.Dy(); y++ {
+			for x := 0; x < dr.Dx(); x++ {
+				if _, _, _, a := mask.At(maskp.X+x, maskp.Y+y).RGBA(); uint8(a>>8) != g.alphaAt(x, y) {
+					t.Fatal(string(r), x, y)
+				}
+			}
+		}
+
+		if cachedGlyph(face, r) != g {
+			t.Fatal("not cached")
+		}
+	}
+
+	if glyphs, pages := GlyphAtlasSize(face); glyphs != 5 || pages != 1 {
+		t.Error(glyphs, pages)
+	}
+}
+
+func BenchmarkRenderWas(b *testing.B) {
+	src, err := ioutil.ReadFile("../_raw/was.txt")
+	if err != nil {
+		b.Skip(err)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, 756, 5000))
+	run := func(b *testing.B, getFace func() font.Face, cold bool) {
+		for i := 0; i < b.N; i++ {
+			// only the rendering is timed
+			b.StopTimer()
+			face := getFace()
+			b.StartTimer()
+
+			rd := &Renderer{Columns: 80, LineHeight: 19, Face: face, Theme: WhiteTheme}
+			if _, err := rd.Render(context.Background(), dst, src); err != nil {
+				b.Fatal(err)
+			}
+
+			if cold {
+				b.StopTimer()
+				dropAtlas(face)
+				b.StartTimer()
+			}
+		}
+	}
+
+	// every glyph is rasterised from outlines on every render, which is what happened before the atlas,
+	// the font is parsed once, a new face is needed as faces cache glyphs too
+	f := testTrueTypeFont(b)
+	b.Run("cold", func(b *testing.B) {
+		run(b, func() font.Face { return newTestFace(f) }, true)
+	})
+
+	shared := newTestFace(f)
+	defer dropAtlas(shared)
+	b.Run("atlas", func(b *testing.B) {
+		run(b, func() font.Face { return shared }, false)
+	})
+}
@@ -0,0 +1,67 @@
+package kkformat
+
+import (
+	"image"
+	"image/draw"
+	"strconv"
+)
+
+// Gutter customises the line numbers, code blocks can override it by options after the opening "```":
+//
+//	```go nums=off        no line numbers
+//	```go every=5         only 5, 10, 15 ... are numbered
+//	```go rule=on         a rule between the numbers and the code, "rule=off" removes it
+//
+// Lines of prose are numbered by their lines in the source, rows of tables are not numbered.
+type Gutter struct {
+	Hide  bool // code blocks have no line numbers
+	Prose bool // lines of prose are numbered too
+	Every int  // only every nth line is numbered, 0 means every line
+	Rule  bool // a rule is drawn between the gutter and the text
+}
+
+// gutterOf returns g overridden by the options of the code block
+func (f *fence_t) gutterOf(g Gutter) Gutter {
+	if f.nums != 0 {
+		g.Hide = f.nums < 0
+	}
+	if f.rule != 0 {
+		g.Rule = f.rule > 0
+	}
+	if f.every > 0 {
+		g.Every = f.every
+	}
+	return g
+}
+
+// parseSwitch parses "on" and "off" into 1 and -1, others are 0
+func parseSwitch(s string) int8 {
+	switch s {
+	case "on":
+		return 1
+	case "off":
+		return -1
+	}
+	return 0
+}
+
+// number returns the text of line n in the gutter, an empty string if it is skipped
+func (g *Gutter) number(n int) string {
+	if g.Every > 1 && n%g.Every != 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// gutterWord returns the gutter of a row of prose, s is right aligned in w columns and followed by a space
+func gutterWord(s string, w uint32) *word_t {
+	v := spaces[:w-uint32(len(s))] + s + " "
+	return (&word_t{}).setType(runeSpace).setValue([]rune(v)).setLen(w + 1).setSpecialType(specialLineNumber).setIsCode()
+}
+
+// drawGutterRule draws the rule in the middle of column col of the current row
+func (o *Formatter) drawGutterRule(col uint32) {
+	dx, top := o.Pos.Dx, o.rowTop()
+	x := dx*2 + 2 + int(col)*(dx+1) + dx/2
+	draw.Draw(o.Img.Dst, image.Rect(x, top, x+1, top+o.LineHeight), o.Theme[TNLineNumber], image.Point{}, draw.Src)
+}
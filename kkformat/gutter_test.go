@@ -0,0 +1,54 @@
+package kkformat
+
+import "testing"
+
+func TestGutterOptions(t *testing.T) {
+	f := parseFence("go nums=off every=5 rule=on")
+	if f.lang != "go" {
+		t.Fatal(f)
+	}
+	if g := f.gutterOf(Gutter{Rule: false, Every: 2}); !g.Hide || !g.Rule || g.Every != 5 {
+		t.Error(g)
+	}
+
+	f = parseFence("go rule=off nums=on every=x")
+	if g := f.gutterOf(Gutter{Hide: true, Rule: true, Every: 2}); g.Hide || g.Rule || g.Every != 2 {
+		t.Error(g)
+	}
+
+	g := Gutter{Every: 5}
+	for n, want := range map[int]string{1: "", 5: "5", 12: "", 20: "20"} {
+		if s := g.number(n); s != want {
+			t.Error(n, s)
+		}
+	}
+}
+
+func TestRenderGutter(t *testing.T) {
+	prose := "a b c d e f g h i j k l m n o p q r s t u v w x y z"
+	code := "```\n01234567890123456\n```"
+	for _, c := range []struct {
+		src    string
+		gutter Gutter
+		rows   int
+	}{
+		// 10 letters in a row
+		{prose, Gutter{}, 3},
+		// 3 columns of numbers and a space take the room of 2 letters
+		{prose, Gutter{Prose: true}, 4},
+		// 17 runes of code fit into the row only without the gutter
+		{code, Gutter{}, 2},
+		{code, Gutter{Hide: true}, 1},
+		{"```nums=on\n01234567890123456\n```", Gutter{Hide: true}, 2},
+	} {
+		fo := testFormatter(c.src, 16*10)
+		fo.Columns, fo.Gutter = 20, c.gutter
+		res, err := fo.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Rows != c.rows {
+			t.Errorf("%q %v: %d", c.src, c.gutter, res.Rows)
+		}
+	}
+}
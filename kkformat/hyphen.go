@@ -0,0 +1,170 @@
+package kkformat
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Hyphenator finds the syllable boundaries of Latin words using Liang's algorithm
+type Hyphenator struct {
+	LeftMin  int // minimal number of runes before the first hyphen
+	RightMin int // minimal number of runes after the last hyphen
+
+	patterns   map[string][]uint8
+	exceptions map[string][]int
+	maxLen     int
+}
+
+// NewHyphenator builds a hyphenator from TeX style patterns and exceptions, both are space separated
+func NewHyphenator(patterns, exceptions string, leftMin, rightMin int) *Hyphenator {
+	h := &Hyphenator{
+		LeftMin:    leftMin,
+		RightMin:   rightMin,
+		patterns:   make(map[string][]uint8),
+		exceptions: make(map[string][]int),
+	}
+
+	for _, p := range strings.Fields(patterns) {
+		letters := make([]rune, 0, len(p))
+		levels := []uint8{0}
+		for _, r := range p {
+			if r >= '0' && r <= '9' {
+				levels[len(levels)-1] = uint8(r - '0')
+				continue
+			}
+			letters = append(letters, r)
+			levels = append(levels, 0)
+		}
+
+		h.patterns[string(letters)] = levels
+		if len(letters) > h.maxLen {
+			h.maxLen = len(letters)
+		}
+	}
+
+	for _, e := range strings.Fields(exceptions) {
+		word := make([]rune, 0, len(e))
+		points := make([]int, 0, 4)
+		for _, r := range e {
+			if r == '-' {
+				points = append(points, len(word))
+				continue
+			}
+			word = append(word, r)
+		}
+		h.exceptions[string(word)] = points
+	}
+
+	return h
+}
+
+// Hyphenate returns the indexes of runes in word before which a hyphen can be inserted
+func (h *Hyphenator) Hyphenate(word []rune) []int {
+	n := len(word)
+	if n < h.LeftMin+h.RightMin {
+		return nil
+	}
+
+	w := make([]rune, 0, n+2)
+	w = append(w, '.')
+	for _, r := range word {
+		if !unicode.IsLetter(r) {
+			return nil
+		}
+		w = append(w, unicode.ToLower(r))
+	}
+	w = append(w, '.')
+
+	if points, ok := h.exceptions[string(w[1:n+1])]; ok {
+		return points
+	}
+
+	// levels[i] is the level of the gap before w[i]
+	levels := make([]uint8, len(w)+1)
+	for i := 0; i < len(w); i++ {
+		for j := i + 1; j <= len(w) && j-i <= h.maxLen; j++ {
+			p, ok := h.patterns[string(w[i:j])]
+			if !ok {
+				continue
+			}
+
+			for k, v := range p {
+				if v > levels[i+k] {
+					levels[i+k] = v
+				}
+			}
+		}
+	}
+
+	points := make([]int, 0, 4)
+	for i := h.LeftMin; i <= n-h.RightMin; i++ {
+		// the gap before word[i] is the gap before w[i+1]
+		if levels[i+1]%2 == 1 {
+			points = append(points, i)
+		}
+	}
+
+	return points
+}
+
+// hyphenate cuts the head off w so that the head plus a trailing hyphen is no wider than width,
+// it returns nil if there is no such break point
+func (h *Hyphenator) hyphenate(w *word_t, width uint32) *word_t {
+	if width < 2 {
+		return nil
+	}
+
+	points := h.Hyphenate(w.value)
+	for i := len(points) - 1; i >= 0; i-- {
+		at := points[i]
+		if StringWidth(w.value[:at])+1 > width {
+			continue
+		}
+
+		head := w.dup()
+		head.value = append(append(make([]rune, 0, at+1), w.value[:at]...), '-')
+		head.setLen(StringWidth(head.value))
+
+		w.value = w.value[at:]
+		w.setLen(StringWidth(w.value))
+		return head
+	}
+
+	return nil
+}
+
+var hyphenators = struct {
+	sync.Mutex
+	m map[string]*Hyphenator
+}{m: make(map[string]*Hyphenator)}
+
+// GetHyphenator returns the hyphenator of lang ("en", "de", "fr", "es" or "it") or nil if not available,
+// patterns are parsed at the first call
+func GetHyphenator(lang string) *Hyphenator {
+	hyphenators.Lock()
+	defer hyphenators.Unlock()
+
+	if h, ok := hyphenators.m[lang]; ok {
+		return h
+	}
+
+	var h *Hyphenator
+	switch lang {
+	case "en":
+		h = NewHyphenator(hyphenEnPatterns, hyphenEnExceptions, 2, 3)
+	case "de":
+		h = NewHyphenator(hyphenDePatterns, "", 2, 2)
+	case "fr":
+		h = NewHyphenator(hyphenFrPatterns, "", 2, 3)
+	case "es":
+		h = NewHyphenator(hyphenEsPatterns, "", 2, 2)
+	case "it":
+		h = NewHyphenator(hyphenItPatterns, "", 2, 2)
+	default:
+		return nil
+	}
+
+	hyphenators.m[lang] = h
+	return h
+}
@@ -0,0 +1,1628 @@
+package kkformat
+
+// German (1996 orthography) hyphenation patterns by Werner Lemberg et al.,
+// converted from the hyph-utf8 project (hyph-de-1996.tex).
+
+const hyphenDePatterns = `
+.ab1a .ab1or .ab3l .ab3ol .ab3s2 .ab3u .abi4t .abo2 .ade3n .ae3 .aft2 .ag2u .ag4n .ag4r .ai2s .akt2a
+.al2e .al2tu .al3k .al3lei .al3se .al4tan .al4tei .al5len .alt3s4 .alter6s5 .ampe4 .amt2s1 .amt4sc
+.an1er .an3d2 .an3g4li .an3k4 .an3na .an3s2 .an3z2 .an4si. .ana1c .and4ri .anden6k .ang2 .angs4
+.angst3 .ani2s .ap5p6le. .aps2 .ar2sc .ar2tr .ar3k2a .ar4m3ac .ar4mun .ar4t3ei .ar4tan .ar6t5erh
+.ari1e .arter4 .arz2 .as3tr .as4ta .asbe2 .at4h .at4r .ata1 .ate2 .au2s3 .au3d .au4f3 .aue2 .aufs4
+.auss2 .auß2 .ax2 .bah6ner .bahn3 .baus4 .be3erb .be3r2e .be3r4a .bel2a .ber4g3a .ber4g3r .ber4tr
+.ber6g5e6b .bi2t .bi4os .bit1a .bo4s3k .boge2 .bogen3 .bogens6 .bu4ser .by4t .ca2s3t .ch4 .char8mes
+.chi3er .da2r1 .da4te. .da4tes .dab4 .dar3in .darm1 .de1i .de1o2 .de1s .de2al .de3lo .de3r4en .de3sk
+.de8ments .dein2 .den4ka .den4kl .den4ko .des2t .di3el .di4en .dien4e .dien6st .dienst7a8d .do1pe
+.do2mo .do3b .don4a .dor2f1 .dy2s3 .ebe2r1 .edu3s .eg2o .eh2e .ehe1i .ei2s3t .ei2sp .ei3e2 .ei3f2e
+.ei3k .ei4neb .ei4tr .ein3d .ein6erl .eine2 .eis3s4 .eise4 .ek3li .eke2 .el2a .el2bi .el2bl .el2fl
+.el2i .el4fei .el6st .elb3s .em3m2 .en1 .en2d3r .en2gl .en2ta .en4d3er4 .en4da .en4dü .en4t1r
+.en4tei .en4tio .en5trop .end3s .enn2 .ent3 .ents4 .er1e .er1i .er2bu .er4bei .er4d3er .er4dan
+.er4dei .er4dep .er4z3el .er4zen4 .er8brecht .er8stein .er8stritt. .er8stritten. .erden6k .ere3c
+.erf4 .ers2 .erster6 .es3p .es3t3r .es3ta .es3th .ese3le .est6e .et2s .eu1 .eu3g4 .eu3r4 .eu3t .ext4
+.fe3la .fer4no .fi2s .fi3est .fi4le. .fi4len .flug1 .for2t .fs4 .fu2sc .ga2me .ga4s1 .ga4t .gas3e
+.gd2 .ge3lu .ge3n2o .ge3n4e .ge3r2e .ge3r4a .ge3ro .ge3s4a .ge3u .ge5nar .geb2l .gebe4a .gee4
+.gel2d1 .gel4b3r .gene7cke .ger4s .glan2 .gol6der .gs4 .halt4e .hau2t1 .he2 .he3fe .he3le .he3rat
+.he3rer .he3ri .he4bei .he6r5inn .her3an .her6b5ra .hi4s .hin3u .hips4 .ho4fen .ho4met .ho4st .hof1e
+.ia4 .im2a .im5m2 .ima4ge .in1 .in3gl .in3n2e .in3sk .ind4 .ink2 .inu1 .ioni1 .ire3 .is2a .is3t
+.it2h .iv2 .ivo3 .joni1 .jor3 .ka2b3l .ka2i .ka3le .ka3ta .ka4t3io .ki4e .klang3 .ko3b .kopf1
+.kor4da .kraf2 .ks4 .kus2 .la3be .la3ho .lase2 .le3n2i .le4ar .le4gas .len3z .li2f .li4ve.
+.lich8t7er8s .lo2sc .lo3ver .lo4g3in .los3s4 .lus4tr .luster6 .lut4h .ma2st .ma3d .ma3ge .mal4e
+.mat4c .matu3 .md2 .me3l2a .me3ne .me3no .men8schl .men8schw .ment4 .mes4sp .mi2f .mi2st .mi4t1
+.mik4 .mil2z .mm2 .mutter5 .na3no .na3t .nat2h .ne1ro .ne2s .nebe4n .ner2f .ni3k4l .ni4e .nich2
+.nicht5e .no4th .nus4 .näs1c .oa3s .ob1a .obe2 .ober5ei .of2e .oper4 .or2a .ord4e .ort2 .ort4h
+.orts3e .os2tu .os3s .os4t3el .os4t3r .os4tal .os5t6alg .os8ten8de .os8terwe .ost5end .oste2
+.oste6re .ot1 .ozo4 .pa2r1e .pab4 .par3t4h .pe2c .pe3la .pe3le .pf4 .ph4 .po4st .poka2 .pro1 .ps2
+.ra3ch4e .ra3me .ra3sa .rabe4 .rau2m .rau8schl .re3ale .re3cha .re5insz .reb3s2 .rei4st .reis6e5i
+.reli1 .res6tr .ri4as .richt6e .ro2h .ro2tr .ro3be .ro3m4a .ro4a .ruf3s .ruh2r1 .runder6 .räu3sc
+.rö2s .rü1b .rü6cker6 .sa3br .sali3e .sami1 .sau1c .sau5er. .sch4 .schaf8t7end .scheiner8 .se1ro
+.se2ei .se2ha .se2t1 .se3ck .se3re .sen3s .sen4f .sha2 .si3gn .si4te .ski1e .skis2 .sour2 .spani7er.
+.spiege8lei .st4 .ste2i .steiner8k .sto4re .sucher6 .ta2t1u .ta2t3h .ta2to .ta3ra .ta4tor .tage4s
+.tan4k3a .tan4k3l .tar3t .te1ra .te2e .te2f .te2s .te3l .te3no .te4st .tehe3 .tei8l7ersc .teiler8s
+.ten3s .test3r .tester8g .tester8h .th4 .ti2e .ti2me .ti2s .ti4mes .ti8sch7end .tite4 .to2n .to2pr
+.to2w .to4der .to4nat .to4nin .to4pl .tode4 .todes5 .tri3es .tro2s .ts4 .tsa3 .tse3 .tu3ra .tu3ri
+.tur4ma .turm1 .ub2 .ufe2 .ufer1 .ul2b3 .um3 .uma2 .ume2 .umo2 .un3a2 .un3d .un3g .un3s .une2 .uni4t
+.uns4t .ur1c .ur1e .ur1o2p .ur3a2d .ur3o4m .ur3s2 .ur4inf .uran6fa .ut2a .ut3r .ve5n2e .vo4r .voll1
+.wa2s .wah4l .weg5s .wei4ta .welter8e .welter8kl .wer4kr .wer4tr .wer6ker .wetterer8s .wi4e .wor2
+.wor4tu .wor8tend .wort5en6 .wur2f1 .xe3 .ya4l .zahn3 .zel4la .zel6leb .zelle4 .zeug4i .zi2e
+.zie4l3u .zin4ka .zin4s3c .zin4st .zu2pf .zu4gra .zuch2 .zucht3 .zug3l .zwe2 .zwei8g7end .zweigen8
+.äm3 .är6schl .ät2h .ät2s .öd2 .öl3l .übe4 1a2bla 1a2blä 1a2blö 1a2dop 1a2dä 1a2hor 1a2nom 1aas
+1ab3s2p 1ab5sc 1abd 1abent 1abf 1abg 1abh 1abk 1abn 1abtei 1abw 1add 1adv 1akaz 1akku 1akz 1algo
+1alkoh 1alph 1altä 1amt. 1an1äs 1an3gri 1an3s2ä 1anb 1anf 1angab 1angeb 1angeh 1anh 1anl 1anmu
+1annah 1anr 1anspr 1anst 1antr 1antw 1antá 1anw 1anzei 1anzü 1apfel 1ar1c 1arb 1arzt 1aufla 1aufn
+1ausd 1ausf 1ausg 1ausl 1ausr 1ausw 1ausz 1auto 1be. 1be1c 1bem 1ben 1bi 1c2a 1c2o 1c2r2 1c2u 1ce1r
+1ced 1cet 1chato 1chef 1chia 1chip. 1chiru 1chy 1châ 1ché 1cit 1ck2ag 1cka. 1ckat 1cke 1cki 1cku
+1cky 1clu 1cy 1cé 1d2y 1de 1di 1du 1e2hep 1e2lem 1e2mir 1e2mul 1e2pid 1e2pile 1e2pit 1e2poc 1e2pos.
+1e4uk 1effi 1ei2g3n 1eifr 1einu 1eisho 1eiswo 1ekd 1ekz 1elek 1elf. 1elfm 1elft 1ellip 1emba 1embo
+1emiss 1empf4 1ency 1energ 1engad 1engag 1engp 1ensem 1entd 1entf 1entga 1enthu 1enthü 1entla 1entn
+1entsc 1entso 1entw 1entz 1enzep 1epis 1erdb 1erdg 1erhab 1erklä 1erosi 1essay 1estni 1et4hi 1ethn
+1etym 1eu3tha 1euro 1exis 1extr 1f4lop 1f4lot 1f4luc 1f4lug 1f4luss 1f4ro2n 1f4rän 1fa 1fe 1fi 1floß
+1fo 1frau. 1fris 1fu 1fä 1fé 1fö 1fü 1h2ö 1hirt 1hè 1i2dio 1i2dy 1i2js 1i2sol 1iatr 1idee 1illu
+1imbi 1immo 1imp 1index 1indik 1indus 1infiz 1info 1inhab 1inkas 1innta 1insuf 1integ 1iron 1itii
+1ka 1ko 1kä 1kö 1kü 1lad 1lammf 1laub. 1laug 1law 1le 1li 1lo 1lu2f2 1lu2n 1lu2st 1lumpe 1ly 1là
+1länd 1läuf 1lö 1ma 1me 1mi 1mo 1mu 1mä 1mé 1mö 1mü 1n2öt 1na 1ne 1ni 1no 1nu 1ny. 1nyh 1nyr 1nys
+1nyw 1nä 1nüt 1ob3li 1oberf 1offiz 1ohmi 1ohnm 1onke 1ony 1oog 1op3t4 1ope 1oppo 1orake 1ordn 1osm
+1oxy 1p2arc 1p2l2 1p2o 1p4el 1pa. 1pa2no 1pa2ro 1paa 1pac 1pag4 1pak 1palm 1panto 1pap 1para 1parf
+1park. 1park1s 1parti 1partn 1pat4h 1pat4r 1pati 1pau 1pav 1pe. 1peda 1peel 1peg 1peil 1pem 1pensu
+1pep 1perle 1pers 1perü 1pes5s2 1pfä 1ph 1pil 1pis 1prak 1prax 1prei 1prem 1pres 1prinz 1priv 1pro
+1präd 1präf 1präg 1präl 1präp 1präv 1prüf 1prüg 1pu 1pä 1pé 1püf 1queu 1r2ad 1r4abi 1ra. 1rabbi
+1raby 1rah. 1rangi 1ranu 1rat 1raü 1re 1ri 1robo 1ropl 1ru 1ry 1ré 1rí 1röl 1rösl 1rü 1s2tif 1s2tim
+1s2tut 1s2tyl 1s2tä 1s2tö 1s4trik 1s4y 1sa 1sc 1se 1si 1so 1sp2 1stamm 1stan 1steh 1steue 1stich
+1stitu 1stof 1stoß 1strap 1stras 1stru 1strö 1strü 1stub 1stund 1stüc 1stüt 1su 1sä 1sé 1sí 1sö 1sü
+1t2hi 1t2ho 1t2r4 1ta 1te 1th2r2 1the. 1then 1ti 1to 1tu 1ty1 1tà 1tä 1tö 1u2niv 1umd2 1umf 1umg
+1umk 1uml 1umr 1umsat 1umz 1un3si 1unab 1undd 1undf 1undn 1undv 1undz 1unget 1ungew 1unglü 1unio
+1unr 1unt 1unw 1ve2 1vo 1waa 1wack 1wag 1wah 1wal 1wann 1war2e 1warn 1was 1weg 1weh 1werbu 1werdu
+1werk. 1werke 1wes2e 1wet 1wi4r 1wid 1wied 1wild 1win2d5r 1wiss 1witz. 1witzl 1wo1c 1woh 1wolf
+1wu4t1 1wuc 1wurst 1wäh 1wäl 1wäs 1wöc 1wüh 1würf 1würst 1x2a3g2 1x2ad 1x2as 1xa. 1xae 1xe 1xu 1ya2c
+1ße 1ßi 1ä2gy 1ä2gä 1ähnl 1äpfel 1äq 1ärz 1ästh 1äug 1äuß 1äx 1ää 1ën 1ö2ko3 1ödu 1öf 1ölu 1üb
+2a1chi 2a1ci 2a1cl 2a1e1 2a1he 2a1ke 2a1kr 2a1nö 2a1t 2a1w 2a1ya 2a1z 2a1ö2 2a1ü 2a2ar 2a2g1l 2a3au
+2a3ca 2a3kam 2a3m4an 2a3n2i 2a3nak 2a3nos 2a3pu 2ab2et 2aba 2abbat 2abbin 2aber 2abew 2abi 2ablet
+2ablo 2ablu 2abo 2abro 2abrö 2abu 2aby 2abö 2abü 2ach3m 2ach3r 2achb 2achf 2achsc 2achv 2acu 2ad.
+2ad2t1 2ad3rec 2ada. 2adat 2ade. 2adeg 2adf 2adli 2adp 2adq 2ads2 2af3l 2afa 2afe 2afi 2afo 2afra
+2afro 2afä 2afü 2ag. 2aga 2ages 2agm 2ags 2agt 2agu 2ah. 2ai. 2aib 2ak. 2ak3l 2aka3b4 2akal 2akar
+2akb 2akc 2akd 2ako 2aks 2aktb 2aktik 2aktis 2aktm 2aktsi 2aktsp 2aktst 2aktw 2akun 2akur 2al3arr
+2ala. 2alai 2ale 2alg. 2ali 2altu 2am. 2am2ple 2am2s 2amel 2amf 2amir 2amis 2amit 2amk 2aml 2amm.
+2ammal 2ampe. 2ampen 2ampo 2amu 2amä 2ana. 2ana1s 2anab 2anan 2anbas 2anbu 2and. 2ane 2anf. 2anfab
+2anfi 2anfs 2ang. 2ange. 2angh 2angie 2angs. 2anj 2ank. 2anken 2anki 2anks 2ankt 2anlad 2anmo 2ann.
+2anns 2annt 2ano. 2anof 2anog 2anpu 2anrö 2ant. 2antie 2anto 2anu 2anwi 2anzb 2anzd 2anzg 2anzh
+2anzk 2anzm 2anzr 2anzs 2anzt 2anzv 2anzw 2anzy 2ao 2ap3l 2apa 2apfes 2api 2apr 2ar. 2ar2bl 2ar3abb
+2ar3s2i 2aran 2arap 2arar 2arb. 2arb1r 2arb3t4 2arba 2arbek 2arben 2arber 2arbo 2arbs2 2arbu 2archl
+2archr 2arg4o 2arh 2ari 2ark3l 2arp 2arr 2arsa 2artb 2artei 2arto 2arts 2artuc 2aru 2arv 2ary 2arze
+2arzi 2arzu 2as3sa 2as3ta 2asa 2ascht 2asim 2asis 2asu 2asy. 2au. 2au1i 2au3a2 2aub 2aue 2aufe.
+2aufeh 2aufo 2auft. 2aug 2auj 2aup2 2aur2 2ausc 2ause. 2ausen 2auste 2aut. 2autb 2aute 2autg 2auts2
+2auu 2auv 2auw 2aux 2auz 2avi 2b1abs 2b1adel 2b1adl 2b1adm 2b1af 2b1am 2b1ans4 2b1c 2b1e2del 2b1eff
+2b1eier 2b1eime 2b1emp 2b1entb 2b1ents 2b1epi 2b1g4 2b1h2 2b1inb 2b1inh 2b1int 2b1inv 2b1lac 2b1län
+2b1m 2b1of 2b1onk 2b1p4 2b1q 2b1s 2b1unh 2b1v 2b1z4 2b1äh 2b1öl 2b3e2x 2b3entw 2b3law 2b3leid
+2b3lenk 2b3rad 2b3rat. 2b3ratg 2b3red 2b3ref 2b3rent 2b3rep 2b3riem 2b3rind 2b3roh 2b3rol 2b3rost
+2b3rou 2b3rund 2b3umk 2b3umr 2b4rah 2b5d4 2b5f4 2b5k4 2babf 2babg 2banf 2banl 2banw 2becht 2bemul
+2bentd 2bentf 2bimp 2bl. 2bleh 2bleih 2bleit 2blesu 2blich 2blief 2blig 2blis. 2blun 2br. 2bre.
+2breg 2brek 2breo 2brigk 2bruf 2bumf 2buml 2burn 2bärz 2bäug 2böf 2büb 2c1q 2can 2cc 2cec 2cef 2cek
+2ch1a2g 2ch1ak 2ch1e4ta 2ch1ins 2ch1int 2ch1inv 2ch1unf 2ch3e4x 2chab 2chaf 2chatt 2chb 2chc 2chd
+2cheh 2chei 2chemp 2chf 2chg 2chh 2chinf 2chinh 2chiso 2chj 2chk 2chl4 2chn4 2chob 2chp 2chra 2chre
+2cht 2chuf 2chuh 2chum 2chunm 2chunt 2chur 2chut 2chv 2chz 2ck1eh 2ck1id 2ck1o2 2ck1uh 2ck1um3
+2ck1up 2ck3l 2ck3n 2ck3r 2cka2b 2cka2c 2ckal 2ckan 2ckap 2ckb 2ckc 2ckd 2cke2ro 2ckef 2ckemp 2ckf
+2ckg 2ckh 2ckk 2ckm 2ckp 2ckt 2ckunt 2ckv 2ckw 2ckz 2ckü 2cp 2cs 2cua 2d1a2lar 2d1ab 2d1air 2d1alp
+2d1ans 2d1ap 2d1art 2d1aus3 2d1b4 2d1e2vid 2d1eff 2d1ehr 2d1emb 2d1emot 2d1emp 2d1entg 2d1erz.
+2d1erzv 2d1esel 2d1eul 2d1f6 2d1g2 2d1h2 2d1ide 2d1imb 2d1in1it 2d1ind 2d1inf 2d1inh 2d1inj 2d1ins
+2d1inv 2d1irl 2d1irr 2d1isr 2d1j 2d1k4 2d1oh 2d1opf 2d1orc 2d1ord 2d1org 2d1q 2d1s 2d1t 2d1u2m1e
+2d1ufe 2d1uh 2d1umb 2d1ums 2d1umv 2d1unf 2d1ungl 2d1uni 2d1ur3t 2d1urk 2d1url 2d1urn 2d1ursa 2d1v2
+2d1ä2u 2d1äg 2d1äh 2d1ämt 2d1änd 2d1äng 2d1äp 2d3arc 2d3atta 2d3au2f 2d3aug 2d3etw 2d3int 2d3m2
+2d3p2 2d3rad 2d3rak 2d3rast 2d3rauc 2d3rea 2d3ref 2d3reic 2d3rez 2d3rh 2d3rob 2d3roll 2d3rose
+2d3rost 2d3rot 2d3rou 2d3rov 2d3rud 2d3ruh 2d3rät 2d3räu 2d3un3d 2d3unz 2d5ric 2d5rind 2daff 2dalte
+2danb 2danda 2danf 2danh 2danna 2danw 2danzi 2danzü 2darb2 2daud 2dauk 2dc 2de3e4 2deid 2deig 2deise
+2dekz 2delek 2delem 2delf. 2delfm 2dentd 2dentn 2dentw 2dentz 2deol 2depoc 2derdb 2didy 2dimp 2diso
+2dope 2dort 2dosm 2drahm 2draub 2draum 2draup 2dreg 2drek 2drese 2drip 2drisi 2driss 2drohr 2dräd
+2dumd 2dumf 2dumg 2duml 2dumr 2dunr 2dunsi 2dunt 2dunw 2däq 2därz 2däx 2düb 2e1a 2e1che 2e1cl 2e1ha
+2e1hi 2e1ho 2e1hu 2e1lu 2e1lä 2e1ny2 2e1nö 2e1ro. 2e1um 2e1ß 2e3ca 2e3ce 2e3f2o 2e3j 2e3kr 2e3ku
+2e3lem. 2e3len. 2e3lie 2e3nad 2e3rem 2e3ron 2e3taf 2e3te 2e3xy 2eb2s1 2eba 2ebea 2ebec 2ebed 2ebeg
+2eber 2ebet 2ebew 2ebh 2ebi 2ebl 2ebo 2ebr 2ebu 2eckt 2eco 2ect 2edip 2ee 2efe 2efi 2efl 2efr 2efu
+2efä 2efü 2egi 2egl 2eher 2ehm 2ehn 2eht2 2ei3a2 2eidn 2eig. 2eig2er 2eiga 2eigeb 2eigeh 2eiges
+2eigew 2eigi 2eigre 2eigru 2eigrö 2eigrü 2eigs 2eigt 2eigu 2eigä 2eil. 2eilb 2eim. 2eimp 2einfo 2eir
+2eitä 2ekt 2ekä 2el1emp 2elai 2elao 2elat 2eld 2elei 2eler 2elig 2elk 2eln 2elo 2ely 2elzy 2elö 2eme
+2emie 2emin 2emä 2emü 2enat 2endel 2ene. 2ene2m 2enen 2enes 2eni 2enniv 2ens4to 2entfo 2entfö 2entö
+2enu4t 2ep2a 2eper 2epist 2epr 2erdec 2erdel 2erdy 2eren 2erer. 2ererb 2ererv 2ererw 2eres 2erhai
+2erham 2erhas 2erher 2eri 2erk. 2erkaj 2erkm 2erkre 2erl. 2erlag 2erren 2errü 2eru 2eröh 2es3sc
+2es3w 2esb 2esf 2esh 2esi 2esm 2esr 2ess. 2essk 2esso 2essp 2essä 2etal 2etb 2etg 2eth. 2eto 2etr
+2etw 2etz 2euc 2eud 2euna 2eux 2ev2e 2evor 2ex. 2ex2ta 2exas 2exc 2exd 2exes 2exik 2exs 2ext. 2extu
+2extv 2exu 2ey1 2f1a2b5l 2f1a2p 2f1ab5b 2f1ab5s 2f1an3k 2f1an3z 2f1astr 2f1auf 2f1c 2f1e2he 2f1e2ta
+2f1eing 2f1einw 2f1emp 2f1ex 2f1i2so 2f1inf 2f1int 2f1j 2f1o2f 2f1op 2f1q 2f1u2ni 2f1unf 2f1ungl
+2f1unm 2f1v 2f1ärm 2f1ätz 2f1ök 2f3art 2f3arz 2f3d4 2f3entf 2f3entw 2f3entz 2f3leb 2f3n2 2f3rad
+2f3rah 2f3rep 2f3ric 2f3roc 2f3url 2f5lein 2f5lon 2fabf 2fabg 2fabn 2fabw 2fanb 2fanl 2fanr 2fanw
+2fe2lek 2felem 2ferd. 2feu. 2fimp 2fl. 2flins 2fober 2fre. 2fref 2freg 2freim 2frein 2frek 2frest
+2frig 2frol 2fräd 2funr 2funt 2fäq 2färz 2fäug 2fäx 2fö2f 2füb 2g1alau 2g1alg 2g1alp 2g1alta 2g1altd
+2g1amt 2g1ank 2g1ansi 2g1app 2g1arm 2g1arti 2g1arz 2g1auf 2g1aus 2g1aut 2g1b4 2g1c 2g1e1ul 2g1eid
+2g1eif 2g1einr 2g1eise 2g1ernt 2g1h 2g1ill 2g1inf 2g1ins 2g1int 2g1j 2g1lac 2g1lag 2g1lam 2g1lauf
+2g1m2 2g1o2f 2g1oh2 2g1org 2g1osz 2g1q 2g1t 2g1u2f 2g1u2ni 2g1uh 2g1unf 2g1ungl 2g1url 2g1v 2g1w
+2g1äp 2g3aug 2g3isel 2g3le. 2g3leb 2g3leg 2g3lieb 2g3loch 2g3luf 2g3p4 2g3radl 2g3rah 2g3rak 2g3raub
+2g3rec 2g3ref 2g3reic 2g3reih 2g3renn 2g3ric 2g3riem 2g3rol 2g3rose 2g3rost 2g3ruh 2g3rum 2g3rut
+2g3rüc 2g3unk 2g3z2 2g5f4 2g5nah 2g5re. 2ga2dr 2gabf 2gabg 2gabsc 2gabtr 2gabw 2gabz 2gadl 2ganb
+2ganf 2ganl 2ganmu 2ganr 2ganst 2ganw 2garc 2gatm 2gd 2ge2lek 2gek. 2gelem 2gentf 2gentw 2gerdg
+2gimp 2gind 2ginh 2ginv 2giok 2gladu 2gland 2gleh 2gles 2glib 2glif 2glis 2glos 2gls 2gluk 2glw
+2gläuf 2glöch 2glös 2glöw 2gn. 2gn3ent 2gnac 2gnanl 2gnb 2gnc 2gnd 2gnf 2gng 2gnh 2gni4s3 2gnint
+2gnk 2gnl 2gnm 2gnp 2gnr 2gns 2gnt 2gnu 2gnv 2gnw 2gnz 2gobj 2gog 2gope 2gopt 2gord 2gra2r 2graum
+2grege 2grig 2groc 2groh 2gruf. 2gräd 2gröh 2gued 2gunr 2gunt 2gärz 2güb 2h1amt 2h1ap 2h1aufm 2h1c
+2h1emb 2h1h2 2h1j 2h1k4 2h1m 2h1org 2h1ums 2h1v 2h1z 2h2al. 2h3b4 2h3d4 2h3emp 2h3entw 2h3f4 2h3g4
+2h3p4 2habn 2habw 2hae 2haka 2hale 2hana 2hanb 2hani 2hanl 2hano 2hanr 2hanz 2harb 2hats 2heff 2hefr
+2hein 2helt 2henga 2herif 2hexp 2hi3n2i 2hi3re 2hid 2hima 2hio 2his. 2hn 2ho2e 2ho2w1 2hod 2hoi
+2holy 2hope 2hore 2hot. 2hot3s2 2hunf 2hunt 2hur 2hw2 2hy2t 2härz 2häug 2hö. 2i1b 2i1en 2i1g 2i1h
+2i1k 2i1o 2i1pi 2i1q 2i1v 2i1w 2i1x 2i2d3r 2i3rad 2i3u2 2i5rig 2ic 2idel 2idia 2idoo 2idu 2if 2ilb
+2ile 2ilh 2ili 2ilo 2ilv4 2imo 2imt 2imu 2in. 2in3t4r 2ind. 2inde. 2inden 2indr 2indä 2ine 2ing
+2inhar 2inhau 2inhe 2inie 2inig 2inis 2ino 2inri 2inse. 2insed 2insen 2insk 2inst2e 2inta 2inte.
+2inth 2intö 2inä 2ip. 2ipf2 2ips 2ipu 2irek 2irk 2irn 2iru 2isb 2ise 2isf 2isma 2ismi 2isp 2itel
+2itr 2itt 2iä 2j1v 2k1a2ben 2k1abb 2k1abs 2k1abt 2k1adm 2k1age 2k1akt. 2k1allt 2k1anor 2k1ans
+2k1anth 2k1anzu 2k1arm 2k1ast. 2k1auss 2k1e1c 2k1e2va 2k1e2x 2k1eic 2k1eig 2k1ein 2k1emp 2k1entg
+2k1entl 2k1ents 2k1er2zi 2k1h4 2k1i2so 2k1indi 2k1indu 2k1inf 2k1inse 2k1inst 2k1int 2k1k4 2k1lac
+2k1lüc 2k1m 2k1o2fe 2k1o2x 2k1orc 2k1org 2k1p2 2k1uhr 2k1up. 2k1urk 2k1urt 2k1w 2k1änd 2k1ärg
+2k3a2bo 2k3a2dr 2k3c 2k3d4 2k3eros 2k3g2 2k3j 2k3ler 2k3leu 2k3liz 2k3loc 2k3nad 2k3näp 2k3oas 2k3q
+2k3rad 2k3rah 2k3reak 2k3real 2k3rec 2k3rede 2k3ref 2k3reic 2k3reih 2k3rh 2k3ric 2k3ries 2k3run
+2k3räum 2k3uml 2k3v 2k3z2 2k5nach 2k5nam 2k5neu 2k5niv 2k5re. 2kabd 2kabf 2kabg 2kabh 2kabn 2kabw
+2kabz 2kadv 2kanb 2kanda 2kandä 2kanf 2kanim 2kanl 2kanom 2kanzü 2karbe 2karc 2katt4 2kausw 2kauto
+2kaz 2ke1o2 2ke2lek 2keff 2keise 2kentf 2kentw 2kentz 2kep 2kerd 2kergu 2kersa 2ki2de 2ki3l2a 2kidy
+2kiern 2kilä 2kinh 2kiz 2kland 2kleh 2klic 2klig 2klist 2klok 2klose 2klux 2kly 2kläd 2klöc 2klöf
+2knah 2knes 2knetz 2kney 2knorm 2knov 2knum 2ko1pe 2kobj 2kop. 2kop3s 2kopz 2korpi 2kraum 2kred.
+2kredn 2kredu 2kreim 2kresu 2krib 2krip 2krot 2kruf 2kräd 2kröh 2kumg 2kunt 2kunw 2kut. 2l1a2dr
+2l1ada 2l1adl 2l1al 2l1amt 2l1ann 2l1anp 2l1apf 2l1arom 2l1ausb 2l1auss 2l1c 2l1eff 2l1eig 2l1entk
+2l1enzy 2l1erfo 2l1erz 2l1erö 2l1esel 2l1eul 2l1g 2l1ido 2l1indu 2l1inf 2l1inh 2l1inj 2l1insu 2l1int
+2l1inv 2l1isl 2l1k 2l1m 2l1orc 2l1ord 2l1ov 2l1p 2l1q 2l1ufe 2l1uh 2l1umh 2l1ums 2l1umw 2l1una
+2l1unf 2l1uni 2l1uns 2l1v2 2l1z 2l1ähn 2l1äpf 2l1öhr 2l1öl3 2l3atl 2l3h2 2l3o2ly 2l3r2 2l3öfe 2la1ho
+2la2b3l 2la2ben 2la4nä 2la4sp 2labb 2labd 2labh 2labn 2labs 2labw 2labz 2ladd 2ladj 2ladm 2laf 2lamn
+2lanf 2lanha 2lanl 2lans2 2lantw 2lanw 2larc 2larm. 2lash 2lask 2lat2t1a 2latm 2laun. 2lausd 2lausf
+2lausg 2lausl 2lausr 2lausw 2lausz 2lauto 2le2tap 2le2tat 2lec 2leint 2lektr 2lekz 2lelek 2lelf.
+2lemp 2lency 2lentf 2lentn 2lentz 2lep 2ler2wo 2lergi 2lerke 2lermä 2lesy 2leuro 2lex 2limm 2limp
+2linsp 2linst 2linsz 2linz 2lisol 2lisot 2lobj 2loe 2lope 2lopt 2lort2 2lox 2luff 2lumd 2lumf 2lumg
+2lumk 2luml 2lumr 2lumz 2lunr 2lunt 2lunw 2lur 2luse 2luto 2lx 2lymp 2lämt 2läq 2lärz 2lät 2läub
+2läuc 2läue 2läug 2läx 2löck 2löd 2lök 2löp 2lüb 2lüh 2m1a2d4r 2m1a2nal 2m1a4nat 2m1adm 2m1agg
+2m1ago 2m1akt 2m1angr 2m1ansa 2m1anä 2m1arti 2m1au2f 2m1b2 2m1d 2m1e2mis 2m1e2mu 2m1e2pi 2m1eff
+2m1eif 2m1eig 2m1emp 2m1endl 2m1ex 2m1f4 2m1g2 2m1h4 2m1imm 2m1indu 2m1inse 2m1j 2m1m 2m1o2x 2m1obs
+2m1opf 2m1q 2m1t 2m1uh 2m1v 2m1w2 2m1z2 2m1ähn 2m1änd 2m1äp 2m1öl 2m3abf 2m3n2 2m3r2 2mabb 2mabg
+2mabk 2mabm 2mabs 2mabt 2mal2de 2mallt 2manb 2manl 2mansä 2mantw 2manw 2manz 2marb 2matmo 2mausd 2mc
+2melem 2melf. 2mentn 2meou 2mepa 2mes2sa 2meö 2midy 2mimp 2minfo 2minh 2mo1pe 2mobj 2mog. 2mopt 2mäo
+2mäq 2mö2f 2müb 2n1ab 2n1af 2n1ak 2n1albk 2n1amt 2n1c 2n1e2tat 2n1e2tu 2n1ebn 2n1egg 2n1ei 2n1eks
+2n1ems 2n1erlö 2n1ernt 2n1ernä 2n1ersa 2n1erz 2n1eup 2n1ex 2n1f 2n1j 2n1m2 2n1o2x 2n1ob2s 2n1oh
+2n1org 2n1ort 2n1q 2n1uh 2n1ums 2n1umv 2n1v2 2n1ä2m 2n3an. 2n3i2gel 2n3idy 2n3l2 2n3okk 2n3umb
+2n3umz 2n3w 2naly 2nani 2nanr 2nantr 2nanw 2narc 2nart 2ne2tap 2ne3sh 2ne4tag 2nec 2nee 2nefr 2nehe.
+2nehem 2nehen2 2nepf 2ner3g4 2nerdb 2nerfü 2niget 2nind 2ninf 2ninh 2nins 2nit 2no2d 2no2ly 2no2sti
+2nobj 2noff 2nony 2norc 2nostv 2nu1c 2numl 2numr 2nunr 2nup 2nur 2näq 2näu 2nöd 2nü4b 2o1e2 2o1fl
+2o1fr 2o1g 2o1ha 2o1hi 2o1ho 2o1hy 2o1j 2o1k 2o1ped 2o1pei 2o1pen 2o1pr 2o1q 2o1raw 2o1ro 2o1rö 2o1t
+2o1um 2o1w 2o1ö2 2o1ü 2o2u2n 2o3a2 2o3b2i 2o3b2ä 2o3bec 2o3bu 2o3bü 2o3d2a 2o3dia 2o3dir 2o3du
+2o3f2es 2o3fer 2o3ni 2o3po 2o3ru 2o3s2a 2o3sy 2o3z2 2ob. 2ob2lö 2obb 2obe. 2obea 2obef 2oben 2obev
+2obez 2oblo2 2obo 2obrü 2oby 2obö 2oc 2odif 2odn 2odr 2ofa 2ofi 2ofo 2oft 2ofu 2ofä 2ofö 2ohl 2ohö
+2oi 2ol 2om 2ona 2onc 2one 2ong 2onn 2onuk 2onut 2onä 2op. 2ope. 2opel 2opf. 2opl 2oppt 2opy 2or.
+2or3a2b 2ora. 2orar 2orau 2orca 2ord. 2ordb 2ordr 2ordu 2ordw 2ore 2orf 2orget 2orgia 2orgr 2orh
+2oria 2oric 2oris 2oriu 2ork 2orm 2orp 2orq 2orr 2ors2 2os1p 2os2kl 2os2ko 2osc 2ose 2osh 2osi 2oso
+2oss 2osu4 2ovel 2ovi 2ovo 2ox. 2oxk 2oß 2p1auf 2p1b 2p1d2 2p1hei 2p1hü 2p1j 2p1k2 2p1n2 2p1p 2p1s
+2p1t 2p1uh 2p1v 2p1w 2p1z2 2p3a2dr 2p3c 2p3g2 2p3le. 2p3ler 2p3lu 2p3m2 2p3rer 2palt 2panl 2pann
+2pantr 2parb 2parer 2parfö 2parg 2parp4 2parr 2patel 2pausz 2paß 2pef 2peic 2peis 2peku 2pentw
+2perse 2persi 2pex 2pf. 2pf3t4 2pfs2 2ph. 2ph1ers 2phb 2phd 2phf 2phg 2phk 2phm 2phn 2phro 2phs
+2phthe 2phz 2phö 2piso 2pl. 2plig 2poh 2pond 2pre. 2prec 2pree1 2preg 2preiz 2prig 2pring 2proc
+2prott 2prö 2prüh 2prün 2puc 2puk 2pulw 2pur 2pül 2r1acet 2r1amt 2r1anm 2r1anp 2r1aq 2r1e2pos
+2r1e2x1 2r1eilt 2r1endg 2r1entg 2r1entl 2r1ents 2r1er2ö 2r1erb 2r1erd 2r1erf 2r1erm 2r1erni 2r1ernä
+2r1ersa 2r1erz 2r1eur 2r1evid 2r1inf 2r1inh 2r1innr 2r1inq 2r1ins 2r1inv 2r1j 2r1l 2r1q 2r1r 2r1uhr
+2r1una 2r1unm 2r1v 2r1w 2r1z 2r1är 2r1ök 2r3a2tom 2r3abw 2r3air 2r3alk. 2r3alm. 2r3er2la 2ra1ho
+2ra2br 2ra2pri 2raac 2raal 2rabd 2rabf 2rabg 2rabh 2rabk 2rabs4 2rabt 2rabz 2radap 2ragg 2raic 2rakk
+2rakti 2rakz 2ralp. 2ramn 2ranb 2ranf 2ranga 2ranna 2ranr 2rans 2rantr 2ranw 2rapf 2rapo 2rarc 2rart
+2rarz 2rasyl 2ratm 2ratta 2rattr 2rau3g2 2rauf 2rausb 2rausd 2rausf 2rausg 2raush 2rausl 2rauss
+2rausv 2rausw 2rauto 2raß 2reck. 2recki 2redi 2reh 2reid 2reig 2reinb 2reinf 2reisf 2reish 2reisr
+2reisw 2relb 2relf 2relit 2relix 2relt 2rem2u 2remis 2rena. 2rengp 2rentd 2rentf 2rentw 2rentz 2repi
+2repoc 2rergo 2rerlö 2rersp 2rerwa 2res2tu 2ress 2reul 2rewo 2rezi 2reä 2reü 2rh. 2rha 2rheb 2rhef
+2rheit 2rher 2rhi 2rhof 2rhol 2rhot 2rhs 2rhä 2rhöl 2rhü 2ri3t4r 2rima 2rimm 2rimp 2rindu 2rinit
+2rinl 2rint 2risol 2robj 2robs 2roff 2roly 2romb 2romn 2ropf 2ropt 2rorc 2rum 2rund 2rungl 2rupd
+2räh 2räp 2räq 2räuss 2räuß 2räx 2rö2f 2röl. 2rüb 2s1a2dr 2s1a4si 2s1a4sp 2s1ab 2s1ada 2s1adm 2s1agg
+2s1ak 2s1ap 2s1aq 2s1ar 2s1asc 2s1ce 2s1e2ben 2s1e2ros 2s1echo 2s1echt 2s1eic 2s1eid. 2s1eis 2s1elix
+2s1emis 2s1endl 2s1essa 2s1h 2s1imm 2s1ind 2s1inf 2s1inh 2s1inno 2s1inq 2s1ins 2s1int 2s1inv 2s1k2
+2s1l2 2s1o2b 2s1o2rie 2s1ohr 2s1opf 2s1ox 2s1q 2s1u2f 2s1uh 2s1una 2s1unm 2s1uns 2s1urk 2s1w 2s1z2
+2s1äm 2s1är 2s1ök 2s1öl 2s1ös 2s2tint 2s3a2na 2s3a4tem 2s3anb 2s3anh 2s3anl 2s3anp 2s3ans 2s3atl
+2s3e2leg 2s3e4tap 2s3einh 2s3einz 2s3j 2s3m2 2s3n2 2s3oas 2s3ohng 2s3ok 2s3ord 2s3para 2s3pe. 2s3pn
+2s3pres 2s3talb 2s3tet 2s3tik 2s3täus 2s3töl 2s3umst 2s3umwa 2s4pt 2s4torg 2s4trig 2s5tris 2sa2no
+2sanz 2satm 2sauf 2saus 2sauß 2sc. 2scab 2scac 2scal 2scam 2scar 2schd 2schf 2schg 2schh 2schk
+2schmy 2schmö 2schn. 2schox 2schq 2schv 2schz 2schäq 2scj 2sco 2scs 2scu 2sei. 2sei3n2e 2seinb
+2seini 2seink 2seinl 2seinn 2seinw 2self. 2sentd 2sentw 2sentz 2serhö 2sersa 2sexa 2siat 2sidy 2sirr
+2sope 2sp. 2sp4l 2spack 2spag 2spak 2spala 2spalä 2spanz 2spap 2sparo 2spati 2spau 2speg 2spero
+2spers 2sperü 2spha 2spip 2spod 2spog 2spop 2sprak 2sprax 2sprob 2sproz 2spräm 2sprüf 2spub 2spud
+2spun 2spup 2sput 2späd 2spär 2späs 2st3c 2st3t4 2st3url 2st3urt 2st3z2 2sta. 2stabb 2stabl 2stag
+2stak 2stala 2stalk 2stanb 2stanf 2stanl 2stanw 2statb 2stauf 2staug 2stb 2std 2stee 2steic 2steil
+2stel. 2steln 2stels 2stem 2sten 2ster 2stf 2stg 2sth 2stia 2stib 2stie. 2stien 2stig 2stimp 2stio
+2stip. 2stite 2stiv 2stj 2stk 2stm 2stn 2sto. 2stod 2stok 2stopo 2store 2stori 2storp 2stors 2stort
+2stotr 2stow 2stoz 2stp 2stq 2strac 2stral 2stre. 2strep 2stret 2strib 2striu 2stros 2strua 2strub
+2strug 2strun 2ströp 2stue 2stug 2stum2s 2stumo 2stumt 2stun. 2stune 2stung 2stuns 2stunt 2stus
+2stuö 2stv 2stw 2sty 2stön 2stöt 2stütc 2sumf 2sumse 2sunt 2sunw 2sy2l1 2säq 2sö2f 2sü4b 2t1a2bit
+2t1a2ka 2t1a2na 2t1a4nä 2t1abb 2t1ac 2t1akk 2t1alm. 2t1anzu 2t1arm 2t1arz 2t1auf 2t1ausb 2t1e2ben
+2t1e2pi 2t1e2xe 2t1e2xi 2t1ecu 2t1egg 2t1ents 2t1erbs 2t1erbt 2t1erzb 2t1essa 2t1eup 2t1exz 2t1inf
+2t1inka 2t1inku 2t1isl 2t1isr 2t1j 2t1q 2t1umh 2t1umsc 2t1umw 2t1una 2t1und 2t1unm 2t1unv 2t1up.
+2t1y2a 2t1z 2t1ält 2t1ö2d 2t3abn 2t3abt 2t3assi 2t3heb 2t3hef 2t3hei 2t3herz 2t3hil 2t3him 2t3hir
+2t3hob 2t3hoh 2t3hot 2t3ohr 2t3p4 2t3rad. 2t3red 2t3reh 2t3reig 2t3reih 2t3reis 2t3repo 2t3rett
+2t3rez 2t3rh 2t3ruc 2t3rut 2t3rüc 2t3umg 2t3umk 2t3umr 2t3umt 2t3umz 2t3unf 2t5ausw 2t6ernc 2ta4br
+2tabd 2taben 2tabg 2tabk 2tabla 2tadd 2takz 2tam 2tanb 2tanh 2tanp 2tantr 2tanwa 2tanwä 2tapf 2tarab
+2taram 2tarb 2tarc 2tart 2tatt 2tausd 2tausr 2te2tap 2teak 2teche 2teck 2teff 2teh 2tein 2telem
+2temm 2tempf 2tep. 2teppu 2teril 2teros 2th2a 2th3l 2th3n 2thb 2themd 2themm 2thf 2thk 2tholz 2thou4
+2thp 2ths 2tht2 2thub 2thuh 2thut 2thv 2thä 2thü 2ti3tu 2tic 2tieh 2tillu 2timp 2to4pt 2tobj 2toffi
+2tomg 2tomk 2tr. 2tradp 2trahm 2traup 2trea 2trepe 2trige 2trout 2träd 2träuc 2tröh 2ts 2tu2nio
+2tub2 2tud 2tuf 2tuml 2tunif 2tuniv 2täh 2täq 2tärz 2täug 2täuß 2täx 2tää 2tö2f 2töl. 2tü 2u1b 2u1d
+2u1e 2u1g 2u1k2l 2u1rä 2u1rö 2u1t 2u1v4 2u1x 2u1z 2u1ß 2u2nu 2u3u4 2u5he 2ua 2uff 2uft 2uh3ri 2uhi
+2uhl 2uhm 2uhü 2ui 2ul3f4 2ule 2umm 2un2a1s4 2una. 2und. 2unde 2undg 2undsc 2unk 2uns. 2untu 2unz
+2uo 2urc 2urf 2urr 2urta 2us 2v1ab 2v1ass 2v1au 2v1b 2v1c 2v1d2 2v1f4 2v1g 2v1h 2v1i2m 2v1in3d
+2v1int 2v1k 2v1l2 2v1m 2v1n2 2v1ob 2v1op 2v1p 2v1t 2v1v 2v1w 2v1z 2v1ü 2valu 2vanb 2vang 2varb
+2ve3s2c 2ve3s2e 2veig 2vein 2velan 2vemu 2veral 2veü 2vii 2vs 2vumf 2vumg 2vumk 2w1b2 2w1c 2w1d 2w1g
+2w1k 2w1l 2w1m 2w1p 2w1t 2w1w 2w1z 2w3ey 2w3äu 2walb 2wang 2weie 2werg 2wets 2wieb 2wing 2wn 2wäng
+2x1b4 2x1e4g 2x1eu 2x1ex 2x1g 2x1h 2x1j 2x1k2 2x1m 2x1n 2x1r 2x1u2n 2x1w 2x1z 2x1ö2 2x3oe4 2xa2b
+2xal 2xc 2xek 2xerl 2xid 2xod 2xv 2xy 2y1p 2yab 2z1a2b 2z1a2d 2z1a2m 2z1af 2z1al 2z1as 2z1aut
+2z1e2ben 2z1e2cho 2z1e2lit 2z1e2th 2z1e2x1 2z1eff 2z1ein 2z1emp 2z1er4sa 2z1ergu 2z1ergä 2z1erq
+2z1erz 2z1erö 2z1eul 2z1h2 2z1i2so 2z1ind 2z1inf 2z1inj 2z1inv 2z1j 2z1l2 2z1m2 2z1o2r 2z1ob 2z1oh
+2z1osz 2z1ou 2z1q 2z1t 2z1uhr 2z1um. 2z1umb 2z1ums 2z1unem 2z1ungl 2z1uns 2z1urk 2z1url 2z1urn
+2z1urs 2z1urt 2z1v 2z1wed 2z1wel 2z1wen 2z1wer 2z1wes 2z1wo 2z1wü 2z1äc 2z1äm 2z1äus 2z1ök 2z3ak
+2z3aug 2z3c 2z3d2 2z3erhö 2z3g4 2z3n2 2zanb 2zanf 2zangs 2zanr 2zarb 2zarm 2ze2lek 2zelem 2zengp
+2zentw 2zentz 2zerlö 2zeta 2zetts 2zimp 2zint 2zope 2zu2nio 2zumf 2zumg 2zuml 2zumr 2zunab 2zuniv
+2zunr 2zunt 2zwa2s 2zwag 2zwah 2zwal 2zwap 2zweg 2zweh 2zweil 2zwirt 2zwiss 2zwäs 2zäuß 2zö2f 2zöls
+2zön 2zü4b 2ß1b4 2ß1c 2ß1d2 2ß1e2b 2ß1e2g 2ß1e2p 2ß1ec 2ß1ef 2ß1ei 2ß1emp 2ß1entl 2ß1es2s 2ß1est3r
+2ß1ex 2ß1f4 2ß1h 2ß1il 2ß1im 2ß1in 2ß1j 2ß1l 2ß1m 2ß1n2 2ß1p2 2ß1t 2ß1um 2ß1v 2ß1w 2ß1z2 2ß1ä 2ß1ö2
+2ß1ü4 2ß3a4 2ß3g2 2ß3i2k 2ß3k4 2ß3o2 2ß3r2 2ß3s4 2ßelek 2ßentz 2ßer4se 2ßunt 2ä1e 2ä2uf 2ä2un 2ä3he
+2ä3n2i 2ä3s2e 2ä3te 2ä3us. 2ähm 2ähr 2ähs 2äht 2äi 2äma 2äml 2ämp 2än. 2än2e 2än2f5 2än2g3l 2än3g2e
+2äns 2änz 2ärd 2äre 2ärt 2äs2s1c 2äu3r2 2äub 2äul 2äum 2äusc 2öp 2ösc 2ösl 2ü1ß 2übc 2übd 2üc 2ün
+2üt 3a2bo. 3a2bon 3a2er2o1 3a2lema 3a2maz 3a2mul 3a2n1e4k 3a2teli 3a2temg 3a4l3erwä 3a4nim 3a4zal
+3ab1it 3ab3lei 3abga 3abtr 3abz 3adap 3adj 3admi 3aggr 3airb 3akze 3albat 3alenc 3algi 3algor 3allee
+3aloe 3alp. 3alpe. 3amse 3an3d2ac 3aneig 3anfä 3anlag 3anschr 3antei 3antise 3anzün 3armee 3asyl
+3athl 3atla 3atm 3atomk 3attac 3aufent 3aussag 3ausü 3b2and 3b2e1s 3b2ew 3b2ez 3b2it. 3b2ox 3b2y1
+3b2ä1c 3b2äd 3b4loc 3b4lum 3b4rem 3b4rö 3ba. 3bar2s 3bas 3bau. 3bea 3beb 3bek 3bel 3beng 3bensz
+3berg. 3bers. 3bet 3bev 3bib2 3bietu 3bil 3bis 3ble2a 3blec 3blick 3blitz 3blut 3blät 3blü 3bon.
+3bons 3bor. 3bot 3brä 3brü 3cam 3cels 3chanc 3chao 3chara 3chard 3charta 3chef. 3chefs 3chemi
+3chines 3chromo 3chron 3chör 3ck4is 3co2d2 3coa 3com 3cre2 3d2an. 3d2eic 3d2eim 3d2oba 3d2ör 3d4ra.
+3d4rab 3d4ral 3d4reck 3d4reh 3d4reie 3d4ria 3d4risc 3d4rit 3d4rohu 3d4ruc 3d4rüs 3da. 3dam 3dane
+3darl 3dars 3daub 3daw 3de3ru 3debü 3dehn 3delik 3desw 3dic 3dif 3dig 3ding 3drif 3dyn 3däc 3e2f1ene
+3e2meti 3e2tui 3eckty 3effek 3einger 3einkä 3einric 3einsat 3eintö 3elter4n 3embry 3entgeg 3entwic
+3er1eul 3erbarm 3ergebn 3ergiee 3erhebu 3eritr 3erlaub 3erlebn 3erneue 3erup 3erweck 3exp 3f2abr
+3f2aku 3f2an. 3f2ina 3f2jo 3f4av 3f4lim 3f4läc 3f4reu 3fa5ri 3fe. 3fep 3ferei 3fete 3few 3fez 3fi.
+3foli3 3form 3fuc 3fug 3fut 3g2ano 3g2ard 3g2het 3g2hie 3g2laub 3g2lid 3g2lit 3g2ly 3g2num. 3g2nä
+3g4rup 3ga. 3gar. 3gebü 3gefä 3gesc 3gh2r 3ghale 3glanz1 3glea 3gles. 3glü 3gon. 3gons 3gou 3grus
+3gruß 3gumm 3göt 3gür3 3h2ape 3hai. 3heft 3hemd 3hemm 3heusc 3hole 3holz 3hym 3hyp 3i2sot 3ingeni
+3inkarn 3instal 3instit 3intrig 3islam 3isom 3jou 3k2alk 3k2asc 3k2lim 3k4lina 3k4lop 3kadu 3kah
+3kanä 3kara 3kasu 3kateg 3kin. 3kir 3kom 3kost 3kow 3kroth 3kug 3küne 3kür 3l2ab. 3l2adu 3l2and
+3l2aus. 3l2ela 3l2erra 3l2eut 3l4ergew 3l4erlei 3l4erne 3la. 3lab2o 3labil 3laden 3lafü 3lai 3lala.
+3lali 3lao 3laru 3lasser 3le3u2f 3leh 3leih 3leko 3lemes 3lemet 3lepa 3lepf 3lepr 3lergeh 3lesu
+3lexik 3lhi. 3li1pf 3lib4 3lie. 3liefer 3lig 3limo 3lipt 3lis. 3list 3liu 3lob. 3lobb 3lok 3lorb
+3lose 3lux 3lyn 3lys 3läd 3lösc 3lösu 3lübd 3m2einu 3m2eist 3m2o2o 3m2od 3m2oh 3m2on 3m4er. 3m4us
+3ma. 3ma1rh 3ma3un 3malv 3manip 3mas 3maul 3maß 3mebr 3meh 3melk 3melo 3mes 3mige 3mir. 3miri 3mirs
+3mirw 3mirz 3mis. 3mit 3mog 3mom 3mos 3muld 3mult 3mumi 3mun 3my 3mäß 3müh 3mün 3müt 3n2ah 3n2amo
+3n2ann 3n2aul 3n2evi 3n2i3de. 3n2ia 3n2id. 3n2il 3n2inb 3n2inp 3n2inw 3n2is 3n2opa 3n2orl 3n2os.
+3n2ung4 3n4äc 3na. 3nabi 3nac 3nae 3nai 3nako 3nakä 3name 3nar. 3nar2i 3nas 3nat 3nav 3ne. 3ne3l2o
+3ne3lä 3neas 3neca 3nece 3nehm 3neia 3neigt 3neigu 3nelk 3nelu 3nen 3nepa 3nerfr 3nergr 3neri 3nert.
+3nes 3nez 3neß 3nike 3nin. 3ning 3nino 3nita 3nitr 3nix 3no. 3noblo 3noblö 3nom2e3 3nomp 3nor.
+3nordb 3norh 3nors 3norö 3nov 3noz 3nu1a 3nu2s 3nud 3nue 3nug 3nuhi 3nui 3numm 3nuo 3nut 3nuu 3nux
+3nuz 3nä1um 3näe 3näg 3nähe 3nähm 3näi 3näs 3né 3nü. 3nüs 3o2kel 3o4psi 3o4zea 3obj 3okw 3oliv 3omni
+3onkel 3outp 3p2sy 3p2ty 3p4lik 3p4rog 3pal2e 3pala 3palä 3party 3pel. 3pele 3pels 3pen3si 3perio
+3pero 3persp 3pet 3pha1s 3phy 3phän 3pier 3pilo 3pin. 3ping 3pins. 3pinse 3pip 3pirate 3pirin 3pla
+3plä 3pock 3pod 3poe 3poin 3pol 3portal 3porti 3porto. 3portos 3portr 3pote 3potä 3pred 3preis 3prob
+3proj 3proto 3präm 3präs 3pun 3put 3py1 3pä2c 3päd 3pär 3päs 3r2andi 3r2erbr 3r2erfr 3r2erki 3r2erko
+3r2erzy 3r2infr 3r2ins. 3r2insy 3r2äd 3r4al5t4h 3r4ald 3r4arei 3r4er. 3r4erges 3r4es. 3ra1k4l 3radar
+3radf 3radh 3radio 3rado 3radp 3rakü 3raly 3ramsc 3raner 3raub. 3raum 3raup 3raus2c 3re. 3red. 3redn
+3redu 3refe 3refl 3refo 3reg 3reigeh 3reigi 3reigru 3reigä 3reim 3rek 3rendi 3renh 3renl 3renm
+3rentfo 3repe 3repu 3rese 3resol 3reson 3resu 3richtu 3rig 3risik 3riss 3ritter 3rock. 3rogg 3rohr
+3roi 3roman. 3rond 3ronn 3roul 3ruf 3ruhm 3ruin 3rän. 3räni 3räns 3rätse 3röh 3römi 3rötu 3s2aa
+3s2ai 3s2al. 3s2and 3s2ard 3s2chal 3s2cop 3s2eg 3s2eit 3s2elb 3s2enk 3s2ers. 3s2ha. 3s2hop 3s2isc
+3s2it 3s2ki. 3s2kif 3s2kik 3s2klav 3s2low 3s2o3ba 3s2orti 3s2paz 3s2perg 3s2pi4e 3s2pli 3s2prac
+3s2pru 3s2pule 3s2teg 3s2teu 3s2turz 3s2ump 3s2up 3s2ze3n2e 3s2zena 3s2zew 3s2äb 3s2äc 3s2äg 3s2ärg
+3s2ät 3s2üs 3s4ar. 3s4eni 3s4how 3s4ie 3s4ig 3s4ist 3s4lip 3s4pez 3s4prec 3s4pur 3s4tad 3s4tagr
+3s4tah 3s4taur 3s4tereo 3s4ternb 3s4teti 3s4tett 3s4tigm 3s4troh 3s4tud 3s4tück 3sa. 3sabet 3safa
+3saga 3saki 3salb 3sald 3sali 3salo 3salz 3sam 3sang. 3sani 3sanken 3sapr 3sara 3sat2i 3satz 3sau.
+3sau2e 3sauc 3saum 3saus. 3sauste 3schaf 3sches 3schis 3schwu 3see 3seh 3sek 3sena 3sens 3seq 3seri
+3setz 3seuc 3shi. 3shid 3sili 3silo 3simu 3sio 3siru 3skanda 3skep 3skiz 3skop. 3skulp 3slal 3so.
+3so3ß 3soft 3sog 3sohl 3soi 3sol. 3sold 3sole 3son 3sopr 3sorp 3sos 3sott 3sov 3sow 3soz 3spalt
+3spannu 3spant 3spat. 3spaß 3speic 3sphär 3spio 3spring 3spross 3spräc 3sprö 3sprüc 3sprün 3spuk
+3späh 3st6reif 3staa 3stab. 3staff 3stagl 3staks 3stati 3steig 3stemm 3sterbo 3strah 3straß 3strom.
+3struk 3stuf 3stuh 3stäb 3städ 3stätt 3stör 3stüh 3stürz 3su1c 3su2b3 3sud 3sui 3sulta 3summ 3sun.
+3surf 3suv 3sy4s3 3säl 3säul 3süc 3sün 3süß 3t2al. 3t2anne 3t2arth 3t2as. 3t2eil 3t2et. 3t2hag
+3t2ins. 3t2umo 3t4artis 3t4er. 3t4ha. 3t4hema 3t4od 3t4rag 3t4ran. 3t4reib 3ta. 3tabel 3tacu 3taf.
+3tafe 3tag 3tale 3talo 3tam. 3tame 3tams 3tanc 3tanj 3tapol 3tar3bl 3tarabb 3tarba 3tarbek 3tarber
+3tarbi 3tarchr 3tari 3tarzu 3tasc 3tast 3tatb 3taten 3tatsa 3taubh 3taufe. 3taug 3taum 3tausc 3tav
+3tax 3taz 3te. 3te1u2n 3te2la 3team 3teba 3techn 3tefa 3teha 3tehi 3tehä 3tehö 3tei. 3teic 3tel.
+3telb4 3teld4 3telg 3telk 3teln 3telp 3tels 3telt4 3tem. 3tema 3temper 3ten 3terc 3tere. 3tere2m
+3teren 3terer 3teres 3terinf 3terinh 3term 3tes 3teuf 3teum 3teur. 3tewo 3thal. 3thalp 3thea 3theo
+3thi. 3thotr 3ti3te 3tib 3ticc 3tief. 3tiefl 3tiera 3tif. 3tig 3tilg 3timo 3tin. 3tinis 3tio 3tip
+3tirad 3tis 3tiv 3to. 3tobt 3tocht 3tog 3toi 3toj 3tok4 3tol 3tomo 3ton 3too 3top. 3topo 3tor 3tost
+3tote 3totr 3tow 3toz 3trac 3trahi 3trak 3tref 3trepp 3treuh 3tri 3tro. 3troe 3tron 3tropf 3trost
+3trua 3trub 3träg 3tröp 3trös 3tröt 3trümm 3tua 3tuc 3tue 3tuff 3tum. 3tume 3tun. 3tune 3tung.
+3tunge 3tunn 3tuns. 3turn 3tus 3typ 3täg 3tänz 3tätigk 3töch 3tön 3töt 3tüch 3tüf 3tüm 3tür. 3tür3s
+3türe 3türg 3türw 3tütc 3tüte 3u2nif 3ungena 3unty 3unwe 3upd 3upg 3ur3sac 3usus 3v2ri 3va. 3wage4n
+3walz 3weil 3x2em. 3x2ie 3xy. 3xys 3z2ah 3z2ank 3z2aro 3z2er. 3z2ern 3z2orn 3z2öll 3zali 3zar. 3zaub
+3zaun 3ze. 3zeit 3zerl. 3zuc 3zwing 3züc 3öl. 3ölm 4a1ki 4a3be. 4a3bec 4a3d2a2r3 4a3gen. 4a3kü
+4a3le. 4a3len. 4a3se 4a3ti 4a5ren. 4ab2el 4abes 4abil 4abot 4ach1w 4ack. 4adab 4ade1s 4aden 4adh
+4adi 4admu 4age. 4ahr 4ain 4akra 4akä 4alabo 4aland 4aler. 4ales 4alog 4aly 4ame. 4amsc 4anad 4andu2
+4ane. 4anen 4anern 4anfors 4ap. 4apro 4arbef 4arbi 4arem 4arg. 4armü 4aroc 4ata 4atb 4ates 4atli
+4atma 4atmus 4atmä 4atri 4atte. 4atto 4auc 4aufen. 4augeb 4augeh 4augel 4augl 4augr 4aum 4aun 4b1b
+4b1j 4b1t 4b3rüb 4b5n2 4b5w 4be2lek 4benteu 4billu 4bräd 4c1t 4ch. 4ch2m 4ch3erbs 4chanl 4chanz
+4char. 4chelem 4chents 4chentw 4cherke 4chic 4chind 4chrit 4chs 4chw 4chü 4ck. 4ck1ei 4ck3er4hö
+4ckeff 4ckentf 4ckentw 4ckese 4ckex 4cks 4cree 4d1all 4d1ammä 4d1amt 4d1d2 4d1erbs 4d1l2 4d1w
+4d3achse 4d3anei 4d3atl 4d3en4ge. 4d3i2co 4d3n2 4d3ren 4d3rep 4d3rer 4d3umk 4d3z2 4d5rut 4dapp 4daq
+4datm 4deie 4deime 4deinb 4deinw 4den4sem 4denerg 4dentf 4derklä 4dho 4dre. 4drem 4dres. 4driff
+4dritu 4dsb 4dsl 4dyl 4döl1 4e1rok 4e3le. 4e3ner. 4e3nie 4e3not 4e3q 4e3ric 4e3rie 4e3rin. 4e5nati
+4ebes 4ei3e2n 4eif. 4eigeno 4eih 4eitu 4eleh 4en1am 4ena. 4ena2c 4enah 4enatu 4eneigu 4enern 4eners.
+4enorm 4entwet 4enwü 4eo 4ere. 4ereih 4erern. 4erers. 4erfür 4ergebi 4ergebü 4ergeha 4ergehä 4ergeni
+4ergrem 4erhals 4eris 4erleh 4ersted 4erstil 4erteig 4ertru 4ertö 4euf 4f1b2 4f1f 4f1k4 4f1s 4f1t
+4f1w 4f1z 4f1öl 4f3entla 4f3ereig 4f3g2 4f3h2 4f3m2 4f3org 4f3p4 4fann 4fenerg 4fer4leb 4fläd 4flöf
+4g1e2x 4g1eff 4g1emp 4g1g 4g1lab 4g2s1 4g3e4sel. 4g3endmo 4g3led 4g3lein 4g3ler 4g3lun 4g3ring
+4g3rinn 4g5k4 4gangeb 4genda. 4generg 4ger4klä 4ger4seh 4gh. 4gl. 4glenk 4glil 4glin 4gungew 4h1t
+4ha3sa 4halp 4hauto 4he2o 4he3reig 4hea 4heio 4herap 4hese 4hi. 4hia 4hic 4hl 4hocy 4holdy 4hon.
+4hone 4hong 4honh 4honk 4hons 4hony 4hosö 4hotr 4hoz 4hr 4hs 4i1z 4ider. 4ike 4inga 4inn. 4innl
+4instra 4inträ 4inverm 4ish 4ito 4itä 4k1t 4k3b4 4k3erneu 4k3f4 4k3le. 4k3omn 4k3roh 4kala. 4kanw
+4kartik 4ken4gag 4kenlad 4kenläd 4kensem 4kerfah 4kindex 4kl. 4kla. 4kma 4kreg 4ks 4kulp 4l1b 4l1d
+4l1e2pi 4l1f 4l1l 4l1ohr 4l1or3g2 4l1s 4l1t 4l3einsa 4l3en4tro 4l3entw 4l3erhol 4l3j 4l3okk 4l3w
+4labf 4labg 4labo. 4lagg 4lalt 4lasd 4lehe. 4lehs 4leht 4leink 4leleme 4len4sem 4lendet 4lendun
+4lenerg 4leneuv 4lentla 4lerklä 4lesw 4leue 4lhe 4lick 4linsel 4lis2h 4lixi 4ln 4lork 4lorp 4loß
+4lu2b3 4luo 4löz 4löß 4m1k4 4m1l2 4m1p 4m1s 4m1unf 4m3ergän 4m3ungeb 4mar2o 4marag 4marr 4men4gag
+4merklä 4meser 4milz 4mitz 4mu4niv 4munw 4munz 4must. 4mök 4n1a2dr 4n1a2mer 4n1a4sp 4n1adl 4n1adm
+4n1agg 4n1air 4n1assi 4n1d 4n1emb 4n1endb 4n1endd 4n1endf 4n1endh 4n1endk 4n1endp 4n1endt 4n1endw
+4n1engb 4n1engs 4n1engt 4n1entb 4n1entl 4n1ents 4n1es2si 4n1est3r 4n1g 4n1k 4n1n 4n1s 4n1t 4n1une
+4n1uni 4n1z 4n1ähn 4n1än 4n1ök 4n1öl 4n3abs2 4n3ahn 4n3aho 4n3anb 4n3anf 4n3ang 4n3ank 4n3anl
+4n3anna 4n3anp 4n3ans4 4n3auss 4n3b4 4n3ehr 4n3emp 4n3entw 4n3erbe. 4n3erben 4n3erhö 4n3erneu
+4n3ersts 4n3h2 4n3imp 4n3int 4n3inv 4n3p4 4n3ungl 4na2mei 4na2na 4nadd 4nadv 4nakt 4nanh 4nano
+4napfel 4nasy 4natm 4natom 4nauf 4nausb 4nausd 4nausf 4nausg 4nausl 4nausr 4nausw 4nausz 4neic
+4neier 4neing 4neinh 4neinl 4neinz 4neip 4nelek 4nelem 4nemu 4nentd 4nentf 4nentn 4nentz 4nerfas
+4nerwar 4nesyn 4nidee 4nink2 4nisot 4nuhr 4numf 4numg 4numw 4nuna 4nunt 4nunv 4nunw 4näb 4näpfel
+4näst 4näuß 4nö2f 4o1lä 4o3rie. 4o4ur 4olc 4ole. 4olo 4omo 4oph2 4orda 4oril 4orin1 4oti 4ozi 4p1eff
+4p3lad 4parta 4pfe. 4phär 4pross 4ps. 4psys 4pten 4pund 4punt 4r1b 4r1d 4r1egg 4r1ernt 4r1f 4r1g
+4r1ir 4r1k 4r1m 4r1p2 4r1s 4r1t 4r1u2ni 4r1unf 4r1unl 4r1x 4r3arit 4r3einr 4r3ereig 4r3ergeb
+4r3erken 4r3erns 4r3eva 4r3i2gel 4r3inner 4r3osz 4r3u2r 4r3umd 4r3umf 4r3umg 4r3uml 4r3umsa 4r3umw
+4r3unt 4r5antei 4ragm 4raht 4ralpe 4ranl 4ratz 4rau. 4raur 4rc 4re2am 4re2ke 4re2pen 4rechs 4redd
+4reff 4reier. 4reifel 4reign 4reinn 4reisar 4reisb 4reiti 4rekk 4relem 4remb 4remit 4rempf 4rengag
+4repp 4rerfah 4rerträ 4resse 4ressu 4reuu 4ridee 4riefm 4riga 4rigr 4rij 4rinj 4rink 4rinte 4rn
+4rog. 4rohn 4rom. 4romt 4ronk 4roy 4roß 4rumz 4runn 4runr 4runw 4ruz 4räb 4räf 4räg 4räm 4rätz 4räue
+4räut 4röß 4rübu 4rümm 4s1a2mat 4s1a2mei 4s1acc 4s1amn 4s1asy 4s1e2ck 4s1e2kel 4s1e2tik 4s1eig
+4s1entg 4s1ents 4s1eul 4s1orga 4s1ost 4s1osz 4s1unf 4s1uni 4s1ält 4s3abs 4s3aff 4s3agent 4s3antr
+4s3anw 4s3arb 4s3attr 4s3aufb 4s3ausb 4s3ausw 4s3b4 4s3cei 4s3chris 4s3cl 4s3d2 4s3e2gal 4s3eff
+4s3eifer 4s3emp 4s3ermit 4s3ernt 4s3f4 4s3g4 4s3hoc 4s3hof 4s3hö 4s3kab 4s3kam 4s3kana 4s3kap 4s3kar
+4s3kas 4s3klas 4s3klu 4s3kon 4s3kra 4s3kro 4s3le 4s3o2ly 4s3obo 4s3pf4 4s3pil 4s3pis 4s3plu 4s3pok
+4s3pos 4s3ps 4s3r4 4s3tapos4 4s3tapot 4s3trad 4s3v2 4s3we 4s3za 4s3zei 4s3zent 4s3zer 4s3zie 4s3zo
+4s3zu 4s3zw 4s3zü 4s3ähn 4s3änd 4s3äp 4s4t3s2 4s5not 4s5ter. 4s5tole 4s5trag 4s5träg 4sabd 4sabm
+4sakk 4sakt 4samph 4santei 4sarm. 4sart 4sausf 4sausg 4sauss 4sch. 4sch3ar5m 4sch3ei. 4sch3t 4schanc
+4schang 4schao 4schara 4schb 4schc 4schech 4schemp 4schess 4schex 4schiru 4schle. 4schloc 4schlöc
+4schmas 4schmed 4schmüh 4schneb 4schnut 4schobj 4schorc 4schp 4schrad 4schre. 4schrin 4schron
+4schrou 4schs 4schunt 4schweg 4schwet 4schwid 4schör 4seinfl 4seing 4seinr 4seinsc 4seinsp 4seintr
+4sekz 4selem 4senerg 4sensem 4sentf 4sentla 4sentn 4sentwu 4sentwü 4ser4set 4serfül 4serseh 4seröf
+4sexp 4sexz 4sh. 4shan 4shom 4shs 4sk. 4sk3s 4sk3t2 4sk4n 4skalk 4skanä 4skateg 4skb 4sken 4sker
+4skir 4skoh 4skol 4skom 4skos 4skow 4skun 4skv 4skä 4skö 4skü 4so4sk 4sobj 4sosm 4spaa 4spartn
+4sparty 4spatr 4spein 4spensi 4sperle 4spet 4spier 4spla 4sple 4splä 4spoe 4spol 4spote 4spr. 4spred
+4spreis 4sprinz 4sprog 4sproj 4sprop 4spräs 4spunk 4spy 4st. 4st1ann 4stabel 4stabit 4stadm 4stapol
+4stari 4stausb 4stausg 4stausr 4stauss 4stax 4ste. 4ste2s1 4steam 4stechn 4sterm 4steuf 4stex
+4stief. 4stiefl 4stimma 4stipp. 4stis 4stl 4stocht 4stod. 4ston 4stoo 4stor. 4stote 4stou 4strahi
+4strai 4strak 4strans 4straum 4stref 4streib 4streuh 4stropf 4strup 4sträc 4sträne 4stuch 4stunn
+4sturn 4sty. 4styp 4stys 4stäg 4stäp 4stöch 4stüch 4stür. 4stüre 4subi 4swie 4swil 4swis 4swit 4szel
+4szet 4szeu 4szä 4säuß 4t1a2go 4t1agg 4t1anna 4t1asp 4t1axt 4t1c 4t1endl 4t1eng. 4t1g2 4t1inv
+4t1ort. 4t1t 4t1v2 4t1äp 4t1ök 4t3anl 4t3auge 4t3ausg 4t3auss 4t3b4 4t3elf. 4t3en4tro 4t3endal
+4t3energ 4t3engla 4t3ensem 4t3entl 4t3entn 4t3erde. 4t3erwäh 4t3hau 4t3hess 4t3hö 4t3i2gel 4t3ind
+4t3inse 4t3k4 4t3l2 4t3m4 4t3n4 4t3ordn 4t3rake 4t3rein 4t3rel 4t3rev 4t3rinn 4t3röc 4t3röss 4t3umf
+4t3umsat 4t3unt 4t3w 4t5d4 4t5endf 4t5endp 4t5entw 4t5hun 4t5re. 4t5riv 4ta3gl 4taa 4tab. 4tabf
+4tabh 4tabm 4tabs 4tabw 4tabz 4tachs 4tafet 4taff 4talb. 4talbk 4tanal 4tanf 4tangeb 4tansi 4tantei
+4tanzei 4tanzü 4tarkt 4taud 4taufg 4taufl 4tausf 4tausl 4tausz 4tauu 4tava 4tellu 4tempfi 4tendap
+4tentd 4tentz 4terbos 4terklä 4tery 4terzeu 4tetl 4teunu 4texp 4th. 4th3m2 4thak 4thäl 4tidee
+4tiefel 4tieß 4timm 4tinj 4toiz 4tolp 4tolz 4torc 4torga 4tort2s 4tortn 4toss 4toß 4trand 4trang
+4traub. 4trauc 4treb 4trec 4trefe 4trefl 4trefo 4treg 4treic 4treif 4treit 4trig. 4tring 4tript
+4trock. 4tromb 4tromk 4troml 4troms 4tromt 4truf 4truk 4truw 4träng 4träs 4träus 4träuß 4träß 4ts.
+4tsk 4tuh 4tä2m 4täb 4täd 4tägy 4tägä 4täll 4tätt 4töck 4tüb 4tüh 4türz 4tütz 4u1ro 4uc 4ult2a 4un.
+4un2d3r 4unds. 4unsy 4unsz 4unti 4unwä 4ur. 4utr 4uts 4w1s 4wur. 4x1d 4x1f 4x1p 4x1t 4x2l2 4x3s2
+4z1k4 4z1s4 4z1z 4z3b4 4z3ergeb 4z3erreg 4z3erzi 4z3f4 4z3p4 4z3r2 4za4na 4zakk 4zenge. 4zensem
+4zerleb 4zinsuf 4zunget 4ßenerg 5benp 5eigensc 5einschä 5fahrt 5fek 5g4amo 5g4asse. 5gag. 5höhe
+5l6erlebe 5lentwet 5loks 5lüd 5m2ann 5n2en3t2a 5n2enti 5nachw 5nats1 5naui 5nentr 5nerei. 5nerka
+5ney 5norm 5r2ers. 5r4ahm 5r4erlag 5renf 5rergebü 5rigj 5ritu 5rout 5ru3ro 5s2ache 5s2pren 5s4ein.
+5s4er3v 5s4eren 5s4es. 5s4i1c 5s4tär 5s6parten 5sagen. 5samm 5satza 5sebä 5serie 5sex. 5t2amen 5t2ou
+5t4rai 5t4rakt 5t4rans 5t4rigg 5t4rup 5t4ré 5taan 5tork 5tra. 5trade 5tral 5treck. 5treff 5trend
+5trennu 5trieb 5triko 5troy 5trunk 5träc 5träne 5tsubi 5werdens 5zen. 6b5rechte 6chergeb 6cken6sem
+6ckergeb 6ckerzeu 6dertrag 6e3rei. 6e3reib 6fel6tern 6gassess 6hergebn 6k5antenn 6lergen. 6mel6tern
+6mensemb 6n3r2 6r5innenm 6reigens 6s1amma 6s1s 6schef. 6schefi 6schefs 6schlein 6schwerk 6sereign
+6steinga 6sterinf 6sterinh 6sungena 6t3f6 6tergebn 6tergrei 6terhöhu 6tla 6tli 6trahl 6traß 6trendi
+6trom. 6tröm 6z5ertrag 7oberungs 7p4rod 7statth 7t2erhi a1a2ce a1a2n a1ab a1akt a1aq a1b a1cem a1cen
+a1chal a1che a1cho a1chu a1ckar a1d a1flu a1g a1h2i a1ha a1hu a1hy a1hä a1ia. a1ind a1j a1kna a1ku
+a1la a1lu a1lä a1nü a1op a1or a1os5 a1p a1ra a1ro a1rä a1stas a1str a1v a1x a1yeu a1ß a1ä a1ç a2ans
+a2berd a2blin a2bre a2bum a2ceo a2ch1e4c a2chep a2chim a2cho2r a2chy a2ckin a2d1an a2d1au a2dac
+a2dad a2ec a2ek a2ela a2ele a2eli a2ew a2f1a2n a2f1au a2f1ec a2f1än a2f3oc a2f3ur a2ford a2g1au
+a2g1id a2g3re a2g3ri a2gent a2gim a2gund a2h1er2h a2h3ö a2jat a2k1em a2k1ent a2ka3d2 a2kef a2kes
+a2keu a2kup a2l1e4sk a2l1ei a2l1el a2l1er4r a2l1erb a2l1erf a2l1erh a2l1ert a2l1eu a2l1inq a2l1ob
+a2l1äm a2l1ö a2l3erl a2lang a2lart a2leng a2less a2lof a2lum a2m1erf a2m3ap a2malg a2meb a2meri a2mö
+a2n1or a2pe. a2pf a2pht a2pot a2pé a2r1ang a2r1anz a2r1au a2r1er3h a2r1o2p a2r1uh a2r1ö a2r3al
+a2r3app a2rea a2reb4 a2rein a2rele a2rerg a2reri a2rerk a2rerl a2rid a2ror a2rü a2s1p a2s3af a2s3aug
+a2s3i2k a2schm a2sä a2sö a2sü a2t1au a2t3rom a2t3rä a2teb a2tep a2tew a2th3in a2trau a2u a2vr a2xans
+a2xio a3beri a3blat a3chari a3cho. a3ckel a3dau. a3dec a3dee a3el. a3els a3er. a3far a3ik. a3isch.
+a3iv. a3ivl a3ivs a3ka. a3l2eic a3lar. a3lare a3lentf a3lus a3met a3nee a3nol a3nom. a3ot. a3pel
+a3pfl a3r4ale a3ra. a3rali a3ralo a3ras a3reih a3rio a3riu a3rol a3rumm a3s2hi a3s2pi a3s4chi a3sag
+a3schu a3stä a3sus a3t4heb a3tam a3tau. a3tec a3tet a3tub a3tzere a3tü a3vang a3xid a3z2u a3z4a
+a4blag a4blau a4brä a4ch3erh a4ch3erl a4ch3erw a4cherf a4cherö a4cht a4f1ep a4fentl a4glö a4gnä
+a4l3ef a4l3ein a4l3ends a4mesh a4n3ind a4n3ins a4neis a4nerke a4nerz. a4niso a4r3erei a4s1ef a4s2th
+a4s3aa a4s3erke a4sch3ei a4schec a4schef a4schum a4seb a4sec a4sex a4soz a4spir a4thr a4tinf a4unz
+a5n4atm a5nat. a5reni a5sen. a5sol a5som a5t2a3g a6sca a6scherg aa1c aa2be aa2gr aa2r3a aa2rei aa2th
+aa3rea aa4t3r aals2t aar3b aar3d aar3g2 aar3k4 aar3t4 aarf4 aat4s3 aata2 ab1alt ab1au ab1eic ab1eil
+ab1ein ab1er2k ab1er2r ab1er2z ab1ins ab1ir ab1l ab1ur ab1ä ab2am ab2ant ab2of ab2är ab2äu ab3esse
+ab3li ab3r ab3sz ab4lit ab4ros abe1e abe2la2 abe2s1e abe3i4d abe4l3in abi3s4t abst2 abte2s aby4t
+ac1c ach1a ach1ei ach1ob ach1u2f ach3as ach3au ach3l ach3n ach3s4i ach3skr ach3su ach3ö ach3ü
+ach4ei. ach4t1o ach4tak ach4tum ach5erfa ach6t5erw ach6trit ach8tersp ach8traum ach8träume.
+ach8träumen. achs4el achs4or acht6sal ack2sp acksta4 acon4n ad1ama ad1c ad2ag ad2ob ad3rei ad3run
+ad3st ad3sz ad4te2 ade1ra ade2al ade3s2p adefi2 ades4s adi3en adi3er. adi4st adie4sc adt3h ae2b
+ae2ck ae2d ae2i ae2m ae2o3 ae2p ae2sc ae2x aes2a aes5t af1ab af2f3l af2si af2sp af2t1a af2t1o
+af2te2l af2tei af2tra af2tur af2tö af3rau af3re af3rä af3rö af3s2a af3s2h af4flu af4rü af4t5re aff2s
+aff4a afi2e1i afi2t afi4kat afi6kanz afo1s afs4t aft3r aft4erk aft4stä afür3 ag1a2b ag1a2d ag1ar
+ag1ste ag2del ag2di ag2dr ag2du ag2em ag2n ag2th ag3le ag3rat ag3s2ah ag3s4eid ag3stö ag4lan ag4las
+ag4nat ag4ne. ag4nu ag4ro ag4sam agd1 age1i age2s3p age4ler age4neb age4s3i age4s3ti age4sam agein4s
+ages6sen ags4tan ags8porta ah1os ah1w ah2an ah2l1ä ah2l3a2 ah2lö ah2nin ah2no ah2t1ex ah2ta ah2te2l
+ah3mu ah3nee ah3r2e ah3ri ah4at ah4l1ei ah4l3erd ah4l3erh ah4n3a ah4nerd ah4t5r ahe1in ahe1s ahe1u
+ahin3 ahl1o2 ahl3sz ahle4na ahme1i ahme3s ahn3el ahner4e ahner4n ahner6le ahr1a ahr2ti ahr4tri
+ahr4tro ahr4tun ahr6tage ahr6teng ahre4s ahren6sc aht3s6 ai1e4 ai1fr ai2bl ai2lar ai2lei ai2lo ai2sa
+ai3a4 ai3en3 ai3g4 ai3ke ai3s2e ai5n4e ai5schw aid2s aif4 ail3d4 ail3g ain2a ain3s ains2p ait4 aje2
+ajekt4o ak1ins ak2t1a2b ak2t3r ak2tel ak2tö ak3sh ak4at ak4li ak4ri aka4tak aki1s akt2er akt4ri
+aku2s al1af al1age al1am al1ana al1anz al1app al1asi al1ass al1au al1eb al1ec al1epo al1erm al1imm
+al1ind al1ins al1ont al1ort al1u2k al1umb al1ur al1än al1äu al2abr al2arm al2b1l al2boh al2bär al2dr
+al2dä al2enn al2gli al2imb al2klö al2kne al2l1a2r al2l1an al2l1ap al2l1au al2lab al2lid al2lob
+al2lop al2löf al2map al2pho al2stu al2sum al2t1ak al2t3ro al2teu al2tin al2tre al2tö al2u3f al2zar
+al2zau al2zw al2ös al3akr al3arc al3aug al3endr al3exi al3glo al3le. al3lend al3les al3sak al3ska
+al3tam al4atm al4b3er4w al4berh al4d3erl al4d3ern al4lec al4m3ast al4t3er5f al4t3rat al4t3ri al4temu
+al5s6terb al6schei ala2s ala2t1a alat3z alb3ru alb3s alber4e ald3inn alde2s alds2 ale2be ale2p
+ale4ar alen1 alende4 aler4kl aler4mi alf4r ali4nal alk3s alken1 alks4t all3erk alli5er. alli7ers.
+almo6de. alo2ga alo2gr alrat4 alsch3s alt1an alt1op alt4stü alu3b4 alu3g alz4erk am2a am2mar am2mei
+am2mid am2min am2mor am2mö am2t1a2 am2t1ex am2t1u am2t1ä am2t3r am2tei am2tit am2to4 am2tö am3pr
+am3s2h am3sa am3so am3sp am3su am4e4n1 am4ing am4mant am4pf am4schl am4t3ern am4tau am4tel am4tis
+am4tri am6tou am6tre ama3d2 ama3g ame1s ame3r2a ame5r2u amen6spr ammi2e ammu2 amni1 amp2f1a2 ampf1o
+amt3eig an1alg an1eth an1od an2ag an2d1ex an2d1ur an2ei. an2erh an2g1ar an2g1ei an2gan an2gla
+an2k1ak an2kab an2kan an2kei an2klu an2klö an2ko4r an2kro an2nar an2t3ar an2z1i4n an2zid an2zwa
+an2zwi an2zä an3aug an3ch an3e2c an3eif an3f2u an3fe an3i4on an3ne an3s2z an3sar an3skr an3t2ä
+an3tha an4a3ma an4and an4d3ent an4erze an4fj an4g3er4w an4g3erf an4g3erz an4k3opf an4kras an4nef
+an4ut ana3c ana4lin ana4th ana4tr anadi1 and2so and2su and3arm and3ei and4sas and5erob and6spar
+and6spas ande2s anden6ga andy1 ane2mi aner4fa anf3le anf5rau ang1l ang1r ang3n ang3ra angt4 ani2o
+ani3d ani3els ani3g2 ani3ke ani5ers. ank1r ank3no ank3ra ank3rä ank3se ank5erfa anks2p ann4s3p
+annen3s4 ano2la ano3b anoi3 ans1pa ans3pon ans4tr ant3rin anta4re anton2 anze4n ao3i ao3t2s aof4
+aopf4 aost2 aot4r ap2fa ap2n ap3pu ap4la ap4lo ap4lä ap5t2 aps4ter ar1eff ar1ehr ar1eid ar1int
+ar1o2d ar1of ar1um ar2ab ar2b3at ar2b3re ar2bak ar2bau ar2d3r ar2dau ar2dob ar2dop ar2du ar2erw
+ar2gl ar2gn ar2ia ar2ir ar2k1ar ar2kal ar2kil ar2kle ar2klo ar2kor ar2les ar2m1au ar2m1eg ar2m1ei
+ar2mum ar2nan ar2r1ad ar2r3as ar2r3or ar2tau ar2the ar2z1w ar2zau ar2zä ar2zö ar3abf ar3abt ar3adr
+ar3g4r ar3gan ar3inf ar3m2ä ar3mad ar3ni ar3s2h ar3se ar3t2e ar3t2i ar4b3ein ar4is ar4klag ar4merk
+ar4nin ara2st ara3d2 ara3ge arb3erl arb3sk arb3so arde2l are3r2a are3u are5aler aree2 areim3 arein4b
+arein4s arein4t aren6sem arer2e arf1r arf2sp arf3ra ari2su ari3e2n ari3erd ari3erg arin3it arin3s4
+ark2se ark3aue ark3s4a ark3she ark4lö ark6tre arm2or arn2el aro2fe aro3m aro8ckeng aron2 aros3
+arp3fe arre4n1 art3ho art3r art3ske art4res arter6la arwa2 arz2t3r arz4tei arz4tem as1am as1eie
+as1emi as1inn as1o2p as1of as1or as2al as2er as2ph as2pra as2spo as2spr as2st as2ur as3art as3at
+as3hir as3ob as3pio as3s2i as3ski as3so as3sta as3stei as3sti as3str as3stu as3t4ren as3te as3tie
+as3til as3to as3tub as3ät as4es as4t3ese as4tati as4tau as4tex as4tof asa2s asau4f ase4n3o ase4na
+ase4t asin2g aska3s aspek6to ass2ab ass2e ass3ein ass6aus. asse3le ast2el ast2er ast3orc ast3re
+ast5roll aswa2s at1abe at1abr at1akt at1apf at1eig at1ort at1än at2a1f at2asc at2ax at2c at2en at2eu
+at2ex at2hu at2is at2s1o at2s1p at2sa at2se at2si at2t3ec at2t3rä at2tei at2tä at2z1er at2z1in
+at2z1w at2zo at3att at3hag at3re at3rin at3rot at3rü at3t2u at3ta at3thä at4ron at4schn at4t1ak
+at4tad at4tang at4tar at4tau at5ter ata3l ate2ru ate3r4al ate3ran ate4na ateien6d atens4e ater3st
+atern2 ates4sa ati2sa ati2se ati3ka ati4kab ati6k5erw ation4 atis3s ato2mo ato3s ato4man ato4men
+atra4t ats3tät att2el att3s4 atu2n atz3ela atz3elt atz3t4 atze4l au1o au1rh au2bab au2ban au2bau
+au2beu au2bli au2blo au2blu au2blä au2dr au2ere au2fa au2feu au2is au2m1e2r1 au2mal au2mid au2mil
+au2mor au2nio au2no au2s1ah au2sau au2sin au2sis au2so au2spr au2t1äu au3ert au3gu au3h au3in au3lü
+au3n2a au3nu au3ra au3ze au4ferk au4m3ent au4mun au4nei au4s1eh au4s3erb au4s3erf au4s3erk au4serw
+au4t1e2l au4t3erh au4ten4g au4trö au5erein au5erst. au5stein au6schmi aub2si aube4n aude4r3i aue2b
+aue2s aue3rei auer3ö auf1an auf1er auf3ind auf3ski auf3t4 auff4 aug2ar aug2er auk3t aul4les aule2s
+aum1o aum3eri aum3p2 aum3s6 aun2e aup4ter aurü3 aus1or aus3erp aus3s4t aus4se. ausan8ne. aust2a
+aust2o auve4 auz2w auße2 av2a ava3t2 avas4 avener4 awi3e ax2am ax2e ay1 ay2al ay2as ay2u ay3t ayma4
+ays2 aysi1 az2i az2o3 az2z1in az2zen az2zw aza3d aße4 aßen3 b1a2dr b1a2x b1an3t b1ang b1anna b1anz
+b1arz b1ebb b1ernt b1inf b1iso b1o2b b1op b1ort b1une b2ak b2ama b2aro b2auk b2ene b2ens b2ita b2ite
+b2lanc b2latt b2lau. b2le b2lie b2lis b2lit b2lo b2lus b2läse b2r4 b2s1ad b2s1ent b2s1of b2s1un
+b2s3trä b2sim b2stip b2sö b2t3h b2urg b2äl b2äs b3a2ba b3be b3brec b3bru b3esst. b3lad b3late
+b3laus4 b3leb b3leg b3lein b3lese b3leu b3lite b3los b3reif b3ries b3ritt b3s2es b3s2oh b3s2pi
+b3s2äu b3sc b3se. b3sel. b3sen. b3set b3stic b3sto b3stä b3stö b3stü b3ti b3tü1 b3un3gn b3ursa b4ere
+b4eru b4lei. b4let b4ra. b4ra3k b4rer b4rien b4rina b4rio b4risc b4ron b4ruc b4rum b4s3amt b4s3treu
+b4s3är b4schan b4sco b4ski b4sl b4sop b4stob b4stod b4stor b4strac b4stüb b5ga b5hä b5te b5ze
+b6schef ba1yo ba2bl ba2br ba2du ba2k1er ba2k1i ba2ka ba2kra ba2kre ba2l1ak ba2lab ba2lau ba2me
+ba2r3ab ba2r3at ba2rad ba2rei ba2sc ba2st ba3d2e ba3lal ba3n2e ba3r2en ba3sa ba4ck3er ba4l3erk
+ba4t3ent bach7t4e back3s4 bade1i bah6nene bais2 bak1l bak3r bal3ti bal4l3eh bal4l3ei bal6ler6g
+balk4a balke4 ball6erk baller6e ban2a ban2dr ban2kl ban2kn ban2kr ban2ku ban2o ban3gl ban4dal
+ban4dan ban4dar ban4k1a ban6deng band1a banker4 bar3ast bar3de bar3n bar3sc bar3zw barer5ei bas2i
+bas4sa bas4sei bas4st bat2o bau1fl bau1fr bau1s bau3b bau3g2 bau3r bau3s2k bauer4l bauer4s baus4t
+bb2lö bb2s bb3le. bb3ler bbe4n3 bbe4p bbens2 bbru2c bbu1 bde1s bdome4 be1e2h be1ela be1erl be1ert
+be1eta be1ind be1inh be1o be1ra be1un be1ur be2he. be2l1en be2l3om be2let be2löf be2r3am be2ran
+be2re2b be2rö be2s1er be2s1id be3an be3ar be3g2 be3l2i be3lag be3las be3lec be3nei be3s2a be3s4lo
+be3s4ze be3tam be4n3end be4nas be4nat be4ners be4ness be4nis be4r3eiw be4rene be4s3tur be4s5trä
+be4sap be4sar be4stab be4stol be5nabe be5r6inne be6stein bedi4 bee2l bee4rei bef4 begas1 beh5ri
+bei1f4 bei1s4t bei3b bei3k4 bei3l2a bei3sc bei4ge. bein4hi bein6hal beis2e beit2s bel3ere bel3f
+bel3la bel3li bel3sz bel3t bel4un beli4e belle4n3 ben2eu ben3ar ben3n ben3th ben3un ben3z2 ben4s3pa
+ben4spr ben6thei benst4 bent4r ber2ec ber2zö ber3a2s ber3d ber3iss ber3kr ber3n2a ber3st4a ber3ze
+ber4ei. ber4erg ber4erw ber4g3af ber4gal ber4hab ber4in. berb2 bere3ck bere4sc berf4 berg3as berin4s
+bert2a bert2e bert2i bes2po bes3sa bes3sz bes3tin bes3tos bes4abb bes4to4r bes6terh bess4e best2i
+best4r beste2 bester4 bet2sp bfal2 bfal3t bge3 bge5n bgel2e bges4 bi2c bi2k3re bi2ke. bi2kes bi2lei
+bi2lu bi2nok bi2o3 bi2sp bi2st4 bi2tu bi3la bi3sta bi3ti bi3z2 bi4l3ans bi4lau bibe2 biber1 bie2s
+bien3s bieres4 biet2s bik2a bil2an bil4deb bin2e biri1 bis2s1c bit2an bjek4to bl4 bla3b4 ble3l
+ble3s4z blei3sc bling4 blu4tem blut1o bnas4 bni2 bnis1 bo1is bo1r2an bo2c bo2e3i bo2lan bo2lau bo2ne
+bo2r3as bo2rei bo2sc bo2xo bo3ben bo3ch2 bo3d2 bo3fe bo3se bo3th bo4a bo4rig bo4ruh bo4rä bo4s3p
+bo5as bob3r boe1 boh2u boh3re boh4rei bol5le bon2an bon2d1e bon2da boo2l boo2ti bor2d3r bor2da bor2s
+bor4ter bor6t5rat bot2st bot3t bote3n4e bpa2g bra4t3er4 brast4 breli1 bret6t5en bri2da bri2er
+brie4fa bro4tr brot3t4 bru2th brus4 brust3 brä4u bs1erf bs1erg bs1erk bs1ers bs2am bs2chi bs2cu
+bs2ku bs2pl bs2pu bs2t bs2zep bs2zi bs3amb bs3e4r3in bs3tät bs4t1as bs4tol bs4tri bsat2 bsau2r bsch2
+bse2b bse2n1 bsi4t bso2r bss2 bst1a2b bst1ak bst1er bst3ank bst3h bst3ink bst3ro bt4r bta2s btast3r
+bti2s btran2 bts2 bu2chi bu2e3 bu2f bu2s1p bu2sa bu2sc bu2sin bu2su bu3r2i bu3sche bu4chec bu6ch5ers
+bu6schei buch3sp buche4 bucher4 bul2l3a bun4d3er bunde4s bung4 bur1c bur2gr bur4gan bur4gar bur4gin
+burg1a burts3 bus1un bus3cha busch3w by3p2 bys4 bzeit1 bö2b3 bölk3 bü1c bügel3e c1b c1ce c1ch2 c1f
+c1g c1int c1j c1s2ti c1s4tr c1w c1z c2d2 c2h c2k c2l2 c2m2 c2si c3do c3me c3mu c3rä c3ti4 c4ho ca1h
+ca1y2 ca2c ca2e3 ca2pe ca3bl ca3g2 ca3s2a3 ca3t2h cab4 cal2a cal2f3 cal3t cana3 car2s car3b car5n
+carri1 cas5to cchi1 ce1er ce1i ce1u ce2dr ce3in ce3nu ce3r2i ce3s4h cen3a ceo2 cere1 cere3u cet1am
+ceta2 ch1ang ch1edi ch1eim ch1off ch1oh ch1orc ch1ori ch1urs ch1äh ch1ärm ch1äs ch2le ch2lu ch2os
+ch2r4 ch2spo ch2tru ch3a2b3i ch3e4ben ch3echt ch3es4s ch3rad ch3rh ch3öl ch4stal ch5austr cha2ck
+cha2sc chal6l5ei chan3f chasi1 chau3t che2no che3b4 che4fer che4ler che4neb cher3a cher6zie ches5t
+chi3na chle2i chner8ei. cho2f cho3l2a chof4s chut4t ci1c ci1es ci2ak ci2na2 ci2s1 cill2 ck1a ck1err
+ck1in ck1ä ck2ad ck2ere ck2ern ck3ot ck3sc ck3te ck3ö2 ck4spen cka2m cka4r1 cke2ra cke4na ckerk4
+cks2al cks4tri ckt2i cle4a clet4 cli2p1 clin2g clip3a clo1 clo2ck clu4b co1it co1ra co2c co2ke
+co2leu co2pe co3ch co3di co3la3 co4de. co4re co4te co5l2o cof3f2 coi4 com4te. comtes4 con2ne cor2da
+cor3t cos3t coti2 cre4mes cros4 cry2 cs2a cs4f ctio2 ctur6 cu2e cu2p3 cussi4 cä3 cäs2 cô4 d1a2no
+d1ac d1ad d1af d1ag d1alt2 d1amma d1ana d1and2 d1ang d1arz d1as3p d1asy d1ax d1ei d1i2ra d1ob d1of
+d1ö d2ab4rü d2abe d2abä d2ac. d2amp d2andy d2ank d2anz. d2aph d2eb4 d2en. d2erhü d2erm d2es. d2ge.
+d2gesh d2ida d2orn d2os. d2r4 d2s1alk d2s1e2b d2s1ef d2s1emb d2s1eng d2s1ent d2s1erf d2s1erk d2s1ers
+d2s1ert d2s1eta d2s1ev d2s1im d2s1pat d2s1pec d2s3ph d2san d2saut d2scr d2sein d2serh d2serz d2sex
+d2sid d2sop d2spro d2spä d2stas d2ste d2sun d2sö d2th d2ump d2ums. d2ön d3a2bak d3a2ben d3a2bi
+d3a2bo d3ch d3da d3de d3dh d3dä d3hu d3la d3le d3o2ly d3ramp d3rand d3ri d3roc d3rod d3row d3s2co
+d3s2inf d3s2kal d3s2kel d3s2pi d3s4tern d3s4tro d3sha2 d3sho d3soh d3spri d3stec d3stei d3t2ac
+d3t2as d3t2ur d3to2 d3ty d3tö d3tü d3über d4e1ism d4er. d4eren d4erfl d4rauf d4rea. d4reas d4reiv
+d4rej d4resc d4rew d4ri. d4rib d4rid d4rie d4rift d4rik d4ril d4rin. d4rog d4roi d4ross d4räh
+d4s1amt d4s3täti d4schef d4schin d4sehe d4shal d4shor d4sli d4speri d4stag d4steil d4stem d4sten
+d4stoch d4thei d4to4b d5do d5ne d5rieg d5rub d5stell d5strei d5tea d6sporto da1a da1h2o da1in da1is
+da1lü da1s2 da2bri da2cho da2de da2kro da2nan da2por da2r1a da2r3o da2ru da2tom da3brie da3lö da3sh
+da3t2e2 da3unt da4nat dab4ra dach3a dad4r dafo4n dag2o dagi4 dah3l dail5 dal2a dal3b2 damo3
+damp7f8erf dan2k1o dan2kl dan2kr dan4ce. dar2d1e dar2da dar2m1a dar2m1i dar2th dar2tr dar3g dar4mu
+dare2 daren1 das4t dat2st date4n dau3e2 dauer3e dbe2e dbu2c de1c de1on de1ra4s de1ro de1sto de1un
+de1url de2cka de2del de2dit de2fa. de2l1ac de2l1ob de2len de2n1e2d de2ni de2nos de2ob de2r1eu
+de2r3ap de2re2b de2rop de2s1p de2s1än de2sa de2seb de2sei de2set de2sor de2su de2thi de2xer de2xis
+de3a2t de3alo de3ar de3gl de3i4den de3il de3inse de3lak de3lein de3min de3nu de3r4erb de3r4erf
+de3rand de3sem de3spe de3stel de3stri de3ta de3us de4ca. de4l3aug de4n3end de4r3asi de4r3ei4s
+de4r3end de4reck de4ruh de4rum de4sam de4se2h de4sin de5stern de6mentg de6rinnu dea2d deco3 def4l
+deg2 deh2a dehe2 dei2sp dein2d dein6sta del1ec del2l1ä del2la del2s1p del2se del2so del3b2 del3t4
+del3änd del4ade del4l3eb del4l3er del4lei delei4g deler2 deler4r dell3au delle2 dem2ar dem5ents
+dement4 den2am den2es den3th den6s5tau den6scho den6sere den6zers dend2 denk3li denko4 dens4am dep4l
+dep5t depi2 der2bl der3af der3ero der3k2 der3r der3sta der8trage dera2b dera2n derer3n derer4t
+derer6ze derf4 derin4f derin8teg derst2 dert4ra dert7ende. derö2 des1ah des1o des1un des3elt des4end
+des4tex des4tum des6temp desen3e dess2 dess4t dest5alt dest5rat det2 deten4t devil2 dgas3tr dge2t3a
+dge3r dge3s dge4t1e dger2e dha1s4 di1ce di1p4 di1s4ta di1the di2a di2e di2o3b di2osk di2ren di2rin
+di2ris di2s1a2 di2s3te di2sp di2t1u di2ta di2tin di2tob di3ar di3chl di3e2ni di3e2th di3e4d di3enb
+di3ers. di3n2e di3ora di3pt di3s4per di3z2 di4ath di4re. di4stra di4sz di4t3erl di4t3erm di4t3ers
+di4t3r di5v2 dia3s4 diat4 dicht6er die4neb dien3z diener6l dienst5r dies1c dig4n dige2s dik2a dil2s3
+din4a dio4n3i dio5s2 dion3s4 dist2 dit3s dite1c dl3m dl3s dla3g dlap4 dle2ra dli4f dni2 dnis1 do1r4a
+do2fe do2mal do2mar do2mu do2t3o do2tre do3nan do3ta do3un do4ming do4s2tu do5a do5n2a do5s2k doll2
+dom2e domen1 donau1 doni1e dor2f1a dor2f1i dor2f3u dor2fl dor2fo dor2fr dor2fä dor4ter dor4tr dori1
+dos3s dos4t3a dos4tel dos4tes dos4ti dos4tr dos6teng dose4 dost1 doste4c dow2s dox2 dpass3 dpo2st
+dran3k dre2ha dreli1 dres6sei drö2sc drü1b drü5cke ds1eh ds1err ds1ori ds1pas ds1ums ds1än ds2hak
+ds2por ds2pu ds2ti ds2tur ds2zen ds2äu ds3ab ds3ane ds3assi ds3part ds3tauf ds4eign ds4til ds4tip
+ds4tol ds4tri dsau2 dsch4r dse2e dse4t dsen3er dso2r dss4 dst2 dt2ag dt2ax dt2op dt2un dt3hi dt3ho
+dt3r dt3sa dt4hy dt5st dta2be dta2d dta2n dtach3 dtran2 dts2 dtt4 du1alv du1ar du1ce du1i du1os
+du2bli du2f du2kr du2n du4l3art du4sch3w du4schn du4schr dub3l duf2tr duf4ter duf4to dun2kl dun2s
+dun3ke dun4de dund2a dung4 dunst3r dur2 dur3au durch3 dwa2 dwa4r dwes2 dwest1 dy2sp dä3us dö2d dö2f
+dö2s1c döll2 düns3 e1b e1chi e1chu e1ci e1d e1e2ck e1e2x e1eff e1ei e1emb e1emp e1en e1erbt e1erd
+e1erz e1f e1g e1he e1hy e1hä e1hü e1init e1irr e1k e1la e1lü e1m e1nü e1o2b1 e1of e1oh e1on. e1ond
+e1onf e1onh e1onl e1onp e1onr e1ons e1opf e1or e1oste e1p e1rah e1rai e1rald e1rap e1rast e1raub
+e1rauc e1raw e1raz e1roa e1rog e1roh e1rol e1rom e1ros e1rou e1row e1roz e1rä e1s6tü e1stap e1star
+e1stat e1stel e1stil e1stu e1t e1v e1w e1xi e1z e1ñ e1ö2 e2akta e2alo e2alti2 e2am4e e2ano e2are
+e2av e2bob e2bunt e2cho. e2e1s2 e2e3m2a e2e3ne e2e3nä e2ed e2enc e2eno e2ep e2et. e2ew e2f1ad
+e2f1e2b e2f1ins e2farc e2fat e2femi e2fent e2fum e2fäu e2glo e2glu e2gn e2h1er2l e2h1erf e2harz
+e2huni e2id e2il e2inhä e2inl e2insc e2iss e2it e2l1ak e2l1ant e2l1anz e2l1ap e2l1ar e2l1e4ta
+e2l1ein e2l1el e2l1ent e2l1erl e2l1err e2l1ess e2l1id e2l1or e2l1um e2l3a2ne e2l3oa e2lanm e2lerg
+e2lim e2lof e2lol e2lonk e2lya e2m1ano e2m1ans e2m1e2b e2m1erl e2m1i2d e2m3a2b e2manf e2mef e2mele
+e2mig e2moa e2mof e2mop e2n1el e2n1ep e2n1erd e2n1erl e2n1err e2n1ert e2n1eru e2n1erw e2n1ess e2n1ev
+e2n1o2r e2n1ob e2n1op e2n1u e2n1ä e2n3oa e2n3oc e2na e2neff e2nemi e2nerf e2nerh e2nerk e2neth e2nid
+e2nin e2nir e2nof e2pig e2pik e2r1erh e2r1id e2r1ini e2r1o2f e2r1äs e2r3all e2r3ax e2r3uz e2ra2v
+e2rach e2radj e2radm e2rak e2ranh e2rano e2rar e2ratl e2rein e2rele e2remp e2rer2o e2rerk e2rerl
+e2rert e2riat e2rind e2risr e2roo e2s1a2d e2s1il e2s3all e2s3e2x e2s3ec e2s3ein e2s3tom e2s3ums
+e2spel e2sph e2st1a4s e2stip e2t1o2f e2t3res e2thik e2tid e2tinh e2ure e2vak e2we. e2x1in e2xam
+e2xel e2xem e2xil e2xum e2z1enn e2ß1el e2ß1er2g e2ßent e3a2lin e3a2sc e3a4lerg e3ab e3akto e3alei
+e3alex e3anf e3ar. e3arz e3at5t4 e3ath e3aue e3auf e3bak e3blie e3blä e3bän e3cr e3d2o e3di. e3drei
+e3dy1 e3e2lek e3f4lu e3fef e3flü e3ge e3h2ah e3hand e3haut e3helf e3hur e3k2a e3k2l e3k2o e3k2w
+e3ke. e3ke4n e3kes e3key e3l2ov e3lamp e3lea e3leine e3lema e3lep e3ler. e3lex. e3lore e3lot e3m2en
+e3mind e3misc e3mur e3mäs e3nale e3neien e3ni. e3nic e3nio e3nit2 e3niv e3nobel e3nu. e3o2ly e3or.
+e3orb e3ord e3ors e3orw e3os. e3p2f4 e3pio e3pu e3r2ech e3ra. e3rad. e3radi e3rake e3rand. e3rari
+e3ras. e3rati e3ren. e3rena e3renz e3ri3k e3rib e3rio e3riv e3rosit e3s2ce e3s2pi e3s2por e3s2ö
+e3s4pan e3sa2s e3sac e3saf e3sap e3sarg e3spal e3spra e3spu e3strec e3sty e3suh e3sy e3tha e3tur
+e3tü e3um. e3um2s e3umb e3umf e3uml e3umw e3un2g e3usar e3var e3wir e3wit e3z2a e3zi e3zoh e3ä2 e3ü
+e4abi e4alem e4are. e4arer e4ares e4aufo e4ckerr e4h3ente e4hense e4i2n1a e4ic e4ingr e4insa e4is.
+e4l1ans e4l3ernä e4landa e4lanw e4lense e4ler4fa e4lerfi e4m1a4s3p e4mesu e4n1e2sc e4n1ent e4n3a2b
+e4n3a2p e4n3ack e4n3att e4n3aur e4n3erei e4n3ermo e4n3erne e4n3iso e4naf e4nalb e4nalk e4nalm e4nalo
+e4nand e4nant e4nanz e4nast e4natl e4naut e4ne2x e4neige e4nein e4neis e4nense e4nermi e4nur e4nuto
+e4när e4r1e2ti e4r1ema e4r3adr e4r3eime e4r3ico e4r3uhr e4radmi e4rangr e4remu e4rense e4rentn
+e4rents e4rerfo e4rerne e4rh e4ro4r e4rundu e4s3ke e4s3kl e4sabe e4sky e4spers e4stant e4starb
+e4staum e4staus e4sten e4stig e4t1ein e4t3hal e4thot e4tinf e4torg e4traum e6ch5erzi e6l5ei6ern
+e6lereig e8rersche ea2b3l ea2be ea2bo ea2c ea2dr ea2g ea2l3u2 ea2la ea2r1ei ea2ra ea2ro ea3g4l
+ea3ga4 ea4br ea4l3ent ea4na ea4nä ea4rene ea4sp eadli4 eakt2 eal3tr ealer2 ealer4t eam1o eam3 eamt2
+ean3a2r eas3s easin4 eat3s2 eate2 eater1 eatu3 eau2fe eau3g eau3n eb2el eb2lö eb3le. eb3ler eb3lo
+eb3str eb4leu eb4rea eb4sche eb4stät eba2p ebe1er ebe2lo ebe4ler ebe4ras ebe4s3eh ebenen3 ebert4
+ebese2 ebet4s ebot2 ebs3in ebs3pa ebs3t4h ebs3tau ebs3tem ebs3ti ebse2 ebu2t1 eby4t ebö2s ec1s ec4k
+ech1am ech1ei ech1ob ech1w ech1ä ech2en1 ech3l ech3m ech3n ech3r ech3ser ech3t4ei ech3ö2 ech4ri
+ech5sel ech6terh echst5re echter8ha eci4a eck4sta ecke4n1 ed2a ed2dr ed2e ed2i ed2s1es ed2s1o ed2s1p
+ed2s1u ed2s3tr ed2sal ed2si ed2ö ed4seh ede2al ede2r ede3n4er ede4ran eden4se eden4sp edens1 edeo2
+eder3a eder3t2 edes2t edi3an edi6teng edu2s edys4 ee1c ee1ro ee1rö2 ee1u2 ee2cho ee2tat ee2th ee2tu
+ee3a4 ee3e2 ee3ing ee3mä ee3o ee3po ee3r2un ee3re ee3s4t ee3sh ee3sp ee4ce ee4r3en4g eeb2l eed3s2
+eede1s eede3 eef4l eeg4 eein4se eeis3s eel2e eel2ö eele4n een1er een2z een3s eena2g eer1ei eer2e2s
+eer2ös eer3as eer3k eerst4 eert2 ees3k eet2a eet2i eet4r eewa4r ef1ana ef1ar ef1em ef1id ef2fl
+ef2tan ef2tei ef3rol ef3rom ef3so ef3sp ef4le ef4reih ef4ru ef4rü efe2n1 efe4l3ei efer5f efeuil4
+efi2s efs2 eg1a2m eg2anz eg2en eg2th eg3ni eg3nä eg3se eg4run eg4rö eg4s3an eg4sal eg4sei eg4sin
+eg4sk eg4so eg4sto egd4 ege1u ege2lo ege2ra ege4l3au ege4ler ege4n1a ege4s5tr ege6nero ege8l7ei8er
+ego1p egs2ag egs2e3l egs2pe egsau3g egst2 egung4 egus3 eh1ach eh1arm eh1eff eh1ein eh1elt eh1lam
+eh1lä eh1roc eh1rö eh1ste eh1unf eh1w eh2al eh2l3au eh2mab eh2r1a2 eh2rei eh2rel eh3im eh3mu eh3na
+eh3no eh3oly eh3sh eh3ta eh3üb eh4lent eh4mant eh4rin eh5l2er ehalt4s ehe1ra ehe3str ehen4tr ehl1or
+ehl2se ehl3ein ehlo2 ehls2t eho2f eho2l ehr1e2c ehr1ob ehr1of ehr1ä ehr4ern ehr6erle ehre3s ehs2
+ehst2 eht4r ehö4rer ei1ce ei1e ei1flo ei1p ei1sto ei2bar ei2bli ei2cho ei2d1a ei2hum ei2kak ei2lam
+ei2lar ei2let ei2lob ei2m1ag ei2m1or ei2mab ei2mur ei2n1o2 ei2n3ie ei2nel ei2neu ei2sa ei2sum ei2sur
+ei2t1a2b ei2t1ur ei2t3h ei2tal ei2tan ei2tap ei2tar ei2tin ei2tor ei2tän ei2zar ei3de ei3do ei3e2l
+ei3gl ei3k4la ei3klä ei3o2 ei3re ei3s2ky ei3sas ei4b3ute ei4blu ei4deis ei4ds ei4g3rat ei4glo
+ei4lant ei4lanz ei4lein ei4n3an ei4n3at ei4n3en4g ei4n3ä ei4na4s ei4nac ei4nerf ei4nerk ei4s3erl
+ei4s3erw ei4serg ei4t1um ei4tat ei4tess ei4trau ei4tro ei6nen6se ei6s5erst ei6schwu eibu4t eid4ein
+eid5erre eie2b eie2m eie2t eien3s eienge4 eig2ar eik2ar eik2i eik2l eik4am eil2ö eil3ane eil3d4
+eil3f4 eila2n eile2n1 eilm2 eim2p4l eim3all eim3alp eim3sa ein3ebe ein3g2 ein3k4 ein3n2 ein4fiz
+ein4fo. ein4fos ein4nen ein4tol ein5erbe ein6derk ein6karn ein6stal ein6terv eina2d einer6sc eip2f
+eir2c eis2pe eis4tol eisser6s eit3z2 eite4ra eitsa4g eitt4 eiv2 eive4 eiz1in ek2e ek2t3at ek2t3o4b
+ek2tan ek2te2l ek2tä ek4n ek4s1p ek4t3er4z ek5t6ante ekor4da ekt2o ekt3erf ekt3erk ekur2a el1a2m
+el1a4si el1af el1ana el1asp el1erd el1erf el1erh el1erk el1eru el1erw el1evo el1ex el1ita el1obe
+el1uf el1ur el2a3mi el2abt el2lim el2lor el2sum el2zar el2zwa el3a2ri el3abu el3ader el3arr el3aufw
+el3des el3dri el3echt el3ehe. el3g2l el3les el3lär el3p4 el3the el3use el3uto el3z2ac el4d3erf
+el4larb el4lart el4lel el4s5ein el4t3ent el4tans el4tesc el4zene el5le. el5lend el5stern el5ten.
+ela2br ela2ck ela2re ela2s ela3su eld3s2 eld5erst elder4p elder4s ele2c ele2mi ele2ti elea2r elen1e
+elen4k3l eler2a eler2ö eles2 elet4ta elf2er elgi5er. elgi5ers eli3ef. eli3no eli4are elin3a elk3s2c
+ell2er ell2ö ell3ein ell3eis ell3sp ellen5s ellenen5 elm2e elm3ein elo2ri els2ph elte2s elte4m
+elter4b elter4f elter6le elter6sc elu2t elö2s em1alk em1app em1aus em1erw em1im em1int em2dra em2dä
+em2m1ei em2sa em2sim em2spr em2st em2äh em3pfl em3po em3t4 em4scha emd1r emen3ta emen4t3h emen6gel
+emi2ei emi3k2 emi3n2a emi3tr emma3u emo3s empo1s en1a2x en1al en1as en1e2c en1e4kl en1ers en1eta
+en1eul en1ima en1imi en1ost en1ö2d en2alg en2ce. en2dal en2dex en2eid en2era en2nef en2nel en2ora
+en2san en2seb en2sid en2t1os en2thi en3a2re en3a2z en3ak en3ane en3ark en3aro en3aug en3d2um en3d2ü
+en3d4ort en3f en3g2al en3g2i en3gn en3i2ko en3ill en3k2ü en3ol en3sabb en3sac en3spo en3t2el
+en4d3es4s en4dang en4entr en4erfr en4ert. en4k3erk en4n3erl en4ner4f en4s3tät en4t3rol en4tanm
+en4tanw en4terb en4tid en4z3erk en4z3erm en4zerl en5sche en5t2ag ena2sc ena3l2i ena4n enadi4 enal3p
+enat4s enau2f end2ac end3rom end3s2l end3s2p end3s4au end3sz ende4lä ene2ro ene4ben ene4le enf2a
+enf2u eng2o eng3se eng4ra enge3r4a eni2ö eni3er. eni3erp eni4m eni5ers. enk3aus enk3erg enn3erg
+enn3ste enni6ger enns2 eno2br eno2ma eno2w eno4ri ens2th ens3ere ens3umf ens4por ens4tel ens6temp
+ensen3e enst2ü enst5alt ent4ark ent4sto ente2n enz2äp enz3erg enz5ersc enzlan4 enzo2l enü1st eo1c
+eo1o eo1ra eo1s2 eo1ul eo3ben eo3bl eo3br eo3dr eo3g2 eo3la eo3se eoch2 eom2 eort2 eot2e ep2p1a
+ep2pei ep2pr ep2tal ep2tau ep3le ep3sh ep4pl epa2g epas6ser eport4 eppe3l ept2an epu2s er1a er1c
+er1e2ck er1e2h er1e2l er1e4ta er1eb er1edi er1eff er1eig er1emb er1epe er1ers er1ess er1inb er1ink
+er1inl er1int er1o2p er1ob er1ox er1u2m1 er1und er1ä2m er1äf er1äh er1äp er1ätz er1ös er2an. er2dob
+er2gop er2tho er3aic er3alke er3apf er3apr er3are er3arr er3asc er3att er3aue er3aug er3chl er3de
+er3echs er3eis. er3eisb er3eisf er3eisr er3erf er3for er3hei er3hu er3ker er3mag er3me er3mi er3ne
+er3oly er3omb er3onk er3p er3ror er3rä er3s2a er3sen er3sk er3sp er3stel er3swi er3sz er3tat er3use
+er4d3en4g er4g3are er4m3ers er4n3alt er4nene er4nerf er4nerk er4t3er4g er4t3erf er4ter4h er4terk
+er4ters er4z3ers er4zerk er5eisar er5s2i era2g era2ß era4na eraf4a eral4eb eran3d4 erau2f erb2au
+erb2e erb2sp erch2o erd3erw erd3st erda3me erdes4t erdeu2 ere2th ere3lev ere4ben ere4dit ere4i
+ere4vid erei5str ereli1 eren1e eren8z7en8d erer3fa erer4kl erer4ri eres3sk erf2e erf4r erg3s
+erg5elst ergel6s3 ergs2o ergs2p ergs4t eri2de eri3e2n1 erik4l erk5t4 erm2 ermen4s ern1os ero2bl
+ero2br eror2a ers2el ers4ana ers4tod ers6tr ersch4 erse4h3u erst5ers ert1ab ert3s2p erta2d erts2e
+eruf4s3 erung4 erö2d erö4l erü4b es1ax es1ehr es1um es1ur es2ach es2ank es2anm es2anr es2ast es2el
+es2ens es2har es2kat es2oh es2ort es2pek es2s1ag es2s1pa es2sof es2spu es2tec es2th es2tid es2tur
+es3a2ra es3ab es3ak es3ampl es3anz es3apf es3ato es3aus es3he es3ku es3l es3ob es3se es3str es3stu
+es3unt es3z es4chem es4chi es4park es4serh es4stab es4t3eng es4t3erz es4t3ess es4tanb es4tang
+es4ter4ö es4tr es4zene es5trac esa2v esbi5er. esch2 ese3in4s ese4nal ese4neu ese4r1u2 esen3o esen3sk
+eser4at eses2k esi2st esi3er. eso2r eso3re ess3erg ess4erf essali3 essau4s est1ak est1ob est3ori
+est3ums est5eing est5eink est5einl est5erha esta3ge estab4b ester6ke estmo6de et1a2mi et1ant et1ini
+et1äh et2abl et2ax et2en et2h et2on et2spe et2ste et2t1um et2t3ak et2t3h et2t3r et2ta2b et2tad
+et2tau et2tei et2zw et3hä et3hü et3rec et3su et4an. et4at et4ros et4sh et4sum et4tang et4tans et4tim
+eta2c etab4 etat3r ete2e ete2o ete2s ete3ke eten3d2 eter4hö eter4tr eti2m eti2ta eti2th etin1
+eto4n3al etons4 ets1p ets2c etsch3w etscher7e ett1a ett2as etta2m ette4n1 etwa4r eu1a2 eu1id eu1in1
+eu1o2 eu1p eu1s4tr eu1sta eu1sto eu2eb eu2esc eu2fer eu2g1a eu2gre eu2gri eu2kä eu2nio eu2ral eu2sis
+eu2z1w eu2za eu2zo eu3b4 eu3eri eu3erk eu3err eu3g2er eu3h eu3l2e eu3ma eu3p2f eu3sp eu4g3ing eu4nei
+eu4nis eu4r1an eu4r3ast eu4rens eu5t2o eu6gense euch4ta eude1s eudi4e eue6reif eue6reis euer3ei
+eueren4 euerer6s euerer6t euf2a eug3sp eugs4 eul2i eulan2 euland3 eum4s1p eum4se eun2e eun3ka eunk2
+eur3f4 eur4er euren2 eust4 eut2e eut2h eut6scha eut6schn eut6schr eva2s eve5ri evie3le ewei4sc
+ewert4 ewi2s ewä2s ex1er ex2tin ex3at ex3l ey2n ey4ne eys2 ez2o ez2w ez2ä ezi2s eße3re f1a2ka f1ader
+f1amt f1anp f1ausb f1eis f1ent f1ob f1um f1ya f1älte f2akto f2ar f2ech f2eie f2eind f2em. f2enti
+f2ento f2er. f2ere f2ern. f2ers. f2ert f2erz f2f1e2b f2f1ef f2f1ei f2f1emi f2fim f2il f2ink f2l2
+f2r2 f2s1a2s f2s1al f2s1e2b f2s1ent f2s1er f2s1eta f2s1i2d f2s1o2 f2s1pas f2s1un f2sa2n f2saut f2sph
+f2spre f2spro f2stas f2stip f2t1e2ti f2t1erl f2t1erz f2t1ex f2t1of f2t1äu f2t3h f2t3ot f2tum f2uh
+f2ur f3aktio f3arc f3at f3aug f3ch f3f2ak f3f4rä f3fas f3flu f3flü f3lad f3lap f3lats f3ler f3li.
+f3ling f3län f3läu f3lö f3ra. f3rand f3rat f3rauc f3rec f3red f3reic f3rip f3rot f3ru f3rü f3s2ky
+f3s2pl f3s2por f3sc f3soh f3sol f3spann f3stat f3stel f3stern f3sy f3t4ran f3tat f3ti f3tü f4at.
+f4ats f4erel f4ergr f4erpa f4erpf f4erpl f4erra f4lans f4lasc f4lee f4lex f4lor f4lut f4lög f4lü
+f4rei. f4reie f4reig f4ri3k f4risc f4rist f4rop f4ruc f4s1ehr f4s3tres f4sca f4sce f4schan f4schef
+f4schro f4scr f4stäti f4stüte f4t1ent f4t5hei f4ta. f4tid f4tinf f4tins f4tric f5land f5le. f5lüm
+f5rap fa2ben fa2ch3i fa2cho fa2del fa2di fa2dr fa2ke fa2nar fa2st fa2to fa2xa fa2ß fa3ec fa3la fa3le
+fa3s4a fa3sh fa4cheb fa4chel fab4 fach3s4p fah6l5ent fai3b fal2kl fal2tr fal4l3ei fal6lerk fal6scha
+fal6schl fal6schm fall5ent faller6s fan2gr fand2a far2b1a far2b3r far2b3u far2bo far2r1a far2rh
+far4b3er far4bel far4bin farb1l farr3s faus4t3r fbau1 fber2 fdien2 fe1em fe1ra fe2c fe2dr fe2e1i
+fe2l1a fe2l1er fe2l1o fe2l1ä fe2les fe2ni fe2no fe2pi fe2r1ä fe2rab fe2ral fe2rau fe2re2b fe2rec
+fe2rö fe2st fe3che fe3ins. fe4r3eis fe4rang fe4ranz fe4rer4g fe4tag featu4 feein5 feh4lei fel2da
+fel2dr fel3au fel4d5ri fel4s3oh feld6erh felde4m fels2t felt2 fem4m fen1a fen3au fen3s2a fen5s2c
+fenst2 fer3da fer3ell fer4ant fer4fah fer4nei fer4reg ferd2e3 ferg4 ferie4n3 ferri2 fert4r fes4t1o
+fess2e fest3a4b fest3an fest3ei fest3r fet4t3a fetti3s feuer3ö ff1a2d ff1au ff1lag ff1ox ff1rak
+ff2en ff2s1p ff3ar ff3erle ff3le ff3li ff3ro ff3stü ffab6s ffe2e ffe2m ffe3in. ffe5inha fff4 ffi3k
+ffin3s ffs2am ffs3tan ffs3ti fft2 ffus3s fge3s fgeb2 fi1er2f fi2ar fi2do fi2k1as fi2k1o4 fi2k3r
+fi2kel fi2kin fi2kn fi2l1an fi2les fi2lo fi2o fi2r fi2s3t fi2tin fi2xel fi3at fi3li fi3ni fi3ol
+fi3ra fi3s2a fi3s2h fi4lin fi4re fi4sch3a fi4sch3w fi4schr fi4tor fi6schei fid2 fien3 fil2ip fil2ma
+fil2mä fil3d fil4med fil4mei fin2s fin3sc fin3sti fing2 fing4e fing4s4 fir3me fis2p fisch3l fisch3o
+fit1o2 fite2 five4 fka4t3 fl4e flauma4 flek3 flekt2 fli4ne flo2w flu4gen flu4ger flut1o fma2d
+fma5che fni2s fo2be fo2na fo2nop fo2nu fo2x fo3n2er fo3rin fo4nan fo4nin fob2l fol2k3 fon3au fon3dr
+fons4 for2t3r for2th for2u for3tu for4m3a4g for4m3ei for4mas for4st for4t3ei for4ter for6schl
+forni7er. fort3s2 fot4r fra4m frach6tr frei1f frei3k2 fri2e fri3d fricht6e fro2s fro4n1a frös2
+fs1en1e fs1ums fs2on fs2pul fs2t fs2än fs3ane fs3ar fs3s4 fs3tak fs3th fs3trü fs3tut fs3tät fs4tol
+fse2n fse4t fst4r ft1a2be ft1a2r ft1abl ft1af ft1ala ft1an ft1eck ft1edi ft1eh ft1eig ft1ein ft1eis
+ft1eli ft1emi ft1erk ft1in ft1urk ft1url ft2ag ft2s1 ft3att ft3erfü ft3om ft3res ft3ro ft3ruh ft3s2c
+ft3st ft3z2 ft4sam ft4sche ft4seh ft4staf ft4stei ft4stem ft4stru ft6s5treu ft6stier fte2c fte2he
+fto2 fts3i fts3tät ftse2 ftsen1 ftstro4 ftwa4 ftze3d fu2ß1er fu4re. fuku3 fun2k3r fun2kl fun2ko
+fun2ku fun6derg fung4 furch2 fus2s1p fus2sa fus2st fz2a fz2w fz2ö fzei8t7end fzeiten6 fzu2ga fä1c
+fä2ßer fäh2r1u fäh4rin fäs6serk fäs6serw fässer4 för4s5 fü2r fühl4sc fün2 g1a2lu g1abr g1ana g1anz
+g1d3r g1da g1do g1dä1 g1dö g1erzä g1etap g1lu g1lüg g1n g1ob g1steu g1öl g2ans. g2ara g2as. g2auk
+g2d3ent g2dak g2dan g2dar g2dau g2dei4 g2der g2dop g2e3p4 g2eil g2enc g2es. g2g3n g2hu g2l4e g2lia
+g2lie g2lik g2lim g2lio g2liz g2loa g2lob g2loc g2lok g2lom g2lop g2lor g2lot g2lut g2n2a g2nie
+g2nif g2no g2ny g2nü g2r4 g2ung. g3erlas g3gla g3glo g3lec g3lee g3len g3lese g3lev g3lite g3lize
+g3neh g3not g3rand. g3rede g3rein g3reit g3ret g3rev g3riese g3rui g3räu g3s2c g3s2eil g3s2eis
+g3s2pek g3s2pi g3s4tras g3sack g3sal g3sat g3sau4r g3sel. g3seln g3sere g3sil g3sol g3spor g3stan
+g3star g3steh g3stein g3stel g3stif g3stil g3stim g3stir g3sto g3stun g3sy g3säu g3t2i g3te g3to
+g3tü g4abi g4assen g4en. g4leic g4na. g4nin g4non g4rab g4re2e g4reb g4rem g4rer g4rif g4rip g4ross
+g4rot g4ruft g4rün g4s3a2b g4s3a2k g4s3ama g4s3amp g4s3ce g4s3co g4s3ita g4s3op g4s3pl g4s3pru
+g4sa2d g4salb g4sall g4salm g4salt g4sant g4sca g4schef g4se4s g4sela g4sent g4ser g4seu g4sm g4sn
+g4spas g4stanz g4stoch g4stod g4stor g4streu g4sw g5ge g5s4orge g5s4pie g6sporto ga1c ga1fl ga1k
+ga1ny ga1q ga2b3l ga2ka ga2ku ga2lar ga2mec ga2s ga3bu ga3di ga3laf ga3mel ga3pe ga3r2i ga3r2o ga3ru
+ga3t2a ga4se4m ga4sei ga4sel ga4sent ga5schu ga5se. gab2o gab4ri gade2r gadi4e gae2 gal2a gal3lo
+gam3ma gan2g1a gan2g1u gan2gr gan3d2 gang4sp gans2 gar2s gas3al gas3s2 gas4t3el gase2 gast3rä gat2h
+gat4r gau1c gau5ne gber2 gbi2 gbon2 gby4t gd2ad gd2en gd2es gd2or gd3s2 gda3de gdel6s gdt4 ge1c
+ge1e2 ge1ini ge1inn ge1ir ge1ou ge1r2ö ge1ra ge1ro ge1s2 ge2is ge2lev ge2nim ge2r3al ge2ra2b ge2rob
+ge2rop ge2s3eb ge2s3er ge3a2 ge3ble ge3ck ge3ec ge3fu ge3g2l ge3hei ge3lec ge3mu ge3na ge3nid ge3nä
+ge3r2u ge3rann ge3sha ge3si ge3st6e ge3stak ge3t2a ge3ti ge3u4t ge3wa ge4ie2 ge4ig ge4l3ers ge4lene
+ge4lerk ge4ma. ge4n1ac ge4n3al ge4n3ern ge4nak ge4nam ge4nar ge4nat ge4ness ge4näu ge4r3a2r ge4r3ent
+ge4rant ge4ren4s ge4rene ge4reng ge4s3elt ge4s3ter ge4s3tur ge4tang ge4tant ge5t4u ge6sche. geb2a
+geb4lin gebot4 ged4 geest3 gef4l gef4r gegen1 gegen3s4 gei2st gei3sh gein1 gein2v gein5sti geis4sc
+gel1i4m gel2ö gel3ere gel3f gel3la gel3sa gel3ste gel3sz gel3ta gel3z2 gel4b3ra gel6derh gel6ders
+gelb1r gelb3s gelder4 gele5cke geler3ö gell2i gels2p gels2t gelt4r gem2 gem6e gen2dr gen3eid gen3k4
+gen3n gen3sk gen3sz gen3t4h gen3tä gen4aug gen4sam gen5tr gen6erwe gener4f gener4z geo2ri ger2er
+ger3no ger4inn ger4sat ger5me gerin4f gerin4t ges3auf ges3s4t ges3th ges4pi gesch4 gest2 gest4a
+get3s get4ri gfi2l gg2l gg4r gga4t gge2ne ggs2 gh1l gh1w gh2a gh2e gh3sc ghs2 gi2e1i gi2e3l gi2eb
+gi2gu gi2kel gi2me. gi2met gi2ob gi3ne gi3tu gi4eno gi4mes gi4us gich2 gicht1 gie1st gie3g gie3n
+gie3re giel2a gien2e giet2 gif2tr gift5s gin2ga git2a gl2 gl3b gla2s1c glas3t4 glei4t5r gleiter8s
+glerei4 gn2e gn4al gna4l3er gne2tr gnise2 gno1r go1i go1y go2s1 go3be go3in go3t2h go4a go4pos
+goa3li gob2l goh3ren gol2a gol2fr gon2e goo2 gopf4 gor2a gos3p gost2 got6t5erg got6terb gra2ba
+gra2bi gra2s3a gra2st gra4bl gra4sh gra4sp gram1 gram6mer gram8m7en8d grar1e grau3f grau3sk gre3no
+grei4fr gren6z5ei grenz3w gres6ser6 gri2e gril4la gro2b3r gro2ba gro2bl gro3ber gron4 gros6sel
+grun2g gräs1c gs2am gs2e3h gs2ki1e gs2pac gs2thy gs3a2r gs3amb gs3em gs3er1i gs3ha gs3i2k gs3in
+gs3s4 gs3ta gs3tr gs3tä gs3tö gs3tü gs4pant gs4tati gs4tell gs4trat gs4tör gs6port. gsa2v gsa4p
+gsau2g gsch4 gse2 gse4kl gse4t gsen1 gsfi2l gsh4 gsi2d gso2 gso4b gsrat4 gsrü2c gst3err gst3rit
+gst3ros gst4ra gst4res gst5reit gste2r gt2s gt3h gt4hy gt4r gtei3s gti2m gu1an. gu1ant gu1as gu1c
+gu1ins gu1is gu2e gu2s gu2t gu3am gu3sc gu3se gu4ale gu4d3r gu4st guet4 gum2e gummi1 gun2e gun2s
+gunge2 gur2th gur2tr gure4 gurt3s guru1 gus2s1o gus2sp gus3a gus3te gus4ser gus4st gus4tr gus6tend
+gus6terl guschi5 gust3a4b gust3en gut1a gut2sp gut3er4h gut3h gut4sa gy3n gyp2a gzeu4gi gä4u gär3th
+gö2f güs3 h1a2br h1a2dr h1a2lar h1a2ß h1abs h1adle h1affä h1ah h1ansc h1arm. h1arti h1audi h1aufb
+h1aufs h1aukt h1e2pi h1eie h1eif h1eig h1eiw h1ents h1er2fo h1erke h1erör h1i2so h1iat h1inf h1inh
+h1las h1lat h1laut h1lay h1läs h1läu h1lüf h1o2r2an h1o2x h1or3d h1ortu h1q h1r4ah h1rai h1rane
+h1ro2l h1ropa h1s2ti h1sta h1stec h1stei h1stel h1sto h1str h1stun h1stü h1uhr h1uhu h1umh h1una
+h1uni h1unm h1ups h1weib h1weih h1yo h1äff h2a3ra h2aft h2agg h2ahs h2ai h2aj h2ame h2an. h2anbe
+h2and h2ard h2arme h2as h2ause h2ef. h2elf h2en. h2er. h2ere h2ern h2eu h2im h2inde h2keu h2leis
+h2lerg h2lie h2lif h2lim h2lip h2lis h2lit1 h2lo h2lös h2mant h2mo h2mu h2n1unf h2n3ef h2na h2nel
+h2nic h2nid h2nie h2nip h2nor h2nul h2nä h2on h2r1eta h2r1eu h2rec h2rev h2ri h2s1a2d h2s1alk h2s1as
+h2s1eie h2s1erf h2s1erg h2s1erk h2s1erl h2s1erw h2s1i2d h2s1par h2s1pat h2s1u h2s3aur h2s3ec h2s3ing
+h2s3tau h2s3täu h2sall h2san h2sath h2saud h2saut h2serh h2serz h2serö h2seth h2sex h2sofe h2sop
+h2spac h2sph h2spro h2sprä h2staf h2stit h2stol h2stor h2säh h2säug h2t1e2d h2t1eig h2t1eim h2t1eis
+h2t1eke h2t1emi h2t1eu h2t1i6n3 h2t1im h2t1ob h2t1of h2t1urs h2t3a2t h2t3asi h2t3h2 h2t3res h2t3rol
+h2t3ros h2t3rü h2ta2d h2ta2n h2ta2r h2tall h2talt h2tap h2tasy h2tau h2teif h2temp h2ti2d h2tope
+h2trek h2tär h2wall h2wirr h2ü h3abf h3atl h3attr h3au3g h3e4miss h3echs h3eintr h3elem h3entz
+h3erzeu h3i4mit h3impe h3kö h3le. h3leb h3led h3lein h3leist h3ler h3les h3lied h3loc h3log h3los.
+h3losi h3luf h3luk h3lumpe h3läche h3m2ö h3mad h3mag h3mak h3man h3mar h3me. h3med h3mein h3meld
+h3men h3mex h3mil h3mind h3mini h3minz h3mirr h3mop h3mot h3mul h3mä h3nag h3nam h3nau. h3ner h3olym
+h3r2ech h3rat h3re2s3 h3red h3ref h3reic h3reif h3rep h3rez h3ric h3riesl h3rin h3rog h3roh h3rou
+h3ruh h3rut h3räu h3rö2s h3rü h3s2ext h3s4inni h3s4terb h3s4tern h3sele h3skand h3spec h3spei
+h3sperb h3spoi h3st2an h3t2ank h3tanz h3tat. h3tate h3tet h3thera h3thes h3tran h3tub h3tü h3z2o
+h3z2w h3öst h3über h3übu h4eib h4imm h4l3entr h4lents h4lerz h4lesi h4lorm h4marc h4mäc h4mäh h4mäl
+h4möl h4n3e2ro h4n3ersa h4nar h4natt h4r3eig h4rei. h4reinl h4reins h4rerla h4rick h4rist h4romat
+h4rome h4romi h4romo h4ron h4ry h4rüb h4s1ehr h4s3acht h4s3endw h4s3ita h4s3pani h4s3treu h4samt
+h4schan h4seind h4sernä h4stele h4t1e2se h4t1e2th h4t1ess h4t3eilz h4t3elas h4t3elfe h4t3elit
+h4t3engl h4t3enta h4t3oly h4t3ras h4t5ric h4t5rieg h4t5rin h4ta2m h4talo h4tax h4telek h4tenga
+h4tentf h4tents h4textr h4thei h4tho h4tisr h4tord h4traub h4tref h4ts h4öh h4übs h5erkran h5len.
+h5treck h6l3er4nä h6rer6leb h6seinst h6t5erleu h6terfül h6tergeb h6terleb h6terneu h6tersta ha1k4l
+ha2cho ha2del ha2lau ha2nal ha2nan ha2nem ha2pl ha2po ha2pr ha2rom ha2str ha2t3r ha2ta ha2ve. ha3lo
+ha4ch3en ha4far ha4rab hab2a hab2e hab2i hacks4 hade2n hado2 haf2e haf2tr haf3f4l haf4to haft4s3p
+hal2b3r hal2ba hal2bu hal2sp hal2st hal4bel hal4bin hal4sei hal4sk hal4tal hal4tei hal6lere hal6lerf
+hal6lerg hal6t3r halan4c han2d3r han2da han2f1 han2kr han6g5end hand3s har2fr har2th har2tr har2za
+har3ma har4me. har4ne har4tri hart4e has2h3 has3t has4c has4s3t has4sa hasser4 hatt2 hau2sa hau2sc
+hau2ta hau3f4lo hau4san hau4sel hau4spa hau4spe hau4sur hau4t3r hau5f6lie hau6s5ent haussen6 hba4ras
+hbe3r2e hdan2 he1cho he1e2t he1e4m he1ism he1ist he1x2a he1y2 he2b3l he2dit he2el he2f1ei he2f5l
+he2fan he2fau he2fid he2fre he2fu he2hel he2im he2l1an he2l3au he2lek he2len he2lö he2n1e4t he2rad
+he2rat he2re2b he2rel he2tap he2um he3be he3br he3bu he3ch2e he3chi he3cke he3f2em he3gu he3li he3lo
+he3mi he3on he3op he3pa he3ph he3ro he3s2p he3s4a he3si he3stro he3t4s he3th he3tä he3x he4f3ing
+he4lof he4mia he4n3u he4nas he4nat he4nene he4nens he4nerm he4r3a2r he4r3o2b he4reck he4rene he4rerw
+he4rof he4rop he4rot he6reis. he6rersc he6rin6nu heb3eis hed2g hee2s hee3le hef3erm hef4ra hei4mal
+hei4man hei4mar hei4mei hei4mu hei4n3er hei4neb hei6nene heim3p heine2 heit4s3 hekt3a hel1ec hel2or
+hel4l3au hel4mei hen3a2 hen3ebe hen3end hen3erg hen3str hen3te hen3tr hen3z2 hen4gag hen4kan hen4kau
+henen1 henfal4 henst2 hent2a hept2 her2z1w her3a2b her3la her3th her3tr her3um her4eif her4klä
+her4zap her7eises herb2 herin4d herin4f herin4s hert4 hes6tä het2i heter2 heu3g hfaller6 hfan2
+hfel2l3 hfi2s hflei2 hgas1 hget4 hhoh2 hi1ce hi1th hi2ac hi2ang hi2e hi2k3r hi2l3a4 hi2n hi2p3 hi2r
+hi2se hi2tan hi2tel hi2v1o hi3d2e hi3ens hi3nak hi3nam hi3nap hi3nel hi3no hi3ob hi3or hi3ra hi3ri
+hi3tac hi4on hi4pl hi4pu hi5nas hich6t5er hicht6sp hie4rin hier3i hiers2 hif3f4r hil2fr hile3n2
+hin2en1 hin2t1a hin3n2 hin3s2 hips2 hir2m1a hir2mi hir2s hir4ner hirn1 his2a hit2i hit3z2e hkamp2
+hl1ans hl1anz hl1erw hl1ind hl1ob hl2ag hl2enn hl2enz hl2erk hl2ser hl2su hl2ö hl3d4 hl3l2 hl3s2lo
+hl3t2 hl4ere hl4sar hl5s6tern hl5str hla2gr hla2l hlan4d3a hlb4 hle2r3a hle3a hle3e4 hle3run hle4nas
+hlenen3 hles4t hlf4 hlm2 hlo2re hlos4st hls3ka hls3tie hlz2 hm2e hm2s hm3p2 hm3sa hme1e4 hme1s2t
+hme2ra hme3le hmeer4s hmen2s hmi2e hms1p hn1im hn1äh hn2e hn3d4 hn3eig hn3ein hn3ex hn3f4 hn3s2p
+hn3sa hn3z2 hn4eng hn4es hna2c hne2e3 hne3b hne4n1 hne4pf hner3ei hner4de hnflei4 hnhof8stras hnk4
+hns4to hnsuch4 hnts2 ho1on ho1ra ho1y2 ho2b3l ho2ch3 ho2cka ho2f1a2 ho2f1o ho2f3l ho2f3r ho2feu
+ho2fu ho2fä ho2l1a2 ho2l1ei ho2l1op ho2me. ho2mec ho2med ho2rak ho2rar ho2rau ho2rop ho2sei ho2sp
+ho3bern ho3ret ho3sl ho3spr ho3th ho4ar ho4cha ho4rens ho4sla ho4ßene ho6ckerl hoche2 hock3t hocker4
+hof3f4a hol3ar hol3g4 hol3k hol3s hol6zene holl2 hom2e hon2er hond4 hoo2r hor3ta hor4ter horo2 hose2
+hr1a2g hr1c hr1eh hr1int hr1ums hr2erk hr2erm hr2erw hr2erz hr2s1ac hr2s1er hr2sen hr2set hr2sin
+hr2su hr2tab hr2tan hr2te2l hr2th hr2top hr3a2c hr3ad hr3ap hr3ass hr3d hr3l hr3schl hr3spa hr4eini
+hr4s1of hr4s3and hr4sh hra2b hrb4 hre4t hrei3th hrei4ba hrei4br hreli1 hrer3s hrer4sa hrer6geb
+hrer6tüc hress2 hrest2 hrg2 hri4e hrit6tel hrk4 hrm2 hro4r hrr4 hrs3k hrs3l hrst2 hrt2sa hrt2se
+hrt2sp hrt3ric hrt4sin hrz2 hs1ern hs1of hs1org hs2im hs2kal hs2ung hs3tabl hs3tie hs3tum hs4cr
+hs4erne hs4pie hs4tief hs4tri hse2e hse4lin hse4mis hsha2k hss4 hst3alt hst3ran hstro2 ht1a ht1e2c
+ht1e2he ht1eff ht1ein ht1erh ht1or ht1ä ht2a2s ht2ag ht2s1o ht2sah ht2sal ht2scr ht2sel ht2sp ht3ane
+ht3arr ht3e4ber ht3erfü ht3ergr ht3erst ht3erwä ht3erze ht3ine ht3rak ht3rand ht3rat ht3rau ht3rec
+ht3rei ht3ru ht3röm ht3s4hak ht3skal ht3z2 ht4akt. ht4akte ht4heu ht4ri ht4s3a4n ht4s3end ht4s3eri
+ht4s3tur ht4s3tür ht4sein ht4seng ht5erspa ht6rates ht6raume hte3cha hte4m hte6l5ei. hter6de.
+hter6gri hter6häl hter8höhu hter8spar hterer6s htni2 hto2 htod1 hts2ti hts3k hts3tät hts5trau
+htse2r1 htsha2 htt4 htti2 htu2e hu1c hu2b1a hu2b1ei hu2b1en2 hu2b3l hu2bi hu2bu hu2fa hu2h1i hu2h3a
+hu2k1i hu2kä hu2l1eb hu2l1ei hu2l1er hu2l3a2 hu2l3in hu2lem hu2let hu2lid hu2lo hu2lä hu2lö hu2n
+hu2so hu2t1o4 hu2t3r hu2tab hu2ti hu3m2a hu3sa hu4b5r hu4bel hu4l3eng hu4lent huk3t4 huko1 hul3s4
+hule2 hull2 hun3d2e hun3ge hunde3i hunde3s hung2 hung4s hungsa4 hur2th hur3g2 hus2s1o hus2s3a hus2sp
+hus2st hus4ser4 hut2t hut2zu hut4z3er hut4zen hvil2 hwe1c hwein6sa hy2lor hy2pe. hz2a hzug4 hä2kl
+hä3usp hä6s5chen häde2 häu2s1c hö2c hö2s1 hö3ck hübe4 hüf2 hühne4 hüs3 i1a i1che i1chi i1cho i1chu
+i1ci i1cl i1d i1e2x i1ei i1ell2 i1ergi i1ern i1ett i1f4lä i1flü i1fre i1fy i1i4s i1ie i1im i1it. i1j
+i1la i1lu i1lä1 i1ny2 i1nö i1pa i1pe i1pr i1r4a i1rä i1rö i1stat i1stel i1steu i1stil i1stro i1stü
+i1ß i1ä2m i1äp i1är. i1ärs i1ät i1ñ i1ö2k i1ön i1ös. i1öst i1ü4 i2a1h2 i2a1q i2a2ra i2aa i2ab i2ache
+i2af i2ag i2aj i2aku i2am i2ap i2asi i2av i2b1auf i2b1aus i2b1eig i2b1eis i2b1ep i2b1in i2b3rau
+i2b3ren i2b3roc i2b3unk i2b3unt i2baut i2bim i2blad i2bleu i2bö i2ch3r i2dea i2dol i2dö i2e1un
+i2e2l1a i2ele i2els2 i2ene i2ere i2erni i2esc i2ese i2f1au i2f1ef i2f1erg i2f3arm i2fec i2g1ess
+i2g3att i2garb i2gim i2gl i2grou i2har i2is. i2k1au i2k1e4r2e i2k1ed i2k1ei i2k1ens i2k1er2f
+i2k1er2h i2k1eta i2k1off i2k1uh i2k3l i2kakt i2ke3ru i2keb i2kef i2ker2l i2kero i2kins i2kne i2kres
+i2krö i2kup i2kär i2köl i2kü i2l1ac i2l1ak i2l1au i2l1er2h i2l1erd i2l1ind i2l1ip i2l1or i2lab
+i2larb i2lemb i2ler2g i2lum i2lär i2m1arm i2m1art i2m1aus i2m1erf i2m1erl i2m1erz i2m1i2d i2m1ind
+i2m1ins i2m1ob i2m3anh i2marc i2maut i2meg i2mej i2mek i2mele i2melf i2meti i2mew i2mim i2minf
+i2mo2p i2mö i2n1ou i2n1u i2n1äh i2n3au i2narb i2narm i2neff i2neng i2o1p i2o1st i2o3sz i2of i2oh
+i2ony i2oo i2or i2ou i2ov i2s1erm i2s1es4s i2s1i2d i2s1of i2s1än i2sau i2sca i2schl i2schm i2scr
+i2serh i2sop i2spar i2spro i2säh i2sü i2t1ei i2t1ex i2t1of i2t1äs i2t3run i2taut i2temp i2tepo i2thy
+i2tid i2tuns i2v1ad i2v1ak i2v1am i2v1e4x i2v1ef i2v1ent i2v1ur i2v1ä i2veb i2vr i2vun i2xa i2z1ag
+i2z1ap i2z1erl i2z1w i2zan i2zaus i2zele i2zo2f i2zuna i2zän i2zö i3a2l1a2 i3a2l1et i3a2leb i3a2lia
+i3a2lin i3a4lerm i3ad. i3al. i3al3b4 i3al3d4 i3al3t4 i3al3z2 i3alc i3alef i3alei i3alel i3aleng
+i3alent i3alerb i3alerf i3alerh i3alex i3alf i3alg i3alim i3alj i3alk i3alm i3aln i3alr i3als i3alv
+i3am. i3amp i3an. i3and2 i3anl i3ans i3ant i3anw i3anz i3ar. i3as. i3at. i3at2h i3ats i3au i3blu
+i3ca i3ch4lo i3cke i3d2ac i3d2ans i3dat i3der i3dsc i3e2nek i3e2no i3en. i3en3s2e i3en3s2p i3en3sa
+i3en3sc i3en3sz i3ena i3end i3enec i3enex i3enf i3eng4 i3enh i3enj i3enk i3enla i3enle i3enm i3enn
+i3enp i3enr i3ens. i3enth i3enty i3env i3enw i3enz i3enä i3enö i3erbun i3ern. i3g2o i3g4neu i3ig
+i3in i3k4leri i3k4let i3kaz i3ki. i3kie i3klu i3kus i3l4aufb i3laub i3lip. i3lips i3lou i3lus i3nald
+i3nee i3nitz i3no3t i3o2x i3o4pf i3ol. i3om. i3oms i3on. i3ong i3onn i3ons3 i3opt i3or. i3orc i3orp
+i3ors i3ort i3os. i3ot. i3ots i3oz. i3per i3pfan i3r2ü i3ra. i3ras i3ree i3ro i3ré i3s2che i3s2eu
+i3sac i3sat i3suf i3tat i3tauc i3text i3tü i3vol i3xi i3z2as i3z2wi i3ä4tem i4ago i4ari i4ate i4athe
+i4demu i4elen i4ethe i4g3lim i4gefar i4glag i4gnä i4k1ang i4l3init i4labs i4lentl i4lents i4lerkl
+i4lerri i4me3sh i4n3ae i4n3enzy i4n3er4tr i4n3erbi i4ne4ben i4nesk i4nuh i4s1amt i4s3etat i4samp
+i4sch3e4h i4sch3re i4schar i4schef i4schin i4schna i4schwa i4schwo i4schwü i4schüb i4seint i4ski
+i4sku i4t1esk i4telek i4tents i4tiso i4tref i4zener i5al3l i5cu i5hea i5kerfam i5ner. i5sching i5thr
+i5tic i5tig i5toc i6ber6geb i6gebrau i6kantei i6lereig i6ner6leb i6schemi i6scher6z i6schwir
+i6sel6ter i6tereig i8t7ersche ia1o ia2kei ia2kr ia2l1o2r ia2l3u4 ia2lon ia2lä ia2n1e2b ia2nal ia2nau
+ia3do ia3lek ia3pf ia3s2p ia3sh ia3un iaf4l ial3ar ial3as ial3p ial4ler iall2a iall2i iampe4 ian2a
+ian2er ian2s1p ian3alt ias3s iast4 ib1art ib2bli ib2o ib2ser ib2un ib3ric ib4ste ibe1ro ibe4n1 ibus1
+ic1c ic1in ic3la ice1s ich1a ich1ei ich1l ich1w ich1ä ich2er ich2s1i ich2tr ich3le ich3li ich3n
+ich3ort ich4spe ich4tab ich4tan ich5m ich6art. ich6sele ich6stie icherin5 ichsen3 ick1s ickt2 id1a2n
+id1au id2ab id2ax id2o id2s1p id2set id4ru ide1rö ide2on ide3so ide4n1o idel4ä iden4se ider6reg
+iderin8nu ides2p idi1s idni3 idt4 idä1 ie1c ie1e2 ie1ind ie1o4 ie1ro ie1str ie2b3re ie2bl ie2bri
+ie2bä ie2cho ie2ck ie2d3an ie2dr ie2f1an ie2f3l ie2fau ie2fro ie2fäh ie2gl ie2gre ie2h1in ie2l1e2b
+ie2lek ie2lo2b ie2nim ie2rad ie2rap ie2ret ie2rö ie2san ie2t1o4b ie2t1ö2s ie2t3ho ie2t3ru ie2tan
+ie2tap ie2tat ie2tau ie2thy ie2w1u ie3a2 ie3de ie3g4n ie3nu ie3r2er ie3s4pa ie4b3rü ie4fonk ie4g5li
+ie4lene ie4leng ie4lor ie4n3in ie4nas ie4num ie4r3eis ie4r3erz ie4reck ie4spu ie4t3ent ie4t3erh
+ie4t3ert ie4t3ri ie4tag ie4tha ieb4sto iech3t ief1ei ief1r ief2i ief3f4 iefe2m ieg3r ieg4ra ieg4s3c
+ieg4se ieg4st ieh3r4 iel1ec iel3d4 iel3eid iel3sz iel3ta iel4erw ieler4e ieler6fi ieler6ke ieler6la
+ieler8geb ieler8lebn ieles4 ielf4 ieli2d ielt2 iem2e ien1ag ien1eb ien2s ien3er4g ien3s2k ien3si
+ien4am ien4tar iener6fo iener6la ienge4f ienge4z iens6t5er ienst5rä ier3a2 ier3sei ier3sta ier3ste
+ier3te ier3z2 ier4re. ier4s3eh ierer3k ierf4 ierg4 ierk2 iers2t ierts4 ies2st ies6ser6g iesen3s4
+iess3ti iest6e iet1a iet2se iet3her iet3zw ieu2e if1ar if1ein if1erh if1lac if1än if2e4n if2f3l
+if2far if2fro if2s if2t1op if2t3ef if2te2l if2ted if2tep if2tra if2tro if2tur if3l if3r if3sa if3se
+if3sp if3sta if4at if4los if4rev if4t1ei if4t3a if4t3esc if4t3ri if4terk iff2s iff4ste iflo4 ift1r
+ift3sp ift3sz ifte2s ifts2t ig1art ig1ein ig1erz ig3rad ig3re ig3s2o ig3s4tü ig3sa ig3sp ig3stei
+ig3str ig3sä ig4na ig4no ig4ren ig4s3to ig4sal ig4schr ig4spa ig4sti ig5erwer ig6stras iga1i iga3s4
+igd4 ige2ra ige4na ige4nid ige6nene iger4ze igo1p igs2ag igung4 ih1elt ih1um. ih1w ih3m ih3n ih3r
+ihe1e ihe1u ihe4n ihs2 ii2 ii3a4 ii3h ii3t ik1ak ik1art ik1ebe ik1in ik1o4ri ik1äh ik2t3re ik3amt
+ik3att ik3rä ik3sa ik3ste ik3sz ik4lim ik4län ik4ris ik4t3esk ika2ge ikaken3 ikanten8n ike2c ike2n1
+ike2ra ike4l1 iki1s iko1p2 iko1s iks2 ikt2u ikt3erk il1anm il1ans il1asp il1e2c il1ein il1el il1ent
+il1erf il1err il1ex il1ins il1ob il1ox il1ur il2c il2da il2dor il2dr il2erz il2f3l il2f3re il2gl
+il2m1ap il2m1au il2mak il2min il2mor il2of il2oh il2op il2zar il2zau il2zwa il3a2ma il3der il3l2er
+il3t4h il4d3en4t il4lenn il4sein il5chen ila2br ilan6zer ilb4l ild1o ild3ebe ild4erp ilde2s ildi2
+ile4th iler4ei iler4fo ilf2 ilf4s3 ilfe3s ili3e4n3 ili4g3ab iliga2 ilik4 ill2an ilm1ei ils4to ilt2
+ilung4 ilz1er ilü4 im1ans im1ein im1urk im2al im2en im2mei im2mä im2um im3aren im3pfo im3pse im3sph
+im3t2i im4at im6menth ima3i ima4tur imad2 imat5sc imm3ent immen1 imp2fa imp2s imt2e imt3s2 imtu2
+in1a2c in1ad in1am in1e2c in1eu in1od in1or in1äs in1ö2d in2alp in2an in2ars in2dal in2dan in2em
+in2erh in2et in2g1af in2g1ag in2g1al in2g3at in2gam in2gl in2gor in2i3d in2nor in2seb in2sur in2är
+in3ab in3ana in3ann in3att in3d2ü in3de in3dö in3erle in3f4 in3g2er in3gla in3glä in3k2ü in3ols
+in3s2z in3te in3unz in3z2e in3zwä in4elen in4g3erw in4k3ent in4ner4m in4s3tät in4sm in4strü in6samt.
+ina2be ina6lere inaler4 ind2ac ind2i ind3se ind4eid ind4ri ind5erke inda2 inde3sp ine2x ine3nä
+ine3un iner4lö ing1ar ing4s3am ing4s3pr ings6por ini3k4r ini3se init2 ink4er inma4le inne4n ino1s
+inost2 ins2am ins2i ins3umz ins4tip ins4to ins4tri insch2 int2o int3s inthi1 invil2 inz2i inz2u io1c
+io1r2h io2d io2n3au io2nor io3e4 io3k6r io3me io3sh io3t io4nee io5ska iof4l ion2 ion3an ion3d2
+ior2e iore4n ios2p ios2u iot4r ip2an ip2pan ip2sa ip2sei ip2sp ip3pe ip3pu ip4pl ip4sta ip4stü iph2
+ipi3el ipi3en ipp1f ips3t ipt2a ipt2u ir1c ir1u2m ir1äh ir2b3l ir2ch1o ir2he ir2i ir2k3l ir2m1ag
+ir2m1ei ir2m1o2 ir2mak ir2mau ir2mum ir2mä ir2n3a ir2no ir2rh ir2st ir3s2h ir3sche ir3se3 ir4e
+ir4kene ir4munt ir4nat ir4sch3r ir4sch3w ir4schl ir4schm irat2 ire4na irg4s iri3a irke4n irli4n
+irm4th irme4n1 irpla4 irre4l irt2s3t iru2s1 is1erg is1org is1ort is1pa is1pe is1pic is2ap is2end
+is2et is2o2n3 is2por is2st is2sum is3are is3auf is3la is3sa is3sta is3stu is3t6o is3tang is3tr
+is3täu is3tör is4e3li is4eind is4s1ac is4sau is4tab is4toc is4tru is6schen isa2r isau2g isch3ei
+isch3ma isch3ru isch3wu ise1e ise2n1 ise3a ise3hi ise4n3a2 ise4r3ei iseh2a isen3s isi1s isi2a
+isin3g4 iso6nend isonen4 iss2po iss3che iss3erf iss3tr ist2an ist2id ist3a2c ist3rei ist4e ist4ra
+iste4n istes3 isum3p it1a2m it1a2re it1ab. it1abs it1alt it1an it1app it1art it1au it1eff it1in1
+it1o2p it1ob it1uh it1ums it1urg it1änd it2eic it2erö it2os it2s1e it2s1o it2sa it2t1o4b it2teb
+it2top it2ung it2z1w it2ze2c it3anr it3raf it3ras it3rau it3re it3rob it3rom it3räu it4ret it4s3e2r1
+it4se2h it4tri it4z3erl it6zergr ita2po ita3ne ital3a ite2n ite4l1a iten3s2 iti2v5a iti3ker iti3sp
+itmen2 ito4be its1ag itt2sp itt3hä itt3rol itt4seh itt4sei itt4sor itt4sti itt6schi ituran4 itut4
+itz2er itz3erg ität2 iu4m1 ium4se iuma2 iun2 ius3t iv1an iv1ei iv1elt iv1ene iv1erh iv1erl iv1ins
+ive3re ive3s ive4n iver3s iver4kl iver8folge ix2em ixt2 iz1au iz1ir ize2n izei3c izeits4 izz4a
+iß1er2s iä2ti iä4tr iär2 iär3m iät3s4 j2a j2u ja1c ja1st ja3l2a ja3ne ja5ru jab4 jah4r3ei jahr2s
+jani1 jani3t4 jas2o jat2 je2a je2g je2p je2t1a je2t1u2 je2t3r je2tin je3v je3w je4t3h je4tor jean2s
+jek2ta jek4t3r jek4ter jek4tin jekt3o2 jektor4 jes3t jet3s2 jet3t ji2a ji2v jit3 jo1r2a jo2b1 jo2i
+jo2sc jo4da joa3 job3r jong2 jord2 jou2l ju1i ju2b3l ju2k ju3l2 ju3ni ju3r4a ju3t2e1 jugen6 jugend3
+jung3s4 jur2o jus3t k1area k1arti k1ef k1ei1s k1ero k1erz. k1ese k1last k1lauf k1lu k1ou k1st2 k1ämi
+k1öl k2amt k2ans. k2ar3ta k2ard k2arg k2ark k2ars k2arte k2arw k2aus. k2en. k2er. k2erc k2erko k2erl
+k2ers. k2ids k2ini k2inn k2le k2lien k2lif k2lin k2lip k2lir k2lisc k2lud k2lug k2lum k2lär k2löst
+k2n2 k2on k2os k2r2 k2s1e2b k2s1e2v k2s1ec k2s1eng k2s1ent k2s1i2d k2s1in k2s1is k2s1u k2san k2sau
+k2sav k2serf k2serg k2serk k2serl k2sers k2serw k2sex k2so2r k2sop k2spal k2sph k2spä k2stal k2stit
+k2stor k2stuc k2stum k2stur k2stüt k2säh k2sö k2t1a2r k2t1ad k2t1au k2t1erh k2t1of k2t3h k2t3rau
+k2tent k2terö k2tex k2ti2d k2tins k2tuns k2u3n2a k2öf k3en4te. k3er4lau k3ergeb k3erken k3erleb k3hu
+k3laug k3leg k3leit k3lem. k3lin. k3lor k3ne k3nu k3osz k3rats k3reif k3ren k3res k3rev k3risi k3rou
+k3s2pat k3sac k3spe k3stat4 k3stäl k3sul k3t4ran k3tal k3tub k3tü k3wa k4elt k4erfam k4lar k4link
+k4löt k4nec k4nol k4ral k4raw k4raz k4roch k4roi k4rok k4ron k4rop k4räc k4rän k4s1amt k4s3tanz
+k4seind k4sm k4stier k4strop k4t1ela k4t3erfo k4tentf k4tents k4tref k5en6gel. k6erlebe k6es. k6nur
+ka1c ka1f4l ka1fr ka1ho ka1in ka1k4l ka2l1os ka2lan ka2leb ka2lop ka2lu ka2nau ka2o1 ka2s3t ka2tan
+ka3ar ka3nu ka3r2i ka3sz ka3t2h ka4l1eh ka4lens ka4n1a2s ka4sp ka4ste ka4t3r kab2bl kade2r kaf3t2
+kag2 kaga3 kaken2 kal2k1a kal2k3l kal3d kal3eri kal4kan kal4tex kal4th kala3b4 kall2i kan2e kan3d4
+kan4al kan4tar kank4 kar2pf kar3d2a kari3es karu2 kas2o kas6tras kasi1 kau2f1o kau3t2 kau4fer
+kauf4s3a kauf4sp kaufs7tem kbo4n kbu2s kby4 ke1in2d ke1ra ke1rod ke1up ke2ben ke2di ke2gl ke2he.
+ke2hen ke2im ke2l1en ke2l1er ke2l1o2 ke2la ke2lä ke2lö ke2mi ke2n1e2b ke2nim ke2no ke2pl ke2r1ä
+ke2ran ke2rau ke2re2b ke2sa ke2sel ke2tu ke3lag ke3reig ke4l3am ke4nene ke4nens ke4t1a ke4t1eb
+ke4tel ke4th ke6rin6nu kefi2 kege2 kehr2s kehr4s3o kei2li kein4e keit2 kel1ac kel1au kel3b4 kel3eis
+kel3sk kel7l4e keld4 kell2i ken1a ken3au ken3dr ken3in ken3s2k ken3si ken3sz ken3th ken3z2 ken5s6tei
+ken6ten. kend4 kenen1 kener4n kenn2a kenn2e ker2fo ker2na ker3ein ker4ble ker4nei ker4reg ker4zeu
+ker5g ker6n5eur ker8oberung. kerin4t kerin6st kerz2 kes2sa ket2ag ket3ha kete4 keu6schl key3 kga4s1
+kge3s4 kho3m ki1c ki1f4l ki1f4r ki2el ki3a ki3d4r ki3k4 ki3lo ki3n4o3 ki3or ki4ad kia2r kie2z
+kie4lei kiel3o kier2s kier4st kin3s king3s kini3k2 kinos2 kio4s kis2p kis3s kis4to kist2 kiv2 kive4
+kkab4 kl4 klan2 klan3du kle2br kle2ra kle3us klit2s klo2i3 klost6 klu4b klung4 kma2la kne1e kno2b3l
+ko2al ko2nem ko2nu ko2rel ko2sp ko2stü ko2ter ko2we ko3l2a ko3le ko3pte ko3r2a ko3ri ko3ti ko3un
+ko4mu ko4sk ko5ad kob4s kobal2 kof3f2 koh3lu koh4a kohl2e kohle3i koka3 kol2k3 kon2i kon3s4 kont6e
+kop4fen kop6f5err kopfa2 kor2ba kor2bl kor2br kor2n3ä kor3m kor4nac kor4no2 kor6derg korden3 korder4
+kot3s2 kot4r kot4tak kre1i2e4 krei4st krei6sei kreier4 kreli1 krob4 ks1ei ks1er ks1o ks1pa ks2chi
+ks2eid ks2eif ks2end ks2ere ks2on ks2pel ks2por ks2pul ks2tep ks2zen ks3a2b ks3a2r ks3ha ks3kl ks3s4
+ks4tel ks4tol ksa2k ksch4 kt1abr kt1abs kt1akt kt1am kt1an kt1ein kt1erg kt1eta kt1ini kt1s2z kt1ums
+kt1äu kt2and kt2is kt3aug kt3ing kt3inn kt3ras kt3run kt3rü kt3s kt3z kt4ere kt4ro kt4sor kta3ri
+kta4re kte1ra kte3ru kte4n1 kten3s2 kten3z kti2me kti2s1e kti4ter ktion4 ktro1s kts1o kts2el kts2pa
+kts2ti kts4a kts4t ktsen1 ktt2 kturen4 ktä3s ku1c ku2al ku2h ku2rei ku2ro ku2s3t ku2sp ku2su ku3l2e
+ku3l2i ku3la ku4schl kud4r kul2tr kul4to kum2e kum2s1 kun3da kun4s4 kunden3 kung4 kunst3 kur2bl
+kur2sp kur4ste kur4str kuri2e kuri4er kurs1c kus3a2r kze3l kä2s1c kär2 käse3 kü1c kür4s l1a2po l1ab
+l1ano l1ansi l1ar3t l1eisf l1endp l1enni l1erg l1erkl l1ersa l1ny l1nü l1o2bl l1of l1urn l1urt
+l1ärme l2a2st l2abä l2ache l2ager l2akk l2ama l2amp l2anhe l2as. l2asg l2ay l2b1id l2b1ins l2b1o2ra
+l2b1uf l2bant l2bled l2bli l2ck l2d1a4n l2d1ah l2d1ak l2d1al l2d1au l2d1e2mi l2d1elf l2d1ems
+l2d1er2p l2d1es2s l2d1ori l2d1ul l2d1um l2d3ari l2dad l2daf l2dein7 l2deis l2dex l2dob l2dop l2dran
+l2drec l2drüc l2e1ra l2eid l2ein. l2eind l2einu l2eit l2eli l2em. l2er. l2erfr l2erfü l2erga l2ergl
+l2ergr l2erka l2erko l2euk l2f1ec l2feis l2geti l2i3t2e l2ins. l2insa l2k3ru l2l1am l2l1ef l2l1ind
+l2l1ou l2labk l2labt l2limb l2lo2ri l2lobe l2lof l2lär l2m1a2m l2m1ad l2m1e2p l2m1ef l2m1erf l2m1erl
+l2m1erz l2m1id l2m3a2b l2mof l2mum l2nin l2oba l2obr l2phir l2s1a2d l2s1e2b l2s1id l2s1pir l2s1un
+l2s3ph l2s3trü l2san l2serf l2serg l2serh l2serk l2serl l2sers l2serw l2simp l2sop l2spac l2stas
+l2ste l2stit l2t1ap l2t1au l2t1eis l2t1o2f l2t1o4b l2t1um l2t3ato l2t3h l2t3rö l2tab l2ti2d l2ump
+l2ura l2z1ac l2z1ag l2z1ap l2z1er2h l2z1id l2z1u4fe l2z1ur l2zan l2zat l2zele l2zo2f l2zwu l2zäp
+l2zär l2zö l3arti l3b2lat l3b2lu l3b2lö l3bac l3blo l3blä l3bum l3ca l3che l3chi l3chlo l3cl l3co
+l3d2erl l3d2ern l3d4ru l3dat l3de. l3der. l3däm l3ereig l3erfas l3erzeu l3f4lu l3f4lä l3fah l3fi
+l3g2i l3g4ra l3g4ro l3gas l3go l3k2an l3k4las l3k4lu l3kale l3kap l3kar. l3labu l3lage l3lame
+l3lans. l3lec l3len. l3lep l3ler. l3low l3ly l3mana l3n2e l3phr l3phä l3pu l3s2pit l3s2äm l3s2öl
+l3s4kele l3sac l3sare l3sarg l3sark l3seil l3spri l3spul l3stea l3stec l3steh l3stei l3stel l3stemp
+l3suf l3t2erg l3t4hu l3tarta l3tartu l3tehu l3thas l3tic l3tine l3tub l3tü l3vl l4b1e4ta l4b3eink
+l4b3eise l4chei l4chent l4d3ato l4d3erfa l4dentl l4der4he l4eine l4eist l4en. l4ergef l4erger
+l4erges l4erkle l4ers. l4erwa l4es. l4ferei l4l3ermi l4l3ernt l4latm l4leise l4lentf l4lents l4lergo
+l4linf l4lorb l4messa l4munt l4ole l4p1är l4s1amb l4s3ort. l4s3täti l4samp l4schin l4schmü l4stat.
+l4state l4steil l4sten l4stoch l4t1elt l4t1esk l4t1o2ri l4t1öt l4tame l4thei l4tord l4torg l4ts
+l4z3enth l5t2an. l6d5erlas l6der6geb l6lereig l8lergene la1ce la1k4l la2bit la2bus la2ce. la2f1ei
+la2fa la2fu la2g1a la2gio la2gob la2kin la2kro la2lar la2mor la2na la2nem la2nof la2ph la2r1an
+la2r1ei la2rel la2ro la2sin la2sis la2so la2t3ra la2tö la3ar la3b2a la3b4ra la3d2i la3nad la3nan
+la3t2e la3va la4g3n la4mun la4nat la4nau la4rene la4s1e2l la4sam la4sei la4ste la4stu la4sä la4tel
+la4us la5seb la5t4i lab2br lacks2 laf1r laf3s laf3t4 lag3d lag3l lago4 lak3t lake2 lam2m1a lami3t
+lamt4s lan2d3r lan2da lan2z1w lan3erd lan4dam lan6d5erw lan6d5erz lan6d5inn lan6g5esc land3au
+laner4f lang3s4 lap2pl lar1e2b lar3g lar3ini larf4 lart4h las4t3an las4t3ri lass4tr lat2ak lat2o
+lat2s lat2t3r lat2th lat3st lat4ri lat4t3in lat4tan lat4tex latzer4 lau2b3r lau2fo lau2tr lau3gl
+lau4fin lau4fri lau6scha laub4se lauben6s5 laubs1 lave4n lawa4 lb1ärm lb2s lb3a2ri lb3le lb3rea
+lb3sa lb3se lb3si lb3so lb3sp lb4sh lb4sk lbau1c lbb4 lbby4 lbe3rei lbe4ral lbe7s lberin5 lbs4t
+lbst1u lbst3ac lbst3ei lbu4n lbzei2 lch1ob lch1w lch3le lch3li lch3n lch3r lch3s2 lch3ü ld1arm
+ld1ass ld1erh ld1i4mi ld1id ld1är ld1ät ld2ac ld2os ld2ö2 ld3a2b1 ld3a2ck ld3ane ld3aus ld3r ld3sa
+ld3st ld3th ld3tu ld4ram ld4ris ld5rie lda2g lda2r lde3sa lde3str lde4ben lde4na lden5erg lder4tr
+ldo2r lds4an ldt4 ldt5s ldwes4 le1os le1sta le1sto le1str le2ad le2as le2b3re le2bl le2chi le2er
+le2g1ab le2gl le2gä le2inf le2ini le2le le2m1ei le2mau le2mor le2mu le2n1ed le2r1ä le2ra2s le2rag
+le2rap le2rau le2re2b le2rel le2rup le2spo le2thi le2u le2vol le2xis le3ar le3f2a le3lei le3len
+le3les le3s4h le3sei le3sk le3unt le4is le4mun le4n3a4t le4n3an le4na2d le4neur le4r3ei4m le4r3eis
+le4reng le4rerg le4rers leben4s3 lech1a lech7t6e leg1as leg4r lege1i lego3 leh3r2e lei2ta lei3l2
+lei4ble lei4fan lei4fei lei4str lei4to lei4ßer lei6nerb lei6scho lei6sern lei8schei leif1a leif3s
+leifer6g leim3p lein4du leis6s5er leisch5a leit3s4t leit3sk lem1o2 lem3s len1a len3ska len3sz
+len4gag len4k3lo len4kau len4klu len6sein lent4wä leo2f lep5t ler2e3c ler2zo ler2zä ler3kr ler3l
+ler4nal ler4nei ler4ric ler4sto ler4wer lerer5k lerin4s lerk2 lers2t les2am les2ko les2ti les4e
+les4ki lesi1 lest6 leste3r lester6i let2i let4tel let4top letsche6 lett1r letts2 lf1erl lf2en
+lf2s3ti lf2spe lf2su lf3einh lf3led lf3lo lf3ram lf3res lf4ru lf4rü lfe1e lfe4rel lfun2 lfur1 lg2lö
+lg4p lga3t lgd4 lgen2a lgens4 lgeräu3 lgung4 li1c li1efa li1efk li2ad li2ast li2cka li2cl li2gre
+li2nef li2neh li2nep li2nes li2nol li2o li2sp li2tal li2vea li2ves li2z3ä li2zau li3d2a li3efl
+li3ene li3ker li3l li3m2a1 li3nar li3nu li3s2a li3schm li3shi li3t2ä li3tu li3vr li4am. li4ds
+li4g3ers li4om li4schu li4t3r li4tur li5nie li6tun lian2g libi1 lich4ta lich4to lie2s1c lie4n1a2
+lie4rei liebe4s5 lien3s lier4sp lig4n lig4ra lik2o lik2sp lik2u lik4t1o2 lik4ter likop4 lil2a limas4
+limat4 lin1it lin2a lin4kan lin4kar link2s liss4 lit1s2 lit3se lit3sz lit4a litz4er liv2e livi3e
+lizei3 lk1alp lk1erd lk2l lk2men lk2s1 lk2ü lk3lad lk3lic lk4ne lk4spe lk5ner lke3r2e lkor2b1 lks3t
+lkse2 lkt2 ll1anz ll1arm ll1aus ll1ech ll1eic ll1eim ll1exe ll1ins ll1ob ll1opf ll1or ll1ur ll1äm
+ll2abr ll2anb ll2eis ll2ere ll2es ll2s1es ll2spr ll2säu ll3a2ma ll3att ll3aufg ll3ertr ll3k4 ll3l2
+ll3n2 ll3ol ll3ska ll3t ll3z2 ll4anwa ll5ebene ll5en6dun ll5m2 lla3gl lla4ner llb4 llch4 lld4 lle2la
+lle2ra lle3er lle4na llen3dr ller4fo llf4 llg2 lli4gan llt2e llt2i llti2m llts2 llus5t6 lm1a2ge
+lm1aka lm1apf lm1art lm1c lm1ind lm1ins lm1orc lm1äst lm3att lm3e4dit lm3p2 lm3s2k lm3s2z lm3ste
+lm3t4 lmbu2 lmd2 lmer2 lmpf4 lms6t ln3are lna2r lnd2 lnes2s lnus2 lo2fe lo2gl lo2gor lo2gre lo2k3r
+lo2min lo2n1o lo2o lo2spe lo2spr lo2ta lo2ve lo3r2en lo3tha lo4ak lo4chel lo4gh lo4nin lo4rä lo4sa
+lo4ske lo4ste lob4ri lobe2s loh2e loi4r lomä3 lop2p1a lor2an lor3am los3t4r los3to lot2h lot4e
+loti4o lp1ho lp2ar lp2f lph4 lpt4 lrau2s lre1s lrebs2 lrut4 lrö2 ls1eli ls1er ls1eta ls1um ls1äus
+ls2al ls2amt ls2ele ls2ext ls2kal ls2ky ls2po ls2pu ls2tie ls2tu ls2und ls2äug ls3a2b ls3ane ls3ha
+ls3s4 ls3tabl ls3unk ls4t3erk ls4taf ls4tip ls4tol ls4tri ls4tüm ls6terne ls6terns ls6tru lsau2
+lsau4m lsau4r lschs2 lse2t lst2a lstab6 lstahl3 lt1abs lt1alg lt1am lt1ara lt1art lt1eh lt1eig
+lt1ein lt1uh lt1äh lt1öl lt1ös lt2en lt2erö lt2est lt2s1pe lt2se2l lt2sti lt3aut lt3ents lt3rec
+lt3rei lt3ris lt3rol lt3räu lt3s2ph lt3t lt4hem lt4s3ort ltag4 ltampe4 ltan3d ltbau1 lte2c lte3mi
+lte3str lte4ral lten4sp lten6gel lter4fa lter4nä lter4se lter6ken lti3t ltimo4 lto2w ltra3l lts2eh
+ltu2r1i lturan4 lu1an lu1id lu1is. lu2dr lu2es lu2g1a lu2g1e2b lu2g3i lu2g3r lu2gei lu2go lu2gu
+lu2r1an lu2rei lu2ri lu2ro lu2s1u lu2sp lu2t1e4g lu2t1o4f lu2t3a lu2tel lu2top lu2tä lu3fo lu3mu
+lu4ru lu4t1or lu4t3erg lu4t3r lu4tas lu4tau lu6t5ersa luba2 lubs2 luf4t1a luf4tei luft3e luft3r
+lug3l lug3sp lugen1 lul2ö lum2ph lumbi1 lume4 lung4sc lus2s1c lus2s1o lus2s3t lus4s1p lus4s3a
+lus4s3er4 lus4sei lus4stä lus4t1o2 lus6terl lust3re lut5schl luter2 luter4s lv3r lva3 ly1a ly1o ly3c
+ly3no ly3onn ly3t lz1aus lz1imi lz1w lz2wec lz3l lz3t2 lze2l lzi4m lzvol2 lä1c lä2s1c lär4mar lö2b3
+lö2f lü2hel lück4e2 lücker3 lüh1l m1ab m1anza m1arz m1of m1ums m1ört m2ab4ra m2abe m2abli m2abä
+m2adä m2aldi m2alp m2anfr m2anh m2anle m2ark m2d1a2s m2d1erl m2d1um m2dan m2dei m2ei3l2 m2im2a
+m2inde m2m1ak m2m1al m2m1ans m2m1au m2m1e2b m2m1ef m2m1eu m2m1ins m2mab m2mum m2p1ene m2p3len
+m2p3les m2pf m2sam m2san m2sau m2sped m2spot m2spro m2spä m2stit m2sü m2t1e2d m2t1erb m2t1erf
+m2t1erg m2t1erk m2t1erl m2t1ers m2t1ert m2t1eta m2t1eu m2t1ev m2t1i2r m2t1in m2t1öl m2t3e2r1i m2t3h
+m2t3ro m2ti2d m2tim m2trö m3a2bar m3a2tel m3b4r m3ch m3kn m3le m3lo m3pon m3pu m3s2tu m3s4to m3s4tr
+m3sat m3sc m3se m3steh m3stei m3stel m3stä m3sy m3sä m3tü m4angel m4atme m4ay m4enta m4ersh m4mentl
+m4mentw m4nesi m4p3lem. m4s1e2d m4s1ef m4s1ene m4s1eu m4s1än m4sap m4sco m4se2le m4sein m4sent m4sex
+m4t3engl m4t3erei m4tenga m4tentf m4tentg m4tentr m4tents m4ts m4umb ma1f4 ma1ho ma1q ma1yo ma2bri
+ma2ci ma2del ma2ge. ma2geb ma2gef ma2geg ma2gek ma2gep ma2get ma2gev ma2gew ma2ke. ma2l3at ma2l3ut
+ma2lan ma2lau ma2mid ma2nar ma2nau ma2net ma2or ma2ta2b ma2tan ma2tä ma3chan ma3dac ma3g4n ma3gl
+ma3l2e ma3r2i ma3r2u ma3s2p ma3s4a ma4d2s ma4ges. ma4lakt ma4lex ma4s3z ma4t3erd ma4t3erz ma4tort
+maa2 mach2e mach4tr mach8t7ers mach8terh mack2s mada2m mae4 mag2a magi5er. magi5ers mahl4st mai4s3e
+mal1ak mal2ag mal3lo mal3t mali1e malu4 mam3m man2ce man2th man3d4 man3ers man3g2 man4gl mant3he
+manu3 mar2an mar2kr mar2sp mar2su mar3g2 mar6schl mar6schm mar6schr maro3d mas2e mas4st mas4ta
+mas4tel mas4ti mas4to mas4tr massen3 mat3se mat3sp mat3url matt4r mau2ta mau3r mb4l mbe2e mbe3r2e
+mbera2 mbert4 mble1i mbu3sc mbut2 mby4t md1är md3ato md3ras md3s2e mde2a mder2 mdt4 me1c me1ef me1o
+me1ra me1sto me2ben me2l1au me2lek me2ler me2lob me2nim me2re2b me2sal me2xe me3a me3e4n1 me3l4ant
+me3nage me3rak me3rid me3sze me3ta me3th me3tu me4gel me4n3an me4nas me4rens me4sä me4trig meb4
+medi3e4 mee4rei meg4 mega3 meh6l3er meh6rert mein4da meinde3 meiner6k mel2se mel3d2 mel3t4 mel4k3ei
+melb2 melde3i melet4 mell2 men2on men2so men3ar men3au men3ge men3k4 men4se. men4sen men4ser
+men4t3ak men6ses men6t5ers menen1 menst4 ment4sp ment5eig mer3f mer3sm mer4a3s4 mer4err mer4erw
+mer4sto mera3um merin4d merin4t mern3s2 mert4r merz6eng mes1pr mes2po mes2sp mes2st mes4s1o
+mes6ser6g mess3an met6t5en6d meta1s meu1 mfi2le mi1ch mi1f4 mi1p mi1s4tr mi1sto mi1ä mi2ci mi2di.
+mi2k1an mi2kar mi2kel mi2kin mi2ku mi2nef mi2sa mi2ste mi2t1u mi2t3h mi2ta mi2to mi2tr mi3a2b mi3da
+mi3dr mi3ele mi3h mi3k4l mi3kr mi3l2a mi3l2i mi3n2o mi3ni mi3nu mi3sau mi3v2 mi4e3no mi4n3e4ri
+mi4n3of mi4sch3w mi4scha mi4schr mia2n mibi1 mic1e mie1s mie2ti mie2tr mie3dr mie4rob mie4to mien3s
+mierer4 mil4che milch1 mild4s min2ac min2eu min2ga min5anze minde4s miner1 ming3s mini3k4 mioni1
+mis2p mis4ser mis5sar mise1 mit3s2 mit5sa mite2 mitt2e ml3c ml3f ml3k ml3p ml3s mm1anz mm1art mm1ein
+mm1inb mm1inh mm1int mm1äu mm2ene mm2s mm2un mm3p2 mm3s2i mm3s2p mm3sa mm3so mm3sta mm3sti mm3te
+mma1st mma3a mme2s mme3sc mme4lin mme4na mme4r3a2 mme4rec mme4sz mmes5t mmgas4 mmi1s4t mmi3el mmi3k
+mmi3sc mmi5tw mmpf4 mmt2 mmu3r mmül2 mmüll1 mo1ny mo1ra mo1y mo2be mo2dr mo2fe mo2g1al mo2k1l mo2ner
+mo2nä mo2per mo2rak mo2rar mo3de mo3ti mo5to mode3s moh2a moi3r mol3d mom2e mon2do mon2i mon2s3
+mon3s4u mon3sa mon3th mon4dac mon4del mons4e mont2a mor2an mor2d3a mor2dr mor3g mor3t2 morf4
+morgen5s6 mos2ti moster4 mot4r mous2 mp1haf mp1hos mp2fr mp3lei mp3sh mp3str mp4f3er4z mp4f3erf
+mp4f3err mp4lis mp6fer6ge mp6ferpr mpa3ne mpe2n1 mpe4lin mpf1ef mpf3erg mpf3erp mpf3l mpor6tag
+mpor6ter6 ms1as ms1ori ms1ums ms2erh ms2po ms2pu ms2ti ms3s4 ms4tü ms5trä msau3e msch2 mse2n mso2r
+mt1ab mt1ak mt1ar mt1ein mt1eis mt1elt mt1ent mt1ita mt1ob mt1op mt1um mt1ös mt2s1e mt2sa mt3arr
+mt3aug mt3s2ka mt3s4kel mt3sco mt3send mt3stu mt3z mta2m mti2s mtmen2 mts3tät mtu3re mu1a mu2ner2
+mu2s1o mu2s3t mu2sp mu2su mu3cke mu3la mu3ni mu4ckel mu4nin mu4r1uf mu4s1a mul4lau mum2s1p mun6derf
+muru2 must4e mut1au mut4str mvoll1 mwa2 mwa4r mweg4s mwel4 mwelt3 mwu1 my1al my2s3 my3l2 mä3t4r
+mäh1r mär1 mär2kl mär2z mär4zer mäu2s1c mö2c möbe2 mü2her mühl1a mül4len mütter3 n1a2gi n1aig n1amp
+n1an n1apfe n1aq n1ar n1au n1e2go n1e2he n1ef n1ele n1emi n1endg n1endl n1engu n1erf n1erh n1erk4
+n1ermi n1eros n1eröf n1id n1in1 n1irr n1of n1onk n1ont n1ops n1yo n1äf n2ais n2ald n2als. n2alty
+n2anz. n2ard n2are n2ark n2arle n2aro n2arta n2arth n2asf n2auso n2c3ab n2ck n2d1ak n2d1anz n2d1ede
+n2d3ott n2d3rat n2d3re n2d3run n2d3rö n2da2d n2dana n2dani n2danl n2daut n2dax n2dei n2deth n2do2ri
+n2dob n2dof n2dopt n2drif n2droc n2drod n2drui n2duns n2dö n2e3f2a n2e4n3a n2ef. n2ell n2em. n2enb
+n2enc n2enf n2enh n2enj n2enk4 n2enm n2env n2enw n2erat n2erj n2erkö n2erli n2ern. n2ers. n2erv
+n2es. n2eun n2ew n2f1u n2g1a2c n2g1a2v n2g1ak n2g1ans n2g1ein n2g1erg n2g1id n2g1äl n2g3rai n2g3rat
+n2glic n2gn n2gum n2gö n2i3nu n2idi n2ie n2ina n2ins. n2ip n2k1ac n2k1ins n2k1o4be n2k1ort n2k1äh
+n2k1äp n2k3len n2k3rel n2k3rez n2katm n2kaut n2ketu n2kim n2knis n2knit n2kopt n2kren n2krol n2kum
+n2köl n2küb n2n1all n2n1uf n2n1unf n2nada n2nalg n2nan n2nau n2nof n2nop n2o1rak n2obel n2oble n2ohe
+n2s1a2d n2s1agi n2s1erf n2s1erk n2s1erw n2s1eu n2s1o2d n2s1op n2s1urs n2s1än n2s1äus n2sall n2salt
+n2sanm n2saut n2sem. n2sene n2sepo n2serh n2serz n2serö n2seth n2sety n2simp n2sini n2soff n2sph
+n2spo n2spro n2sprä n2stas n2stob n2sut n2t1e2mo n2t1e4ta n2t1eis n2t1äu n2t3rü n2t5hum n2tath
+n2teig n2troh n2um. n2z1a2g n2z1ach n2z1au n2z1erh n2z1ini n2z1op n2z1wu n2z1wä n2zan n2zar n2zat
+n2zinh n2zof n2zwet n2zwir n2zwö n2zän n2zär n2zöl n2äss n2ör n3a4nä n3abh n3achse n3and2 n3can
+n3ce4n3 n3ces. n3chl n3d2es1 n3dai n3dap n3dat n3dee n3f2al n3f2ang n3f2en n3f2er n3f2ä n3fi n3g2ars
+n3g4en n3g4es n3glot n3kal n3klag n3klin n3ma n3mä n3n2i n3nec n3nelb n3ole n3s2arg n3s2kal n3s2kel
+n3s2ky n3s2pi n3s4tic n3s4tän n3sabo n3sark n3sche. n3schu n3sil n3sis n3skle n3smara n3spe n3stand
+n3star n3steri n3stif n3stim n3stäm n3suf n3sy n3t2a3c n3t2arb n3t2arm n3t2arz n3t2i n3taro n3tehe
+n3thr n3trop n3tub n3tü n3unk n3uto n3ver n3vl n3z2es n3zani n4a3men n4al. n4ale n4as. n4dakt
+n4de4ros n4dei. n4dentl n4dents n4en. n4er. n4ett n4fex n4g3ni n4gt n4k3erle n4kalg n4kelem n4klade
+n4kre. n4n3iso n4nein n4ol. n4s1ont n4s3erne n4s3ort. n4s3prie n4schef n4schl. n4seinf n4seint
+n4sersc n4siso n4speri n4spers n4stale n4stat. n4stats n4stilg n4strie n4strik n4t1all n4t1ein
+n4t1es4s n4t3inf n4t3inh n4t3o4rie n4t3rieg n4tansp n4tanza n4tarti n4torg n4ts n4zen4se n4zentl
+n4zerwe n5cu n5der. n5deren n5deri n5gene n5he n5mi n5s4pen n5s6terne n5s6terns n5t4lem n5t4ree
+n5trep n6der6sat n6n5ereig n6staten n6testri n6zenerg n7halts na1fra na2b3l na2b3u na2bor na2br
+na2bä na2ch1 na2der na2gem na2h1a na2ka na2kro na2l1a2 na2leb na2lid na2lu na2lä na2mat na2mid
+na2per na2pos na2pr na2r1a na2rom na2str na2sym na2tem na2th na3dab na3g4r na3gin na3m4n na3me.
+na3ot na3r2u na3sä na4l3ent na4l3erw na4lar na4ler4g na4lerm na4nat na4schw na4t3au na5chen.
+na6lerei nab4rü naben3s4 nach3sp nach8t7ersc nachen4 nacht8raum nade4l1 nag2a nai2e nai4re nal1et
+nal1ex nal2ph nal3am nal3da nal3ei nal3gl nal3l2a nal3t2 nal5tr nales2 nalf4 nalg2 nam4sp namens3
+namt4s nan1eu nan6zene nan6zeng nanzen4 nap2si nar2rh nas2s1c nas4ta nasyl2 nat1ei nat2o nat4sa
+nau2fr nauf4fr nave4 navi5er. navi5ers nbe2in nbe3r2e nbes2 nby4 nch3m ncor2 nd1ann nd1au nd1c
+nd1imm nd1or nd2ag nd2erh nd2spr nd2ös nd3arr nd3att nd3elfe nd3rau nd3th nd3ti nd4ram nd4sene nda1f
+nde2se nde3o nde4al. nde4mot nde4rob ndel3l ndel4s3a ndels5en nden3sk nder5ste nder6läs nderer3
+ndes3s ndia3 ndo1c ndo1st ndo6na ndt4r ndwa5re ndy3 ne1ck ne1ra ne1rös ne1sta ne2ap ne2bl ne2dit
+ne2ke ne2l ne2n1e2b ne2neu ne2no4 ne2o3b ne2oh ne2or ne2pen ne2pi ne2pos ne2r3af ne2r3ap ne2ra2b
+ne2rac ne2rag ne2ram ne2ran ne2rau ne2reb ne2rec ne2rup ne2s1ev ne2s1of ne2s1or ne2s1pa ne2sal
+ne2sei ne2ste ne2t1an ne2tab ne2th ne2u ne2vol ne3at ne3au ne3e4in ne3eis ne3her ne3l2i ne3len
+ne3r4al ne3ska ne3the ne3ti ne4lim ne4lit ne4n3i ne4n3u ne4nene ne4t3ha ne4te2l ne4tin ne5nac neb4r
+neck2a nee1r2 nee3t neei2 neen2 neg4l neg4r nei4dei neiss4 nek3t2 nel2l1a nel3b nel4lei nen3ei
+nen3s2e nen3s2p nen3sk nen4dar nen4gen nen4nar nen5z2e nene4m nenen1 neos4 nept4 nerb2a nere2 nert4
+nerz2a nes2an nes3ti nes4sig nesi1 net1ak net2z1i net3ta net3te net3tr net4zer neu1c neu3g4 neu4ere
+neuer4f neuer4k neuer4r neuer4s neuer4w neur2 nf2es nf2o nf2t3r nf2tan nf3s2 nf3tu nf4ar nf4le nf4r
+nfalt4 nff4 nfi4le. nfo1s nft4st nfts3tr ng1a2me ng1ad ng1ams ng1and ng1ant ng1opf ng1or ng2lad
+ng2läs ng2nu ng2ob ng3d4 ng3erse ng3hu ng3ne ng3roc ng3ts ng4lok ng4nom ng4ran ng4s3e4h ng4scr
+ng4sek ng4sens nga2n nge3l4ei nge3s4a nge3sp nge4ram nge5nerw ngelb4 ngen3sa nger4zä ngg3s ngs3au
+ngung4 ngzei4t nhe2r ni1ce ni1el ni1fl ni2de ni2eu ni2g1a2 ni2gn ni2gre ni2k3r ni2kar ni2kel ni2ki
+ni2kor ni2nal ni2nor ni2ob ni2s1e ni2s1u ni2san ni2som ni2sp ni2sä ni2ti ni3ak ni3d4r ni3ene ni3eni
+ni3k4erh ni3l2a ni3l2i ni3ok ni3ol ni3ora ni3se. ni3spi ni3v2 ni4erna ni4ron ni4sam ni4schw nibb4
+nie2sa nie3b nie3l2a nie4n3 nie4rei nife4s3 nig3li nig4san nig4sp nihi3 nik3ing nik3t4 nin2ac ning4s
+nis3cha nis3s4 nit2o nit2ta nit3s4 nit4tra nit6t5er6k nit6tele nit6ter6g nitt3ri nitt4sa nk1abr
+nk1ang nk1apf nk1aus nk1ei. nk1eti nk1id nk1inh nk1ori nk1ums nk1urh nk2er nk2lo nk2sal nk2se nk2so
+nk2tak nk2tan nk2tin nk2top nk2tro nk2tru nk3art. nk3ersa nk3leis nk3les nk3rep nk3ro nk3rät nk3s2z
+nk3sen nk4nac nk4neb nk4rab nk4t3ern nk4tau nk4tent nk4terg nka2ge nka3sc nke2c nke2t nke4lei nke4na
+nke4ros nken4te nko2r nkord2 nks2ti nkt1it nkt1r nkt2et nkt3ric nkt4sen nkte3sk nku2n nla3ge nle2ga
+nle3x nmen2s nn1ori nn1ur nn2ei. nn2ens nn2erh nn2erk nn2ero nn2eu nn2ex nn2stö nn2th nn3erwa nn3f
+nn3s2p nn3se nn4ergr nn4sam nn4stoc nn5t2a nna2be nna3st nne2rö nne3lu nne4le nne4s1e nner2z nner4ei
+nner4fü nner4la nner6geb nner6war nng4 nnis3t nno2b nno2r nno3be nnst4 nnvol5le nnvoll4 no1c no2bla
+no2ed no2fe no2leu no2liv no2per no2pi no2rad no2s3p no2t3in no2t3op no2tan no2ter2 no2tex no2tho
+no2tr no2tä no3dr no3id. no3r2e no3r4ar no3ral no3sh no3tart no4lig no5at no5sk no6tentr noch4r
+noche4 nok2l non2e nons4 nor2a nor2d5r nor3mal nor4da nor4des norm2a nos2e1 nos2u nost1r not1e4i
+not3h noterb3 npa2ge npa2s npf4 npro1 npsy3 nran2 nre3s4z nrebe2 nreli1 nräu3s nrö2s nrücker6 ns1eb
+ns1erg ns1ers ns1id ns1of ns1un ns2av ns2ax ns2eh ns2ele ns2ext ns2inf ns2kis ns2orc ns2pac ns2pel
+ns2tep ns2ti ns2tu ns2um ns2ung ns2unw ns2äug ns3a2k ns3elem ns3erle ns3ertr ns3int ns3s4 ns3tabl
+ns4alp ns4anat ns4ath ns4cr ns4erko ns4om ns4pek ns4pie ns4pir ns4tati ns4tent ns4teu ns4tor ns4tric
+ns4trip ns4tüm ns4unk ns4unz ns4zene ns6trun ns8tagent nsa2r nsa2s nsau2s nsau4r nsch5eul nsch7werd
+nscht4 nse2ha2 nseh5ere nsei4n3 nsen4sp nsfi2l nsi2tr nsi4den nsi4te nsinn2 nsinns3 nspa2g nsrü2
+nst1ak nst3ane nst3u4t nst5eife nst5erge nst5opfe nst7einhe nsta2n1 nt1ant nt1ar3t nt1ark nt1eh
+nt1rau nt1äm nt2alp nt3artu nt3hel nt3ho nt3rea nt3rec nt3reif nt3rich nt3sp nt3z nt4en nt4erh
+nt4erk nt4erm nt4ern nt4ers nt4ert nt4hos nt4hu nt4hy nt4ral nt4raum nt4repr nt4rig nt4spar nt5spe
+nta2lo nta3ne nta4lin ntak4ta nte1e nte2st nte3au nte3g6 nte3sa nte3v nte4lin nte4na nte6r5eis
+nten6te. ntera2 nteu3 nteu6eri ntge4n nti3c ntim3p ntine4 ntini1 ntmen2 ntmo4 ntni2 ntnis1 nto1s
+ntopf3e nts2ah nts2t ntt2 ntu1s ntu4re. nu2es nu2fe nu2kr nu2ma nu2ra nu2t1a nu3a2r3 nu3kl nu3sc
+nu3se nu3spo nu4ale nu4n nu4r2i nu4t3r nubi1 nude2 nuf2 nuk4 nul2l1a nul4l3eb nul4lei nulle2 nun3s
+nur2z nur3s nus6serl nuss3er4 nvoran4 nz1erl nz1eta nz1id nz1int nz1wa nz3a4ne nz3erem nz3le nz3s
+nz5erste nza2k nza2s nze2t nze3sk nze3u4t nze4l3a nzer4lö nzer6tra nzi2ga nzig4s nä2hi nä2hu nä2sc
+när4s5t nür1c o1b o1ce o1che o1chu o1ci o1ck o1cl o1d o1he o1hu o1hä o1i2d o1i4tu o1im o1in o1ism
+o1la o1lu o1lé o1op o1or o1p2i o1pa o1pec o1pek o1r2ag o1raa o1ral o1ran3d4 o1ras o1uh o1v o1x o1yo
+o1ä o1ç o1ñ o2a4r o2as o2b3ein o2ber o2ch1e4c o2ckar o2ckau o2don o2dre o2e3t o2f1e2b o2f1e2d
+o2f1e2t o2f1ec o2f1ei o2fent o2g1ab o2g1ac o2g1ei o2g1ini o2h1o2p o2isc o2l1ef o2l1eis o2l1ert
+o2m1a2ge o2m1ap o2m1ars o2m1art o2m1au o2m1ef o2m1ei o2m1int o2m1org o2m3oa o2mab o2meb o2mel o2meru
+o2mum o2n1erb o2narb o2nef o2nerh o2nof o2noke o2p3le o2pera o2pfe o2pum o2r1e2b o2r1e2ck o2r1er
+o2r1eu o2r1ob o2r1ox o2r3add o2r3att o2rabb o2rind o2ro2r o2rya o2rü o2s1er4k o2s3ca o2stö o2sö
+o2t1abi o2t1ah o2t1ak o2t1au o2t1i2m o2t1ö o2t3hi o2teb o2thr o2til o2wh o2wu o2xu o2ß1el o2ß1en2k
+o2ß1enz o2ß1erb o2ß1ere o2ß1erf o3b2al o3ber. o3ca o3cke o3cki o3cu o3d2e3i o3dec o3dex o3dy o3er
+o3et. o3ets o3g4n o3gh o3he. o3hem o3hen. o3her. o3here o3hes o3ie o3isch. o3l2as o3mat o3meld o3mig
+o3nal o3nee o3ner. o3nett o3nod o3nur o3p2n o3pa5s o3phe o3r2ere o3r2ero o3rien. o3rier o3rou o3rä
+o3s2hi o3s2po o3s4ze o3sche o3sk o3sphä o3tabe o3tal o3tam o3tau. o3tem o3the o3tran o3tü o3ven
+o3wec o3wi o4a3bi o4a3la o4a3mi o4ac o4ad o4at o4berb o4bunt o4büb o4ckin o4e3s o4f1erb o4ine
+o4m1a2sy o4mante o4mep o4mn o4munt o4n3ends o4nikr o4nim o4nind o4ninh o4nins o4r3alm o4r3un o4rang
+o4ska o4ski o4skr o4t1eib o4t1eic o4t1eis o4t1emi o4t1er2l o4t1erw o4tentb o4them o5ass o5au o5men.
+o5ree o5rus o6ck5ersc o6rienti oa3che oa3chi oa3de oa3in oa3k2e oa4n oad4st oak1l ob1auf ob1la ob1or
+ob2am ob2as ob2e ob2lu ob3ite ob3lei ob3rei ob3s2h ob3sk ob3sz obe4na obe4ris oben3d4 ober3in
+ober5eis oberin6g obi4t obs2p obu2s obu2t3 oby4t oc1c och1a och1eh och1ei och1o och1s och1w och3l
+och3m och3r och3u4t och3ö2 ocha2b ocha2r oche2l oche4b ocher4k ochi4d ocht4 ochu2f ock3sz ock3ta
+od2dr od3ak ode2n1 ode2s1e ode3sp ode4l3ag odein3 odi3c odium4 odo4s odt4 oe3di oe4m oe4sc oen1e
+oet4h of1a2d of1a2g of1au of1eun of2ang of2f1a of2f1in of2f3l of2f3r of2fo of2fu of2s1 of2tei of3le
+of3li of3rä of3sta of3str of3sä of3th of3ur of4lö of4rü of4sam of4sen of4staf ofa2c off3erz off3sh
+off3si off3sp off3t4 offs2 ofi3k4l ofi3s4 ofs2ch oft2a og1ang og1ans og1l og1o2ri og1ste og2gl og2lo
+og3le og3s2p og3sti oga3d oge2l1i ogeld2 ogen4id ogener4 ogeni3 ogerätein8 ogi2er ogin1 ogo4i oh1alk
+oh1eis oh1er2z oh1er4t oh1s oh1w oh2la2d oh2lu oh2lä oh2n1o oh2ni oh2rel oh2rem oh2rol oh3lec oh3lep
+oh3lo oh3nee oh3rie oh3öl oh4l1o2r oh4l3erh oh4lerg oh4lerw oh4n1ac oh4rat oh4rerg oh4rin ohen3s
+ohl1a ohl1ei ohler2 ohls2e ohm2 ohn1ap ohn3sk oho4len ohol1e ohr3a2 ohren3s ohrer2 ohrt4r oi1th oi2r
+oi4da oiss2 ojek8tori ok1lä ok2a ok2e ok2li ok2o ok2s1p ok2so ok3ac ok5t2 oka2la oka3b2 oka3i
+oka6lere okale2 okas4t oki4o oko4pt ol1a2v ol1ant ol1eie ol1exz ol1ort ol1ät ol2ar ol2chr ol2d1ed
+ol2d3o ol2dei ol2deu ol2dim ol2dä ol2e3u2 ol2f3l ol2f3ra ol2fa ol2fem ol2g3r ol2gl ol2i ol2k3re
+ol2kl ol2l1ac ol2l1ak ol2l1au ol2l1e2b ol2l1ei ol2lad ol2lel ol2of ol2y ol2z1a2 ol2zim ol2zo ol2zw
+ol3abu ol3ke ol3s2k ol3t4h ol3zan ol4arm ol4d1am ol4d3eng ol4dr ol4l1e2c ol4l3erw ol4l3ess ol4ler4k
+ol4ster ol4z3ern olaf4 olar3s2 olast4 old5ersa olde2s ole1s ole3s2t oler2 olf1r olf3ere olft4
+olge4ne oli2er oli3k4 oli3ze oli5tu olie4n1 oll3am oll3s2a oll3sp oller6ge olo1p olu2th om1alg
+om1all om1ebe om1ene om1er2h om1er2z om1ind om1ins om1o2ri om3ansc om3ing om3ma om3pf om3sk om3t4
+oma2bl oma4ner omar4te omer4s omi2c3 omiet1 omm2e oms2 on1ap on1e2c on1ema on1erd on1erg on1ers
+on1erö on1orc on1äh on2au on2dan on2dra on2eng on2eu on2gue on2i3d on2inn on2seb on2t1eb on2te2l
+on2th on3a2b on3ann on3auf on3ein on3f2 on3gla on3ing on3k2 on3n2an on3n2e on3ord on3ta on3v on3z2
+on4drin on4erka on4s3l on4sam on4t3erl on4t3rat ona3g ona3th onaler6e onan6z5ei onat2s ond1r ond3sk
+onde8rers onderer5 one2m one2n1 one3h onen3s2 oner4fa ong3s2 ong4r onie3g onli4 onlo2c ono1 ono3s
+onot4 ons1a ons1p ons3ing ons5tri onse4t onsen1 onsi2d onst2a onst4r ont5end onze3in oo2k3l oo2ka
+oo2kn oo2mo oo2su oo2t1a oo2tr oo2tur oo4sk oo4t3h oor3d oos3s4 oot1ei oot2st op1akt op1ef op1erh
+op1flü op2f3a op2fin op2fo op2fä op2p3r op2pan op3fah op3lag op3li op3sz op4pl opa2le opa3s4t opab4
+ope3l4a3 opf3la opi3er. opi5a4 opi5ers. opie4r3u opin2 opo2la or1a or1c or1e2th or1eff or1eig or1ima
+or1opf or1uh or1änd or1ät or2am or2ce or2d1ir or2dar or2dau or2deu or2dit or2do2 or2far or2gl or2gn
+or2m1eb or2mam or2mor or2mum or2n1a2c or2nal or2nar or2t1ak or2t1an or2t1au or2t1um or2t3e2v or2tef
+or2the or2tin or2tö or2uf or3a2mi or3adr or3ap or3arr or3g4a or3ghi or3gla or3gle or3k2a or3ni
+or3no1 or3oly or3r2e or3rh or3s4a or3sh or3si or3sk or3sz or3z2e or4alt or4d3ing or4k3ar or4m3er4g
+or4m3erf or4mans or4muni or4munt or4nin or4t3ere or4t3erl or4t3off or4t3räu or4ten5g or4terk or4to2r
+or4trau or5ne. oral3l oran2f oran2m oran4ze oraus6wa orb2l ord1am ord3eng ord3s2t ord3t orde4s ore2a
+ore2h oreli1 orems2 orer1i orf3li orgi1e ori4mi ork2s ork4r orm3asp orm3ord ormu4n ormwa5 oro2pe
+oro3n2a orr4a ort3erb ort3erf ort3erg ort3re orta2r orte4n orter6fa orter6sc orum4s os1um os2co
+os2el os2ex os2ho os2kal os2lo os2pac os2pe os2pra os2s1ep os2s1o2 os2s3t os2san os2sei os2sik
+os2sim os2sp os2t3h os2tid os2tit os2tug os3ad os3pec os3tarr os3til os3toc os4hu os4mog os4pot
+os4s3en4k os4s3enz os4s3er4b os4s3er4f os4son os4sto os4sä os4t1or os4tam os4tat os6s3a2c osa1s
+osal2 osch3ar osch3le ose1e ose1in2 ose2n osol1 oss1pa oss2er oss3ala oss3and oss5erei osser4e ost1a
+ost1ei ost1o4b ost3eur ost3ran ost3re ost3rot ost3rä ost3uf ost5erwe osta4s oste2n oster3e oster8wei
+ostes5s ot1ant ot1ast ot1erb ot1url ot1ä ot2em3p2 ot2id ot2in ot2o ot2s3at ot2spr ot2t3h ot2t3r
+ot2tan ot2teb ot3entr ot3inh ot3opf ot3rat ot3re ot3rin ot3roc ot3rus ot3sch ot3sti ot3stra ot3t4ra
+ot3t4ru ot4rau ot4ta2s ot4terh ot4terk ot4tim ot4tri ota2s ote1i ote2s ote3i4n ote4l1a ote4lin
+ote4na otli4 oto1s oto4rei otob4 ots1o ots1p ots2en ots2pe ots3tau ott1a ott2o otte2s5 ou1f4l ou1is.
+ou2le. ou2les ou2ret ou3gl ou3s2i ou3tu4 ou4ge ouff6 oun4ge. our4ne. oure2 ouri4 ourie4 ourme4
+out3s2 ov2a ove3s4 oviso3 owe2r1 ox2a ox3l oy1s2 oz3z ozen4ta ozes4sc ozir3 ozon1a oß1is oßer2 p1e2b
+p1hand p1hau p1lah p2ad p2eim p2f1ab p2f1ak p2f1au p2f1i2d p2f1in3s p2f1äu p2f3om p2fa2r p2fad p2faf
+p2fef p2fei p2fent p2for p2fum p2fär p2ho. p2hob p2im p2p1ab p2p1erz p2p1h p2p1ö2 p2p3ra p2p3ru
+p2p5rä p2pat p2pf4 p2ple p2pri p2r2 p2st p2sö p2t3h p3fen. p3s2ti p3sta p3stea p3stel p3stä p3stö
+p3stü p3te p3ti p3tung p4f1ep p4fener p4ferde p4lau p4leg p4liz p4p1i4a p4p1lac p4p1um p4plan p4ps
+p4rä p4t1e2b p4t1e2ti p4t1ei p4t1en2g p4t1ent p4t1ep p4t1erw p4t1erz p4t1in1 p4t3ec p4tele p4temp
+p4tos pa1fr pa1ho pa1k4l pa1q pa2m3a pa2nar pa2neu pa3da pa3gh pa3l2i pa3s2p pa3uni pa4n3at pa4nisl
+pa4r3aff pa4rant pa4st pa5t4e2 pak4to pal2m1o pal2ma pal2mä pal2ta pal2tr pal4tei pala3t pan3d
+pan3sl pan3t4h pan4ds pan4n3eb pan5ze panf4 pang4 pank4 panne2 panz2 pap2pr pap4s papi2 papie8r7end
+papieren8 par3akt par3d par3m2 par3ne par3z par4k3am par4kau par4kr parer8geb pargel6d pas2e pas2s1p
+pas6sein pas6serg passer4 pat1a pat4c pay2 pda4 pe1ra pe2a2 pe2en pe2l1a2 pe2l1er pe2l1ä pe2let
+pe2leu pe2lob pe2n1o pe2r1ä pe2rau pe2st pe3nal pe3pi pe3r2i3d pe3run pe3s2a pe4l1e2h pe4l3ink
+pe4lai pe4nas pe4nen1 pe4ni2t pea4r pea4s pech1 pei1 pekt4i pekt4sp pel3inn pel3k pel3l2a pel3sp
+peld4 peli2d peli4n pell2i pell4e pen3d2a pen3s2o3 pen3sz pen6ster pens2a pens2p pent2a penty2 pept2
+per2am per3as per4r3an pere1s pere2b perer2 perer3z peru2 perwa4r pes4ter pese2n pest1o pet4r pf1ai
+pf1am pf1ans pf1eim pf1ein pf1inn pf1lam pf1ra pf3are pf3f4 pf3lei pf3lo pf3lä pf3r pf3sa pf3se
+pf3sl pf3sz pf4es pf4lan pf4leg pf4rü pfe2l pfe2r5a pfer6pro pfi2s pgra2 ph2a ph2l ph3t4 ph4r
+phal4te4 phe4n1e phen3d2 phen3s2 phi4kan phien3 phik1a phu3t phu4s phä1 pi1ce pi2a1 pi2el pi2nad
+pi2o pi2pe pi2z1in pi3gl pi3le pi3o2i3 pi3onu pi3os pi3ri pi3t2h pi4ali pia3k4 pia3n piab4 piap2
+pias4 pid2 pie2ra pie4reb piel3a pil2zw pil4zer pin3s2p ping3s pingen4 pit2a pit2s pitz2e pku2 pla2y
+pla3na ple1c ple2e ple3n2 plu2s po1c po1ob po1pe po1rau po1s4tr po2el po2i po2l1au po2lan po2p1ak
+po2p1ar po2pl po2stä po2t1u po2t3in po2ta po2w4 po3id po3li po3pt po3un po3x po4sta pob2 pol3z2
+pold2e polo3p pom2ph pont2 por2th por3s por4tre por6tric porf4 pos3tel pos4tag pos4tei pos4tem poss2
+post3ra pot1ar pot2h pott1r pp1ans pp1au pp1ei pp1fr pp1lä pp2e2n1 pp3l pp3oh pp3p4 pp3rol pp3rot
+pp3s4a pp3sy pp5te ppe3e ppe4na ppel5ste ppeli5ne ppels2 pps2p ppt4 pre2ei prei4s3c prei6sei pren4ga
+press4e pri2l1 pri2t1 pri4e prings4 prit3a prit5t priter4 pro1st pro3be prot2e ps1ad ps1id ps2hi
+ps2th ps2tu ps2ze ps4pi ps4to pss4 pst1au pst3erh pt1a pt1uh pt1um pt1urs pt2ab pt3a4t pt3erei
+pt3ing pt3r pt3s2 pt3z2 pt4sl pta2g pto2mo pto2p pto2w pts4t ptü4 pu1a pu2dr pu2k1o pu2kl pu2lin
+pu2ra pu2rei pu2s3t pu3she pu5t2e pub4 pul2s5t pul2sp pum2pl pun2e pun2s pus2h put2s puzi3 pwa4r
+py3t pä2d1er pä2t3h pä2to pä3cke pä4ck3er pä4t1e2h pä4t3erb pä4tent pä4tep pä4tr päde2 pät3s4 pö2bl
+pö2c pül3l2 qu4 que3rel que4te. quer5n r1a2nal r1aa r1ab r1ahn r1ansc r1anth r1ar r1ce r1ch2i r1che.
+r1chen r1ci r1cl r1e4rek r1ein r1erg r1erk r1erl r1erne r1erre r1erri r1ert4 r1erw r1h4 r1ind r1innu
+r1ny r1nü r1or r1unse r1ß r1ç r1ölp r1ör r2a1as r2able r2abä r2ac. r2ack r2af r2ago r2ai r2al r2ami
+r2amm r2anbe r2angl r2anmi r2anmu r2ans. r2anz. r2ap r2ar1a r2are r2arf4 r2ark r2as r2ax r2b1ab
+r2b3le. r2bang r2bant r2barz r2bim r2binf r2blan r2bleu r2ck r2d1a2l r2d1ak r2d1elb r2d1inn r2d1uk
+r2daf r2darz r2dei r2delf r2dof r2drau r2dö r2eff. r2ei. r2eib r2eie r2el. r2elev r2ell r2els r2emi
+r2ena r2enz r2erer r2erli r2erse r2erte r2fent r2fo2b r2g1a2d r2g1ah r2g1ak r2g1ap r2g1ask r2g1e2c
+r2g1or r2g1öd r2g3na r2g3ni r2g3no r2g3oa r2g3ral r2g3res r2garb r2geto r2glan r2gleu r2glig r2gne
+r2go4b r2greg r2gret r2ha. r2he. r2ie r2is r2it r2k1ak r2k1erw r2k1im r2k1äh r2k3rom r2kef r2klis
+r2kob r2kou r2krou r2küb r2l1a2sc r2l1ar r2l3aug r2m1ad r2m1ank r2m1erh r2m1erl r2m1erp r2m3aph
+r2mab r2marc r2marz r2ment r2meo r2mide r2muni r2n1all r2n1ep r2n1op r2n1or r2nanz r2nau r2nid r2nin
+r2o3de r2on r2pli r2s1a2d r2s1ebe r2s1ef r2sein r2sepi r2serh r2serz r2stas r2stin r2stip r2stit
+r2t1ad r2t1all r2t1ang r2t1ar r2t1ima r2t1o4b r2t1up r2t1urt r2t3ae r2t3hi r2t3ute r2tabo r2telf
+r2temo r2terö r2texa r2tid r2trou r2wo. r2z1erd r2z1erf r2z1erg r2z1erl r2z1erw r2z1ess r2z3ot
+r2zant r2zar r2zat r2zwir r2zwä r2är. r2ös. r2öse r3a2kro r3a4nil r3a4rist r3alt r3asth r3atel r3axt
+r3bac r3ben. r3blat r3blau r3blen r3ch4lo r3d4rü r3de. r3des r3don r3enthä r3erleb r3f2es r3f4lä
+r3fam r3flü r3fot r3g2el r3gog r3grun r3han r3i2tal r3iso r3isr r3kel r3ket r3kol r3kon r3kri r3l2i
+r3l2o r3l2u r3lag r3lec r3lep r3lex r3ly r3nad r3nage r3nit r3nod r3pa r3pe r3pu r3r2u r3r2ü r3res
+r3s2hav r3s4kri r3s4no r3s4tern r3s4tü r3sabo r3schu r3shir r3sho r3so r3spe r3stie r3stink r3sto
+r3stra r3stä r3suf r3sy r3t2anb r3taf r3taufe r3teh r3tic r3tre1s r3trop r3tü r3unio r3v2o r3wei
+r3woh r3wort r3z2wec r3zähn r4ad. r4al. r4ali r4als r4anda r4ande r4as. r4aste r4at. r4b3last r4belä
+r4d1ex r4d3ernt r4deis r4dengl r4eigel r4em. r4en. r4erfe r4ergen r4ergru r4erwes r4fland r4frauc
+r4ge4tap r4haltb r4inspi r4kelem r4m3einh r4mantr r4muna r4n1ast r4n1erg r4n1erl r4n1ert r4n1erw
+r4n3ari r4n3att r4nef r4neif r4neis r4nerz r4nex r4pt r4s1amt r4s1op r4s3ang r4s3ort. r4s3ph r4samp
+r4sanf r4sanp r4sarm r4sch3e4b r4shu r4skor r4sky r4sob r4sord r4sorie r4spara r4sput r4stale
+r4stans r4stant r4stot r4t3albe r4t3einh r4t3ents r4t3erla r4t3ernä r4t3inf r4t3ris r4ter4fo r4terfa
+r4torg r4trak r4ts r4ventz r4z3ents r5hea r5land r5nes r5rega r5regi r5top. r5werk r5wert r5zene
+r6erschi r6erstad r6scherl r6st5eint r6strang r6tereig r7gie r7stati r7statu r8b7rechts r8blasser
+ra2ab ra2b1ar ra2bei ra2bli ra2ce ra2cho ra2chu ra2dei ra2el ra2f1er ra2gn ra2kre ra2kus ra2l3u
+ra2la2 ra2lid ra2lä ra2mei ra2mer ra2nan ra2nar ra2nau ra2pok ra2pos ra2rom ra2sta ra2t1ei ra2tan
+ra3ar ra3g4le ra3hö ra3ke ra3lamp ra3lex ra3rie ra3ris ra3spr ra4b5lo ra4cheb ra4d1r ra4dam ra4l3ab
+ra4l3end ra4l3ing ra4l5ern ra4lent ra4lind ra4schl ra4tid ra5tor rab2bl rab2er rab3erd rabdru4
+rach6t5rä rad5ri rad5t4 rada2 raf3ahn raf3ar raf3r rafe2 raft5s rages4 rahle4n rail2l ral1ak ral3b4
+ral3sk ral3su rala4s rali1e ram4m3u ram4man ram6m5ers ram6mens rama3s ramt4s ran2kr ran2kü ran3ade
+ran3ka ran4d3er ran4dep ran4spa ran6g5e6be rand3s rand5se rang5ste rangs2 rani1e rano2i rap2pr
+rar3in rar3zw raren1 raro2 ras2a ras4t3ei ras4to rat1a rat2ak rat2o rat3ze rat4r rau2m1i rau2sp
+rau3e4n rau3fä rau4m3ag rau4man rau4tra rau4tro raus5se raus8gewä raus8scheidu raut1r raut5s raxe3
+rb1art rb1auf rb1ech rb2al rb2lin rb2lö rb2ob rb2s1o rb2sei rb2ser rb2su rb2u rb3einh rb3ler rb3ras
+rb3rea rb4la2d rb4sam rb4stä rba3re rba4del rbb2 rbe3erf rbe3inf rbe3int rbe3r2e rbe3rum rbei5d2
+rbel2o rber6gin rbi3tu rbit2a rby4t rbü4b rce4n rch1s4 rch1w rch3l rch3m rch3r rch3sp rch3t2a
+rchter6r rd1an rd1ara rd1ark rd1iri rd1ita rd1os rd2ac rd2amm rd2ei. rd2sän rd3oss rd3rat rd3s2k
+rd3s2z rd3th rd4ri rd4rö rd5ris rdani1 rde3ob rde3r4er rde3sp rde4nu rdem6 rden3d rderin6s rdo4st
+rdt2s rdt4 rdär2 re1e re1in2v re1on re1ro re1sta re2b1a re2b1l re2dik re2h1o re2hac re2har re2hi
+re2hü re2lek re2m1ei re2ni re2ob re2pis re2r1ep re2rob re2sa re2t1ak re2tau re2thy re2u re2wi re3at.
+re3ats re3da re3gi re3hol re3lat re3lo re3mig re3nal re3or re3sar re3uni re4h3ent re4hene re4info
+re4n3an re4nac re4rosi re4sam re4schw re4se2h re4trol re5lei rea2d rea6l5erw reb1r reb3ra reb3so
+rech3ar rege4l3ä reh1l4 rehen1 rei3l2a rei3l2i rei3n4e3c rei3nal rei4bel rei4ble rei4fei rei5nac
+reim2p rein2a rein4du rein4sz rein6teg rein8s7tre reinen5 reises4 reister6 reit3s2 rel2e rel4lar
+rel4lei relea4 relu2 rem2da rem4str rems1c ren2eu ren2zw ren3dr ren4nar ren4z3in ren6nene ren6sein
+ren6serg ren6z5er6f ren6z5er6s renns4 renrü2 rens2p renzer6l renzer6w rer2bi rer2fü rer2gr rer2hö
+rer2ke rer2n rer2st rer2zä rer3sc rer4kan rer4reg rer4rei rer4wac rer4wec rera2 rere2 res2po res4tas
+res4tex res6s5erw reu3g2 reu4eri rewa4r rf2s1ä rf2su rf2ta rf2u rf3fe rf3lic rf4lö rf4ru rf4rü
+rf4sam rff2 rfi4le. rfolg4s rft4r rfzu3 rg2log rg2lu rg3art. rg3op rg3rin rg3rüs rg3s4i rg3se rg3sp
+rg3su rg3sä rg4rau rg4sel rga3su rga4ner rgas4ta rgd2 rge2bl rge4an rge4l3er rgen4z3w rgen6sem
+rgi4sel rgs2ei rgs2pe rgs2po rgs2ti rgs2tu rgs4tr rgö2 rho2i3 rhu2s ri1ce ri1cha ri1el ri1er. ri1eu
+ri2ano ri2ast ri2con ri2dau ri2de2l ri2e1i ri2f1a ri2f1o ri2fei ri2fer ri2fr ri2fä ri2kar ri2kin
+ri2kn ri2kor ri2kä ri2mag ri2mau ri2me. ri2ob ri3at ri3de. ri3els ri3eni ri3ers. ri3n2e ri3s2ko
+ri3san ri4atr ri4ds ri4enä ri4gene ri4kone ri4s3p ri4s3t ri4sch3o ri4schw ria1s ria3ne rib2bl
+richt8spo rie2f3r rie3l2a rie3re rie3sa rie4nu rief1a riein1 rien3s riene2 riere4n rif3s rif4fer
+rif4ter rif6f5end rig1l rim2s rim4sc rim4st rin2c rin2fo rin2ga rin2gr rin2kl rin2ko rin2kr rin2so
+rin4dex rin4sek rin4t5re rin6dize rine1i ring3le ris2a ris4t3r ris6t5ers rismu2 rit2a rit2t1r
+rit2t3a rit3ant rit4to rits2 rix1 rk1all rk1are rk1asp rk1o4ri rk2am rk2lo rk2lu rk2sei rk2sel
+rk2ser rk2so rk2sp rk2t3r rk2ta rk2tel rk2tin rk2tum rk2um rk2ö rk3rin rk3räu rk3shi rk4las rk4lau
+rk4lim rk4n rk4stec rk4stoc rk4t1o2 rk4t3eng rk4t3erf rk4t3erl rk4t3erw rk4t3erz rk4tent rk4terg
+rk4teta rk4tri rk5ersta rk5nu rk6tersc rka2b3l rkauf4s rke2n1 rken3s4t rkstati6 rkt3ers rkto4b rku2n
+rku2sa rl2ab rl2s5to rl2spr rl2ö rl3ste rl3t rlan4d3i rle2a rlg4 rli2s rli4ne. rlou1 rls2a rlz2
+rlös3s rm1ald rm1ami rm1anz rm1ef rm1o2ri rm2ene rm2es rm2är rm3d2 rm3p2 rm3s2k rm3sa rm3t2 rma2la
+rma4s3pe rmat2o rme1st rme4na rmes4z rmeta2 rmi6nanz rmi6neng rminen4 rmo1s rmon3s4 rmu2n rn1ema
+rn1ene rn1ur rn1ö rn2e2t rn2eid rn2eng rn2eu rn2oh rn3ani rn3are rn3aug rn3de rn3dr rn3f rn3g2
+rn3oly rn3s2a rn3s2z rn3s2ä rn3s4p rn3t2a rn3t2e rn4ade rn4and rn4erhi rna2b rna2r rna4n rnd4 rne2n
+rne3uf rne4tem rne4to rnk2 rnn2 rns2u rnz2 rnö2d ro1c ro1ir ro1ny ro1o2f ro1pe ro1sta ro2bo2r ro2bre
+ro2hö ro2liv ro2m1er2 ro2mad ro2mal ro2nan ro2r3al ro2rat ro2rel ro2ro ro2sum ro2t3ho ro2tan ro2tei
+ro2tru ro2tä ro2ßi ro3e4 ro3fl ro3in ro3le ro3n4ab ro3s2i ro3sh ro3te ro3tu ro3unt ro4t3au ro4tas
+ro5s2k rob2l roch2a rog2a roh1l rok2l rol3s rol4lan rol6lerg rolle4 rolls2 rom3s romen3e ron2t1u
+ron4t3r ron4tan ron6tend rons2 ror2ü ror3th rort2s ros2s1c ros4sal ros4san ros4st ros6t1r rot2ta
+rot3s rots2o rp3se rp4lu rper3in rpf4 rpo2st rpro1 rps3t rr1auf rr1c rr1äm rr2ab rr2ei rr2er rr2hen
+rr2hos rr2i rr2o rr2st rr2th rr3obs rr3str rr3stu rrat2s rrb2 rre2le rre2pa rre2ve rrer4s rri3k2
+rrm2 rrn3au rro2re rro3m rrr2 rrz2 rs1ere rs1ers rs1eta rs2al rs2an rs2end rs2ext rs2hor rs2il rs2ka
+rs2kel rs2ki rs2kl rs2p rs2t3h rs2tev rs2ti rs2tu rs3anm rs3ant rs3anz rs3ar rs4ark rs4mog rs4por
+rs4t4erb rs4temp rs4tol rs4tor rs4tr rs4tuc rse2e rse2n rse2t rse4ne rss2 rst3abl rst3ala rst3erl
+rst3erw rst3ing rst3ran rst5eing rster2 rsuch4s rt1abs rt1am rt1ann rt1ant rt1ein rt1erh rt1erk
+rt1ers rt1erz rt1or rt1umb rt1änd rt1ärm rt2ame rt2hum rt2is rt2s1o rt2spa rt2spr rt2u3na rt3akr
+rt3att rt3erei rt3he rt3hol rt3rams rt3rand rt3rati rt3rec rt3rol rt3roma rt3sex rt3t4 rt3z2 rt3äh
+rt4eind rt4ersp rt4s3tan rt4seh rta2ck rta3l2e rte1e2 rte2n1 rte3s2k rte4na rtei1s4 rtels4t rten3s4
+rten3z rteo2 rter4re rter6mit rter8löse rtik2 rto1pf rtrü2c rts2el rts3ing rts4tie rtu4t ru1a ru1ins
+ru1is ru2cku ru2dr ru2fa ru2g3r ru2mi ru2si ru2st ru2t3r ru2tab ru2z1w ru3a2r3 ru3pr ru4ale ru4nis.
+ru4t1el ru4t1o4 ru4tei ru6ckerl rub2i rube4 ruben3 rubens4 rude2a ruf2s ruf4ter ruff4 rufs1p run2e
+run2ga run2kr run4d1a run4d3er runden5e runds2 runei2 rur1e rus2p rus2s1p rus4st rut3h rut6scha
+rute4 rv2el rve3s rve4n1e rvenen4 rwe4gel rwelt4s rwun3s ry2c ry3sth rysti1 rz1eng rz1erk rz1id
+rz1int rz2ans rz2of rz2tan rz2th rz2än rz3te rze2p rze2ra rzir3 rzu4g3l rä1fr rä1ro rä2sc rä2u rä3ra
+räse2 räte1s räu2s räu7schen. räus4c rö2b3l rö2du rö3le röl2l rös1c rü1ch rü2hel rü4ckel rücks4
+rüh1l rüher2 rün3z s1a2gr s1all s1alt s1am3p4 s1an s1au s1av s1e2th s1ein s1erfü s1erh s1erot s1erz
+s1erö s1ex s1i2so s1ill s1in1i s1o2fe s1o2he s1orc s1out s1peri s1t s1ungl s1url s1urt s1äh s1ö2d
+s2ack s2aft s2ahs s2al2a s2al3t4h s2an. s2an2c s2are s2ark s2ars s2cheu s2chä s2cr s2eim s2el. s2ell
+s2els s2enb s2enf s2ensa s2enso s2erfr s2ergr s2ern. s2exi s2exo s2has. s2hip s2i3do s2ide. s2ins.
+s2kis. s2law s2os. s2pace s2pan. s2pera s2perr s2phä s2poi s2pons s2pore s2porn s2pran s2pric s2prit
+s2prän s2pä s2s1aj s2s1isr s2sall s2sanf s2scr s2sill s2simp s2spro s2sumg s2sumr s2t2il s2t3hi
+s2t3uk s2t3uni s2ta s2te2d s2tei s2tel s2tew s2texa s2thu s2ti2r s2tieg s2tiel s2tins s2to s2tr
+s2ume s2umsp s2zes s3abi s3ameri s3area s3arr s3ath s3atta s3e2lit s3erbe. s3erneu s3hu s3hä s3infor
+s3kad s3kh s3kin s3kre s3lab s3li s3lo. s3loc s3loe s3lof s3ly s3lä s3o4ze s3phe s3pi2k s3s2tep
+s3s2ä s3s4tern s3s4tras s3s4trat s3sars s3schw s3sel s3sen. s3skala s3sol s3spi s3spri s3sprä s3stel
+s3sto s3stran s3strec s3strom s3strä s3strö s3stä s3stü s3sy s3ta3l2i s3tat. s3tele s3telf s3tinn
+s3troc s3trog s3u2t s3umfa s3umfe s3umsa s4anse s4chei s4cher s4chif s4chl s4chu s4eins. s4en.
+s4ensi s4er. s4erfe s4parka s4phin s4s3estr s4samt s4sang s4sano s4sans s4sanz s4sce s4sco s4seben
+s4seis s4sop s4stag s4t1a2ve s4t2ars s4t3ends s4talg s4tand s4tanh s4tanm s4tar. s4tarb s4tark
+s4tarm s4tart s4tase s4tasi s4tau. s4taut s4teins s4tentf s4tents s4testn s4tinf s4tipe s4tisl
+s4to4ne s4toff s4torb s4tory s4trea s4trome s4trud s4uns. s4unst s4unwa s5sack s5ten. s5trank
+s6terben sa1f4r sa2be sa2bit sa2bl sa2bor sa2br sa2cho sa2gio sa2git sa2ka sa2l1id sa2l3an sa2lar
+sa2ner sa2pe sa2po sa2rom sa2tom sa2tr sa2ve sa2xi sa2y sa3i2k1 sa3lat sa3rin sa4fe sa4lerk sa4nä
+sa4r1u2 sa4t3ant sa4tol sab2ä sach3t saf4tr sag2e sag4n sai4r sail2 sak2e sal2se sal3bl sal4le.
+sam4ta sam4to samt3st san3sp san4dan san4dri san4sk sand3s sap3p sar2ga sas2a sas2tu sat1ei sat2a
+sat2o sat4z3en sat4zel sau2gr sau2sp saug3le sauri1 sba4ne sbau6men sbe3r2e sc4h sch2e sch3ana
+sch3rom sch3s2k sch4lac sch4web sch5erfü sch6ein. schi4d schi4e schs2e schs4ti scht2a scht2i scht2o
+sd4r sda3me sde1s2 sdi1st sdien4e se1ers se1sta se1u2n se2dik se2e3ig se2el se2gl se2h1in se2h3ö
+se2hag se2hel se2hüb se2l1a se2lef se2ler se2lob se2n1im se2no se2pen se2r3a2d se2re2b se2sel se2tat
+se2ty se2x3en se3ar se3at. se3e2r1i se3en. se3er. se3lad se3nal se3ru se3s4a se3sk se3stec se3stei
+se3tun se4herk se4n3u se4nad se4nas se4nene se4ners se4ness se4nott se4noz se4r3eim se4r3enk se4ruh
+se4rup se5stemp seb2 sech4st see1i4 see1ra see3len see3s4 see3t seen2e seer2e sef4l seg4r seh1a
+seh1s seh3l seh3re seh3t seh5r2i seher4e sei3da sei3le sein4du sein4fo sein6str sein8stit seine3i
+seit2s sel1ec sel3ers sel3sz selz2 sem2a sem2e sen3au sen3d4r sen3tr sen3tä sen3zw sen4s3e4h sen4zer
+sen8s7turm senen1 senst2 sent2a seo2r ser2um ser3al ser3ass ser3g2 ser3k4 ser3äus ser6sehn serb2
+sest3ri set2a seum4sc sex3t4r sfal6l5er sga3su sgang4 sge3s4a sh2a sh2e sh3n sh4r2 shal4li shalt2
+shalt4s shi4r sho4re si1err si1f4 si2ad si2cha si2g1a2 si2g3r si2gei si2k1ab si2k1el si2k1ä si2k3i
+si2k3n si2k3r si2kak si2kar si2ket si2ku si2s1e2 si2s3p si2sa si2sis si2su si2tal si2tau si2tra
+si2va si2vor si3e2n3 si3n4a si4sam si4schu si4v3erf sid2 sie2bu sie4hes sieh1e sig4n sig4st sik3erl
+sik3s sik3t4 siken2 sikin1 siko3 sil2br sil2e sim4st sin2g3r sin3g4le sing1a sing3sa sing3so sings2
+sini1e sinner4 sion4 sirn4 sis3s4 sit2u siv1o4 sive3 siz2 sk4a sk4l ska4te. ska4tes ska4to ske2li
+ski1s sko2pr skto2 sku2s1 sl3b sl4a sla2ve sler3s slo3be sma3b4 sma3sc sme3na smi2t snab4 sni3er.
+sni3ers sni4a so1c so1rh so2l1ei so2rei so2rel so2ro so3et so3l2o so3la so3li so3o so3unds so3unt
+so4lau so4ru sog4l sohle2 sol2la sol4ler som2e son2a son3sä son4s1o sone4 sop3s sore2 soth1o soun2
+sound1 sp5le. spa2m spe3p4 spier4r spor6tag spu4rer srat2s sre3cha sre4th sreli1 srö2s srücker6
+ss1ec ss1epe ss1off ss1ums ss2ann ss2ant ss2ara ss2erf ss2orc ss2phi ss2pot ss2ti ss2tur ss2ur
+ss3alba ss3att ss3erfü ss3erse ss3i2ko ss3l ss3s4 ss3tak ss4agi ss4eind ss4ergr ss4eru ss4teu ss4tör
+ssa1s ssa3bl ssa3bo ssau3e ssau4r ssch2 sse1e sse2lö sse3int sse3ta sse6ratt sseh2a ssen6sem sser4hö
+sser4öf sser6mit sser6wei sses4sa ssing3s ssoi4 ssquet4 sssau4 sst2a sst2e st1a2mi st1alp st1alr
+st1app st1e2po st1edi st1eid st1eun st1ev st1inb st1ira st1iri st1ita st2ac st2ell st2ens4 st2u
+st3ansp st3erfü st3ho st3uga st3una st4hen st4rade st4ross sta3lak sta6rens stapo1 stast4 ste2g3r
+ste2r3a ste3sc ste4mar ste4na ste4tag ste6ment stei4gr stein6sp stel6l5än stes4se stes5tr sto2bl
+sto3mi sto3s2t stra4fa strie3s4 sts4t stu3ra stu5re stum4sc stwor2 su1an su1is su1it. su2cha su2cho
+su2eb su2k su2m1a su2mei su2mel su2min su2mor su2n su2r1o su2ra su2rer su2s1 su3fi su3l2i su3s2a
+su3tr su4ba2 su4br su4ne su4te su6ments suchs3p sul3t sum1o2 sument4 sun6d5erh sunder4 sunds4 sung4s
+sup3p4 sure4 svoran4 swe6gers sweh2 sy2n3 sy4nä sym3 sö2c sü2d1 süden4 t1a2dr t1a2mer t1abst t1ada
+t1adm t1afg t1afr t1alta t1ampl t1amt t1anm t1ano t1ans t1anza t1arti t1auk t1ausk t1e1xa t1e2r1ö
+t1ehr t1eis. t1eisb t1eiw t1entb t1h t1id t1in1it t1inh t1ins t1int t1obs t1or3g t1ord t1orth t1räts
+t1st4 t1u2no t1ämt t1ängs t1öst t2aktu t2anho t2ans. t2anz. t2appe t2ar2ta t2arau t2ard t2arei
+t2arko t2arl t2armä t2ause t2ch1u t2ech t2ein. t2eine t2emb t2erho t2erhu t2ern. t2erse t2h2e t2hali
+t2han. t2hy t2id. t2inn t2insä t2lef t2oba t2ordi t2orw t2s1a2d t2s1a2s3 t2s1e2b t2s1e2v t2s1ent
+t2s1ep t2s1er t2s1eti t2s1i2d t2s1op t2s1ori t2s1u t2sall t2san t2sau t2sef t2seth t2sex t2si2k
+t2sini t2spac t2spal t2spat t2sph t2spo t2spro t2spä t2staf t2stea t2säh t2sö t2t1eis t2t3ho t2tanm
+t2temu t2teo t2trou t2z1e2d t2z1eie t2z1i2d t2z1ä t2z3om t2za2 t2zuni t2äf t2ät t3agent t3atl t3aufo
+t3cha t3che t3chr t3cl t3cr t3einkü t3ereig t3est3ri t3ge t3hand t3hap t3herd t3herr t3hiel t3hin
+t3hoc t3hof t3hov t3immat t3insa t3ochs t3radie t3rann t3reak t3rech t3reiz t3rent t3repr t3rind
+t3rumä t3rund t3russ t3s2ouv t3s2pek t3s2pi t3s2pon t3s2por t3s4tern t3s4tero t3sac t3sche t3schl
+t3schü t3seil t3seme t3sexi t3skala t3sol t3som t3sped t3spei t3stein t3stif t3stim t3sy t3tel t3ti
+t3tü t3u2fer t3unga t3uns t3vo t3ze2s3 t3zer3z t3zwie t3zäh t4ani t4ante. t4ape t4at. t4ate t4ck
+t4ebb t4era t4erfr t4ergru t4erhan t4erhau t4erhei t4erhäu t4erli t4erlä t4erp t4erra t4erro t4ers.
+t4erst. t4ersti t4erstr t4erstu t4erstä t4erstü t4eru2 t4erv t4erzei t4he1in t4hek t4heme t4hene
+t4heni t4herm t4hol. t4holo t4or. t4ore t4ors t4raue t4rauf t4reck t4rei. t4reik t4rem t4ren. t4rer
+t4res. t4rete t4rib t4rick t4rid2 t4rik t4rip t4roi t4rop t4rüg t4s1amt t4s3ko t4s3pic t4s3tanz
+t4s3täti t4s5th t4sachs t4samp t4sch3am t4schef t4schro t4spins t4stabe t4stag t4stale t4stas
+t4stat. t4stit t4stoch t4stoi t4stren t4strie t4sty t4sw t4t1ah t4t1ap t4t3igi t4tana t4tentb
+t4tentf t4tents t4tid t4tinf t4tins t4torg t4wist t4z1eis t4z3entg t4z3ents t4zentl t5a2tel t5lö
+t5na t5orient t5stub t5wa2 t6en. t6endo t6ergem t6erges t6ergew t6erhall t6erstad t6s5essen t6schart
+ta1i2s ta1ins ta1ir. ta1r2h ta2b3an ta2der ta2dol ta2er ta2g1e2i ta2ga ta2kro ta2l1o2r ta2la ta2let
+ta2lop ta2lu ta2pes ta2pl ta2r1er ta2ra ta2rel ta2rom ta2ru ta2t1er ta2ta2b ta2tan ta2tem ta2tom
+ta2tr ta2van ta3d2s ta3i2k ta3kes ta3lag ta3lat ta3or ta3rak ta3sa ta4l3end ta4lens ta4lerg ta4nat
+ta4nerf ta4poka ta5se tad6t3 tadi3 tag4san tag4st tagen1 tags3c tah2 tahls4t tai2l1 tai4r tak4t1o2
+tal1an tal2ga tal2l1ö2 tal2se tal3au tal3d4 tal3eng tal3th tal4l3ac tal6ents tali6ene tall3ei
+tall3s2 tals3en talt4r tam4m3er tamm1a tan2dr tan2kl tan2z1w tan3ab tan3da tan4gra tan4tan tand4ar
+tand4st tao2 tar2tr tar3ap tar3g tar4to tar6ter6e tark4l tas4tem tas4to tat1ei tau2b1a tau2bl tau2br
+tau3f4li tau6scha tau6schm tau6schr tau6schw tauch5sp tauchs4 taufs4 tbauer4 tbe3r2e tblock5e
+tblocken8 tby4t tch1w tch2i tch3l tcor2 tdar2m1 tdun2 te1em te1erw te1ral te2a2 te2chu te2cki te2dit
+te2er. te2hac te2him te2is te2kel te2l1ö te2ler te2leu te2lit te2lob te2lä te2m1ei te2m1er te2m1o2r
+te2map te2mau te2mi te2mu te2n1e2b te2nef te2neh te2ni te2nol te2ny te2r3ap te2rad te2re2b te2rec
+te2ret te2spr te2su te2tat te2va te2vi te3ad te3ag te3al te3an te3ar te3lan te3n4ei. te3nö te3ria
+te3xel te4lant te4lost te4mun te4n3an te4n3end te4n3ern te4n3in te4nad te4nas te4nat te4nene te4neng
+te4nens te4ness te4nil te4r1uf te4r3emi te4r3end te4r3ent te4r3erp te4rene te4reng te4rers te4rerw
+te4tabl te5isch. tea3c tea4s tecks4 tee3t teen1 tei1fl tei2la tei3z tei6lent tei6nens teik2 teim2
+tein6hab teinen4 tekt4 tel1ac tel1au tel1ec tel1in tel3ab tel3ehr tel3eng tel3le tel3li tel3s2k
+tel3ta tel6lant tel6lein tele3s tem3i2m tem3ing tem3s ten1a2 ten1im ten3au ten3d4r ten3da ten3ei
+ten3n2 ten3se ten3te ten3ä ten4gag ten6serg ten6tric tend4an tene4m tenen1 tenf4 teng2a tenk4 tens2p
+tens3th teo2f tept2 ter3a2c ter3a2s ter3d ter3ga ter3gl ter3iko ter3k ter3ta ter3z2a ter3zw ter4ane
+ter4nar ter4obe ter4re. ter5zo ter6stat tera2b tera2m terd2s terer3k terer3l terg2 terin5d terst4
+tert4 tes2c tes3a2c tes3si tes3tan tes4pen tes4tel tes6terg tes6terh tes6terk tesa2k tet2 tewa2s
+tex4ta tg4r tga4s3er tgenen3 tger2a tger2i thero3 thi3er. thi3nu thic3k4 thmu2 tho1s tho3chr ti1ce
+ti1el ti1eu ti1f4r ti1rh ti1s4tr ti1th ti2ad ti2are ti2e1i ti2el. ti2ern ti2gan ti2git ti2kam ti2kar
+ti2kin ti2kn ti2kra ti2krä ti2lar ti2lei ti2lel ti2lu ti2lö ti2ma2g ti2n3an ti2nam ti2nor ti2osk
+ti2sei ti2sp ti2su ti2tal ti2v1o ti2van ti2vel ti2za ti2zir ti3a2m ti3ag ti3e4n1 ti3fe ti3k2en ti3lo
+ti3naf ti3nak ti3nu ti3p4l ti3s2th ti3sk ti3ti ti4d3en4d ti4gerz ti4k3rei ti4kau ti4klu ti4lant
+ti4que. ti4ron ti4v3r ti4vene ti4verh ti4verk ti4verl ti5n2e ti6schei tial2l tie2fr tie3br tie3s2t
+tie4rei tie4reu tieg4 tiel3a tien3s tih2 tik1r tik4ere tilt4 tim2s tim4man tim6merg timm1a timmer4
+tin2g1a tin2k1l tin2kn tin2kr tin2um tin4g3l tin4spa tin4sum tine1i ting3s tioxi3 tis2el tis3ti
+tisch3l tisch3w tiss4 tit2a tium4s tiver2 tl4e tlan2g tle2ra tlei6der tlings5 tlit1 tlung4 tma2st
+tmen6t5 tmen8schl tmo4des tnes2 tnes4s to1c to1ßu to2m1u to2min to2n2eh to2nan to2pak to2pan to2pat
+to2rel to2rem to2rop to2rö to2tho to2tä to3be to3le to3ny to3ren to3s2h to3un to4as to4d1un to4mun
+to4n3ig to4pfe to4rein to4ru to4rän to5at to6ck5ent tob2l tod1er2 tof4f3er tof4fa tof6f5ent toff3s
+toi4r tomar4b tond2 toner6ke top1hi tor3int tor3ta tor4fan tos2e tos2p tots2 touil2 tpf4 tpi2n
+tra2st tra3cha tra3chl tra4dem tra4fah tra4far tra4leb tral3l tre2br tre2ta tre4tri tre5cke
+tre7isch. tret3r tri2er tri2x tri3gl tri3ni tri4ena tri4ers tri4ke. tri4kes trie3fr trizi1 tro2mi
+tro3b4 tro3na tro3sm tro4kes tro4men tro6mans trol4la tru2th trum2 trums1 try1 trü1be trü1bu
+trücker6 ts1ahn ts1eh ts1eng ts1eta ts1ir ts1off ts1par ts1pas ts1än ts1äus ts2ame ts2av ts2cor
+ts2ens ts2pul ts2tu ts3a2r ts3ab ts3ane ts3iko ts3ort. ts3pate ts3tak ts3un ts4kele ts4pare ts4put
+ts4terb ts4tol ts4tric ts4tüm ts5alben ts5s4 tsch4li tse2e tse2t tse4he. tsing4 tso2r tswa2s tt1ab
+tt1ad tt1art tt1ebe tt1eif tt1ein tt1u2f tt1äh tt2ac tt2ag tt2al tt2ant tt2erö tt2es1 tt2häu tt2int
+tt2sal tt2sen tt2spe tt2spr tt3achs tt3hi tt3rü tt3s2z tt3z2 tt4ere tt4lef tt4s3tät tta2ke tta4n
+tta6g5ess tte2ro tte2so tte4l3e4b tte4la tte4len tte4lin tte4na tte4rik tte4s3ä2 tte4sa ttel1o tto1s
+tts1p tu1alm tu1alv tu1cho tu1ist tu2chi tu2ere tu2gan tu2kr tu2r1ag tu2r1e2b tu2r1er tu2r3e2v
+tu2r3o tu2ran tu2ras tu2re4t tu2rei tu2rid tu2rä tu2sa tu2se tu2so tu3an tu3fen tu3ta tu4ale tu4rene
+tu4res tu4ru tu4schl tuba3b tudie4n3 tuf2e tuh4ler tul2i tum2b5l tum2si tum2so tum4s5tr tun2en
+tund2e tung4s tur1c tur3eis tur3f4 tur4mun turan4l turg2 turin1 turo2p tvoran4 twi4e ty2pa tys2
+tz1ag tz1au tz1ec tz1ehr tz1erw tz1eti tz1int tz1wi tz1wu tz1wä tz2ene tz2ere tz2th tz2tin tz3ar
+tze2t tze4n1 tzen5s4t tzer6gre tzig4s tzu2gu tzwan4d3 tä1c tä2ru tä2s tä4reng tü3ber. tück2s tür1c
+u1a2c u1a2l1a u1a2l1ä u1al. u1al5t4 u1alb u1ald u1alf u1alg u1alh u1aln u1alp u1alr u1als u1alw
+u1alz u1am u1ans u1ars u1ay u1cha. u1che u1chi u1chu u1ci u1cl u1h u1ie u1im u1j u1k2e u1ki u1kr
+u1ku u1l u1o2b u1o2x u1or3c u1ort u1orw u1os. u1pa u1pe2 u1pr u1q u1ra u1stal u1stel u1stu u1w u1ya
+u1äm u1äu u1ök u1ü2 u2b1ehe u2b3oz u2b3rit u2bob u2bop u2ch3r u2ched u2ck3i u2ckem u2don u2ed u2eg
+u2eh u2ep u2ev u2f1ei u2f1em u2f1erh u2f1et u2f1id u2f1ins u2f1ä2ß u2f1än u2f1äs u2f3a2r u2fent
+u2ferf u2fim u2fob u2fum u2g1a2d u2g1ak u2g1ans u2g1ap u2g1erf u2g1erl u2g1esk u2g1i2d u2g3rä u2gani
+u2ganz u2geig u2gein u2gerr u2gerv u2gim u2gl u2greg u2gres u2grou u2gum u2gö u2gü u2hu u2l1el
+u2l1or u2lop u2lü u2m1a2k u2m1ad u2m1ap u2m1aus u2m1ef u2m1ein u2m1erf u2m1erl u2m1u2r u2m1äh u2m3ot
+u2m3um u2mab u2marc u2marm u2mart u2maut u2n3an u2nap u2narb u2nob u2ny u2p3ras u2pf1i u2pfe u2r1a2m
+u2r1akt u2r1au u2r1ep u2r3ar u2r3att u2rab u2ral4t u2rele u2rerw u2rind u2s1a2s3 u2s1ec u2s1ei
+u2s1erl u2s1ese u2s1pas u2sang u2serp u2sex u2sid u2sop u2spac u2sph u2spo u2spu u2stun u2stur
+u2sumd u2sumg u2sumz u2säh u2sü u2t1alt u2t1ap u2t1ar u2t1ex u2t2ev u2t3hi u2t3ho u2taut u2tent
+u2thu u2thy u2tid u2tops u2trou u2tum u2tär u2töl u2ve. u2z1ec u2ß1u u3a2b u3a2leb u3a2let u3a2lid
+u3a4lent u3aler2 u3ar. u3au u3bit u3ches u3d2a u3dru u3e2ni u3e4r3ent u3en. u3end u3eremp u3ererf
+u3erex u3erin4t u3erl. u3ern u3errü u3eruh u3erum u3erunf u3erunt u3erwi u3fac u3fah u3fal u3fam
+u3fas u3fen. u3ge. u3gon u3gos u3ig u3in. u3isch. u3ischs u3käu u3mat u3me. u3merk u3n2am u3n2it
+u3ne u3nic u3nuc u3o2ret u3of u3or. u3ors u3p4i u3raba u3rand u3rasc u3ru u3s2e3b u3s2pec u3s2pek
+u3s4piz u3s4tras u3sche. u3schi u3schu u3se. u3seid u3sep u3si. u3sol u3spit u3t2et u3taf u3teh
+u3tek u3tem u3tom u3tü u4b3eins u4b3erde u4ckent u4ela u4ferla u4ferle u4ferne u4g1lä u4g3lo u4g3n
+u4g3reis u4gabte u4glic u4glis u4glu u4ige u4matl u4matm u4n3erz. u4nerk u4rense u4rentn u4s1amb
+u4s3af u4samt u4sch3eu u4schab u4schak u4schef u4sense u4sentl u4t1a2m u4t1une u4tentf u4tord u4tz
+u6gleitb u6gleitu u8be8cken. ua2g ua2lo ua2lu ua2th ua3sa ua4lerg uad4r ual3erk uale2 uan2a uan3ta
+uant2 uar4t3an uara2b uasi1 uat2i uat2o ub1eul ub2er ub2l ub2s1o ub2san ub2sp ub3lic ub3lu ub3läu
+ub3ric ub3t4h ub4es ub4lut ub4rü ub4sche ubb2l ube2be ube2e ube4n1a uben3o ubert4 ubsau2 ubst2 uc1c
+uch1a uch1ec uch1ei uch1in uch1op uch1w uch1ä uch2so uch2sp uch3im uch3l uch3m uch3n uch3ü uch4sel
+uch6t5erf uch6t5ert ucherin8t uchst2 ucht3re uck2er uck3elf uck4sti ucker8geb ud2e ud2ob ud3ra
+ude2n1 ude3i4 udein7 udel3se uden3e uden3s2 udert4 udes2 udi3en uditi4 ue2ck ue2en4 ue2k ue2le ue2mi
+ue2ner ue2no ue2r1ä ue2r3a2 ue2r3o4 ue2rec ue2ret ue2ta ue2ti ue2x1 ue3r4erb ue4n3a2 ue4r3emi
+ue4rein ue4rer4g ue4tek ue6rersc ue6rerst uel4lau ueli4 uen1 uen2gl uen2zu uen2zw uen4gag uen6zene
+uene2 uenge2 uenk4 uer2ö uer3d2 uer3esk uer3g2 uer3sc uer3t4 uer3z2 uer4ei. uer4nan uer4ne uer6baut
+uera4t uerb2 uere2 uerer4h uerer4l uerer4m uerer6sp uern3s4t uerst6 uf1ab uf1ak uf1au uf1aß uf1ori
+uf2fro uf2spo uf3ane uf3fe uf3l uf3r uf4s3tic uf4stab uf4tin uf5sä ufa2ck ufa2n ufa2t ufall4
+ufel4s3a uff4l ufo2r uft1eb uft3er4g uft3erd uft3s2 ufta2b ufter4l ug1ar ug1au ug1ei ug1in ug1or
+ug2abe ug2et ug2gl ug2uns ug3d4 ug3g4t ug3hu ug3liz ug3oc ug3om ug3rie ug3ro ug3rüs ug3s4tr ug3s4tü
+ug3sei ug3span ug3stu ug3stä ug4spr ug4spu ug4stur ug4unge uge4lob ugen3s2 ugg2 ugge4st ugo3 ugo4b
+ugo4p ugs4por ugs4tan ugs4to ugu3te uh1la uh1lä uh1w uh2a uh2lar uh2li uh2r3er3 uh2r3o uh2ru
+uh4l3ent uh4rin uh4rü uhe1s uhe3a2 uhl3erb uhr1a uhrei4s uhs4 ui1ch ui1em ui2che ui4cker ui4s5t
+uil4les uis2e uisi4n uit3s uk1äh uk2a uk2t1el uk2t1er uk2ta uk2tin uk2tum uk2ö uk2ü uk4n uk4t3o4ri
+uk4t3r uk4tent uke2n1 ukle1i uko2m1 ukts2 uku2s ukä2 ul1am ul1emb ul1er2h ul1eta ul1id ul1ins ul1äm
+ul2ar ul2dei ul2dr ul2kn ul2lo ul2pha ul2s1ec ul2sa ul2sei ul2ser ul2sum ul2vr ul3ka ul3l2i ul3le
+ul4dan ul4lerk ul4sam ul4tri ula2s ulan2e ulb4l uld2se ule2t ule4n uli2k ull1au ull3s2 ulm2e ulni2
+ulo2i ulp1h uls2th ult3ar ult3s ulz2w um1a2r um1all um1ang um1anz um1erg um1erw um1ide um1ind um1inh
+um1ir um2mei um2pho um2s1pe um2sim um2sum um3t4 um4s1er um4s3an um4sam um5engel umen1e umer2a umes2t
+ump2fa ump4fin umpf4li un1 un2a3br un2ag un2al un2d1um un2da un2dex un2dim un2dop un2dor un2emi
+un2es4 un2gam un2gat un2glu un2go un2gr un2im un2ir un2k1a2 un2k1es un2ket un2kne un2kro un2n1ad
+un2os un2är un3at un3de. un3eid un3ein un3eis un3fa un3iro un3isl un3ker un3se un3sk un3sp un3ta
+un3te un3tr un3z2a un4dap und3ak und3erf und3erz und3erö und3sp und3st unda2b under8tend underer6
+underten8 undo2b undü4 une2b une2d une4n1 unen2t unf2 unft4s ung3ri ung4sa ung5h unge3r4e ungs3
+ungs5tr uni3k4 unk2tr unk3s2 unk4tit unk4tri unko2p unlö2 unn2e2 unna2 unne4n uno4r uns2 uns4t1r
+unsch5el unsta4g unste4c unt3s unte4ri unvol2 unvoll3 unz2e uore4 uos2 uote2 up2fa up2fu up2pl up4lu
+up4t1o up4t3a2 up4tene up4tid up4tim up4tr upe4re uper1 uperer4 upra3 upt3erf upt3erg upt3erk
+upt3ers upten1 ur1ang ur1anz ur1eff ur1eig ur1er3h ur1eta ur1ide ur1off ur1ä2m ur1äl ur1än ur2anh
+ur2ert ur2eth ur2f3l ur2fro ur2gla ur2gri ur2mau ur2mum ur2mun ur2s1er ur2san ur2spa ur2tai ur2tro
+ur2z1a2 ur2z1ec ur2z1op ur2z1w ur2zep ur2zi ur2zä ur3ap ur3asp ur3b2a ur3da ur3di ur3ku ur3l ur3n2e
+ur3p4 ur3re ur3sze ur3ti ur4ate ur4mant ur4matt ur4mern ur4s1of ur6gense ura2be ura3to ura4na ura4ri
+ura4str uran3a4t uran4ge uran5s urch1 urcht3e urd2 ure2n ure3u ure4na uren6gag urer3k urf3t urf4spr
+urg1l urg3inn urg3s4 urgros4 uri2c uri3en urin8stin urm2ei urmet1 uro1s4 urost2 ursau4 urst4r urt2
+urt3ein urt3sc uruf4 urzt4 urü2 us1erw us1inn us1is. us1oh us1ou us1pe us1pic us2ann us2por us2s1eb
+us2sep us2sez us2sof us2sum us2th us3a2b us3ark us3kl us3oc us3part us3t2in us3tau us3ther us3tr
+us4sesp us4tein us5ser. us6tris usa2gi usch3mü usch5eic usch5wer use3ran use4rec usi3er. usi5ers.
+usrich7 uss2el uss3erf usse4g usse4n usser4z ust3erl ut1e2d ut1ei. ut1eie ut1ein ut1ela ut1äh ut2ans
+ut2es ut2säu ut2z1in ut2z1w ut2zeh ut2zet ut3c ut3hel ut3rea ut3rü ut3s2k ut3sau2 ut3ser ut3te
+ut4schl ut4schm ut4scho ut4schö ut5t2l uta2s ute2n1 ute4ral ute4ros ute5r4er ute6ring uten2a uter3a
+utfi2 uti2vi utli4n uto3 uto4ber utor2a utos4 utt4le utts2 utu2b utu3ro utu4n utu4re utu5ru utz2er
+utz3eng uufe2 uum1 uuma4 uve3rä ux2e ux2o ux3t2 uz1we uz2er uz3ot uz3z2 uzo2f uä2s uö2d v1arm v1ele
+v1ra v1ro v1sta v1steu v2eil v2il v2lie v2ree v3le3 v3s2z v4at va1c va1f4 va1s2 va2la va2t1a2 va2t1u
+va2t3h va2tei va4t1in va4t3eng va4t3r va4tess va4tid va4tim va4tord vab4r vag2a vat3s4 vati8ons.
+vatik2 ve3an ve3ar ve3b4 ve3d ve3fa ve3g ve3h2 ve3la ve3lei ve3li ve3lo ve3ma ve3me ve3nal ve3ne
+ve3ni ve3nö ve3of ve3rad ve3rand ve3ri ve3sa ve3t ve3v ve3w ve3x ve4l1au ve4nas ve4nin ve4rek ve4rin
+veau1s veit2 veits3 ven2c ven6t3ag ver1 ver3a ver3b2l ver3d2 ver3fa ver3g4 ver3k ver3u4 ver4ane
+ver4sep ver5te ver6bart vera4s vere2 verf4 vern2 vert4 ves1 ves3ti vete1 vete3r vi2ad vi2c vi2el
+vi2er vi2l1a vi2l3in vi2lei vi2lä vi2sp vi2ä vi3ar vi3de vi3s2i vi3s2o vi3sa vi3z vi4a3t vi4l1e2h
+vi4lers vi4na vid3s2t vie2h1a vie2w1 vie4rec viela2 viele2 vig2 vima2 ving5 vis2u vise4 viv2 vize1
+vm2e vo2be vo2gu vo2r1 vo3ga vo3ri vo4rie vo5rig vob4l voge2l1 vol2a vol2l1a vol2li vol6lend
+vol6lert vollen6 vor3a vor3g vor3o voran8schl vore2 vorm2 vormen4 vort4 vot2a voy1 vr2 vs2c vs2e
+vs2p vu2enu vu2et vue3 vö2c w1u2f w2a w2i w2r w2ä w3anf w3ho w3ro w3s2h w3s2k wa2g3n wa2lar wa2les
+wa2p wa3che wa3go wa3li wa3na wa3ren wa3sa wa3sche wa3sh wa3su wa4sch3l wa4scha wa4schw wa5ge wab2bl
+wach4t4r wach8stub waffe2 waffel3 wah2l1i wah4ler wahl5ent wal2d3a wal2m1 wal2t1a wal4din wal4to
+wal4tur wal6tere wal6terl wala3c wals2 wan2d1a2 wan2dr wan3g2e wan4zer wan6z5en6d wandels6 wang4s
+war2th ware1i wart4e was2c was3s wass4e2 wbu2 we2a we2b1a we2b3l we2b3r we2bo we2e2 we2fl we2g1a
+we2g1o2 we2g3r we2r3a2 we2rö we2s1p we3cke. we3ckes we3n2i we4g1ei we4g3l we4gn we4r3io we4st
+we5cken. webe1i webs2c weed3 weg3s weg5ersc wegs2a wegs4t weh4r3er wei2bl wei2gr wei2t3r wei3dr
+wei3k4 wei3nel wei3sc wei5ze weib4r weifel6d weigs4 weins3a weinsau6 weis6sel weis6spi wel2t1
+wel4t3a2 wel4t3r wel4th wel4to wel5le4 wel6schl wel6schr wel6t5en6d welt3i welte2 wen2gl wen2ka
+wen3a2 wen4k3ri wen4kla wendes4 wer2bl wer2fl wer2g3o wer2gr wer2k1a wer2k3l wer2ki wer2kn wer2ko
+wer2ku wer2s wer2t1a wer2th wer2tä wer3t3ei wer4kre wer4t1o2 wer4t3ri wer4tre wer4tum wer5be
+wer6gels wer6t5erm wer6teig werb2s werbe3i werd2 werde3i werer2 werin2 werter6k wes2tu wes4t1a
+wes4t1o4 wes4tex wes4ti wes6ten6d west3ei west3r weste2 wett3s whi4 wi1cka wi2e wi2sp wi3th wi5s2e
+wicht4s wie3l wie3n2e wim2ma wim4m3u win2a win2g3r win2kl win2no win3s win4d3e4c win4dei win6d5erz
+win8n7er8sc wint2 wire3 wisch3l wiss4z wiz2 wn3sh wns2a wo2cha wo2r3i wo4r3u woch2e4 woh4lei wol2la
+wol4ler wolf2s3 wor3a wor3d wor3ü wor4t3r wor4tel wor6terh worn2 wort1a wort3s2 wot2 ws2e ws2t wti2
+wuch4sc wuch4st wul2 wul3se wun2s wund4e wung3r wungs4 wunsch5l wur2f1o wur2fa wur2fr wur2s wus2
+wus3te wäs2c wäss4e wöl2fo wört4h wül2 wün3 x1a x1ce x1ch x1cl x1ele x1em x1i2do x1i4tu x1or x1q
+x2an3t2 x2anz x2ems x2en x2er. x2ere x2is1 x2t1e2d x2t1el x2t1er2f x2t1ev x2t1um x2t1un x2t1ä x2t3h
+x2t3ran x2tid x2til2l x2trau x3le x3lä x3tan x3tas x3tät x4tent x4tor xa1fl xa2m xal2l xand4 xda4
+xe2l xen3s2 xers2 xi1c xi2d1em xi2dan xi2dei xi2l1u xi2ler xi2lo xi2sa xi2se xi2so2 xi2sp xi2su xi3g
+xi4ds xich2 xide2 xie3l xim2 xin3s2 xis2c xis3s2 xis3t xis4tä xive4 xkal2 xpor4t3r xpor6ter xt1a
+xt1ein xt1o2 xt2ant xt3rec xt3s2 xtblo4 xtfi2 xti2la xtra3b4 xu1a xu2s3 xuss4 y1a2m y1al. y1ank y1b
+y1c y1d4 y1e y1f2 y1g y1h y1j y1k2 y1l y1o1s2 y1ont y1ou y1q y1r y1t2 y1u2r y1v y1w y1y y1z2 y1ät
+y2a3ra y2ach y2ag y2ana y2chi y2ec y2ef y2el y2ere y2es y2l1a2m y2l1es y2l1et y2le. y2n1o y2ost
+y2p1i2d y2p1in y2p1um y2p3l y2pf y2s1ur y2te. y2tes y3chis y3dr y3est y3i4 y3lant y3lat y3r2e y3ri
+y3ro y3s2h y3s2ty y3s2z y3to1 y4lantr y4p3s y4s3l y4stro y4t3r ya1h ya2s3 ya3z yan2g yat2 ych3n
+ydri4 ydrid1 ye2d ye2th yen4n yer2n1 yes2p yg2l ygi2 ygie5 yhr2 yk3s2 yk4l yke3n yl1a2c yl1em yl1ora
+yl3ane yl3c yl3s2 yl4ante yl4anti yla2n ylau2 yle2 yli4n yloi4 yloid1 yloni1 ym2pha ym4a ym4e ymp4
+ympi1 yn2eu yn3k2 yno4d yno4t yob2 yoga3 yom4 yon2a yon4i yp1ab3 yp1an yp2e2 yp3t ypa2 ypo3 ypu2
+yra3k yri1e yri2a yri3en yros3t yrr2 ys1pr ys2an ys2c ys2e1 ys2the ys3to ys3tr ys4po ys4tra ysein2
+ysme3 yst2e yst4h ysu2 yt3t yt4h ythe1 ytos2 yze3r2 z1a2n z1aq z1ar z1au2f z1e2ga z1erwe z1inh z1of
+z1uni z1wac z1war z1wur z1wör z1än z1äp z1är z1öl z2an. z2e1ind z2ei1f4 z2eino z2entn z2erfe z2erhe
+z2erko z2ers. z2hen z2il z2t1au z2weig z2wic z2wisc z2wit z2z1id z2äh z3at z3aur z3ly z3oas z3sa
+z3sh z3sk z3sz z3t4hem z3t4her z3thr z3thy z3tic z3tü z3z2a z4engl z4erges z4ergl z4t1ent z4t1erz
+z4tehe z4z3al za1c za2sc za2to za3gr za3ne za4pf zab3l zah3le zah4ner4 zan2ka zans4 zanti1 zar2tr
+zast4 zat2e zbe3r2e zbü1b zbübe3 zdan2 zdi1st zdä1 ze1c ze1e2 ze1ral ze1sta ze1ur ze2i1s4 ze2l1a
+ze2l1er ze2l1in ze2l1o ze2l1ä ze2len ze2n3o ze2rad ze2re2b ze2sp ze2sä ze2tr ze3ho ze3in. ze3inse
+ze3n2em ze3sch ze3stau ze4n1ac ze4nas ze4not ze4spo ze4spr zeeu3 zeh2l zehe4 zehen1 zei2t1a zei2tr
+zei3la zei3sk zei4ne zei4t3er zei4t3ri zei4to zeil2 zeile4 zeist4 zel3d zel3la zel3sa zel3sz
+zel4l3ac zel6lein zel6ler6t zela2d zell3s2 zelm4 zels2 zelu2 zembe2 zen2zw zen3au zen3n zen4tha
+zen4z3er zenen1 zens2p zent3s zeo4r zer2ze zer2öf zer4gon zer4lau zer4le. zer4len zer4n3e4b zer4nan
+zer4nei zer4t3ag zer4tin zer4to zer6teng zer6tere zer6terl zer6trau zerin6te zers2 zert4an zerta2
+zes1e zes2sa zes2sp zes2st zes4seb zes4sei zes4ser4 zes5tr zes6s5end zes6sent zes6tra zessen4
+zeu2g3r zfeue2 zger2a zger4s3 zhir3 zi1erh zi1es zi1th zi2dei zi2o3 zi2tan zi3ar zi3ess zi3s2z
+zi4n3in zi4t1o4 zich2o zie2l1i zie4ler zien3s zil2e zill2 zim4t3 zin1it zin2e zin2na zin2sa zin3ei
+zin4o zin4ser zirk2 zirk6s zisse4 zite4 zithe2 ziv2 zle1s zlei3ti zme2e zo2gl zo2o zo3re zog4s3
+zol2la zol6lert zoller4 zon3s4 zon4t3er zor4ne zsau2 zspor2 zt2el zt3ane zt3eins zt3he zt3hi zt3ho
+zt3rec zt3s2 zta2n zte3str ztein1 zu1 zu2el zu2gar zu2go zu3a zu3e2r1 zu3f4 zu3g1l zu3hu zu3k zu3pl
+zu3r4a zu3s4 zu3t2 zu4gent zu4gla zu4glö zub4 zuch2e zud4 zudi4 zug1un zui2 zul2 zum2a zum2i zum2u
+zumen2 zun2e zunf4 zung4 zuo2 zup2fi zusch4 zut3z zut4r zut4u zuz2 zw2 zweiter6 zwi4e zy1an. zy2le
+zz1ini zz2ö zz4at zza3b4 zzi1s4 zzin1 zzug4s zä2 zä3hi zür1c ß1erw ß1q ß1unf ß2ers. ß3tü ße2la ße2le
+ße2ni ße2no ße4n3a2 ßens4t ßer2ei ßer3b ßer3t ßge2bl ßi2g1a2 ßig4s ßler3 ßos2 ßrö2 ßsau4 ßsch2 ßt1in
+á1n â1t ä1a ä1b ä1ce ä1che ä1chi ä1chu ä1ck ä1d ä1g ä1hi ä1hu ä1im ä1is. ä1isk ä1j ä1k ä1la ä1lu
+ä1on ä1pa ä1rö ä1v ä1z ä1ß ä2b3l ä2chr ä2d1ia ä2da ä2dr ä2g1a ä2g3l ä2g3r ä2k3r ä2kle ä2r1e2l ä2r1ei
+ä2r3a4 ä2rene ä2rind ä2rü ä2s1p ä2t3a2 ä2t3r ä3isch. ä3me ä3s2kr ä3su ä3usg ä3usk ä3usn ä4h1ei ä4s3t
+äb2s äch2s1o äch2sp äch2st äch3l äch4s3a äche1e äche4n ächt4e äd2s äde1s2 äe2x äf2fl äf2s äf3l äf3r
+äf3t2e äf4ro äfe4n äfig3 äfigs4 äft4s3 äg2n äg3s4ta äg3s4tr äg4ra ägd2 äge1i äge2r3a äge3s äh1a
+äh1in äh1w äh2rel äh3l2e äh3na äh3ne äh3ri äh4l3e4be äh5ler äher5t äher8gebn ähl1a äk3l äk4li äka2la
+äl2bl äl2l1a äl2p3 äl2st äl3te äl4schl älbe2 älk3 älks4 äm2s ämer2s ämi3en ämoni3e ämp7f4e ämt2e
+än2dr än2gr än2k3l än2kr än2s1c än3k2e än3n4e4 än4s1a änd2e äne1s äne2n1 änft2 äng3se änge4ra änk2s
+äns2e änte3le äo3s2 äp2pl äp2pr äp2s1c äp4st är1c är1int är1ob är1of är1ä är2b3le är2em är2er är2es
+är2seb är2si är2st är2th är2zu är2zw är3ge är3ke är3re är3spu är3str är3ze är4af är4seh ärde4s äre2n
+ärf2s ärk2s ärm2s ärm3arm ärm3ent ärme1e äro2p ärs1er ärse2 ärt2s3 ärt4e äs2s3t äs2sp äs4tr äse1i4
+äse3g äse3t äse4ren äse5ref äser2i äser4ei äss2e äss3erw äss5erkr äss5ersa äst2e ät1ob ät2s1i2
+ät2s1p ät2s3a ät2s3t ät2sä ät2tei ät2zw ät4schl ät4schr ät4tr äte1e äte1i äte2n äte3a äte3l2 äteo2
+äts1or äts3l ätte4n ätze3l äu1c äu2b3l äu2br äu2ma äu2sp äu2tr äu3d äu3el äu3nu äu3s2e äu4schi
+äu4schm äude3 äuder2 äug3l äum3p äum4s5 äumpf4 äun2e äure1 äus2s1c äuse1i äut2e äß1erk äß1ers è1c
+è1m è1n è1r é1b é1c é1g é1h é1l é1o é1p é1r é1s é1t2 é1u2 é1v é1z2 égi2 élu2 ê1p ê4t í1l ño1 órd2
+ö1b ö1c ö1d ö1e ö1g ö1he ö1hu ö1ke ö1m ö1pe ö1t ö1v2 ö1w ö1z ö1ß ö2b3le ö2b3r ö2chr ö2g3l ö2g3r ö2l
+ö2r1e2l ö2r1ec ö2r1ei ö2r1em ö2r1ene ö2r1er2e ö2r1une ö2rent ö2rer2g ö2rer2l ö2rim ö2sa ö2sch1l
+ö2sch3a ö2sch3m ö2schi ö2schn ö2schw ö2sein ö2sp ö2st ö2t3r ö3cke ö3ig. ö3isch. ö3ni ö3r2erb ö3r2erz
+ö3set ö3su ö4sch3ei ö4t3a ö6sch5erf ö6sch5eri öb2l öb2s3 öbe4l3i öch1l öch2s öch4ste öchs4tu öchst3r
+öchst5ei öd2st öde1r ödel3l ödi3 ödien3 ödin3 öf2fl öf3l öge3le ögen2s1 öh3ri öhe4n1 öhl2e4 öhre4
+ök2s ök3r öl1a2 öl1ei öl1em öl1in öl2f1ei öl2k3l öl2nar öl3le öl3sa öl3sz öl3tu öl4en ölf2er ölk4e
+ölks4 öll1a ölo2 öls2 ölz2w öm2s ön2e ön2s ön3sc ön3sp önizi1 önn2e öo1 öo2ta öoti1 öp4s3t öpf3l
+ör1c ör1ess ör1o2 ör2b3l ör2dr ör2err ör2erw ör2f3l ör2gl ör2kl ör3a2 ör3dra ör3sk öre2n1 örer2f
+örn2e örner4v örpe2 örs2e ört2e öru4 ös1ei ös2s1c ös2st ös2th ös3te ös3tr ösche2 öscher3 öse3str
+öst1a2 öt2sc öt2tr öte4n1 öts2 öze3 özes4 öß2ti ößen3 ü1che ü1ei ü1g ü1he ü1hu ü1k2 ü1lu ü1pe ü1pi
+ü1r2o3 ü1v ü1z ü2ckin ü2f1a ü2f1ei ü2f1erg ü2f1ä ü2f3i ü2fent ü2fo ü2fum ü2g3l ü2gn ü2h1ei ü2h1eng
+ü2h1ent ü2her2k ü2her2z ü2herf ü2hex ü2lö ü2m1id ü2m1in ü2m1u ü2ma ü2ment ü2n1erd ü2r1e2l ü2r1ei
+ü2schl ü2t1al ü2t3h ü2t3r ü3cke4n ü3d2ens ü3den. ü3l2e ü4bet ü4ckers ü4d3a4 ü4l3ef ü4n3a2 üb1ä
+üb2s3t üb3l üb3r üb4e2 übe3c übe3le übe3ne übe4na über3 üch2s1c üch3l ücht4e ück1er ück3eri ücker6ke
+üd3o4 üd3r üd3s2 üd3t4 üde2c üde2l üden2g üdu2 üe2 üeb3 üf2fl üf3l üf3ter üfer2 üg2e üg3s2 üg4s3t
+üge2l1a2 üge2lo üge2lä üge4lec üge6lei6s ügen3s üh1er üh1i4 üh1lä üh1o2 üh1ro üh1s üh1w üh3a2 üh3mo
+üh3ne üh3r2e üh3t2 ühl2er ühl4sta ühl4sti ühla2 ühn2s ühr3ei. ühr3ta ühre2n1 ühren3s4 ühs2p üht4r
+ül1a ül2c ül2l1a2 ül2l1ei ül2lid ül2lo ül2lö üle2ra üll2er ülls2 üme2ra ün2da ün2dr ün2g3l ün2s
+ün2za ün2zun ün2zw ün3sc ün3se ün3sp ün3str ünd3s ünf1 ünf3li ünster3 ünzu2 üp2pl üpf3l ür1a ür2fl
+ür2fr ür2s ür2z1in ür2z1w ür2zö ür3sc ür3se ür3si ür3sp ür4g3en4g ürge4ra ürk2e ürom2 üror2 ürr2
+ürt4h ürte2l3 ürz2a üs2a üs2e üs2s1c üs2s3a üs2st üs4s1o üse1e2 üse1r4 üse1s üse3l2 üse3t üse4n
+üss2e üst3a2 üste2n üt2s1 üt2tr üt2zw üt3z2e üte2r1e üte2ra üte3m üte4n üten3s üten3z2 ütent4 üter3n
+üterich6 ütte4n
+`
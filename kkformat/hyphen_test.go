@@ -0,0 +1,38 @@
+package kkformat
+
+import (
+	"strings"
+	"testing"
+)
+
+func hyphenateString(h *Hyphenator, word string) string {
+	runes := []rune(word)
+	parts := make([]string, 0, 4)
+	last := 0
+	for _, at := range h.Hyphenate(runes) {
+		parts = append(parts, string(runes[last:at]))
+		last = at
+	}
+	return strings.Join(append(parts, string(runes[last:])), "-")
+}
+
+func TestHyphenate(t *testing.T) {
+	for lang, words := range map[string][]string{
+		"en": {"jus-ti-fi-ca-tion", "com-puter", "Pro-gram-ming", "ta-ble", "cat", "acad-e-my"},
+		"de": {"Sil-ben-tren-nung"},
+		"fr": {"té-lé-phone"},
+		"es": {"bi-blio-te-ca"},
+		"it": {"mac-chi-na"},
+	} {
+		h := GetHyphenator(lang)
+		for _, w := range words {
+			if res := hyphenateString(h, strings.Replace(w, "-", "", -1)); res != w {
+				t.Error(lang, w, res)
+			}
+		}
+	}
+
+	if GetHyphenator("xx") != nil {
+		t.Error("unknown language")
+	}
+}
@@ -0,0 +1,59 @@
+package kkformat
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/image/font"
+)
+
+// Renderer holds the configuration of rendering, it is safe for concurrent use as long as its fields
+// are not changed after the first call of Render. Each call gets its own Formatter from a pool.
+type Renderer struct {
+	Columns    uint32
+	LineHeight int
+	Face       font.Face // must be comparable, see glyph.go
+	Theme      []image.Image
+	Hyphens    *Hyphenator
+	Vertical   bool
+	Indent     bool
+	TabWidth   int
+	Gutter     Gutter
+	Budget     Budget
+}
+
+var formatterPool = sync.Pool{New: func() interface{} { return &Formatter{} }}
+
+// Render renders source onto dst, which must support SubImage, dst must not be shared by concurrent calls
+func (r *Renderer) Render(ctx context.Context, dst draw.Image, source []byte) (*Result, error) {
+	if _, ok := dst.(IImage); !ok {
+		return nil, errors.New("kkformat: dst doesn't support SubImage")
+	}
+
+	o := formatterPool.Get().(*Formatter)
+	*o = Formatter{
+		Source:     source,
+		Columns:    r.Columns,
+		LineHeight: r.LineHeight,
+		Img:        &font.Drawer{Dst: dst, Face: r.Face},
+		Theme:      r.Theme,
+		Hyphens:    r.Hyphens,
+		Vertical:   r.Vertical,
+		Indent:     r.Indent,
+		TabWidth:   r.TabWidth,
+		Gutter:     r.Gutter,
+		Budget:     r.Budget,
+		wp:         o.wp[:0],
+		wd:         o.wd[:0],
+		wl:         o.wl[:0],
+	}
+
+	res, err := o.RenderContext(ctx)
+
+	o.Source, o.Img = nil, nil
+	formatterPool.Put(o)
+	return res, err
+}
@@ -0,0 +1,40 @@
+package kkformat
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestRendererConcurrent(t *testing.T) {
+	rd := &Renderer{Columns: 80, LineHeight: 16, Face: basicfont.Face7x13, Theme: WhiteTheme}
+	src := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20) + "\n```\nfunc main() {}\n```\n")
+
+	render := func() []byte {
+		dst := image.NewRGBA(image.Rect(0, 0, 600, 800))
+		res, err := rd.Render(context.Background(), dst, src)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		return dst.Pix[:len(dst.Pix)*res.Image.Bounds().Dy()/800]
+	}
+
+	expect := render()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !bytes.Equal(render(), expect) {
+				t.Error("different output")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,192 @@
+package kkformat
+
+import (
+	"bytes"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	rubyBar   = '｜'
+	rubyOpen  = '《'
+	rubyClose = '》'
+
+	rubyMaxBase    = 10
+	rubyMaxReading = 20
+)
+
+func isRubyHan(r rune) bool {
+	return unicode.Is(unicode.Han, r) || r == '々' || r == '〆' || r == 'ヶ'
+}
+
+// parseRuby tries to parse a ruby annotation at buf[idx:], there are two forms:
+//
+//	｜漢字《かんじ》, the base starts after "｜"
+//	漢字《かんじ》, the base is the run of Han characters before "《"
+//
+// "｜《" is an escaped "《", in this case reading will be nil
+func parseRuby(buf []byte, idx int) (base, reading []rune, next int, ok bool) {
+	r, w := utf8.DecodeRune(buf[idx:])
+	explicit := r == rubyBar
+	if explicit {
+		idx += w
+		if r, w = utf8.DecodeRune(buf[idx:]); r == rubyOpen {
+			return []rune{rubyOpen}, nil, idx + w, true
+		}
+	} else if !isRubyHan(r) {
+		return
+	}
+
+	for {
+		r, w = utf8.DecodeRune(buf[idx:])
+		if w == 0 || r == '\n' || r == rubyClose || len(base) > rubyMaxBase {
+			return nil, nil, 0, false
+		}
+
+		idx += w
+		if r == rubyOpen {
+			break
+		}
+
+		if !explicit && !isRubyHan(r) {
+			return nil, nil, 0, false
+		}
+		base = append(base, r)
+	}
+
+	for {
+		r, w = utf8.DecodeRune(buf[idx:])
+		if w == 0 || r == '\n' || r == rubyOpen || len(reading) > rubyMaxReading {
+			return nil, nil, 0, false
+		}
+
+		idx += w
+		if r == rubyClose {
+			break
+		}
+		reading = append(reading, r)
+	}
+
+	if len(base) == 0 || len(reading) == 0 {
+		return nil, nil, 0, false
+	}
+
+	return base, reading, idx, true
+}
+
+// nextRuby returns the ruby word at the current position or nil
+func (s *stream_t) nextRuby() *word_t {
+	base, reading, next, ok := parseRuby(s.buf, s.idx)
+	if !ok {
+		return nil
+	}
+
+	s.idx = next
+	if reading == nil {
+		return (&word_t{}).setType(runeFullDelim).setValue(base).setLen(StringWidth(base))
+	}
+
+	w := (&word_t{}).setType(runeFull).setValue(base).setLen(StringWidth(base))
+	w.ruby = reading
+	return w
+}
+
+// RubyHTML escapes text into HTML, ruby annotations will be converted into <ruby> tags
+func RubyHTML(text string) string {
+	buf := []byte(text)
+	out := &bytes.Buffer{}
+
+	last := 0
+	for i := 0; i < len(buf); {
+		base, reading, next, ok := parseRuby(buf, i)
+		if !ok {
+			_, w := utf8.DecodeRune(buf[i:])
+			i += w
+			continue
+		}
+
+		out.WriteString(html.EscapeString(string(buf[last:i])))
+		if reading == nil {
+			out.WriteString(html.EscapeString(string(base)))
+		} else {
+			out.WriteString("<ruby>" + html.EscapeString(string(base)) + "<rp>（</rp><rt>" +
+				html.EscapeString(string(reading)) + "</rt><rp>）</rp></ruby>")
+		}
+		i, last = next, next
+	}
+
+	out.WriteString(html.EscapeString(string(buf[last:])))
+	return out.String()
+}
+
+func (w *words_t) hasRuby() bool {
+	for _, word := range *w {
+		if word.ruby != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// drawRuby draws the annotation of word, (x, y) is the pen position before the word was drawn
+func (o *Formatter) drawRuby(word *word_t, x, y int) {
+	unit := o.Pos.Dx + 1
+	l, r := word.surroundingSpaces()
+	base := StringWidth(word.value[l:uint32(len(word.value))-r]) * uint32(unit)
+
+	reading := uint32(0)
+	for _, c := range word.ruby {
+		reading += RuneWidth(c) * uint32(unit) / 2
+	}
+
+	if o.Vertical {
+		y += int(l)*unit + (int(base)-int(reading))/2
+		for _, c := range word.ruby {
+			o.drawSmall(c, x+o.LineHeight, y)
+			y += int(RuneWidth(c)) * unit / 2
+		}
+		return
+	}
+
+	m := o.metrics()
+	x += int(l)*unit + (int(base)-int(reading))/2
+	y -= m.Ascent.Ceil() + (m.Ascent.Ceil()+m.Descent.Ceil())/2 + 1
+	for _, c := range word.ruby {
+		o.drawSmall(c, x, y)
+		x += int(RuneWidth(c)) * unit / 2
+	}
+}
+
+// drawSmall draws r at half size, (x, y) is the top left corner
+func (o *Formatter) drawSmall(r rune, x, y int) {
+	m := o.metrics()
+	asc, gh := m.Ascent.Ceil(), m.Ascent.Ceil()+m.Descent.Ceil()
+	gw := (o.Pos.Dx + 1) * int(RuneWidth(r))
+
+	g := o.glyph(r)
+	if !g.ok {
+		return
+	}
+	dr := g.dr.Add(image.Pt(0, asc))
+
+	// downscale by taking the max of every 2x2 block, so thin strokes will survive
+	small := image.NewAlpha(image.Rect(0, 0, (gw+1)/2, (gh+1)/2))
+	for py := dr.Min.Y; py < dr.Max.Y; py++ {
+		for px := dr.Min.X; px < dr.Max.X; px++ {
+			if px < 0 || px >= gw || py < 0 || py >= gh {
+				continue
+			}
+
+			if a := g.alphaAt(px-dr.Min.X, py-dr.Min.Y); a > small.AlphaAt(px/2, py/2).A {
+				small.SetAlpha(px/2, py/2, color.Alpha{a})
+			}
+		}
+	}
+
+	b := small.Bounds()
+	draw.DrawMask(o.Img.Dst, b.Add(image.Pt(x, y)), o.Img.Src, image.Point{}, small, image.Point{}, draw.Over)
+}
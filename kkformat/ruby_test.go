@@ -0,0 +1,79 @@
+package kkformat
+
+import (
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestRubyHTML(t *testing.T) {
+	for in, out := range map[string]string{
+		"吾輩《わがはい》は猫":   "<ruby>吾輩<rp>（</rp><rt>わがはい</rt><rp>）</rp></ruby>は猫",
+		"それは｜猫の額《ひたい》": "それは<ruby>猫の額<rp>（</rp><rt>ひたい</rt><rp>）</rp></ruby>",
+		"｜《ルビ》ではない":    "《ルビ》ではない",
+		"かな《かな》":       "かな《かな》",
+		"漢字《\n》<b>":    "漢字《\n》&lt;b&gt;",
+	} {
+		if res := RubyHTML(in); res != out {
+			t.Error(in, res)
+		}
+	}
+}
+
+func TestRenderRuby(t *testing.T) {
+	render := func(src string) (*Result, *boxFace) {
+		face := &boxFace{Face: basicfont.Face7x13, asked: map[rune]bool{}}
+		defer dropAtlas(face)
+
+		fo := testFormatter(src, 16*10)
+		fo.Img.Face = face
+		res, err := fo.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Rows != 2 || len(res.Lines) != 2 {
+			t.Fatal(src, res.Rows, res.Lines)
+		}
+		return res, face
+	}
+
+	plain, _ := render("吾輩は猫\n普通")
+	for _, src := range []string{"｜吾輩《わがはい》は猫\n普通", "吾輩《わがはい》は猫\n普通"} {
+		res, face := render(src)
+
+		// the annotated row is taller by half a line, the rows below are pushed down
+		if dy := res.Lines[0].Dy() - plain.Lines[0].Dy(); dy != 16/2 {
+			t.Error(src, res.Lines, plain.Lines)
+		}
+		if res.Lines[1].Min.Y != res.Lines[0].Max.Y || res.Lines[1].Dy() != plain.Lines[1].Dy() {
+			t.Error(src, res.Lines)
+		}
+
+		// the reading is drawn above the base, the markup is not drawn at all
+		for _, r := range "わがはい" {
+			if !face.asked[r] {
+				t.Errorf("%s: %q is not drawn", src, r)
+			}
+		}
+		for _, r := range "｜《》" {
+			if face.asked[r] {
+				t.Errorf("%s: %q is drawn", src, r)
+			}
+		}
+
+		base := inkOf(plain.Image, plain.Lines[0])
+		ink := inkOf(res.Image, res.Lines[0])
+		if ink.Dy() <= base.Dy() || ink.Min.Y >= base.Min.Y+16/2 {
+			t.Error(src, ink, base)
+		}
+	}
+
+	// an escaped bracket is drawn as is
+	res, face := render("｜《ルビ》ではない\n普通")
+	if res.Lines[0].Dy() != plain.Lines[0].Dy() {
+		t.Error(res.Lines)
+	}
+	if face.asked['｜'] || !face.asked['《'] {
+		t.Error("the escaped bracket is not drawn")
+	}
+}
@@ -0,0 +1,312 @@
+package kkformat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+// A side by side diff is rendered as two panes, each pane is a Formatter in code mode without fences.
+// Rows of the shorter side are padded with empty lines so both panes stay aligned, changed parts of
+// a line are wrapped in markOn and markOff which are zero width in the pane.
+
+const (
+	markOn  = '\ue000'
+	markOff = '\ue001'
+
+	// lines sharing less than this percentage of runes are not highlighted intraline
+	intralineMinCommon = 40
+)
+
+type pane_t struct {
+	gutter  func(n int) (string, uint16) // gutter of the nth line, the string must be gutterW wide
+	gutterW uint32
+	markTN  int // background of the marked runes
+}
+
+// paneImage is the area of dst starting at x, w pixels wide, translated to the origin
+type paneImage struct {
+	draw.Image
+	x, w int
+}
+
+func (p *paneImage) Bounds() image.Rectangle {
+	b := p.Image.Bounds()
+	return image.Rect(0, b.Min.Y, p.w, b.Max.Y)
+}
+
+func (p *paneImage) At(x, y int) color.Color {
+	return p.Image.At(x+p.x, y)
+}
+
+func (p *paneImage) Set(x, y int, c color.Color) {
+	if x >= 0 && x < p.w {
+		p.Image.Set(x+p.x, y, c)
+	}
+}
+
+func (p *paneImage) SubImage(r image.Rectangle) image.Image {
+	return p.Image.(IImage).SubImage(r.Add(image.Pt(p.x, 0)))
+}
+
+// codeRows returns the number of rows a line takes in code mode when avail columns are left after the gutter
+func codeRows(line []rune, avail uint32, tabs int) int {
+	rows, w, col := 1, uint32(0), uint32(0)
+	for _, r := range line {
+		if runeType(r) == runeUnknown {
+			continue
+		}
+
+		rw := RuneWidth(r)
+		if r == '\t' {
+			rw = tabStop(col, tabs)
+		}
+		col += rw
+		if w+rw > avail {
+			rows, w = rows+1, 0
+		}
+		w += rw
+	}
+	return rows
+}
+
+func splitLines(buf []byte) [][]rune {
+	text := strings.TrimSuffix(strings.Replace(string(buf), "\r\n", "\n", -1), "\n")
+	lines := [][]rune{}
+	for _, l := range strings.Split(text, "\n") {
+		lines = append(lines, []rune(l))
+	}
+	return lines
+}
+
+// intraline returns the changed runes of a and b, nil if the lines have too little in common
+func intraline(a, b []rune) (da, db []bool) {
+	ia, ib := make([]int, len(a)), make([]int, len(b))
+	for i, r := range a {
+		ia[i] = int(r)
+	}
+	for i, r := range b {
+		ib[i] = int(r)
+	}
+
+	common := 0
+	da, db = make([]bool, len(a)), make([]bool, len(b))
+	for _, op := range myersDiff(ia, ib) {
+		switch op.kind {
+		case '=':
+			common++
+		case '-':
+			da[op.a] = true
+		case '+':
+			db[op.b] = true
+		}
+	}
+
+	longer := len(a)
+	if len(b) > longer {
+		longer = len(b)
+	}
+	if longer == 0 || common*100/longer < intralineMinCommon {
+		return nil, nil
+	}
+	return
+}
+
+// markRunes wraps the runes of line marked in changed with markOn and markOff
+func markRunes(line []rune, changed []bool) []rune {
+	if changed == nil {
+		return line
+	}
+
+	out, on := make([]rune, 0, len(line)+4), false
+	for i, r := range line {
+		if changed[i] != on {
+			if on = changed[i]; on {
+				out = append(out, markOn)
+			} else {
+				out = append(out, markOff)
+			}
+		}
+		out = append(out, r)
+	}
+	if on {
+		out = append(out, markOff)
+	}
+	return out
+}
+
+type paneLine struct {
+	text    []rune
+	gutter  string
+	special uint16
+}
+
+// RenderSideBySide renders the line diff of old and new into two panes of r.Columns columns each,
+// the left pane shows old and the right pane shows new. dst should be twice as wide as the one used by Render.
+func (r *Renderer) RenderSideBySide(ctx context.Context, dst draw.Image, old, new []byte) (*Result, error) {
+	if _, ok := dst.(IImage); !ok {
+		return nil, errors.New("kkformat: dst doesn't support SubImage")
+	}
+
+	for _, src := range []*[]byte{&old, &new} {
+		if max := r.Budget.MaxRunes; max > 0 && len([]rune(string(*src))) > max {
+			*src = []byte(string([]rune(string(*src))[:max]))
+		}
+		// marks are reserved
+		*src = bytes.Map(func(r rune) rune {
+			if r == markOn || r == markOff {
+				return -1
+			}
+			return r
+		}, *src)
+	}
+
+	a, b := splitLines(old), splitLines(new)
+	ids := map[string]int{}
+	id := func(lines [][]rune) []int {
+		out := make([]int, len(lines))
+		for i, l := range lines {
+			k, ok := ids[string(l)]
+			if !ok {
+				k = len(ids)
+				ids[string(l)] = k
+			}
+			out[i] = k
+		}
+		return out
+	}
+
+	w := (&fence_t{start: 1}).gutterWidth(len(a))
+	if w2 := (&fence_t{start: 1}).gutterWidth(len(b)); w2 > w {
+		w = w2
+	}
+	avail := r.Columns - w - 1
+	filler := paneLine{nil, spaces[:w], specialLineNumber}
+
+	var left, right []paneLine
+	// push appends a row to both panes, i and j are line indexes in a and b, -1 means none,
+	// the shorter side will be padded so the next row starts at the same height
+	push := func(i, j int) {
+		li, ri, lrows, rrows := filler, filler, 1, 1
+		if i > -1 {
+			li, lrows = paneLine{a[i], padNumber(i+1, w), specialDiffDel}, codeRows(a[i], avail, r.TabWidth)
+		}
+		if j > -1 {
+			ri, rrows = paneLine{b[j], padNumber(j+1, w), specialDiffAdd}, codeRows(b[j], avail, r.TabWidth)
+		}
+
+		if i > -1 && j > -1 {
+			if string(a[i]) == string(b[j]) {
+				li.special, ri.special = specialLineNumber, specialLineNumber
+			} else {
+				da, db := intraline(a[i], b[j])
+				li.text, ri.text = markRunes(a[i], da), markRunes(b[j], db)
+			}
+		}
+
+		left = append(left, li)
+		for k := lrows; k < rrows; k++ {
+			left = append(left, filler)
+		}
+		right = append(right, ri)
+		for k := rrows; k < lrows; k++ {
+			right = append(right, filler)
+		}
+	}
+
+	// removed and added lines in a row are paired up as changed lines
+	var dels, adds []int
+	flush := func() {
+		for k := 0; k < len(dels) || k < len(adds); k++ {
+			i, j := -1, -1
+			if k < len(dels) {
+				i = dels[k]
+			}
+			if k < len(adds) {
+				j = adds[k]
+			}
+			push(i, j)
+		}
+		dels, adds = dels[:0], adds[:0]
+	}
+
+	for _, op := range myersDiff(id(a), id(b)) {
+		switch op.kind {
+		case '=':
+			flush()
+			push(op.a, op.b)
+		case '-':
+			dels = append(dels, op.a)
+		case '+':
+			adds = append(adds, op.b)
+		}
+	}
+	flush()
+
+	if r.Budget.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Budget.MaxDuration)
+		defer cancel()
+	}
+	return r.renderPanes(ctx, dst, [2][]paneLine{left, right}, w)
+}
+
+func (r *Renderer) renderPanes(ctx context.Context, dst draw.Image, panes [2][]paneLine, w uint32) (*Result, error) {
+	res, half, height := &Result{}, dst.Bounds().Dx()/2, 0
+
+	for p, lines := range panes {
+		text := []rune{}
+		for i, l := range lines {
+			if i > 0 {
+				text = append(text, '\n')
+			}
+			text = append(text, l.text...)
+		}
+
+		lines := lines
+		o := &Formatter{
+			Source:     []byte(string(text)),
+			Columns:    r.Columns,
+			LineHeight: r.LineHeight,
+			Img:        &font.Drawer{Dst: &paneImage{Image: dst, x: p * half, w: half}, Face: r.Face},
+			Theme:      r.Theme,
+			TabWidth:   r.TabWidth,
+			Budget:     Budget{MaxRows: r.Budget.MaxRows},
+			pane: &pane_t{
+				gutter: func(n int) (string, uint16) {
+					if n < len(lines) {
+						return lines[n].gutter, lines[n].special
+					}
+					return spaces[:w], specialLineNumber
+				},
+				gutterW: w,
+				markTN:  []int{TNDiffDelWord, TNDiffAddWord}[p],
+			},
+		}
+
+		pr, err := o.RenderContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Truncated = res.Truncated || pr.Truncated
+		res.Warnings = append(res.Warnings, pr.Warnings...)
+		if pr.Rows > res.Rows {
+			res.Rows = pr.Rows
+		}
+		if h := pr.Image.Bounds().Dy(); h > height {
+			height = h
+		}
+	}
+
+	draw.Draw(dst, image.Rect(half, 0, half+1, height), r.Theme[TNLineWrap], image.Point{}, draw.Src)
+	res.Image = dst.(IImage).SubImage(image.Rect(0, 0, 2*half, height))
+	res.TextBounds = res.Image.Bounds()
+	return res, nil
+}
@@ -0,0 +1,77 @@
+package kkformat
+
+import (
+	"context"
+	"image"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestMyersDiff(t *testing.T) {
+	apply := func(a, b []int) string {
+		s := ""
+		for _, op := range myersDiff(a, b) {
+			switch op.kind {
+			case '=':
+				if a[op.a] != b[op.b] {
+					t.Fatal(a, b, op)
+				}
+				s += "="
+			case '-':
+				s += "-"
+			case '+':
+				s += "+"
+			}
+		}
+		return s
+	}
+
+	for _, c := range []struct {
+		a, b []int
+		ops  string
+	}{
+		{nil, nil, ""},
+		{[]int{1, 2, 3}, []int{1, 2, 3}, "==="},
+		{[]int{1, 2, 3}, nil, "---"},
+		{nil, []int{1, 2}, "++"},
+		{[]int{1, 2, 3}, []int{1, 4, 3}, "=-+="},
+		{[]int{1, 2, 3, 4}, []int{2, 3, 5}, "-==-+"},
+	} {
+		if ops := apply(c.a, c.b); ops != c.ops {
+			t.Error(c.a, c.b, ops)
+		}
+	}
+}
+
+func TestIntraline(t *testing.T) {
+	a, b := []rune("return a + b"), []rune("return a - b")
+	da, db := intraline(a, b)
+	if string(markRunes(a, da)) != "return a \ue000+\ue001 b" || string(markRunes(b, db)) != "return a \ue000-\ue001 b" {
+		t.Error(string(markRunes(a, da)), string(markRunes(b, db)))
+	}
+
+	if da, db = intraline([]rune("abcdef"), []rune("uvwxyz")); da != nil || db != nil {
+		t.Error(da, db)
+	}
+}
+
+func TestRenderSideBySide(t *testing.T) {
+	r := &Renderer{Columns: 40, LineHeight: 16, Face: basicfont.Face7x13, Theme: WhiteTheme}
+	old := "a\nb\n" + strings.Repeat("x", 100) + "\nd\n"
+	new := "a\nc\nc2\nd\n"
+
+	res, err := r.RenderSideBySide(context.Background(), image.NewRGBA(image.Rect(0, 0, 800, 16*20)), []byte(old), []byte(new))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a, b|c, x*100 (3 rows)|c2, d
+	if res.Rows != 6 || res.Truncated || len(res.Warnings) > 0 {
+		t.Error(res.Rows, res.Truncated, res.Warnings)
+	}
+	if res.Image.Bounds().Dx() != 800 {
+		t.Error(res.Image.Bounds())
+	}
+}
@@ -0,0 +1,314 @@
+package kkformat
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"strings"
+)
+
+// Tables are recognized at the start of a line of prose, they are either markdown pipe tables
+// (a header, a delimiter row like "|---|:--:|" and the body) or at least two lines of tab separated values
+// with the same number of tabs. Cells are laid out in aligned columns with borders:
+//
+//	┌─────┬─────┐
+//	│ a   │ b   │
+//	├─────┼─────┤
+//	│ 1   │ 2   │
+//	└─────┴─────┘
+//
+// The borders are drawn as lines in the border columns instead of box drawing characters, which are
+// full width. Columns are narrowed (widest first) until the table fits, cells are wrapped within their columns.
+
+const (
+	alignLeft = iota
+	alignCenter
+	alignRight
+)
+
+const (
+	tableContent = iota
+	tableTop
+	tableSeparator
+	tableBottom
+)
+
+// tableRow_t describes the borders of a row of a table
+type tableRow_t struct {
+	kind    int
+	borders []int // columns of the vertical borders
+}
+
+type table_t struct {
+	header bool // the first row is the header
+	align  []int
+	rows   [][]string
+	widths []uint32
+}
+
+// parseTable parses the table starting at buf[idx:], n is the number of bytes it takes, nil if there is none
+func parseTable(buf []byte, idx int) (t *table_t, n int) {
+	var lines [][]byte
+	for i := idx; i < len(buf); {
+		line := lineAt(buf, i)
+		lines = append(lines, line)
+		i += len(line) + 1
+		if len(lines) == 2 {
+			break
+		}
+	}
+	if len(lines) < 2 {
+		return nil, 0
+	}
+
+	t = &table_t{}
+	split := splitPipeRow
+	if align := parseDelimiterRow(lines[1]); align != nil && bytes.IndexByte(lines[0], '|') > -1 && len(splitPipeRow(lines[0])) == len(align) {
+		t.header, t.align = true, align
+		t.rows = append(t.rows, splitPipeRow(lines[0]))
+		n = len(lines[0]) + len(lines[1]) + 2
+	} else if tabs := tsvTabs(lines[0]); tabs > 0 && tsvTabs(lines[1]) == tabs {
+		split = splitTSVRow
+		t.align = make([]int, tabs+1)
+	} else {
+		return nil, 0
+	}
+
+	cols := len(t.align)
+	for n < len(buf)-idx {
+		line := lineAt(buf, idx+n)
+		if len(line) == 0 {
+			break
+		}
+		if t.header && bytes.IndexByte(line, '|') == -1 {
+			break
+		}
+		if !t.header && tsvTabs(line) != cols-1 {
+			break
+		}
+
+		row := split(line)
+		for len(row) < cols {
+			row = append(row, "")
+		}
+		t.rows = append(t.rows, row[:cols])
+		n += len(line) + 1
+	}
+
+	if n > len(buf)-idx {
+		n = len(buf) - idx
+	}
+	return t, n
+}
+
+func splitPipeRow(line []byte) []string {
+	s := strings.TrimSpace(string(line))
+	s = strings.TrimPrefix(s, "|")
+	if strings.HasSuffix(s, "|") && !strings.HasSuffix(s, "\\|") {
+		s = s[:len(s)-1]
+	}
+
+	cells := strings.Split(s, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+func splitTSVRow(line []byte) []string {
+	cells := strings.Split(strings.TrimSuffix(string(line), "\r"), "\t")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// tsvTabs counts the tabs of line, leading tabs are indents and not counted
+func tsvTabs(line []byte) int {
+	return bytes.Count(bytes.TrimLeft(line, " \t"), []byte{'\t'})
+}
+
+// parseDelimiterRow parses "| :--- | :---: | ---: |" into the alignments of columns, nil if line is not a delimiter row
+func parseDelimiterRow(line []byte) []int {
+	if bytes.IndexByte(line, '-') == -1 {
+		return nil
+	}
+
+	var align []int
+	for _, c := range splitPipeRow(line) {
+		if c == "" {
+			return nil
+		}
+
+		a, dashes := alignLeft, strings.Trim(c, ":")
+		if strings.Trim(dashes, "-") != "" || dashes == "" {
+			return nil
+		}
+		if strings.HasSuffix(c, ":") {
+			if a = alignRight; strings.HasPrefix(c, ":") {
+				a = alignCenter
+			}
+		}
+		align = append(align, a)
+	}
+	return align
+}
+
+// layout computes the widths of columns so the table fits into columns, false if it can't
+func (t *table_t) layout(columns uint32) bool {
+	cols := len(t.align)
+	// "│ " + cells joined by " │ " + " │", a column is at least 2 wide to hold a full width rune
+	if uint32(5*cols+1) > columns {
+		return false
+	}
+
+	t.widths = make([]uint32, cols)
+	total := uint32(3*cols + 1)
+	for _, row := range t.rows {
+		for i, c := range row {
+			if w := StringWidth(c); w > t.widths[i] {
+				t.widths[i] = w
+			}
+		}
+	}
+	for i := range t.widths {
+		if t.widths[i] == 0 {
+			t.widths[i] = 1
+		}
+		total += t.widths[i]
+	}
+
+	for total > columns {
+		widest := 0
+		for i, w := range t.widths {
+			if w > t.widths[widest] {
+				widest = i
+			}
+		}
+		t.widths[widest]--
+		total--
+	}
+	return true
+}
+
+// borders returns the columns of the vertical borders
+func (t *table_t) borders() []int {
+	b, x := []int{0}, 0
+	for _, w := range t.widths {
+		x += int(w) + 3
+		b = append(b, x)
+	}
+	return b
+}
+
+// wrapCell breaks s into lines not wider than w, at spaces if possible
+func wrapCell(s string, w uint32) [][]rune {
+	var lines [][]rune
+	line, lineW := []rune{}, uint32(0)
+	flush := func() {
+		lines = append(lines, []rune(strings.TrimRight(string(line), " ")))
+		line, lineW = []rune{}, 0
+	}
+
+	for _, word := range strings.SplitAfter(s, " ") {
+		ww := StringWidth(strings.TrimRight(word, " "))
+		if lineW > 0 && lineW+ww > w {
+			flush()
+		}
+
+		for _, r := range word {
+			rw := RuneWidth(r)
+			if r == ' ' && lineW+rw > w {
+				continue
+			}
+			if lineW+rw > w {
+				flush()
+			}
+			line = append(line, r)
+			lineW += rw
+		}
+	}
+
+	if len(line) > 0 || len(lines) == 0 {
+		flush()
+	}
+	return lines
+}
+
+// lines returns the rows of the table, each row is a line of words and the borders to be drawn
+func (t *table_t) lines() (lines []words_t, rows []*tableRow_t) {
+	borders := t.borders()
+	total := uint32(borders[len(borders)-1] + 1)
+	separator := func(kind int) {
+		lines = append(lines, words_t{indentWord(strings.Repeat(" ", int(total)))})
+		rows = append(rows, &tableRow_t{kind, borders})
+	}
+
+	separator(tableTop)
+	for ri, row := range t.rows {
+		if ri == 1 && t.header {
+			separator(tableSeparator)
+		}
+
+		cells, height := make([][][]rune, len(row)), 1
+		for i, c := range row {
+			if cells[i] = wrapCell(c, t.widths[i]); len(cells[i]) > height {
+				height = len(cells[i])
+			}
+		}
+
+		for k := 0; k < height; k++ {
+			line, pad := words_t{}, uint32(2)
+			for i := range row {
+				var text []rune
+				if k < len(cells[i]) {
+					text = cells[i][k]
+				}
+
+				left, w := uint32(0), StringWidth(text)
+				switch t.align[i] {
+				case alignCenter:
+					left = (t.widths[i] - w) / 2
+				case alignRight:
+					left = t.widths[i] - w
+				}
+
+				line = append(line, indentWord(strings.Repeat(" ", int(pad+left))))
+				if len(text) > 0 {
+					word := (&word_t{}).setType(runeLatin).setValue(text).setLen(w)
+					if ri == 0 && t.header {
+						word.style = styleBold
+					}
+					line = append(line, word)
+				}
+				pad = t.widths[i] - w - left + 3
+			}
+			lines = append(lines, append(line, indentWord(strings.Repeat(" ", int(pad-1)))))
+			rows = append(rows, &tableRow_t{tableContent, borders})
+		}
+	}
+	separator(tableBottom)
+	return
+}
+
+// drawTableRow draws the borders of the current row of a table
+func (o *Formatter) drawTableRow(row *tableRow_t) {
+	dx, top := o.Pos.Dx, o.rowTop()
+	left, mid, bottom := dx*2+2+int(o.block.gutter)*(dx+1), top+o.LineHeight/2, top+o.LineHeight
+	x := func(col int) int { return left + col*(dx+1) + dx/2 }
+	src := o.Theme[TNLineNumber]
+
+	y0, y1 := top, bottom
+	switch row.kind {
+	case tableTop:
+		y0 = mid
+	case tableBottom:
+		y1 = mid + 1
+	}
+	if row.kind != tableContent {
+		draw.Draw(o.Img.Dst, image.Rect(x(row.borders[0]), mid, x(row.borders[len(row.borders)-1])+1, mid+1), src, image.Point{}, draw.Src)
+	}
+	for _, b := range row.borders {
+		draw.Draw(o.Img.Dst, image.Rect(x(b), y0, x(b)+1, y1), src, image.Point{}, draw.Src)
+	}
+}
@@ -0,0 +1,101 @@
+package kkformat
+
+import (
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTable(t *testing.T) {
+	src := "| a | b |\n|:--|--:|\n| 1 | 2 |\n|3\nnext"
+	tb, n := parseTable([]byte(src), 0)
+	if tb == nil || !tb.header || src[n:] != "next" {
+		t.Fatal(tb, n)
+	}
+	if !reflect.DeepEqual(tb.rows, [][]string{{"a", "b"}, {"1", "2"}, {"3", ""}}) || !reflect.DeepEqual(tb.align, []int{alignLeft, alignRight}) {
+		t.Error(tb.rows, tb.align)
+	}
+
+	src = "x\ty\n1\t2\n\tindented"
+	if tb, n = parseTable([]byte(src), 0); tb == nil || tb.header || len(tb.rows) != 2 || src[n:] != "\tindented" {
+		t.Error(tb, n)
+	}
+
+	for _, src := range []string{"a | b\nc | d", "| a |\n|---|---|", "\tx\n\ty", "x\ty\nz"} {
+		if tb, _ := parseTable([]byte(src), 0); tb != nil {
+			t.Error(src, tb)
+		}
+	}
+}
+
+func TestTableLayout(t *testing.T) {
+	tb := &table_t{align: []int{alignLeft, alignLeft}, rows: [][]string{{"short", "a long cell which has to be wrapped"}}}
+	if !tb.layout(30) {
+		t.Fatal()
+	}
+	if tb.widths[0] != 5 || tb.borders()[2] != 29 {
+		t.Error(tb.widths, tb.borders())
+	}
+
+	lines, rows := tb.lines()
+	if len(lines) != len(rows) || len(lines) != 2+2 {
+		t.Fatal(len(lines))
+	}
+	for _, l := range lines {
+		w := uint32(0)
+		for _, word := range l {
+			w += word.len
+		}
+		if w != 30 {
+			t.Error(w)
+		}
+	}
+
+	if tb.layout(10) {
+		t.Error(tb.widths)
+	}
+}
+
+func TestRenderWideTable(t *testing.T) {
+	// tables wider than the spaces of def.go
+	src := "| a | b |\n|---|---|\n| 1 | " + strings.Repeat("x", 100) + " |"
+	fo := testFormatter(src, 16*10)
+	fo.Columns = 120
+	fo.Img.Dst = image.NewRGBA(image.Rect(0, 0, 1000, 16*10))
+	res, err := fo.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// borders, the header, the separator and the row are all wider than 80 columns
+	if res.Rows < 5 {
+		t.Fatal(res.Rows)
+	}
+	for _, l := range res.Lines[:5] {
+		if l.Max.X != res.Lines[0].Max.X || l.Max.X < 100*(fo.Pos.Dx+1) {
+			t.Error(res.Lines)
+			break
+		}
+	}
+}
+
+func TestWrapCell(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		w    uint32
+		want []string
+	}{
+		{"", 3, []string{""}},
+		{"ab cd ef", 5, []string{"ab cd", "ef"}},
+		{"abcdefg", 3, []string{"abc", "def", "g"}},
+		{"漢字漢字", 5, []string{"漢字", "漢字"}},
+	} {
+		var got []string
+		for _, l := range wrapCell(c.s, c.w) {
+			got = append(got, string(l))
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: %q", c.s, got)
+		}
+	}
+}
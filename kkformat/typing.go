@@ -0,0 +1,149 @@
+package kkformat
+
+import (
+	"errors"
+	"image"
+	"image/gif"
+)
+
+// The typing animation reveals a rendered image row by row (or a cell at a time) with a cursor at the end.
+// Only the first and the last frames are full images, others only hold the area changed since the previous
+// frame, which includes the erased cursor.
+
+const (
+	typingDelay     = 5   // default delay between frames in 100ths of a second
+	typingHold      = 300 // delay of the last frame
+	typingMaxFrames = 200 // default limit of frames
+)
+
+// Typing holds the options of the typing animation, zero values mean defaults
+type Typing struct {
+	ByCell    bool // text appears a cell at a time instead of a row at a time
+	Delay     int  // delay between frames in 100ths of a second
+	MaxFrames int  // steps are merged so there are at most MaxFrames frames
+}
+
+// typingStep tells that text before x in row is revealed
+type typingStep struct {
+	row, x int
+}
+
+// steps returns the steps of revealing lines, a row is completed by revealing it to the right edge w,
+// text starts at column 2 as the margin takes 2 cells, see Formatter.render
+func (t *Typing) steps(lines []image.Rectangle, cell, w int) []typingStep {
+	var steps []typingStep
+	for i, l := range lines {
+		if t.ByCell {
+			for x := cell * 3; x < l.Max.X; x += cell {
+				steps = append(steps, typingStep{i, x})
+			}
+		}
+		steps = append(steps, typingStep{i, w})
+	}
+
+	max := t.MaxFrames - 2 // the first and the last frames
+	if max < 1 {
+		max = 1
+	}
+	if len(steps) > max {
+		n, merged := (len(steps)+max-1)/max, steps[:0]
+		for i := n - 1; i < len(steps); i += n {
+			merged = append(merged, steps[i])
+		}
+		if last := steps[len(steps)-1]; merged[len(merged)-1] != last {
+			merged = append(merged, last)
+		}
+		steps = merged
+	}
+	return steps
+}
+
+// Animate makes the typing animation of res, which must be rendered onto a paletted image,
+// bg is the image it was rendered on before rendering
+func (r *Renderer) Animate(res *Result, bg *image.Paletted, t Typing) (*gif.GIF, error) {
+	img, ok := res.Image.(*image.Paletted)
+	if !ok || res.Lines == nil {
+		return nil, errors.New("kkformat: only paletted images in horizontal mode can be animated")
+	}
+	if t.Delay <= 0 {
+		t.Delay = typingDelay
+	}
+	if t.MaxFrames <= 0 {
+		t.MaxFrames = typingMaxFrames
+	}
+
+	b := img.Bounds()
+	lines := res.Lines
+	cell := cachedGlyph(r.Face, 'a').advance.Round() + 1
+	cursorIdx := uint8(img.Palette.Index(r.Theme[TNNormal].At(0, 0)))
+
+	// cursor returns the cursor at step s, it moves to the next row once a row is done
+	cursor := func(s typingStep) image.Rectangle {
+		if s.x >= b.Max.X {
+			if s.row+1 >= len(lines) {
+				return image.Rectangle{}
+			}
+			s = typingStep{s.row + 1, 0}
+		}
+		x := s.x
+		if x == 0 {
+			x = cell * 2
+		}
+		l := lines[s.row]
+		return image.Rect(x, l.Max.Y-r.LineHeight+2, x+cell, l.Max.Y-2).Intersect(b)
+	}
+
+	frame := func(rect image.Rectangle, s typingStep, c image.Rectangle) *image.Paletted {
+		f := image.NewPaletted(rect, img.Palette)
+		row := 0
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			// pixels before limit are revealed
+			for row < len(lines) && y >= lines[row].Max.Y {
+				row++
+			}
+			limit := 0
+			if row < s.row {
+				limit = b.Max.X
+			} else if row == s.row {
+				limit = s.x
+			}
+
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				idx := bg.ColorIndexAt(x, y)
+				if image.Pt(x, y).In(c) {
+					idx = cursorIdx
+				} else if x < limit {
+					idx = img.ColorIndexAt(x, y)
+				}
+				f.Pix[f.PixOffset(x, y)] = idx
+			}
+		}
+		return f
+	}
+
+	g := &gif.GIF{Config: image.Config{ColorModel: img.Palette, Width: b.Dx(), Height: b.Dy()}}
+	add := func(f *image.Paletted, delay int) {
+		g.Image, g.Delay, g.Disposal = append(g.Image, f), append(g.Delay, delay), append(g.Disposal, gif.DisposalNone)
+	}
+
+	prev := typingStep{0, 0}
+	prevCursor := cursor(prev)
+	add(frame(b, prev, prevCursor), t.Delay)
+
+	for _, s := range t.steps(lines, cell, b.Max.X) {
+		c := cursor(s)
+		// rows from the previous step to this one have changed
+		rect := image.Rect(0, lines[prev.row].Min.Y, b.Max.X, lines[s.row].Max.Y)
+		if prev.row == s.row {
+			rect.Min.X, rect.Max.X = prev.x, s.x
+		}
+		rect = rect.Union(prevCursor).Union(c).Intersect(b)
+		if !rect.Empty() {
+			add(frame(rect, s, c), t.Delay)
+		}
+		prev, prevCursor = s, c
+	}
+
+	add(img, typingHold)
+	return g, nil
+}
@@ -0,0 +1,74 @@
+package kkformat
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestTypingSteps(t *testing.T) {
+	lines := []image.Rectangle{image.Rect(0, 0, 40, 16), image.Rect(0, 16, 20, 32)}
+
+	ty := Typing{MaxFrames: typingMaxFrames}
+	if s := ty.steps(lines, 8, 100); len(s) != 2 || s[1] != (typingStep{1, 100}) {
+		t.Error(s)
+	}
+
+	// cells end at 24 and 32 in the first row, the second row has no cells
+	ty.ByCell = true
+	if s := ty.steps(lines, 8, 100); len(s) != 4 || s[0] != (typingStep{0, 24}) || s[2] != (typingStep{0, 100}) {
+		t.Error(s)
+	}
+
+	// merged steps still end with the whole image
+	ty.MaxFrames = 4
+	if s := ty.steps(lines, 8, 100); len(s) != 2 || s[1] != (typingStep{1, 100}) {
+		t.Error(s)
+	}
+}
+
+func TestAnimate(t *testing.T) {
+	rd := &Renderer{Columns: 20, LineHeight: 16, Face: basicfont.Face7x13, Theme: WhiteTheme}
+	bg := image.NewPaletted(image.Rect(0, 0, 200, 16*10), GetPalette())
+	draw.Draw(bg, bg.Bounds(), rd.Theme[TNBackground], image.Point{}, draw.Src)
+	dst := image.NewPaletted(bg.Rect, bg.Palette)
+	copy(dst.Pix, bg.Pix)
+
+	res, err := rd.Render(context.Background(), dst, []byte(strings.Repeat("line\n", 5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Lines) != res.Rows {
+		t.Fatal(res.Lines, res.Rows)
+	}
+
+	for _, ty := range []Typing{{}, {ByCell: true, MaxFrames: 6}} {
+		g, err := rd.Animate(res, bg, ty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		max := ty.MaxFrames
+		if max == 0 {
+			max = typingMaxFrames
+		}
+		if n := len(g.Image); n < 3 || n > max || len(g.Delay) != n {
+			t.Error(ty, n)
+		}
+		if last := g.Image[len(g.Image)-1]; last != res.Image {
+			t.Error(ty, "the last frame is not the image")
+		}
+		for _, f := range g.Image {
+			if !f.Rect.In(res.Image.Bounds()) {
+				t.Error(ty, f.Rect)
+			}
+		}
+	}
+
+	if _, err := rd.Animate(&Result{Image: image.NewRGBA(bg.Rect)}, bg, Typing{}); err == nil {
+		t.Error("RGBA images can't be animated")
+	}
+}
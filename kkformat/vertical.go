@@ -0,0 +1,145 @@
+package kkformat
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"golang.org/x/image/math/fixed"
+)
+
+// In vertical (tategaki) mode, every line produced by the formatter becomes a column of "Columns" half cells,
+// columns are laid out from right to left, if the image is not wide enough, a new band of columns
+// will be started below the current one. Full wide runes stay upright (punctuations are replaced
+// by their vertical forms), half wide runes are rotated 90 degrees clockwise. Ruby annotations are drawn on
+// the right side of the column.
+
+var verticalForms = map[rune]rune{
+	'，': '︐', '、': '︑', '。': '︒', '：': '︓', '；': '︔', '！': '︕', '？': '︖',
+	'…': '︙', '‥': '︰', '—': '︱', '―': '︱', 'ー': '︱', '～': '︴',
+	'（': '︵', '）': '︶', '｛': '︷', '｝': '︸', '〔': '︹', '〕': '︺',
+	'【': '︻', '】': '︼', '《': '︽', '》': '︾', '〈': '︿', '〉': '﹀',
+	'「': '﹁', '」': '﹂', '『': '﹃', '』': '﹄', '［': '﹇', '］': '﹈',
+}
+
+// verticalGeometry returns the height of a half cell, the width of a column,
+// the height of a band and the number of columns in a band
+func (o *Formatter) verticalGeometry() (cellH, colW, bandH, perBand int) {
+	cellH = o.Pos.Dx + 1
+	colW = o.LineHeight
+	if o.ruby {
+		colW += o.LineHeight / 2
+	}
+	bandH = (int(o.Columns) + 4) * cellH
+	if perBand = (o.Img.Dst.Bounds().Dx() - 2*(o.Pos.Dx*2+2)) / colW; perBand < 1 {
+		perBand = 1
+	}
+	return
+}
+
+// columnOrigin returns the top left corner of the nth column
+func (o *Formatter) columnOrigin(n int) (x, y int) {
+	_, colW, bandH, perBand := o.verticalGeometry()
+	band, c := n/perBand, n%perBand
+	x = o.Img.Dst.Bounds().Dx() - (o.Pos.Dx*2 + 2) - (c+1)*colW
+	y = o.LineHeight/2 + band*(bandH+o.LineHeight)
+	return
+}
+
+// nextColumn moves the pen to the top of the current column (Rows - 1), it returns false if the column is out of the image
+func (o *Formatter) nextColumn() bool {
+	cellH, _, bandH, _ := o.verticalGeometry()
+	x, y := o.columnOrigin(o.Rows - 1)
+	if y+bandH > o.Img.Dst.Bounds().Dy() {
+		return false
+	}
+
+	o.Pos.X = x
+	o.Pos.Y = y + 2*cellH
+	return true
+}
+
+// drawColumnMark draws the line wrap marks above or below the current column
+func (o *Formatter) drawColumnMark(r rune, atEnd bool) {
+	cellH, _, _, _ := o.verticalGeometry()
+	x, y := o.columnOrigin(o.Rows - 1)
+	if atEnd {
+		y += (int(o.Columns) + 2) * cellH
+	}
+	o.drawUpright(r, x, y, o.Pos.Dx, 2*cellH)
+}
+
+func (o *Formatter) drawRuneVertical(r rune) {
+	cellH := o.Pos.Dx + 1
+	if RuneWidth(r) == 2 {
+		if v, ok := verticalForms[r]; ok {
+			r = v
+		}
+		o.drawUpright(r, o.Pos.X, o.Pos.Y, o.Pos.Dx*2, 2*cellH)
+		o.Pos.Y += 2 * cellH
+		return
+	}
+
+	o.drawRotated(r, o.Pos.X, o.Pos.Y)
+	o.Pos.Y += cellH
+}
+
+// drawUpright draws r centered in the cell whose top left corner is (x, y)
+func (o *Formatter) drawUpright(r rune, x, y, w, h int) {
+	m := o.metrics()
+	asc, gh := m.Ascent.Ceil(), m.Ascent.Ceil()+m.Descent.Ceil()
+
+	o.drawGlyph(fixed.P(x+(o.LineHeight-w)/2, y+(h-gh)/2+asc), r)
+}
+
+// drawRotated draws r rotated 90 degrees clockwise in the half cell whose top left corner is (x, y)
+func (o *Formatter) drawRotated(r rune, x, y int) {
+	m := o.metrics()
+	asc, gh := m.Ascent.Ceil(), m.Ascent.Ceil()+m.Descent.Ceil()
+	adv := o.Pos.Dx + 1
+
+	g := o.glyph(r)
+	if !g.ok {
+		return
+	}
+	dr := g.dr.Add(image.Pt(0, asc))
+
+	rot := image.NewAlpha(image.Rect(0, 0, gh, adv))
+	for py := dr.Min.Y; py < dr.Max.Y; py++ {
+		for px := dr.Min.X; px < dr.Max.X; px++ {
+			if px < 0 || px >= adv || py < 0 || py >= gh {
+				continue
+			}
+
+			// (px, py) -> (gh - 1 - py, px)
+			rot.SetAlpha(gh-1-py, px, color.Alpha{g.alphaAt(px-dr.Min.X, py-dr.Min.Y)})
+		}
+	}
+
+	x += (o.LineHeight - gh) / 2
+	draw.DrawMask(o.Img.Dst, image.Rect(x, y, x+gh, y+adv), o.Img.Src, image.Point{}, rot, image.Point{}, draw.Over)
+}
+
+// verticalImage crops the rendered columns out of the canvas
+func (o *Formatter) verticalImage() image.Image {
+	bounds := o.Img.Dst.Bounds()
+	if o.Rows == 0 {
+		return o.Img.Dst.(IImage).SubImage(image.Rect(0, 0, bounds.Dx(), 0))
+	}
+
+	_, _, bandH, perBand := o.verticalGeometry()
+	x, y := o.columnOrigin(o.Rows - 1)
+
+	left := 0
+	if o.Rows <= perBand {
+		left = x - (o.Pos.Dx*2 + 2)
+	}
+
+	o.Pos.Y = y + bandH + o.LineHeight/2
+	height := o.Pos.Y
+	if height > bounds.Dy() {
+		height = bounds.Dy()
+	}
+
+	return o.Img.Dst.(IImage).SubImage(image.Rect(left, 0, bounds.Dx(), height))
+}
@@ -0,0 +1,123 @@
+package kkformat
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// boxFace draws every non ASCII rune as a full box and records the runes it was asked for
+type boxFace struct {
+	*basicfont.Face
+	asked map[rune]bool
+}
+
+func (f *boxFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	f.asked[r] = true
+	if r < utf8.RuneSelf {
+		return f.Face.Glyph(dot, r)
+	}
+	dr := image.Rect(1, -11, 13, 1).Add(image.Pt(dot.X.Round(), dot.Y.Round()))
+	return dr, image.NewUniform(color.Opaque), image.Point{}, fixed.I(14), true
+}
+
+func testVertical(t *testing.T, src string, columns uint32) (*Formatter, *Result) {
+	face := &boxFace{Face: basicfont.Face7x13, asked: map[rune]bool{}}
+	fo := &Formatter{
+		Source:     []byte(src),
+		Columns:    columns,
+		LineHeight: 16,
+		Theme:      WhiteTheme,
+		Vertical:   true,
+		Img:        &font.Drawer{Dst: image.NewRGBA(image.Rect(0, 0, 300, 400)), Face: face},
+	}
+	defer dropAtlas(face)
+
+	res, err := fo.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fo, res
+}
+
+// inkOf returns the bounds of the non transparent pixels of img inside r
+func inkOf(img image.Image, r image.Rectangle) image.Rectangle {
+	ink := image.Rectangle{}
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				ink = ink.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return ink
+}
+
+// columnRect returns the area of the nth column, wrap marks excluded
+func columnRect(fo *Formatter, n int) image.Rectangle {
+	cellH, colW, _, _ := fo.verticalGeometry()
+	x, y := fo.columnOrigin(n)
+	return image.Rect(x, y+2*cellH, x+colW, y+(int(fo.Columns)+2)*cellH)
+}
+
+func TestVerticalColumns(t *testing.T) {
+	fo, res := testVertical(t, "一\n一一一", 10)
+	if res.Rows != 2 {
+		t.Fatal(res.Rows)
+	}
+
+	// the first line is the rightmost column
+	first, second := columnRect(fo, 0), columnRect(fo, 1)
+	if first.Min.X < second.Max.X {
+		t.Fatal(first, second)
+	}
+
+	ink1, ink2 := inkOf(res.Image, first), inkOf(res.Image, second)
+	if ink1.Empty() || ink2.Empty() || ink2.Dy() < ink1.Dy()*2 {
+		t.Error(ink1, ink2)
+	}
+	if b := res.Image.Bounds(); !second.In(b) || b.Max.X != 300 {
+		t.Error(b)
+	}
+}
+
+func TestVerticalForms(t *testing.T) {
+	fo, _ := testVertical(t, "一。（一）「一」", 20)
+	asked := fo.Img.Face.(*boxFace).asked
+	for h, v := range map[rune]rune{'。': '︒', '（': '︵', '）': '︶', '「': '﹁', '」': '﹂'} {
+		if asked[h] || !asked[v] {
+			t.Errorf("%q %q", h, v)
+		}
+	}
+}
+
+func TestVerticalLatin(t *testing.T) {
+	// hyphens become a thin vertical stroke when rotated
+	fo, res := testVertical(t, "------", 10)
+	ink := inkOf(res.Image, columnRect(fo, 0))
+	if ink.Empty() || ink.Dx() > 2 || ink.Dy() < 5*(fo.Pos.Dx+1) {
+		t.Error(ink)
+	}
+}
+
+func TestVerticalKinsoku(t *testing.T) {
+	// the full stop stays at the end of the column instead of starting a new one
+	fo, res := testVertical(t, "一一一一一。", 10)
+	if res.Rows != 1 || !fo.Img.Face.(*boxFace).asked['︒'] {
+		t.Error(res.Rows)
+	}
+	col, cellH := columnRect(fo, 0), fo.Pos.Dx+1
+	if ink := inkOf(res.Image, image.Rect(col.Min.X, col.Max.Y, col.Max.X, col.Max.Y+2*cellH)); ink.Empty() {
+		t.Error("the full stop is not drawn below the column")
+	}
+
+	if _, res = testVertical(t, "一一一一一一", 10); res.Rows != 2 {
+		t.Error(res.Rows)
+	}
+}
@@ -0,0 +1,47 @@
+package kkformat
+
+import (
+	"image"
+	"image/draw"
+
+	"golang.org/x/image/math/fixed"
+)
+
+// Watermark draws text at the bottom right corner of img in the TNLineWrap colour. Glyphs are scaled down
+// to half of their size, so the text is tiny and stays in the margin under the last row.
+func (r *Renderer) Watermark(img draw.Image, text string) {
+	s := []rune(text)
+	if len(s) == 0 {
+		return
+	}
+
+	// masks are drawn at full size first, then every 2x2 block becomes a pixel
+	m := cachedMetrics(r.Face)
+	h := m.Ascent.Ceil() + m.Descent.Ceil()
+	full := image.NewAlpha(image.Rect(0, 0, r.textWidth(s), h))
+	dot := fixed.P(0, m.Ascent.Ceil())
+	for _, c := range s {
+		g := cachedGlyph(r.Face, c)
+		if g.ok {
+			dr := g.dr.Add(image.Pt(dot.X.Round(), dot.Y.Round()))
+			draw.DrawMask(full, dr, image.Opaque, image.Point{}, g.mask, g.maskp, draw.Over)
+		}
+		dot.X += g.advance
+	}
+
+	small := image.NewAlpha(image.Rect(0, 0, (full.Rect.Dx()+1)/2, (h+1)/2))
+	for y := 0; y < small.Rect.Dy(); y++ {
+		for x := 0; x < small.Rect.Dx(); x++ {
+			sum := 0
+			for _, p := range [4]image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				sum += int(full.AlphaAt(x*2+p.X, y*2+p.Y).A)
+			}
+			small.Pix[y*small.Stride+x] = uint8(sum / 4)
+		}
+	}
+
+	b := img.Bounds()
+	pad := r.LineHeight / 8
+	at := image.Pt(b.Max.X-small.Rect.Dx()-pad, b.Max.Y-small.Rect.Dy()-pad)
+	draw.DrawMask(img, small.Rect.Add(at), r.Theme[TNLineWrap], image.Point{}, small, image.Point{}, draw.Over)
+}
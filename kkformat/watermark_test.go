@@ -0,0 +1,29 @@
+package kkformat
+
+import (
+	"image"
+	"image/draw"
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestWatermark(t *testing.T) {
+	rd := &Renderer{LineHeight: 16, Face: basicfont.Face7x13, Theme: WhiteTheme}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 40))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	rd.Watermark(img, "png.cat")
+
+	// 7 glyphs of 7x13 become 25x7 at the bottom right corner
+	marked := image.Rectangle{}
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 100; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r != 0xffff {
+				marked = marked.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if marked.Empty() || marked.Min.X < 100-25-2 || marked.Min.Y < 40-7-2 {
+		t.Error(marked)
+	}
+}
@@ -13,6 +13,8 @@ type word_t struct {
 	len   uint32 // length
 	ty    uint16 // type
 	ty2   uint16 // special type
+	ruby  []rune // ruby annotation
+	style uint8  // inline styles outside code blocks, see emphasis.go
 }
 
 func (w *word_t) setIsNaturalStart() {
@@ -24,12 +26,12 @@ func (w *word_t) isNaturalStart() bool {
 }
 
 func (w *word_t) setType(ty uint16) *word_t {
-	w.ty = (w.ty & 0xc000) + (ty << 2 >> 2)
+	w.ty = (w.ty & 0xe000) + (ty << 3 >> 3)
 	return w
 }
 
 func (w *word_t) getType() uint16 {
-	return w.ty << 2 >> 2
+	return w.ty << 3 >> 3
 }
 
 func (w *word_t) setSpecialType(ty uint16) *word_t {
@@ -50,6 +52,16 @@ func (w *word_t) isCode() bool {
 	return (w.ty << 1 >> 15) == 1
 }
 
+// setIsRTL marks the word as a part of a right to left paragraph
+func (w *word_t) setIsRTL() *word_t {
+	w.ty = w.ty | 0x2000
+	return w
+}
+
+func (w *word_t) isRTL() bool {
+	return (w.ty << 2 >> 15) == 1
+}
+
 func (w *word_t) setLen(l uint32) *word_t {
 	w.len = l
 	return w
@@ -76,9 +88,10 @@ func (w *word_t) dup() *word_t {
 }
 
 // split splits the word into multiple parts or nil:
-// first part (if exists) is "width1" long and rest parts (if exist) are all "width2" long
+// first part (if exists) is "width1" long and rest parts (if exist) are all "width2" long,
+// nil will be returned if width2 is 0, the word could never be split
 func (w *word_t) split(width1, width2 uint32) words_t {
-	if w.getLen() <= width1 || w.getLen() <= width2 {
+	if w.getLen() <= width1 || w.getLen() <= width2 || width2 == 0 {
 		return nil
 	}
 
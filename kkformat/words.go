@@ -2,10 +2,8 @@ package kkformat
 
 import (
 	"fmt"
-	"strconv"
-
 	"image"
-	"image/draw"
+	"strconv"
 
 	"golang.org/x/image/math/fixed"
 )
@@ -42,14 +40,22 @@ func (w *words_t) adjustableJoin(opt *Formatter) bool {
 		words = words[1:]
 	}
 
-	// the leading spaces of 2, 4, 6, 8 ... will be preserved, others will be discarded
-	if l, _ := words[0].surroundingSpaces(); l%2 != 0 {
-		if words.last().getType() == runeContToNext || words[0].isCode() {
+	// the leading spaces of 2, 4, 6, 8 ... will be preserved, others will be discarded,
+	// rows start after their gutters and the prefixes of block constructs
+	first := words.leading()
+	if first == len(words) {
+		first--
+	}
+	hung := first > 0 && words[first-1].getSpecialType() == specialIndent
+	if l, _ := words[first].surroundingSpaces(); l%2 != 0 {
+		word := words[first]
+		if words.last().getType() == runeContToNext || word.isCode() || (!hung && opt.block.indent > 0) {
+			// the first row of an indented line keeps its indentation
 			// ignore
-		} else if !naturalEnd || !words[0].isNaturalStart() {
-			words[0].value = words[0].value[l:]
-			if words[0].len -= l; words[0].len == 0 {
-				words = words[1:]
+		} else if !naturalEnd || !word.isNaturalStart() {
+			word.value = word.value[l:]
+			if word.len -= l; word.len == 0 {
+				words = append(words[:first], words[first+1:]...)
 			}
 		}
 	}
@@ -70,13 +76,14 @@ func (w *words_t) adjustableJoin(opt *Formatter) bool {
 
 	for i, word := range words {
 		// fmt.Println(string(word.value), word.len, word.getType())
-		if word.len > 0 {
+		if word.len > 0 || word.getType() == runeMark || word.getSpecialType() > specialLineNumber {
 			if word.getType() == runeExtraAtEnd || word.getType() == runeContToNext {
 				exEnding = word
 				continue
 			}
 
-			if i < len(words)-1 {
+			// no spaces will be inserted into `code`
+			if i < len(words)-1 && (word.style&styleCode == 0 || words[i+1].style&styleCode == 0) {
 				if t := word.getType(); t == runeFullDelim || t == runeHalfDelim {
 					opt.wd = append(opt.wd, word)
 				}
@@ -112,17 +119,17 @@ func (w *words_t) adjustableJoin(opt *Formatter) bool {
 		return opt.wp.join(opt)
 	}
 
-	if opt.wp[0].startsWith("  ") {
+	if fillstart = uint32(opt.wp.leading()); fillstart == 0 && opt.wp[0].startsWith("  ") {
 		fillstart = 1
 	}
-
-	ln := uint32(len(opt.wp)) - 1 - fillstart
-	lnh := uint32(len(opt.wd))
-	if ln == 0 {
+	if fillstart >= uint32(len(opt.wp))-1 {
 		setEx()
 		return opt.wp.join(opt)
 	}
 
+	ln := uint32(len(opt.wp)) - 1 - fillstart
+	lnh := uint32(len(opt.wd))
+
 	// fmt.Println("===", lnh, gap)
 
 	if lnh >= gap {
@@ -171,48 +178,132 @@ func (w *words_t) join(opt *Formatter) bool {
 	opt.Rows++
 	dy := opt.LineHeight
 
-	if opt.Pos.Y+dy+dy/2 > opt.Img.Dst.Bounds().Dy() {
-		return false
+	if opt.Vertical {
+		if !opt.nextColumn() {
+			opt.Rows--
+			return false
+		}
+	} else {
+		extra := 0
+		if words.hasRuby() {
+			// leave room for the annotations above
+			extra = dy / 2
+		}
+
+		if opt.Pos.Y+dy+extra+dy/2 > opt.Img.Dst.Bounds().Dy() {
+			opt.Rows--
+			return false
+		}
+
+		opt.Pos.Y += dy + extra
 	}
 
-	opt.Pos.Y += dy
 	dx := opt.Pos.Dx
 	var exEnding bool
 
+	for _, word := range words {
+		if tn, ok := lineBackgrounds[word.getSpecialType()]; ok {
+			opt.drawLineBackground(tn)
+			break
+		}
+	}
+	opt.drawBlock()
+
+	// right to left paragraphs are aligned to the right, their line wrap marks are mirrored
+	rtl := false
+	for _, word := range words {
+		if t := word.getType(); t != runeContFromPrev && t != runeContToNext {
+			rtl = !opt.Vertical && word.isRTL() && !word.isCode()
+			break
+		}
+	}
+
 	if len(words) > 0 && words[0].getType() == runeContFromPrev {
-		opt.Img.Dot = fixed.P(1, opt.Pos.Y+dy/4)
 		opt.Img.Src = opt.Theme[TNLineWrap]
-		opt.Img.DrawString("\u2937")
+		if opt.Vertical {
+			opt.drawColumnMark('\u2937', false)
+		} else if rtl {
+			opt.Img.Dot = fixed.P((dx+1)*(int(opt.Columns)+2), opt.Pos.Y+dy/4)
+			opt.drawGlyph(opt.Img.Dot, '\u2936')
+		} else {
+			opt.Img.Dot = fixed.P(1, opt.Pos.Y+dy/4)
+			opt.drawGlyph(opt.Img.Dot, '\u2937')
+		}
 		opt.Img.Src = opt.Theme[TNNormal]
 		words = words[1:]
 	}
 
 	if len(words) > 0 && words.last().getType() == runeContToNext {
-		opt.Img.Dot = fixed.P((dx+1)*(int(opt.Columns)+2), opt.Pos.Y+dy/4)
 		opt.Img.Src = opt.Theme[TNLineWrap]
-		opt.Img.DrawString("\u2936")
+		if opt.Vertical {
+			opt.drawColumnMark('\u2936', true)
+		} else if rtl {
+			opt.Img.Dot = fixed.P(1, opt.Pos.Y+dy/4)
+			opt.drawGlyph(opt.Img.Dot, '\u2937')
+		} else {
+			opt.Img.Dot = fixed.P((dx+1)*(int(opt.Columns)+2), opt.Pos.Y+dy/4)
+			opt.drawGlyph(opt.Img.Dot, '\u2936')
+		}
 		opt.Img.Src = opt.Theme[TNNormal]
 		words = words[:len(words)-1]
 		exEnding = true
 	}
 
-	opt.Pos.X = dx*2 + 2
+	if !opt.Vertical {
+		opt.Pos.X = dx*2 + 2
+
+		needBidi := rtl
+		for i := 0; i < len(words) && !needBidi; i++ {
+			needBidi = hasRTL(words[i].value)
+		}
+		if needBidi {
+			opt.layoutBidi(words, opt.Pos.X, rtl)
+		}
+	}
 
 	for i := 0; i < len(words); i++ {
 		word := words[i]
 		opt.Img.Src = opt.Theme[TNNormal]
+		if word.getType() == runeMark {
+			opt.marking = word.value[0] == markOn
+			continue
+		}
+		x0, y0 := opt.Pos.X, opt.Pos.Y
+		if opt.bidi != nil && opt.bidiIdx < len(opt.bidi) {
+			x0 = opt.bidi[opt.bidiIdx].x
+		}
 
 		drawWord := func() {
 			drawR := func(r rune) {
-				dr, mask, maskp, _, ok := opt.Img.Face.Glyph(opt.Img.Dot, r)
-				if !ok {
-					return
-				}
-				draw.DrawMask(opt.Img.Dst, dr, opt.Img.Src, image.Point{}, mask, maskp, draw.Over)
+				opt.drawGlyph(opt.Img.Dot, r)
 			}
 
 			for _, r := range word.value {
-				if w := RuneWidth(r); w == 1 {
+				if opt.Vertical {
+					opt.drawRuneVertical(r)
+					continue
+				}
+
+				if opt.bidi != nil {
+					if opt.bidiIdx >= len(opt.bidi) {
+						break
+					}
+					g := opt.bidi[opt.bidiIdx]
+					opt.bidiIdx++
+					if RuneWidth(r) == 2 {
+						g.x++
+					}
+					opt.Img.Dot = fixed.P(g.x, opt.Pos.Y)
+					drawR(g.r)
+					continue
+				}
+
+				w := RuneWidth(r)
+				if opt.marking && opt.pane != nil {
+					opt.drawRuneBackground(opt.Pos.X, int(w)*(dx+1), opt.pane.markTN)
+				}
+
+				if w == 1 {
 					opt.Img.Dot = fixed.P(opt.Pos.X, opt.Pos.Y)
 					drawR(r)
 					opt.Pos.X += dx + 1
@@ -225,11 +316,30 @@ func (w *words_t) join(opt *Formatter) bool {
 			}
 		}
 
-		if word.getSpecialType() == specialLineNumber {
+		if word.getSpecialType() >= specialLineNumber {
 			opt.Img.Src = opt.Theme[TNLineNumber]
 			drawWord()
+		} else if word.getSpecialType() == specialCallout {
+			if opt.Vertical || opt.bidi != nil {
+				opt.Img.Src = opt.Theme[TNComment]
+				drawWord()
+			} else {
+				opt.drawCallout(word)
+			}
 		} else if !word.isCode() {
+			opt.Img.Src, opt.style = opt.Theme[word.styleTN()], word.style
 			drawWord()
+			opt.style = 0
+
+			if word.style&styleStrike != 0 && !opt.Vertical && opt.bidi == nil {
+				// the line continues to the next word if it is struck through too
+				x1 := opt.Pos.X
+				if i == len(words)-1 || words[i+1].style&styleStrike == 0 {
+					_, r := word.surroundingSpaces()
+					x1 -= int(r) * (dx + 1)
+				}
+				opt.drawStrike(x0, x1)
+			}
 		} else {
 			opt.Img.Src = opt.Theme[TNNormal]
 
@@ -272,9 +382,30 @@ func (w *words_t) join(opt *Formatter) bool {
 			drawWord()
 		}
 
+		if word.ruby != nil {
+			opt.drawRuby(word, x0, y0)
+		}
+
 		opt.Img.Src = opt.Theme[TNNormal]
 	}
 
+	if opt.bidi != nil {
+		opt.Pos.X = opt.bidiEnd
+		opt.bidi = nil
+	}
+
+	if !opt.Vertical && opt.Pos.X > opt.maxX {
+		opt.maxX = opt.Pos.X
+	}
+	if !opt.Vertical {
+		// the row takes the room of annotations above it too
+		top := 0
+		if n := len(opt.rows); n > 0 {
+			top = opt.rows[n-1].Max.Y
+		}
+		opt.rows = append(opt.rows, image.Rect(0, top, opt.Pos.X, opt.rowTop()+opt.LineHeight))
+	}
+
 	if !exEnding && (opt.curSpecial == specialCommentHash || opt.curSpecial == specialComment) {
 		opt.curSpecial = specialNone
 	}
@@ -282,6 +413,18 @@ func (w *words_t) join(opt *Formatter) bool {
 	return true
 }
 
+// leading returns the number of gutters and prefixes of block constructs at the start of the row
+func (w *words_t) leading() int {
+	i := 0
+	for _, word := range *w {
+		if sp := word.getSpecialType(); sp != specialIndent && (sp < specialLineNumber || !word.isCode() || word.getType() != runeSpace) {
+			break
+		}
+		i++
+	}
+	return i
+}
+
 func (w *words_t) last() *word_t {
 	if len(*w) == 0 {
 		return nil
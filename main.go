@@ -3,22 +3,35 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"hash/crc32"
+	"html"
 	"image"
 	"image/color"
 	"image/draw"
+	"image/gif"
+	"image/jpeg"
 	"image/png"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -36,17 +49,32 @@ var sitename = flag.String("n", "png.cat", "site name")
 var truereferer = flag.String("r", "http://127.0.0.1:8102", "referer")
 var listen = flag.String("l", ":8102", "listen address")
 var production = flag.Bool("pd", false, "go production")
+var rendertime = flag.Int("rt", 2000, "max milliseconds of a rendering")
+var watermark = flag.Bool("wm", false, "draw the site name at the corner of images")
+var tokenhash = flag.Bool("th", false, "embed the sha256 of tokens into images as PNG tEXt chunks")
+
+// version is set at build time by -ldflags "-X main.version=..."
+var version = "dev"
 
 const (
 	rawmaxsize = 512 * 1024
+	imgmaxsize = 4 * 1024 * 1024
 	cooldown   = 60
 	imgW       = 756
 	imgH       = 5000
+	maxRunes   = 16 * 1024
+
+	smallmaxsize = 256 * 1024 // larger images are split into pages
+	diffmaxsize  = 512 * 1024 // larger diffs are refused
+	gifMaxFrames = 100        // frames of /g/ animations
+	cardW        = 1200       // size of card images for link previews
+	cardH        = 630
 )
 
 var (
 	palette         = append(kkformat.GetPalette(), color.RGBA{0xf6, 0xf7, 0xeb, 255})
 	drawers         = drawerpool.NewPool(10, imgW, imgH, func(d *font.Drawer) { d.Dst.(*image.Paletted).Palette = palette })
+	diffDrawers     = drawerpool.NewPool(2, imgW*2, imgH, func(d *font.Drawer) { d.Dst.(*image.Paletted).Palette = palette })
 	blackBackground = image.NewPaletted(image.Rect(0, 0, imgW, imgH), palette)
 	whiteBackground = image.NewPaletted(image.Rect(0, 0, imgW, imgH), palette)
 	s1Background    = image.NewPaletted(image.Rect(0, 0, imgW, imgH), palette)
@@ -67,9 +95,11 @@ func checkReferer(r *http.Request) bool {
 	return strings.HasPrefix(r.Referer(), *truereferer)
 }
 
-func serveHeader(w http.ResponseWriter, title string) {
-	w.Header().Add("Content-Type", "text/html")
-	var templ = `<!DOCTYPE html><html><title>` + title + ` - ` + *sitename + `</title>` + static.Header
+// serveHeader writes the page header, head is put into <head> before the charset meta, so the charset is
+// also set in Content-Type
+func serveHeader(w http.ResponseWriter, title string, head ...string) {
+	w.Header().Add("Content-Type", "text/html; charset=utf-8")
+	var templ = `<!DOCTYPE html><html><title>` + title + ` - ` + *sitename + `</title>` + strings.Join(head, "") + static.Header
 	w.Write([]byte(templ))
 }
 
@@ -91,7 +121,7 @@ func serveError(w http.ResponseWriter, r *http.Request, code int, info string) {
 
 func serveIndex(w http.ResponseWriter, r *http.Request) {
 	serveHeader(w, static.NewSnippet)
-	w.Write([]byte(fmt.Sprintf(static.NewSnippetForm, "")))
+	w.Write([]byte(fmt.Sprintf(static.NewSnippetForm, "", kkformat.DefaultTabWidth)))
 	serveFooter(w)
 }
 
@@ -101,10 +131,54 @@ func serveEdit(w http.ResponseWriter, r *http.Request) {
 	if strings.HasSuffix(text, ".png") {
 		text = text[:len(text)-4]
 	}
-	w.Write([]byte(fmt.Sprintf(static.NewSnippetForm, unescape(text))))
+	text, tabs := unescape(text)
+	w.Write([]byte(fmt.Sprintf(static.NewSnippetForm, text, tabStops(tabs))))
+	serveFooter(w)
+}
+
+// serveImport reopens the source embedded in a PNG in the editor
+func serveImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		serveHeader(w, static.Import)
+		w.Write([]byte(static.ImportForm))
+		serveFooter(w)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, imgmaxsize)
+	f, _, err := r.FormFile("png")
+	if err != nil {
+		serveError(w, r, 400, static.NoSource)
+		return
+	}
+	defer f.Close()
+
+	p, err := ioutil.ReadAll(f)
+	if err != nil {
+		serveError(w, r, 400, static.NoSource)
+		return
+	}
+
+	texts, err := pngTexts(p)
+	if err != nil || texts["Source"] == "" {
+		serveError(w, r, 400, static.NoSource)
+		return
+	}
+
+	tabs, _ := strconv.Atoi(texts["Tab-Width"])
+	serveHeader(w, static.NewSnippet)
+	w.Write([]byte(fmt.Sprintf(static.NewSnippetForm, html.EscapeString(texts["Source"]), tabStops(tabs))))
 	serveFooter(w)
 }
 
+// tabStops returns the width of tab stops, 0 means kkformat.DefaultTabWidth
+func tabStops(tabs int) int {
+	if tabs <= 0 {
+		return kkformat.DefaultTabWidth
+	}
+	return tabs
+}
+
 func serveHelp(w http.ResponseWriter, r *http.Request) {
 	serveHeader(w, static.Help)
 	w.Write([]byte(static.HelpPage))
@@ -118,12 +192,24 @@ func isAdmin(r *http.Request) bool {
 	return true
 }
 
-func unescape(s string) string {
+// A token starts with 'a', 'b' or 'c', telling the padding of the base64 string which follows.
+// Tokens of snippets with their own tab width start with 'A', 'B' or 'C' followed by the width in base 36.
+func unescape(s string) (string, int) {
 	t := make([]byte, len(gzipHeader), len(s)+len(gzipHeader))
 	copy(t, gzipHeader)
 
 	if s == "" {
-		return ""
+		return "", 0
+	}
+
+	tabs := 0
+	if c := s[0]; c >= 'A' && c <= 'C' && len(s) > 1 {
+		n, err := strconv.ParseInt(s[1:2], 36, 0)
+		if err != nil {
+			log.Println("unescape:", err)
+			return "", 0
+		}
+		tabs, s = int(n), string(c-'A'+'a')+s[2:]
 	}
 
 	switch s[0] {
@@ -138,7 +224,7 @@ func unescape(s string) string {
 	tu, err := base64.URLEncoding.DecodeString(s)
 	if err != nil {
 		log.Println("unescape:", err)
-		return ""
+		return "", 0
 	}
 
 	block, _ := aes.NewCipher(iv)
@@ -148,19 +234,20 @@ func unescape(s string) string {
 	gz, err := gzip.NewReader(bytes.NewReader(append(t, tu...)))
 	if err != nil {
 		log.Println("unescape:", err)
-		return ""
+		return "", 0
 	}
 
 	buf, err := ioutil.ReadAll(gz)
 	if err != nil {
 		log.Println("unescape:", err)
-		return ""
+		return "", 0
 	}
 
-	return kkformat.BytesToPlane0String(buf)
+	return kkformat.BytesToPlane0String(buf), tabs
 }
 
-func escape(s string) string {
+// escape encodes s into a token, tabs is the width of tab stops, 0 or kkformat.DefaultTabWidth means the default
+func escape(s string, tabs int) string {
 	b := &bytes.Buffer{}
 	gz, _ := gzip.NewWriterLevel(b, gzip.BestCompression)
 	if _, err := gz.Write(kkformat.Plane0StringToBytes(s)); err != nil {
@@ -184,14 +271,17 @@ func escape(s string) string {
 	str := cipher.NewCTR(block, iv)
 	str.XORKeyStream(xbuf, xbuf)
 
-	b64 := base64.URLEncoding.EncodeToString(buf[len(gzipHeader):])
+	b64, prefix := base64.URLEncoding.EncodeToString(buf[len(gzipHeader):]), byte('c')
 	if strings.HasSuffix(b64, "==") {
-		return "a" + b64[:len(b64)-2]
+		b64, prefix = b64[:len(b64)-2], 'a'
+	} else if strings.HasSuffix(b64, "=") {
+		b64, prefix = b64[:len(b64)-1], 'b'
 	}
-	if strings.HasSuffix(b64, "=") {
-		return "b" + b64[:len(b64)-1]
+
+	if tabs > 0 && tabs != kkformat.DefaultTabWidth && tabs <= kkformat.MaxTabWidth {
+		return string(prefix-'a'+'A') + strconv.FormatInt(int64(tabs), 36) + b64
 	}
-	return "c" + b64
+	return string(prefix) + b64
 }
 
 func servePost(w http.ResponseWriter, r *http.Request) {
@@ -225,119 +315,982 @@ func servePost(w http.ResponseWriter, r *http.Request) {
 		ty = "r"
 	}
 
-	content = escape(content)
+	tabs, _ := strconv.Atoi(r.FormValue("tabs"))
+	content = escape(content, tabs)
 	// serveHeader(w, content[:4])
 	// w.Write([]byte(fmt.Sprintf("<img src='/%s/%s'>", ty, content)))
 	// serveFooter(w)
 
-	http.Redirect(w, r, fmt.Sprintf("/%s/%s.png", ty, content), 301)
+	u := fmt.Sprintf("/%s/%s.png", ty, content)
+	q := url.Values{}
+	if hy := r.FormValue("hy"); hy != "" {
+		q.Set("hy", hy)
+	}
+	if r.FormValue("v") != "" {
+		q.Set("v", "1")
+	}
+	if r.FormValue("i") != "" {
+		q.Set("i", "1")
+	}
+	if nums := r.FormValue("nums"); nums == "off" || nums == "prose" {
+		q.Set("nums", nums)
+	}
+	if every, _ := strconv.Atoi(r.FormValue("every")); every > 1 {
+		q.Set("every", strconv.Itoa(every))
+	}
+	if r.FormValue("rule") != "" {
+		q.Set("rule", "1")
+	}
+	if pad, _ := strconv.Atoi(r.FormValue("pad")); pad > 0 {
+		q.Set("pad", strconv.Itoa(pad))
+	}
+	if title := r.FormValue("title"); title != "" {
+		q.Set("title", title)
+	}
+	for _, k := range []string{"bar", "footer", "round", "shadow"} {
+		if r.FormValue(k) != "" {
+			q.Set(k, "1")
+		}
+	}
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	http.Redirect(w, r, u, 301)
+}
+
+// cachedImage is an image in smallCache, the warnings of its rendering are kept so cache hits carry them too
+type cachedImage struct {
+	data     []byte
+	warnings []string
+}
+
+func (c *cachedImage) write(w http.ResponseWriter) {
+	for _, warn := range c.warnings {
+		w.Header().Add("X-Render-Warning", warn)
+	}
+	w.Write(c.data)
+}
+
+// unescapeSmall decodes the text of a /s/ image, it is cut to 10 lines and 2048 bytes
+func unescapeSmall(text string) string {
+	text, _ = url.QueryUnescape(text)
+	line := 1
+	text = simpleEscaper.ReplaceAllStringFunc(text, func(in string) string {
+		if in == " " {
+			return "+"
+		} else if len(in) > 1 {
+			switch in[1] {
+			case 'n':
+				line++
+				return "\n"
+			case 's':
+				return " "
+			case 't':
+				return "\t"
+			case 'l':
+				return "\\"
+			case 'h':
+				return "#"
+			case 'p':
+				return "%"
+			}
+		}
+		return in
+	})
+	if line > 10 {
+		text = strings.Join(strings.Split(text, "\n")[:10], "\n")
+	}
+	if len(text) > 2048 {
+		text = text[:2048]
+	}
+	return text
 }
 
 func serveSmall(prefix string, raw bool) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		text := r.RequestURI[len(prefix):]
-		if strings.HasSuffix(text, ".png") {
-			text = text[:len(text)-4]
+		key := prefix
+		if raw {
+			// tokens are url-safe base64, so the query string can be safely separated
+			text = r.URL.Path[len(prefix):]
+			key += r.URL.RawQuery + "?"
+		}
+
+		format, trimmed := imageFormat(r, text)
+		if trimmed == text {
+			// negotiated by Accept
+			w.Header().Add("Vary", "Accept")
 		}
+		img := &smallImage{prefix: prefix, format: format, token: trimmed}
+		key += format
 
 		if raw {
-			text = unescape(text)
+			img.q = r.URL.Query()
+			img.text, img.tabs = unescape(img.token)
+			// the width of tab stops is in the token, the footer carries the date
+			key += strconv.Itoa(img.tabs) + "?" + parseFrame(img.q).Footer
 		} else {
-			text, _ = url.QueryUnescape(text)
-			line := 1
-			text = simpleEscaper.ReplaceAllStringFunc(text, func(in string) string {
-				if in == " " {
-					return "+"
-				} else if len(in) > 1 {
-					switch in[1] {
-					case 'n':
-						line++
-						return "\n"
-					case 's':
-						return " "
-					case 't':
-						return "\t"
-					case 'l':
-						return "\\"
-					case 'h':
-						return "#"
-					case 'p':
-						return "%"
-					}
+			img.text = unescapeSmall(img.token)
+		}
+
+		if len(img.text) == 0 {
+			w.WriteHeader(400)
+			return
+		}
+
+		start := time.Now()
+		drawer, err := drawers.GetContext(r.Context())
+		if err != nil {
+			// the client has gone
+			return
+		}
+		defer drawer.Free()
+
+		w.Header().Add("Content-Type", "image/"+format)
+		w.Header().Add("Cache-control", "public")
+		if p, ok := smallCache.Get(key + img.text); ok {
+			p.(*cachedImage).write(w)
+			return
+		}
+
+		res, err := img.render(r.Context(), drawer.Drawer)
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+
+			log.Println(err)
+			if err == context.DeadlineExceeded {
+				w.WriteHeader(503)
+			} else {
+				w.WriteHeader(502)
+			}
+			return
+		}
+
+		for _, warn := range res.warnings {
+			w.Header().Add("X-Render-Warning", warn)
+		}
+
+		if res.pages > 1 {
+			// pages are not cached, they are rare
+			w.Header().Add("X-Page-Count", strconv.Itoa(res.pages))
+			if res.page < res.pages-1 {
+				q := r.URL.Query()
+				q.Set("page", strconv.Itoa(res.page+1))
+				// relative to the request, tokens may be long
+				w.Header().Add("Link", fmt.Sprintf("<?%s>; rel=\"next\"", q.Encode()))
+			}
+			w.Write(res.Bytes())
+			return
+		}
+
+		w.Write(res.Bytes())
+		smallCache.Add(key+img.text, &cachedImage{res.Bytes(), res.warnings})
+		log.Println("small:", time.Now().Sub(start).Nanoseconds()/1e6, "ms, size:", res.Len())
+	}
+}
+
+// smallImage holds what an image served by serveSmall is made of
+type smallImage struct {
+	prefix, format string
+	token, text    string
+	tabs           int
+	q              url.Values // the query of /r/ images, nil for /s/ images
+}
+
+// smallResult is an encoded smallImage
+type smallResult struct {
+	*bytes.Buffer
+	size     image.Point // of the encoded image
+	page     int
+	pages    int // images larger than smallmaxsize are split into pages of rows
+	warnings []string
+}
+
+// render renders s onto d and encodes it, it is shared by serveSmall and serveOEmbed so the sizes match
+func (s *smallImage) render(ctx context.Context, d *font.Drawer) (*smallResult, error) {
+	fo := &kkformat.Renderer{
+		Face:       d.Face,
+		LineHeight: drawerpool.LineHeight,
+		Columns:    80,
+		Theme:      kkformat.WhiteTheme,
+		Budget: kkformat.Budget{
+			MaxRunes:    maxRunes,
+			MaxDuration: time.Duration(*rendertime) * time.Millisecond,
+		},
+		TabWidth: s.tabs,
+	}
+
+	var frame kkformat.Frame
+	page, card := 0, false
+	if s.q != nil {
+		parseOptions(fo, s.q)
+		frame = parseFrame(s.q)
+		page, _ = strconv.Atoi(s.q.Get("page"))
+		card = s.q.Get("card") == "1"
+	}
+
+	switch s.prefix {
+	case "/s/", "/r/":
+		copy(d.Dst.(*image.Paletted).Pix, whiteBackground.Pix)
+		fo.Theme = kkformat.WhiteTheme
+	case "/sW/", "/rW/":
+		copy(d.Dst.(*image.Paletted).Pix, whiteBackground.Pix)
+		fo.Theme = kkformat.PureWhiteTheme
+	case "/sb/", "/rb/":
+		copy(d.Dst.(*image.Paletted).Pix, blackBackground.Pix)
+		fo.Theme = kkformat.BlackTheme
+	case "/sB/", "/rB/":
+		copy(d.Dst.(*image.Paletted).Pix, blackBackground.Pix)
+		fo.Theme = kkformat.PureBlackTheme
+	case "/s1/":
+		copy(d.Dst.(*image.Paletted).Pix, s1Background.Pix)
+		fo.Theme = kkformat.WhiteTheme
+	}
+
+	res, err := fo.Render(ctx, d.Dst, []byte(s.text))
+	if err != nil {
+		return nil, err
+	}
+
+	img := res.Image
+	if res.Rows == 1 && !fo.Vertical {
+		img = img.(kkformat.IImage).SubImage(image.Rect(res.TextBounds.Min.X, 0, res.TextBounds.Max.X+1, fo.LineHeight*3/2))
+	}
+	lines := res.Lines
+	if card {
+		// link previews take the top of the image, decorations are left out
+		img, lines, frame = fo.Card(res, cardW, cardH), nil, kkformat.Frame{}
+	}
+	// cards are cropped, so the watermark goes on the final image
+	if dst, ok := img.(draw.Image); ok && *watermark {
+		fo.Watermark(dst, *sitename)
+	}
+
+	out := &smallResult{pages: 1, warnings: res.Warnings}
+	encode := func(img image.Image) (*bytes.Buffer, error) {
+		if frame != (kkformat.Frame{}) {
+			img = fo.Decorate(img, frame)
+		}
+		out.size = img.Bounds().Size()
+		return encodeImage(img, s.format, s.token,
+			[2]string{"Source", s.text},
+			[2]string{"Columns", strconv.Itoa(int(fo.Columns))},
+			[2]string{"Theme", strings.Trim(s.prefix, "/")},
+			[2]string{"Tab-Width", strconv.Itoa(tabStops(s.tabs))},
+			[2]string{"Generator", "eighty " + version})
+	}
+
+	out.Buffer, err = encode(img)
+	if err == nil && out.Len() > smallmaxsize {
+		// too large, try fewer colours first, then split rows into pages
+		img = reduceColors(img, 16)
+		out.Buffer, err = encode(img)
+		for n := 2; err == nil && out.Len() > smallmaxsize && n/2 < len(lines); n *= 2 {
+			var sub image.Image
+			sub, out.page, out.pages = pageOf(img, lines, n, page)
+			out.Buffer, err = encode(sub)
+		}
+	}
+	if err == nil && out.Len() > smallmaxsize {
+		err = fmt.Errorf("image too large: %d bytes", out.Len())
+	}
+	return out, err
+}
+
+// encodePNG encodes img with meta written into iTXt chunks, the sha256 of token is embedded into a tEXt chunk if -th is set
+func encodePNG(img image.Image, token string, meta ...[2]string) (*bytes.Buffer, error) {
+	b := &bytes.Buffer{}
+	e := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := e.Encode(b, compactPalette(img)); err != nil {
+		return nil, err
+	}
+
+	var chunks [][]byte
+	for _, kv := range meta {
+		chunks = append(chunks, pngChunk("iTXt", iTXtData(kv[0], kv[1])))
+	}
+	if *tokenhash {
+		sum := sha256.Sum256([]byte(token))
+		chunks = append(chunks,
+			pngChunk("tEXt", []byte("Token-SHA256\x00"+hex.EncodeToString(sum[:]))),
+			pngChunk("tEXt", []byte("Software\x00"+*sitename)))
+	}
+	if len(chunks) == 0 {
+		return b, nil
+	}
+	return bytes.NewBuffer(insertPNGChunks(b.Bytes(), chunks...)), nil
+}
+
+const pngIHDREnd = 8 + 4 + 4 + 13 + 4 // signature, length, type, data and crc
+
+// pngChunk returns the chunk of typ holding data
+func pngChunk(typ string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], typ)
+	chunk = append(chunk, data...)
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+// insertPNGChunks inserts chunks right after IHDR, which is always the first chunk
+func insertPNGChunks(p []byte, chunks ...[]byte) []byte {
+	out := append(make([]byte, 0, len(p)+len(chunks)*64), p[:pngIHDREnd]...)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return append(out, p[pngIHDREnd:]...)
+}
+
+// iTXtData returns the data of an iTXt chunk without language tags, long texts are compressed
+func iTXtData(key, text string) []byte {
+	b := bytes.NewBufferString(key)
+	if len(text) <= 64 {
+		b.Write([]byte{0, 0, 0, 0, 0})
+		b.WriteString(text)
+		return b.Bytes()
+	}
+
+	b.Write([]byte{0, 1, 0, 0, 0})
+	zw := zlib.NewWriter(b)
+	zw.Write([]byte(text))
+	zw.Close()
+	return b.Bytes()
+}
+
+// pngTexts returns the texts in the tEXt and iTXt chunks of a PNG by their keywords
+func pngTexts(p []byte) (map[string]string, error) {
+	if len(p) < 8 || string(p[:8]) != "\x89PNG\r\n\x1a\n" {
+		return nil, fmt.Errorf("not a png")
+	}
+
+	texts := map[string]string{}
+	for p = p[8:]; len(p) >= 12; {
+		n := binary.BigEndian.Uint32(p)
+		if uint64(n)+12 > uint64(len(p)) {
+			return nil, fmt.Errorf("broken chunk")
+		}
+		typ, data := string(p[4:8]), p[8:8+n]
+		p = p[12+n:]
+
+		key := data
+		if i := bytes.IndexByte(data, 0); i > -1 {
+			key, data = data[:i], data[i+1:]
+		} else {
+			continue
+		}
+
+		switch typ {
+		case "tEXt":
+			texts[string(key)] = string(data)
+		case "iTXt":
+			// compression flag, method, language tag and translated keyword
+			if len(data) < 2 {
+				continue
+			}
+			compressed, rest := data[0] == 1, data[2:]
+			for i := 0; i < 2; i++ {
+				if j := bytes.IndexByte(rest, 0); j > -1 {
+					rest = rest[j+1:]
 				}
-				return in
-			})
-			if line > 10 {
-				text = strings.Join(strings.Split(text, "\n")[:10], "\n")
 			}
-			if len(text) > 2048 {
-				text = text[:2048]
+			if !compressed {
+				texts[string(key)] = string(rest)
+				continue
+			}
+
+			zr, err := zlib.NewReader(bytes.NewReader(rest))
+			if err != nil {
+				return nil, err
+			}
+			text, err := ioutil.ReadAll(io.LimitReader(zr, rawmaxsize))
+			if err != nil {
+				return nil, err
+			}
+			texts[string(key)] = string(text)
+		case "IEND":
+			return texts, nil
+		}
+	}
+	return texts, nil
+}
+
+// imageFormat returns the format of the image requested by the extension of path or else by the
+// Accept header, and path without the extension. Of the types listed in Accept, the one with the
+// highest q wins, the earlier one on ties, png is the default.
+func imageFormat(r *http.Request, path string) (string, string) {
+	for ext, format := range map[string]string{".png": "png", ".gif": "gif", ".jpg": "jpeg", ".jpeg": "jpeg"} {
+		if strings.HasSuffix(path, ext) {
+			return format, path[:len(path)-len(ext)]
+		}
+	}
+
+	format, best := "png", 0.0
+	for _, a := range strings.Split(r.Header.Get("Accept"), ",") {
+		params := strings.Split(a, ";")
+		q := 1.0
+		for _, p := range params[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+				q, _ = strconv.ParseFloat(p[2:], 64)
+			}
+		}
+
+		switch t := strings.TrimSpace(params[0]); t {
+		case "image/png", "image/gif", "image/jpeg":
+			if q > best {
+				format, best = t[len("image/"):], q
 			}
 		}
+	}
+	return format, path
+}
 
-		if len(text) == 0 {
+// encodeImage encodes img in format, meta is only kept by png, see encodePNG
+func encodeImage(img image.Image, format, token string, meta ...[2]string) (*bytes.Buffer, error) {
+	b := &bytes.Buffer{}
+	switch format {
+	case "gif":
+		return b, gif.Encode(b, compactPalette(img), nil)
+	case "jpeg":
+		if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+			// transparent pixels would be black
+			dst := image.NewRGBA(img.Bounds())
+			draw.Draw(dst, dst.Rect, image.White, image.ZP, draw.Src)
+			draw.Draw(dst, dst.Rect, img, dst.Rect.Min, draw.Over)
+			img = dst
+		}
+		return b, jpeg.Encode(b, img, &jpeg.Options{Quality: 90})
+	}
+	return encodePNG(img, token, meta...)
+}
+
+// compactPalette drops the colours not used by img from its palette, png writes paletted images
+// of no more than 16 colours with 4 bits or fewer per pixel
+func compactPalette(img image.Image) image.Image {
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		return img
+	}
+
+	var used [256]bool
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		i := p.PixOffset(p.Rect.Min.X, y)
+		for _, idx := range p.Pix[i : i+p.Rect.Dx()] {
+			used[idx] = true
+		}
+	}
+
+	var remap [256]uint8
+	var pal color.Palette
+	for i, c := range p.Palette {
+		if used[i] {
+			remap[i] = uint8(len(pal))
+			pal = append(pal, c)
+		}
+	}
+	if len(pal) == len(p.Palette) {
+		return img
+	}
+	return remapPaletted(p, pal, remap)
+}
+
+// reduceColors keeps the n most used colours of img, other colours become the nearest ones
+func reduceColors(img image.Image, n int) image.Image {
+	p, ok := img.(*image.Paletted)
+	if !ok || len(p.Palette) <= n {
+		return img
+	}
+
+	var count [256]int
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		i := p.PixOffset(p.Rect.Min.X, y)
+		for _, idx := range p.Pix[i : i+p.Rect.Dx()] {
+			count[idx]++
+		}
+	}
+
+	order := make([]int, len(p.Palette))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return count[order[i]] > count[order[j]] })
+
+	pal := color.Palette{}
+	for _, i := range order[:n] {
+		pal = append(pal, p.Palette[i])
+	}
+	var remap [256]uint8
+	for i, c := range p.Palette {
+		remap[i] = uint8(pal.Index(c))
+	}
+	return remapPaletted(p, pal, remap)
+}
+
+func remapPaletted(p *image.Paletted, pal color.Palette, remap [256]uint8) *image.Paletted {
+	dst := image.NewPaletted(p.Rect, pal)
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		i, j := p.PixOffset(p.Rect.Min.X, y), dst.PixOffset(p.Rect.Min.X, y)
+		for x, idx := range p.Pix[i : i+p.Rect.Dx()] {
+			dst.Pix[j+x] = remap[idx]
+		}
+	}
+	return dst
+}
+
+// pageOf splits the rows of img into n pages and returns the image of page, the page clamped to the
+// pages and the number of pages
+func pageOf(img image.Image, lines []image.Rectangle, n, page int) (image.Image, int, int) {
+	rows := (len(lines) + n - 1) / n
+	pages := (len(lines) + rows - 1) / rows
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	b := img.Bounds()
+	r := image.Rect(b.Min.X, lines[page*rows].Min.Y, b.Max.X, b.Max.Y)
+	if last := (page+1)*rows - 1; last < len(lines)-1 {
+		r.Max.Y = lines[last].Max.Y
+	}
+	if page == 0 {
+		r.Min.Y = b.Min.Y
+	}
+	return img.(kkformat.IImage).SubImage(r.Intersect(b)), page, pages
+}
+
+// parseFrame reads the decorations of an image from the query
+func parseFrame(q url.Values) kkformat.Frame {
+	f := kkformat.Frame{
+		Title:    q.Get("title"),
+		TitleBar: q.Get("bar") == "1",
+	}
+	f.Padding, _ = strconv.Atoi(q.Get("pad"))
+	if q.Get("footer") == "1" {
+		f.Footer = *sitename + " · " + time.Now().Format("2006-01-02")
+	}
+	if q.Get("round") == "1" {
+		f.Border, f.Radius = true, 8
+	}
+	if q.Get("shadow") == "1" {
+		f.Shadow = 16
+	}
+	return f
+}
+
+// parseOptions reads the rendering options of a /r/ image from the query
+func parseOptions(fo *kkformat.Renderer, q url.Values) {
+	fo.Hyphens = kkformat.GetHyphenator(q.Get("hy"))
+	fo.Vertical = q.Get("v") == "1"
+	fo.Indent = q.Get("i") == "1"
+	fo.Gutter.Hide = q.Get("nums") == "off"
+	fo.Gutter.Prose = q.Get("nums") == "prose"
+	fo.Gutter.Every, _ = strconv.Atoi(q.Get("every"))
+	fo.Gutter.Rule = q.Get("rule") == "1"
+}
+
+// servePage serves /p/<token>, a page showing the /r/ image of token with Open Graph and Twitter card tags,
+// the query is passed on to the image
+func servePage(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(r.URL.Path[len("/p/"):], ".png")
+	text, _ := unescape(token)
+	if text == "" {
+		serveError(w, r, 404, static.SnippetNotFound)
+		return
+	}
+
+	img := "/r/" + token + ".png"
+	card := img + "?card=1"
+	if r.URL.RawQuery != "" {
+		img += "?" + r.URL.RawQuery
+		card += "&" + r.URL.RawQuery
+	}
+
+	title, site := html.EscapeString(snippetTitle(text)), siteURL(r)
+	oembed := site + "/oembed?" + url.Values{"url": {site + r.RequestURI}, "format": {"json"}}.Encode()
+	serveHeader(w, title, fmt.Sprintf(static.OpenGraph,
+		html.EscapeString(*sitename), title, html.EscapeString(snippetDescription(text)),
+		html.EscapeString(site+card), html.EscapeString(site+r.RequestURI)),
+		fmt.Sprintf(static.OEmbedLink, html.EscapeString(oembed), title))
+	w.Write([]byte(fmt.Sprintf(static.SnippetPage, html.EscapeString(img), title, html.EscapeString(token), kkformat.RubyHTML(text))))
+	serveFooter(w)
+}
+
+// siteURL returns the scheme and the host of r, which may be behind a proxy
+func siteURL(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// snippetTitle returns the first heading of text, or its first line if there is none, code blocks are skipped
+func snippetTitle(text string) string {
+	first, code := "", false
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") {
+			code = !code
+		}
+		if first == "" && line != "" {
+			first = line
+		}
+		if h := strings.TrimLeft(line, "#"); !code && len(line)-len(h) <= 6 && h != line && strings.HasPrefix(h, " ") {
+			first = strings.TrimSpace(h)
+			break
+		}
+	}
+
+	if s := []rune(first); len(s) > 60 {
+		first = string(s[:59]) + "…"
+	}
+	if first == "" {
+		return static.UntitledSnippet
+	}
+	return first
+}
+
+// snippetDescription returns the first 200 characters of text with spaces collapsed, heading markers and
+// code fences are left out
+func snippetDescription(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") {
+			continue
+		}
+		if h := strings.TrimLeft(line, "#"); len(line)-len(h) <= 6 && strings.HasPrefix(h, " ") {
+			line = h
+		}
+		lines = append(lines, line)
+	}
+
+	s := []rune(strings.Join(strings.Fields(strings.Join(lines, " ")), " "))
+	if len(s) > 200 {
+		return string(s[:199]) + "…"
+	}
+	return string(s)
+}
+
+// oEmbed is the response of /oembed, see https://oembed.com
+type oEmbed struct {
+	Type         string `json:"type"`
+	Version      string `json:"version"`
+	Title        string `json:"title,omitempty"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	URL          string `json:"url,omitempty"`
+	HTML         string `json:"html,omitempty"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// serveOEmbed serves /oembed?url=..., /r/ and /s/ images are photos, /p/ pages are rich. Images larger than
+// maxwidth or maxheight are scaled down in rich responses as they can't be resized.
+func serveOEmbed(w http.ResponseWriter, r *http.Request) {
+	if f := r.FormValue("format"); f != "" && f != "json" {
+		w.WriteHeader(501)
+		return
+	}
+
+	// the response holds the site url, which depends on the scheme and the host
+	site := siteURL(r)
+	key := site + "/oembed?" + r.URL.RawQuery
+	if p, ok := smallCache.Get(key); ok {
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(p.([]byte))
+		return
+	}
+
+	u, err := url.Parse(r.FormValue("url"))
+	if err != nil || (u.Host != "" && u.Host != r.Host) {
+		w.WriteHeader(404)
+		return
+	}
+
+	prefix, path := "", ""
+	if p := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2); len(p) == 2 {
+		prefix, path = p[0], p[1]
+	}
+	res := oEmbed{Type: "photo", Version: "1.0", ProviderName: *sitename, ProviderURL: site + "/"}
+	img := &smallImage{prefix: "/" + prefix + "/"}
+	switch prefix {
+	case "r", "rb", "rW", "rB", "rs1", "p":
+		img.format, img.token = imageFormat(r, path)
+		img.text, img.tabs = unescape(img.token)
+		img.q = u.Query()
+		res.URL = site + u.RequestURI()
+		if prefix == "p" {
+			img.prefix, img.format = "/r/", "png"
+			res.URL = site + "/r/" + img.token + ".png"
+			if u.RawQuery != "" {
+				res.URL += "?" + u.RawQuery
+			}
+			res.Type = "rich"
+		}
+	case "s", "sb", "sW", "sB", "s1":
+		// the query is a part of the text, see serveSmall
+		img.format, img.token = imageFormat(r, strings.TrimPrefix(u.RequestURI(), "/"+prefix+"/"))
+		img.text = unescapeSmall(img.token)
+		res.URL = site + u.RequestURI()
+	}
+	if img.text == "" {
+		w.WriteHeader(404)
+		return
+	}
+
+	size, err := imageSize(r.Context(), img)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		log.Println(err)
+		w.WriteHeader(502)
+		return
+	}
+
+	res.Title, res.Width, res.Height = snippetTitle(img.text), size.X, size.Y
+	maxW, _ := strconv.Atoi(r.FormValue("maxwidth"))
+	maxH, _ := strconv.Atoi(r.FormValue("maxheight"))
+	if maxW > 0 && res.Width > maxW {
+		res.Width, res.Height = maxW, res.Height*maxW/res.Width
+	}
+	if maxH > 0 && res.Height > maxH {
+		res.Width, res.Height = res.Width*maxH/res.Height, maxH
+	}
+	if res.Width != size.X {
+		res.Type = "rich"
+	}
+	if res.Type == "rich" {
+		res.HTML = fmt.Sprintf(`<a href="%s"><img src="%s" width=%d height=%d alt="%s"></a>`, html.EscapeString(site+u.RequestURI()),
+			html.EscapeString(res.URL), res.Width, res.Height, html.EscapeString(res.Title))
+		res.URL = ""
+	}
+
+	b, _ := json.Marshal(res)
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(b)
+	smallCache.Add(key, b)
+}
+
+// imageSize returns the size of img as it is served by serveSmall
+func imageSize(ctx context.Context, img *smallImage) (image.Point, error) {
+	drawer, err := drawers.GetContext(ctx)
+	if err != nil {
+		return image.Point{}, err
+	}
+	defer drawer.Free()
+
+	res, err := img.render(ctx, drawer.Drawer)
+	if err != nil {
+		return image.Point{}, err
+	}
+	return res.size, nil
+}
+
+// serveDiff serves /d/<token1>/<token2>.png, the line diff of two /r/ tokens rendered side by side
+func serveDiff(prefix string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(r.URL.Path[len(prefix):], ".png")
+		tokens := strings.Split(path, "/")
+		if len(tokens) != 2 {
+			w.WriteHeader(400)
+			return
+		}
+
+		old, tabs := unescape(tokens[0])
+		new, newTabs := unescape(tokens[1])
+		if old == "" || new == "" {
 			w.WriteHeader(400)
 			return
 		}
 
 		start := time.Now()
-		drawer := drawers.Get()
+		drawer, err := diffDrawers.GetContext(r.Context())
+		if err != nil {
+			return
+		}
 		defer drawer.Free()
 
 		w.Header().Add("Content-Type", "image/png")
 		w.Header().Add("Cache-control", "public")
-		if p, ok := smallCache.Get(prefix + text); ok {
-			w.Write(p.([]byte))
+		key := prefix + path
+		if p, ok := smallCache.Get(key); ok {
+			p.(*cachedImage).write(w)
+			return
+		}
+
+		fo := &kkformat.Renderer{
+			Face:       drawer.Face,
+			LineHeight: drawerpool.LineHeight,
+			Columns:    80,
+			Theme:      kkformat.WhiteTheme,
+			Budget: kkformat.Budget{
+				MaxRunes:    maxRunes,
+				MaxDuration: time.Duration(*rendertime) * time.Millisecond,
+			},
+			TabWidth: tabs,
+		}
+		if newTabs != 0 {
+			fo.TabWidth = newTabs
+		}
+
+		bg := image.White
+		if prefix == "/db/" {
+			bg, fo.Theme = image.Black, kkformat.BlackTheme
+		}
+		draw.Draw(drawer.Dst, drawer.Dst.Bounds(), bg, image.ZP, draw.Src)
+
+		res, err := fo.RenderSideBySide(r.Context(), drawer.Dst, []byte(old), []byte(new))
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+
+			log.Println(err)
+			if err == context.DeadlineExceeded {
+				w.WriteHeader(503)
+			} else {
+				w.WriteHeader(502)
+			}
+			return
+		}
+
+		for _, warn := range res.Warnings {
+			w.Header().Add("X-Render-Warning", warn)
+		}
+
+		img := res.Image
+		if dst, ok := img.(draw.Image); ok && *watermark {
+			fo.Watermark(dst, *sitename)
+		}
+
+		b, err := encodePNG(img, path, [2]string{"Generator", "eighty " + version})
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(502)
+			return
+		}
+
+		if b.Len() > diffmaxsize {
+			w.WriteHeader(502)
+			return
+		}
+
+		w.Write(b.Bytes())
+		smallCache.Add(key, &cachedImage{b.Bytes(), res.Warnings})
+		log.Println("diff:", time.Now().Sub(start).Nanoseconds()/1e6, "ms, size:", b.Len())
+	}
+}
+
+// serveGIF serves /g/<token>.gif, the typing animation of a /r/ token, the query may set mode=char to
+// type a character at a time, delay between frames in 100ths of a second and the max number of frames
+func serveGIF(prefix string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(r.URL.Path[len(prefix):], ".gif")
+		text, tabs := unescape(path)
+		if text == "" {
+			w.WriteHeader(400)
+			return
+		}
+
+		ty := kkformat.Typing{ByCell: r.FormValue("mode") == "char"}
+		ty.Delay, _ = strconv.Atoi(r.FormValue("delay"))
+		ty.MaxFrames, _ = strconv.Atoi(r.FormValue("frames"))
+		if ty.Delay < 2 || ty.Delay > 100 {
+			ty.Delay = 0
+		}
+		if ty.MaxFrames < 2 || ty.MaxFrames > gifMaxFrames {
+			ty.MaxFrames = gifMaxFrames
+		}
+
+		start := time.Now()
+		drawer, err := drawers.GetContext(r.Context())
+		if err != nil {
+			return
+		}
+		defer drawer.Free()
+
+		w.Header().Add("Content-Type", "image/gif")
+		w.Header().Add("Cache-control", "public")
+		// every option reaching the renderer is in the query, see serveSmall
+		key := prefix + path + "?" + r.URL.RawQuery
+		if p, ok := smallCache.Get(key); ok {
+			p.(*cachedImage).write(w)
 			return
 		}
 
-		fo := &kkformat.Formatter{
-			Source:     []byte(text),
-			Img:        drawer.Drawer,
+		fo := &kkformat.Renderer{
+			Face:       drawer.Face,
 			LineHeight: drawerpool.LineHeight,
 			Columns:    80,
 			Theme:      kkformat.WhiteTheme,
+			Hyphens:    kkformat.GetHyphenator(r.FormValue("hy")),
+			Indent:     r.FormValue("i") == "1",
+			Budget: kkformat.Budget{
+				MaxRunes:    maxRunes,
+				MaxDuration: time.Duration(*rendertime) * time.Millisecond,
+			},
+			TabWidth: tabs,
 		}
 
-		switch prefix {
-		case "/s/", "/r/":
-			copy(drawer.Dst.(*image.Paletted).Pix, whiteBackground.Pix)
-			fo.Theme = kkformat.WhiteTheme
-		case "/sW/", "/rW/":
-			copy(drawer.Dst.(*image.Paletted).Pix, whiteBackground.Pix)
-			fo.Theme = kkformat.PureWhiteTheme
-		case "/sb/", "/rb/":
-			copy(drawer.Dst.(*image.Paletted).Pix, blackBackground.Pix)
-			fo.Theme = kkformat.BlackTheme
-		case "/sB/", "/rB/":
-			copy(drawer.Dst.(*image.Paletted).Pix, blackBackground.Pix)
-			fo.Theme = kkformat.PureBlackTheme
-		case "/s1/":
-			copy(drawer.Dst.(*image.Paletted).Pix, s1Background.Pix)
-			fo.Theme = kkformat.WhiteTheme
+		bg := whiteBackground
+		if prefix == "/gb/" {
+			bg, fo.Theme = blackBackground, kkformat.BlackTheme
 		}
+		copy(drawer.Dst.(*image.Paletted).Pix, bg.Pix)
 
-		img := fo.Render()
+		res, err := fo.Render(r.Context(), drawer.Dst, []byte(text))
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
 
-		if fo.Rows == 1 {
-			img = img.(kkformat.IImage).SubImage(image.Rect(fo.Pos.Dx*2, 0, fo.Pos.X+1, fo.LineHeight*3/2))
+			log.Println(err)
+			if err == context.DeadlineExceeded {
+				w.WriteHeader(503)
+			} else {
+				w.WriteHeader(502)
+			}
+			return
+		}
+
+		for _, warn := range res.Warnings {
+			w.Header().Add("X-Render-Warning", warn)
+		}
+
+		if dst, ok := res.Image.(draw.Image); ok && *watermark {
+			fo.Watermark(dst, *sitename)
+		}
+
+		g, err := fo.Animate(res, bg, ty)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(502)
+			return
 		}
 
 		b := &bytes.Buffer{}
-		if err := png.Encode(b, img); err != nil {
+		if err := gif.EncodeAll(b, g); err != nil {
 			log.Println(err)
 			w.WriteHeader(502)
 			return
 		}
 
-		if b.Len() > 256*1024 {
+		if b.Len() > 2*1024*1024 {
 			w.WriteHeader(502)
 			return
 		}
 
 		w.Write(b.Bytes())
-		smallCache.Add(prefix+text, b.Bytes())
-		log.Println("small:", time.Now().Sub(start).Nanoseconds()/1e6, "ms, size:", b.Len())
+		smallCache.Add(key, &cachedImage{b.Bytes(), res.Warnings})
+		log.Println("gif:", time.Now().Sub(start).Nanoseconds()/1e6, "ms, frames:", len(g.Image), "size:", b.Len())
 	}
 }
 
@@ -360,6 +1313,9 @@ func main() {
 	http.HandleFunc("/edit/", serveEdit)
 	http.HandleFunc("/post", servePost)
 	http.HandleFunc("/help", serveHelp)
+	http.HandleFunc("/import", serveImport)
+	http.HandleFunc("/p/", servePage)
+	http.HandleFunc("/oembed", serveOEmbed)
 	http.HandleFunc("/s/", serveSmall("/s/", false))
 	http.HandleFunc("/sb/", serveSmall("/sb/", false))
 	http.HandleFunc("/sW/", serveSmall("/sW/", false))
@@ -370,6 +1326,10 @@ func main() {
 	http.HandleFunc("/rB/", serveSmall("/rB/", true))
 	http.HandleFunc("/s1/", serveSmall("/s1/", false))
 	http.HandleFunc("/rs1/", serveSmall("/rs1/", true))
+	http.HandleFunc("/d/", serveDiff("/d/"))
+	http.HandleFunc("/db/", serveDiff("/db/"))
+	http.HandleFunc("/g/", serveGIF("/g/"))
+	http.HandleFunc("/gb/", serveGIF("/gb/"))
 
 	ipAccess.m = make(map[string]*ipInfo)
 	go func() {
@@ -389,7 +1349,12 @@ func main() {
 
 			ipAccess.size = 0
 			smallCache.Info(func(k lru.Key, v interface{}, t int64) {
-				ipAccess.size += int64(len(v.([]byte)))
+				switch v := v.(type) {
+				case []byte:
+					ipAccess.size += int64(len(v))
+				case *cachedImage:
+					ipAccess.size += int64(len(v.data))
+				}
 			})
 		}
 	}()
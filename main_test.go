@@ -2,13 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/coyove/eighty/static"
 )
 
 var r = rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -66,3 +75,223 @@ func TestNewSnippet(t *testing.T) {
 		wg.Wait()
 	}
 }
+
+func TestPNGTexts(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	b := &bytes.Buffer{}
+	png.Encode(b, img)
+
+	long := strings.Repeat("long source text ", 100)
+	p := insertPNGChunks(b.Bytes(),
+		pngChunk("tEXt", []byte("Software\x00png.cat")),
+		pngChunk("iTXt", iTXtData("Source", long)),
+		pngChunk("iTXt", iTXtData("Columns", "80")))
+	if _, err := png.Decode(bytes.NewReader(p)); err != nil {
+		t.Fatal(err)
+	}
+
+	texts, err := pngTexts(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texts["Software"] != "png.cat" || texts["Source"] != long || texts["Columns"] != "80" {
+		t.Error(texts)
+	}
+	if _, err := pngTexts([]byte("GIF89a")); err == nil {
+		t.Error("not a png")
+	}
+}
+
+func TestSmallTabWidth(t *testing.T) {
+	src := "a\tb\tc"
+	for _, tabs := range []int{8, 4} {
+		w := httptest.NewRecorder()
+		serveSmall("/r/", true)(w, httptest.NewRequest("GET", "/r/"+escape(src, tabs)+".png", nil))
+		texts, err := pngTexts(w.Body.Bytes())
+		if err != nil {
+			t.Fatal(tabs, err)
+		}
+		if texts["Tab-Width"] != strconv.Itoa(tabs) {
+			t.Error(tabs, texts["Tab-Width"])
+		}
+	}
+}
+
+func TestImageFormat(t *testing.T) {
+	for _, c := range []struct{ path, accept, format, rest string }{
+		{"abc.png", "image/gif", "png", "abc"},
+		{"abc.jpg", "", "jpeg", "abc"},
+		{"abc.gif", "", "gif", "abc"},
+		{"abc", "image/webp,image/gif;q=0.9,image/png", "png", "abc"},
+		{"abc", "image/webp,image/gif", "gif", "abc"},
+		{"abc", "*/*", "png", "abc"},
+		{"abc", "image/gif;q=0.1, image/png", "png", "abc"},
+		{"abc", "image/png;q=0.5, image/jpeg;q=0.8", "jpeg", "abc"},
+		{"abc", "image/gif;q=0", "png", "abc"},
+	} {
+		r, _ := http.NewRequest("GET", "/r/"+c.path, nil)
+		r.Header.Set("Accept", c.accept)
+		if format, rest := imageFormat(r, c.path); format != c.format || rest != c.rest {
+			t.Error(c, format, rest)
+		}
+	}
+}
+
+func TestPalette(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 20, 1), palette)
+	for x := range img.Pix {
+		img.Pix[x] = uint8(x)
+	}
+
+	p := compactPalette(img.SubImage(image.Rect(2, 0, 6, 1))).(*image.Paletted)
+	if len(p.Palette) != 4 || p.At(2, 0) != palette[2] || p.At(5, 0) != palette[5] {
+		t.Error(p.Palette)
+	}
+
+	b := &bytes.Buffer{}
+	png.Encode(b, p)
+	// 4 colours are written with 2 bits per pixel
+	if depth := b.Bytes()[24]; depth != 2 {
+		t.Error(depth)
+	}
+
+	if p := reduceColors(img, 16).(*image.Paletted); len(p.Palette) != 16 || p.Rect != img.Rect {
+		t.Error(p.Palette)
+	}
+}
+
+func TestSnippetTitle(t *testing.T) {
+	for src, want := range map[string]string{
+		"\n  first line\nsecond":                 "first line",
+		"intro\n```sh\n# comment\n```\n## Usage": "Usage",
+		"#hashtag\n####### seven":                "#hashtag",
+		"":                                       static.UntitledSnippet,
+		strings.Repeat("长", 100):                 strings.Repeat("长", 59) + "…",
+	} {
+		if title := snippetTitle(src); title != want {
+			t.Errorf("%q: %q", src, title)
+		}
+	}
+
+	if d := snippetDescription("## a\n\n  b\tc\n```go\nd\n```"); d != "a b c d" {
+		t.Error(d)
+	}
+	if d := []rune(snippetDescription(strings.Repeat("x ", 300))); len(d) != 200 {
+		t.Error(len(d))
+	}
+}
+
+func TestServePage(t *testing.T) {
+	token := escape("吾輩《わがはい》は<b>猫</b>", 0)
+	w := httptest.NewRecorder()
+	servePage(w, httptest.NewRequest("GET", "http://png.cat/p/"+token, nil))
+	if body := w.Body.String(); w.Code != 200 ||
+		!strings.Contains(body, "<ruby>吾輩<rp>（</rp><rt>わがはい</rt><rp>）</rp></ruby>は&lt;b&gt;猫&lt;/b&gt;") {
+		t.Error(w.Code, body)
+	}
+}
+
+func TestCardWatermark(t *testing.T) {
+	render := func() image.Image {
+		drawer, err := drawers.GetContext(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer drawer.Free()
+
+		img := &smallImage{prefix: "/r/", format: "png", text: "card", q: url.Values{"card": {"1"}}}
+		res, err := img.render(context.Background(), drawer.Drawer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := png.Decode(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	plain := render()
+	*watermark = true
+	defer func() { *watermark = false }()
+	marked := render()
+
+	// the watermark is drawn at the bottom right corner of the card
+	b := marked.Bounds()
+	if b != image.Rect(0, 0, cardW, cardH) {
+		t.Fatal(b)
+	}
+	changed := image.Rectangle{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if plain.At(x, y) != marked.At(x, y) {
+				changed = changed.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if changed.Empty() || changed.Min.X < cardW/2 || changed.Min.Y < cardH/2 {
+		t.Error(changed)
+	}
+}
+
+func TestOEmbedErrors(t *testing.T) {
+	for u, code := range map[string]int{
+		"/oembed?format=xml&url=" + url.QueryEscape("http://png.cat/s/a.png"): 501,
+		"/oembed?url=" + url.QueryEscape("http://other.site/s/a.png"):         404,
+		"/oembed?url=" + url.QueryEscape("http://png.cat/edit/abc"):           404,
+		"/oembed?url=" + url.QueryEscape("http://png.cat/r/"):                 404,
+		"/oembed?url=" + url.QueryEscape("http://png.cat/r"):                  404,
+	} {
+		w := httptest.NewRecorder()
+		serveOEmbed(w, httptest.NewRequest("GET", "http://png.cat"+u, nil))
+		if w.Code != code {
+			t.Error(u, w.Code)
+		}
+	}
+}
+
+func TestOEmbed(t *testing.T) {
+	get := func(h http.HandlerFunc, u string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", u, nil))
+		return w
+	}
+	oembed := func(u string, q string) (res oEmbed) {
+		w := get(serveOEmbed, "http://png.cat/oembed?url="+url.QueryEscape("http://png.cat"+u)+q)
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(u, w.Code, err)
+		}
+		return
+	}
+	// size returns the size of the image served at u
+	size := func(h http.HandlerFunc, u string) (int, int) {
+		cfg, err := png.DecodeConfig(get(h, u).Body)
+		if err != nil {
+			t.Fatal(u, err)
+		}
+		return cfg.Width, cfg.Height
+	}
+
+	token := escape("## title\n"+strings.Repeat("some text\n", 20), 0)
+	for _, u := range []string{"/r/" + token + ".png", "/r/" + token + ".png?pad=8&bar=1"} {
+		res := oembed(u, "")
+		w, h := size(serveSmall("/r/", true), u)
+		if res.Type != "photo" || res.Title != "title" || res.URL != "http://png.cat"+u || res.Width != w || res.Height != h {
+			t.Error(u, res, w, h)
+		}
+	}
+
+	if res, u := oembed("/s/hello.png", ""), "/s/hello.png"; res.Type != "photo" {
+		t.Error(res)
+	} else if w, h := size(serveSmall("/s/", false), u); res.Width != w || res.Height != h {
+		t.Error(res, w, h)
+	}
+
+	// pages and images larger than maxwidth are rich
+	w, h := size(serveSmall("/r/", true), "/r/"+token+".png")
+	res := oembed("/p/"+token, "&maxwidth=300")
+	if res.Type != "rich" || res.URL != "" || res.Width != 300 || res.Height != h*300/w ||
+		!strings.Contains(res.HTML, fmt.Sprintf("width=300 height=%d", res.Height)) {
+		t.Error(res)
+	}
+}
@@ -29,6 +29,8 @@ a:hover{text-decoration:underline}
 #post-form .title{padding: 4px;white-space:nowrap;width:1px;text-align:right}
 .bar-item{color:white;display:inline-block;zoom:1;*display:inline;margin:-4px 0;padding:4px 8px;border-left:solid 1px #889}
 .header,.footer{background:#667;padding:4px 0;color:white;margin:0 -1px}
+.drop{display:block;position:relative;margin:4px;padding:64px 8px;border:dashed 2px #ccc;text-align:center;color:#898}
+.drop input{position:absolute;left:0;top:0;width:100%;height:100%;opacity:0;cursor:pointer}
 </style>`
 
 const UntitledSnippet = "无标题"
@@ -55,6 +57,37 @@ const Help = "帮助"
 
 const Error = "错误"
 
+const Import = "导入"
+
+const NoSource = "图片中没有找到原文，只有本站生成的PNG图片可以导入"
+
+const ImportForm = `<form method=POST action=/import enctype=multipart/form-data>
+<label class=drop>将本站生成的PNG图片拖放到此处，或点击选择文件，原文将在编辑器中打开
+<input type=file name=png accept=image/png onchange="this.form.submit()">
+</label>
+</form>`
+
+const OpenGraph = `<meta property="og:type" content="article">
+<meta property="og:site_name" content="%[1]s">
+<meta property="og:title" content="%[2]s">
+<meta property="og:description" content="%[3]s">
+<meta property="og:image" content="%[4]s">
+<meta property="og:image:width" content="1200">
+<meta property="og:image:height" content="630">
+<meta property="og:url" content="%[5]s">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:title" content="%[2]s">
+<meta name="twitter:description" content="%[3]s">
+<meta name="twitter:image" content="%[4]s">
+`
+
+const OEmbedLink = `<link rel="alternate" type="application/json+oembed" href="%s" title="%s">
+`
+
+const SnippetPage = `<div class=snippet><img src="%[1]s" alt="%[2]s" style="max-width:100%%">
+<div class=info><a href="%[1]s">原图</a> · <a href="/edit/%[3]s">编辑</a></div>
+<details class=info><summary>文本</summary><pre style="white-space:pre-wrap">%[4]s</pre></details></div>`
+
 const NewSnippetForm = `<form method=POST action=/post target=_blank><table id=post-form>
 <tr><td colspan=4 style="font-size:1.5em;text-align:center;padding:4px">
 <h2>Text-to-Image Converter</h2>
@@ -75,6 +108,41 @@ Converteix text a la imatge amb un sol clic
 <label for=theme4 class=color-blk style="background:black;color:white;">A</label>
 <input id=theme5 type=radio name=theme value=rs1>
 <label for=theme5 class=color-blk style="background:#f6f7eb;color:black;">S</label>
+断词:
+<select name=hy>
+<option value="">无</option>
+<option value=en>English</option>
+<option value=de>Deutsch</option>
+<option value=fr>Français</option>
+<option value=es>Español</option>
+<option value=it>Italiano</option>
+</select>
+制表符宽度:
+<input name=tabs type=number min=1 max=16 value=%d style="width:3em">
+<input id=vertical type=checkbox name=v value=1>
+<label for=vertical>竖排</label>
+<input id=indent type=checkbox name=i value=1>
+<label for=indent>保留缩进</label>
+行号:
+<select name=nums>
+<option value="">代码</option>
+<option value=prose>代码及正文</option>
+<option value=off>无</option>
+</select>
+每<input name=every type=number min=1 value=1 style="width:3em">行
+<input id=rule type=checkbox name=rule value=1>
+<label for=rule>分隔线</label>
+<br>
+标题: <input name=title maxlength=80 style="width:12em">
+边距: <input name=pad type=number min=0 max=64 value=0 style="width:3em">
+<input id=bar type=checkbox name=bar value=1>
+<label for=bar>窗口标题栏</label>
+<input id=footer type=checkbox name=footer value=1>
+<label for=footer>页脚</label>
+<input id=round type=checkbox name=round value=1>
+<label for=round>圆角边框</label>
+<input id=shadow type=checkbox name=shadow value=1>
+<label for=shadow>阴影</label>
 <input type=submit value="发布 publica" style="float:right">
 </div>
 </td></tr>
@@ -87,7 +155,27 @@ const HelpPage = `
 <li>每行文本可能会被插入多个空格以保证与80列对齐；
 <li>若不想被空格破坏格式（如代码），请插入一对三个反引号（单独一行）：
 	<p style="font-family:consolas,monospace">` + "```" + `<br>&nbsp;&nbsp;&nbsp;&nbsp;a = b + c; <br>` + "```" + `</p>
+<li>代码块的行号默认从1开始，可在开头的反引号后指定起始行号及需要高亮的行，如“` + "```" + `go:120 {122,125-127}”（高亮的行号以显示的行号为准）；
+<li>代码行末尾的“&lt;1&gt;”（可跟在“//”或“#”后，如“f() // &lt;1&gt; &lt;2&gt;”）将显示为带编号的标记，注释符号会被去掉，便于在正文中引用；
+<li>以“#”至“######”加空格开头的行为标题；以“&gt;”开头的行为引用，左侧显示竖线；以“- ”、“* ”、“1. ”等开头的行为列表项，折行后与项目文字对齐；
+<li>Markdown表格（表头下一行为“|---|:---:|”形式的分隔行）及以制表符分隔的多行数据将按列对齐并加上边框，过宽的单元格会自动折行；
+<li>代码块外支持简单的行内格式：“**粗体**”、“_斜体_”、“` + "`" + `代码` + "`" + `”、“~~删除线~~”，标记须在同一行内成对出现，且不计入列宽；
+<li>使用“` + "```" + `diff”代码块（或直接粘贴“diff -u”、“git diff”的输出）可显示补丁，增删的行及“@@”行将以不同底色标出，行号栏同时显示旧、新两个文件的行号；
+<li>“/d/旧token/新token.png”（黑底为“/db/”）可将两个“/r/”图片的内容逐行比较，左右并排显示，修改的行内以深色标出改动的部分；
 <li>图片URL的前缀为：“/r/”，“/rb/”，“/rW/”，“/rB/”，我们同时提供它们对应的简单格式：“/s/”，“/sb/”，“/sW/”，“/sB/”，其后跟明文即可输出png格式的图片。
+<li>可选择拉丁文的断词语言，过长的单词将在音节处以连字符断开，而不是在行内插入过多空格；
+<li>制表符将对齐到制表位，默认宽度为4，可在表单中修改，或在代码块开头指定，如“` + "```" + `make tabs=8”；
+<li>行号可在表单中设置为不显示、正文也显示（按原文的行计数）、每N行显示一次，及在行号后画一条分隔线；代码块可单独指定，如“` + "```" + `go nums=off”、“` + "```" + `go every=5 rule=on”；
+<li>可为图片加上边距、带标题的窗口标题栏、显示站点名及日期的页脚、圆角边框及阴影，加上装饰的图片背景透明；
+<li>勾选“保留缩进”后，以空格或制表符缩进的行（包括代码块中的行）折行时，后续各行将与首行的缩进对齐；
+<li>勾选“竖排”后文本将从右至左竖向排列，标点使用竖排字形，拉丁字母旋转90度；
+<li>支持注音（ruby）：“｜漢字《かんじ》”，若被注音的部分全为汉字，“｜”可省略，“｜《”则表示“《”本身；
+<li>支持希伯来文、阿拉伯文等从右至左的文字，以其开头的段落将右对齐，阿拉伯字母会自动连写；
+<li>将“/r/”换为“/g/”（黑色为“/gb/”）、扩展名换为“.gif”即得逐行打字的动画，参数mode=char逐字显示，delay设置每帧间隔（单位10毫秒），frames设置最多帧数（不超过100）；
+<li>图片链接的扩展名可改为“.gif”或“.jpg”以取得对应格式的图片，省略扩展名时按浏览器的Accept头选择；过大的图片会先减少颜色，仍过大则按行分页，以参数page=1、2……取得后续各页；
+<li>将“/r/”换为“/p/”、去掉扩展名即得分享用的页面，其标题取自第一个标题行（没有则为第一行），在聊天软件中可显示预览卡片；图片链接加上参数card=1可取得1200×630的预览卡片图片；
+<li>支持oEmbed：“/oembed?url=”加上本站“/r/”、“/s/”图片或“/p/”页面的链接即可取得嵌入信息，论坛及维基等软件也可从“/p/”页面中自动发现；
+<li>生成的PNG图片内嵌有压缩后的原文，在“导入”页面拖入图片即可重新编辑；
 <li>本网站不提供任何储存服务，亦不对任何图片内容负责；
 </ol>
 `
@@ -97,7 +185,7 @@ const Header = `<meta name="viewport" content="width=device-width, initial-scale
 ` + CSS + `
 <div id=container>
 <div class=header>
-<a class=bar-item href=/>` + NewSnippet + `</a><a class=bar-item href=/help>` + Help + `</a>
+<a class=bar-item href=/>` + NewSnippet + `</a><a class=bar-item href=/import>` + Import + `</a><a class=bar-item href=/help>` + Help + `</a>
 </div><div id=content-0>`
 
 const Footer = `</div><div class=footer><!--
@@ -64,7 +64,15 @@ func main() {
 	ibuf, _ := ioutil.ReadFile(`../_raw/lorem.txt`)
 	ibuf = append([]byte(" \na(/*/**//*/)\na(\"/*/**//*/\")\n//\"\n"), ibuf...)
 	fo := &kkformat.Formatter{Source: ibuf, Img: d, LineHeight: int(*size * *dpi * 6 / 5 / 72), Columns: 80, Theme: th}
-	img := fo.Render()
+	res, err := fo.Render()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	for _, warn := range res.Warnings {
+		log.Println(warn)
+	}
+	img := res.Image
 	log.Println(time.Now().Sub(start).Nanoseconds() / 1e6)
 
 	// Save that RGBA image to disk.